@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"mediaorganizer/pkg/config"
+	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/processor"
+)
+
+// stdout is where the commands that inspect the journal print their tables.
+var stdout io.Writer = os.Stdout
+
+// runJournalCommand runs one of the commands that inspect the journal. They
+// don't record a run, and their tables go to stdout so they can be piped.
+func runJournalCommand(cfg *config.Config) {
+	// Don't create an empty journal just to report that it is empty
+	if _, err := os.Stat(cfg.DBPath); err != nil {
+		logrus.Fatalf("No journal database found at %s", cfg.DBPath)
+	}
+	journal, err := db.OpenJournalReadOnly(cfg.DBPath)
+	if err != nil {
+		logrus.Fatalf("Failed to open journal database: %v", err)
+	}
+	defer journal.Close()
+
+	switch cfg.Command {
+	case config.CommandStatus:
+		err = runStatus(cfg, journal)
+	case config.CommandReport:
+		err = runReport(cfg, journal)
+	case config.CommandQuery:
+		err = runQuery(cfg, journal)
+	case config.CommandVerify:
+		if !runVerify(journal) {
+			journal.Close()
+			os.Exit(1)
+		}
+	}
+	if err != nil {
+		journal.Close()
+		logrus.Fatalf("%s failed: %v", cfg.Command, err)
+	}
+}
+
+// runStatus prints how many files the journal holds by status and media type,
+// and the history of runs.
+func runStatus(cfg *config.Config, journal *db.Journal) error {
+	stats, err := journal.Stats()
+	if err != nil {
+		return err
+	}
+	typeStats, err := journal.MediaTypeStats()
+	if err != nil {
+		return err
+	}
+	sidecarStats, err := journal.SidecarStats()
+	if err != nil {
+		return err
+	}
+	runs, err := journal.ListRuns()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Journal: %s\n", cfg.DBPath)
+
+	total := 0
+	for _, n := range stats {
+		total += n
+	}
+	fmt.Fprintf(w, "\nFiles by status (%d):\n", total)
+	for _, status := range sortedKeys(stats) {
+		fmt.Fprintf(w, "  %s\t%d\n", status, stats[status])
+	}
+	fmt.Fprintf(w, "\nFiles by media type:\n")
+	for _, mediaType := range sortedKeys(typeStats) {
+		fmt.Fprintf(w, "  %s\t%d\n", mediaType, typeStats[mediaType])
+	}
+	if len(sidecarStats) > 0 {
+		fmt.Fprintf(w, "\nSidecars by status:\n")
+		for _, status := range sortedKeys(sidecarStats) {
+			fmt.Fprintf(w, "  %s\t%d\n", status, sidecarStats[status])
+		}
+	}
+
+	fmt.Fprintf(w, "\nRuns (%d):\n", len(runs))
+	fmt.Fprintf(w, "  ID\tSTARTED\tENDED\tMODE\tVERSION\tFILES\tORGANIZED\tSKIPPED\tDUPLICATES\tERRORS\n")
+	for _, r := range runs {
+		ended := r.EndedAt
+		if ended == "" {
+			ended = "interrupted"
+		}
+		fmt.Fprintf(w, "  %d\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\n",
+			r.ID, r.StartedAt, ended, r.Mode, r.Version,
+			r.TotalFiles, r.OrganizedFiles, r.SkippedFiles, r.DuplicateCount, r.ErrorCount)
+	}
+	return w.Flush()
+}
+
+// runReport prints what a run did to each file it touched: the latest run,
+// or the one given with --run.
+func runReport(cfg *config.Config, journal *db.Journal) error {
+	var run *db.RunRecord
+	if cfg.ReportRun != 0 {
+		r, err := journal.GetRun(cfg.ReportRun)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return fmt.Errorf("run #%d not found", cfg.ReportRun)
+		}
+		run = r
+	} else {
+		runs, err := journal.ListRuns()
+		if err != nil {
+			return err
+		}
+		if len(runs) == 0 {
+			fmt.Fprintln(stdout, "No runs recorded in the journal")
+			return nil
+		}
+		run = runs[len(runs)-1]
+	}
+	records, err := journal.GetFilesOfRun(run.ID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	ended := run.EndedAt
+	if ended == "" {
+		ended = "interrupted"
+	}
+	fmt.Fprintf(w, "Run #%d (%s, version %s): started %s, ended %s\n", run.ID, run.Mode, run.Version, run.StartedAt, ended)
+	fmt.Fprintf(w, "Files: %d, organized: %d, skipped: %d, duplicates: %d, errors: %d\n",
+		run.TotalFiles, run.OrganizedFiles, run.SkippedFiles, run.DuplicateCount, run.ErrorCount)
+	if len(records) == 0 {
+		fmt.Fprintf(w, "\nNo files in the journal were last handled by this run\n")
+		return w.Flush()
+	}
+
+	fmt.Fprintf(w, "\nSTATUS\tSOURCE\tDESTINATION\tNOTE\n")
+	for _, rec := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", rec.Status, rec.SourcePath, orDash(rec.DestPath), orDash(recordNote(rec)))
+	}
+	return w.Flush()
+}
+
+// recordNote explains a record in a report: its error, or how it was handled
+// as a duplicate.
+func recordNote(rec *db.FileRecord) string {
+	switch {
+	case rec.ErrorMessage != "":
+		return rec.ErrorMessage
+	case rec.IsDuplicate && rec.DuplicateAction != "":
+		return "duplicate (" + rec.DuplicateAction + ")"
+	case rec.IsDuplicate:
+		return "duplicate"
+	case rec.NearDuplicateOf != 0:
+		return "near-duplicate"
+	case rec.ContentFormat != "":
+		return "content is " + strings.ToUpper(rec.ContentFormat)
+	}
+	return ""
+}
+
+// queryRow is a journal record as output by the query command.
+type queryRow struct {
+	ID              int64  `json:"id"`
+	Source          string `json:"source"`
+	Destination     string `json:"destination"`
+	Status          string `json:"status"`
+	Type            string `json:"type"`
+	Extension       string `json:"extension"`
+	ContentFormat   string `json:"content_format"`
+	Size            int64  `json:"size"`
+	Created         string `json:"created"`
+	DateSource      string `json:"date_source"`
+	Hash            string `json:"hash"`
+	Duplicate       bool   `json:"duplicate"`
+	DuplicateOf     int64  `json:"duplicate_of"`
+	DuplicateAction string `json:"duplicate_action"`
+	NearDuplicateOf int64  `json:"near_duplicate_of"`
+	Transfer        string `json:"transfer"`
+	CameraMake      string `json:"camera_make"`
+	CameraModel     string `json:"camera_model"`
+	Error           string `json:"error"`
+	RunID           int64  `json:"run_id"`
+	Updated         string `json:"updated"`
+}
+
+// queryColumns are the CSV columns of the query command, in the order of the
+// fields of queryRow.
+var queryColumns = []string{
+	"id", "source", "destination", "status", "type", "extension", "content_format", "size", "created", "date_source", "hash",
+	"duplicate", "duplicate_of", "duplicate_action", "near_duplicate_of", "transfer",
+	"camera_make", "camera_model", "error", "run_id", "updated",
+}
+
+func newQueryRow(rec *db.FileRecord) queryRow {
+	return queryRow{
+		ID:              rec.ID,
+		Source:          rec.SourcePath,
+		Destination:     rec.DestPath,
+		Status:          string(rec.Status),
+		Type:            rec.MediaType,
+		Extension:       rec.Extension,
+		ContentFormat:   rec.ContentFormat,
+		Size:            rec.FileSize,
+		Created:         rec.CreationTime,
+		DateSource:      rec.DateSource,
+		Hash:            rec.Hash,
+		Duplicate:       rec.IsDuplicate,
+		DuplicateOf:     rec.DuplicateOf,
+		DuplicateAction: rec.DuplicateAction,
+		NearDuplicateOf: rec.NearDuplicateOf,
+		Transfer:        rec.Transfer,
+		CameraMake:      rec.CameraMake,
+		CameraModel:     rec.CameraModel,
+		Error:           rec.ErrorMessage,
+		RunID:           rec.RunID,
+		Updated:         rec.UpdatedAt,
+	}
+}
+
+func (r queryRow) csvRecord() []string {
+	itoa := func(n int64) string { return strconv.FormatInt(n, 10) }
+	return []string{
+		itoa(r.ID), r.Source, r.Destination, r.Status, r.Type, r.Extension, r.ContentFormat, itoa(r.Size), r.Created, r.DateSource, r.Hash,
+		strconv.FormatBool(r.Duplicate), itoa(r.DuplicateOf), r.DuplicateAction, itoa(r.NearDuplicateOf), r.Transfer,
+		r.CameraMake, r.CameraModel, r.Error, itoa(r.RunID), r.Updated,
+	}
+}
+
+// runQuery lists the files in the journal that match the query filters, in
+// the format given with --format.
+func runQuery(cfg *config.Config, journal *db.Journal) error {
+	q := cfg.Query
+	if q.Status != "" && !slices.Contains(db.SourceStatuses, db.FileStatus(q.Status)) {
+		return fmt.Errorf("unknown status: %s (valid: %s)", q.Status, joinStatuses(db.SourceStatuses))
+	}
+	records, err := journal.QueryFiles(db.FileFilter{
+		Status:      db.FileStatus(q.Status),
+		MediaType:   q.MediaType,
+		Extension:   q.Extension,
+		CreatedFrom: q.From,
+		CreatedTo:   q.To,
+		Duplicate:   q.Duplicate,
+		Hash:        q.Hash,
+		PathGlob:    q.Path,
+	})
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Query matched %d files", len(records))
+
+	switch q.Format {
+	case config.FormatCSV:
+		w := csv.NewWriter(stdout)
+		w.Write(queryColumns)
+		for _, rec := range records {
+			w.Write(newQueryRow(rec).csvRecord())
+		}
+		w.Flush()
+		return w.Error()
+	case config.FormatJSON:
+		rows := make([]queryRow, 0, len(records))
+		for _, rec := range records {
+			rows = append(rows, newQueryRow(rec))
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	case config.FormatJSONL:
+		enc := json.NewEncoder(stdout)
+		for _, rec := range records {
+			if err := enc.Encode(newQueryRow(rec)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tSTATUS\tTYPE\tCREATED\tSOURCE\tDESTINATION\tNOTE\n")
+	for _, rec := range records {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", rec.ID, rec.Status, rec.MediaType, rec.CreationTime, rec.SourcePath, orDash(rec.DestPath), orDash(recordNote(rec)))
+	}
+	return w.Flush()
+}
+
+func joinStatuses(statuses []db.FileStatus) string {
+	names := make([]string, len(statuses))
+	for i, s := range statuses {
+		names[i] = string(s)
+	}
+	return strings.Join(names, ", ")
+}
+
+// runVerify checks the organized files against the journal and reports
+// whether all of them are intact.
+func runVerify(journal *db.Journal) bool {
+	logrus.Infof("Media Organizer - verify")
+	startTime := time.Now()
+	result := processor.NewVerifier(journal).Verify()
+
+	logrus.Infof("Verify completed in %s", time.Since(startTime))
+	logrus.Infof("Organized files checked: %d", result.TotalRecords)
+	logrus.Infof("Intact: %d", result.Intact)
+	logrus.Infof("Problems: %d", len(result.Problems))
+	for _, p := range result.Problems {
+		logrus.Infof("  %s: %s", p.Path, p.Reason)
+	}
+	logrus.Infof("Errors: %d", result.ErrorCount)
+	return len(result.Problems) == 0 && result.ErrorCount == 0
+}
+
+// sortedKeys returns the keys of a count map in order.
+func sortedKeys[K ~string](m map[K]int) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
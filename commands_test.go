@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+
+	"mediaorganizer/pkg/config"
+	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/media"
+)
+
+// loadConfig loads the configuration as if args were given on the command
+// line.
+func loadConfig(t *testing.T, args ...string) (*config.Config, error) {
+	t.Helper()
+	oldArgs, oldFlags := os.Args, pflag.CommandLine
+	t.Cleanup(func() { os.Args, pflag.CommandLine = oldArgs, oldFlags })
+	os.Args = append([]string{"mediaorganizer"}, args...)
+	pflag.CommandLine = pflag.NewFlagSet("mediaorganizer", pflag.ContinueOnError)
+	return config.LoadConfig("test")
+}
+
+// output runs the journal command of cfg and returns what it printed, with
+// timestamps replaced by a placeholder of the same width.
+func output(t *testing.T, cfg *config.Config) string {
+	t.Helper()
+	var buf bytes.Buffer
+	stdout = &buf
+	t.Cleanup(func() { stdout = os.Stdout })
+	runJournalCommand(cfg)
+	return timestamps.ReplaceAllString(buf.String(), "YYYY-MM-DD hh:mm:ss")
+}
+
+var timestamps = regexp.MustCompile(`\d{4}-\d\d-\d\d \d\d:\d\d:\d\d`)
+
+// seedJournal writes a journal with a finished organize run and an
+// interrupted undo run, and returns its path.
+func seedJournal(t *testing.T, records ...*db.FileRecord) string {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "journal.db")
+	j, err := db.InitJournal(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if _, err := j.StartRun("1.0", "organize", "{}"); err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records {
+		if rec.MediaType == "" {
+			rec.MediaType = "image"
+		}
+		if rec.Extension == "" {
+			rec.Extension = "jpg"
+		}
+		rec.OriginalName = filepath.Base(rec.SourcePath)
+		if rec.CreationTime == "" {
+			rec.CreationTime = "2023-05-01 12:00:00"
+		}
+		if _, err := j.InsertFile(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.FinishRun(db.RunCounts{TotalFiles: len(records), ProcessedFiles: len(records), OrganizedFiles: 2, ErrorCount: 1, DuplicateCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.StartRun("1.1", "undo", "{}"); err != nil {
+		t.Fatal(err)
+	}
+	return dbPath
+}
+
+// organizedRecords are the records of seedJournal for the journal commands.
+func organizedRecords() []*db.FileRecord {
+	return []*db.FileRecord{
+		{SourcePath: "/photos/a.jpg", DestPath: "/out/2023/a.jpg", FileSize: 100, Status: db.StatusCompleted},
+		{SourcePath: "/photos/b.mp4", DestPath: "/out/2023/b.mp4", FileSize: 200, MediaType: "video", Extension: "mp4", Status: db.StatusCompleted},
+		{SourcePath: "/photos/copy/a.jpg", FileSize: 100, Status: db.StatusSkipped, IsDuplicate: true, DuplicateAction: "report"},
+		{SourcePath: "/photos/broken.jpg", FileSize: 5, Status: db.StatusFailed, ErrorMessage: "no destination path"},
+	}
+}
+
+func TestStatusWithDBAndNoSource(t *testing.T) {
+	dbPath := seedJournal(t, organizedRecords()...)
+	before, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadConfig(t, "status", "--db", dbPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.SourceDir != "" || cfg.DBPath != dbPath {
+		t.Fatalf("source %q, journal %q", cfg.SourceDir, cfg.DBPath)
+	}
+	want := `Journal: ` + dbPath + `
+
+Files by status (4):
+  completed  2
+  failed     1
+  skipped    1
+
+Files by media type:
+  image  3
+  video  1
+
+Runs (2):
+  ID  STARTED              ENDED                MODE      VERSION  FILES  ORGANIZED  SKIPPED  DUPLICATES  ERRORS
+  1   YYYY-MM-DD hh:mm:ss  YYYY-MM-DD hh:mm:ss  organize  1.0      4      2          0        1           1
+  2   YYYY-MM-DD hh:mm:ss  interrupted          undo      1.1      0      0          0        0           0
+`
+	if got := output(t, cfg); got != want {
+		t.Errorf("status printed\n%s\nwant\n%s", got, want)
+	}
+
+	// Inspecting a journal doesn't change it
+	if after, err := os.ReadFile(dbPath); err != nil || !bytes.Equal(before, after) {
+		t.Errorf("journal changed by status: %v", err)
+	}
+
+	if _, err := loadConfig(t, "status"); err == nil || !strings.Contains(err.Error(), "--db is required") {
+		t.Errorf("status without --source or --db: %v", err)
+	}
+}
+
+func TestReport(t *testing.T) {
+	dbPath := seedJournal(t, organizedRecords()...)
+	cfg, err := loadConfig(t, "report", "--db", dbPath, "--run", "1")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	want := `Run #1 (organize, version 1.0): started YYYY-MM-DD hh:mm:ss, ended YYYY-MM-DD hh:mm:ss
+Files: 4, organized: 2, skipped: 0, duplicates: 1, errors: 1
+
+STATUS     SOURCE              DESTINATION      NOTE
+completed  /photos/a.jpg       /out/2023/a.jpg  -
+completed  /photos/b.mp4       /out/2023/b.mp4  -
+failed     /photos/broken.jpg  -                no destination path
+skipped    /photos/copy/a.jpg  -                duplicate (report)
+`
+	if got := output(t, cfg); got != want {
+		t.Errorf("report printed\n%s\nwant\n%s", got, want)
+	}
+
+	// The latest run, the undo, handled no file
+	cfg.ReportRun = 0
+	want = `Run #2 (undo, version 1.1): started YYYY-MM-DD hh:mm:ss, ended interrupted
+Files: 0, organized: 0, skipped: 0, duplicates: 0, errors: 0
+
+No files in the journal were last handled by this run
+`
+	if got := output(t, cfg); got != want {
+		t.Errorf("report of the latest run printed\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	intact := filepath.Join(dir, "intact.jpg")
+	changed := filepath.Join(dir, "changed.jpg")
+	for _, path := range []string{intact, changed} {
+		if err := os.WriteFile(path, []byte("original content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash, err := media.ComputeFileHash(intact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(changed, []byte("changed content!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.jpg")
+	dbPath := seedJournal(t,
+		&db.FileRecord{SourcePath: "/photos/intact.jpg", DestPath: intact, FileSize: 16, Hash: hash, Status: db.StatusCompleted},
+		&db.FileRecord{SourcePath: "/photos/changed.jpg", DestPath: changed, FileSize: 16, Hash: hash, Status: db.StatusCompleted},
+		&db.FileRecord{SourcePath: "/photos/missing.jpg", DestPath: missing, FileSize: 16, Hash: hash, Status: db.StatusCompleted},
+		&db.FileRecord{SourcePath: "/photos/failed.jpg", FileSize: 16, Status: db.StatusFailed},
+	)
+	journal, err := db.OpenJournalReadOnly(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	var log bytes.Buffer
+	logrus.SetOutput(&log)
+	t.Cleanup(func() { logrus.SetOutput(os.Stderr) })
+	if runVerify(journal) {
+		t.Errorf("verify passed with a changed and a missing file")
+	}
+	for _, line := range []string{
+		"Organized files checked: 3",
+		"Intact: 1",
+		"Problems: 2",
+		changed + ": content changed",
+		missing + ": destination file missing",
+		"Errors: 0",
+	} {
+		if !strings.Contains(log.String(), line) {
+			t.Errorf("verify did not log %q:\n%s", line, log.String())
+		}
+	}
+}
+
+// queriedRecords are the records of seedJournal for the query command.
+func queriedRecords() []*db.FileRecord {
+	return []*db.FileRecord{
+		{SourcePath: "/photos/2019/a.jpg", DestPath: "/out/2019/a.jpg", CreationTime: "2019-06-01 12:00:00", Hash: "h1", Status: db.StatusCompleted},
+		{SourcePath: "/photos/2019/b.mp4", DestPath: "/out/2019/b.mp4", CreationTime: "2019-12-31 23:59:59", Hash: "h2", MediaType: "video", Extension: "mp4", Status: db.StatusCompleted},
+		{SourcePath: "/photos/2020/c.jpg", CreationTime: "2020-01-01 00:00:00", Hash: "h1", IsDuplicate: true, DuplicateOf: 1, DuplicateAction: "report", Status: db.StatusSkipped},
+		{SourcePath: "/photos/2021/d.mp3", CreationTime: "2021-03-03 08:00:00", MediaType: "audio", Extension: "mp3", Status: db.StatusFailed, ErrorMessage: "unreadable"},
+		{SourcePath: "/photos/new/e.heic", CreationTime: "2021-07-07 07:07:07", Extension: "heic", Status: db.StatusPending},
+	}
+}
+
+func TestQueryFilters(t *testing.T) {
+	dbPath := seedJournal(t, queriedRecords()...)
+	tests := []struct {
+		args []string
+		want []string // file names, in source path order
+	}{
+		{nil, []string{"a.jpg", "b.mp4", "c.jpg", "d.mp3", "e.heic"}},
+		{[]string{"--status", "completed"}, []string{"a.jpg", "b.mp4"}},
+		{[]string{"--type", "video"}, []string{"b.mp4"}},
+		{[]string{"--ext", ".JPG"}, []string{"a.jpg", "c.jpg"}},
+		{[]string{"--from", "2019-12-31"}, []string{"b.mp4", "c.jpg", "d.mp3", "e.heic"}},
+		{[]string{"--to", "2019-12-31"}, []string{"a.jpg", "b.mp4"}},
+		{[]string{"--from", "2020-01-01", "--to", "2021-03-03"}, []string{"c.jpg", "d.mp3"}},
+		{[]string{"--duplicate"}, []string{"c.jpg"}},
+		{[]string{"--duplicate=false"}, []string{"a.jpg", "b.mp4", "d.mp3", "e.heic"}},
+		{[]string{"--hash", "h1"}, []string{"a.jpg", "c.jpg"}},
+		{[]string{"--path", "*/2019/*"}, []string{"a.jpg", "b.mp4"}},
+		{[]string{"--path", "/out/*.mp4"}, []string{"b.mp4"}},
+		{[]string{"--type", "image", "--status", "skipped"}, []string{"c.jpg"}},
+		{[]string{"--status", "completed", "--duplicate"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			cfg, err := loadConfig(t, append([]string{"query", "--db", dbPath, "--format", "csv"}, tt.args...)...)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			rows, err := csv.NewReader(strings.NewReader(output(t, cfg))).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, row := range rows[1:] {
+				got = append(got, filepath.Base(row[1]))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("query %v listed %v, want %v", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryFormats(t *testing.T) {
+	dbPath := seedJournal(t,
+		&db.FileRecord{SourcePath: "/photos/a.jpg", DestPath: "/out/2019/a.jpg", CreationTime: "2019-06-01 12:00:00", FileSize: 100, Hash: "h1", DateSource: "exif", CameraMake: "Canon", CameraModel: "EOS R", Status: db.StatusCompleted},
+		&db.FileRecord{SourcePath: `/photos/copy, "2".jpg`, CreationTime: "2019-06-01 12:00:00", FileSize: 100, Hash: "h1", IsDuplicate: true, DuplicateOf: 1, DuplicateAction: "report", Status: db.StatusSkipped},
+	)
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"table", `ID  STATUS     TYPE   CREATED              SOURCE                 DESTINATION      NOTE
+1   completed  image  YYYY-MM-DD hh:mm:ss  /photos/a.jpg          /out/2019/a.jpg  -
+2   skipped    image  YYYY-MM-DD hh:mm:ss  /photos/copy, "2".jpg  -                duplicate (report)
+`},
+		{"csv", `id,source,destination,status,type,extension,content_format,size,created,date_source,hash,duplicate,duplicate_of,duplicate_action,near_duplicate_of,transfer,camera_make,camera_model,error,run_id,updated
+1,/photos/a.jpg,/out/2019/a.jpg,completed,image,jpg,,100,YYYY-MM-DD hh:mm:ss,exif,h1,false,0,,0,,Canon,EOS R,,1,YYYY-MM-DD hh:mm:ss
+2,"/photos/copy, ""2"".jpg",,skipped,image,jpg,,100,YYYY-MM-DD hh:mm:ss,,h1,true,1,report,0,,,,,1,YYYY-MM-DD hh:mm:ss
+`},
+		{"json", `[
+  {
+    "id": 1,
+    "source": "/photos/a.jpg",
+    "destination": "/out/2019/a.jpg",
+    "status": "completed",
+    "type": "image",
+    "extension": "jpg",
+    "content_format": "",
+    "size": 100,
+    "created": "YYYY-MM-DD hh:mm:ss",
+    "date_source": "exif",
+    "hash": "h1",
+    "duplicate": false,
+    "duplicate_of": 0,
+    "duplicate_action": "",
+    "near_duplicate_of": 0,
+    "transfer": "",
+    "camera_make": "Canon",
+    "camera_model": "EOS R",
+    "error": "",
+    "run_id": 1,
+    "updated": "YYYY-MM-DD hh:mm:ss"
+  },
+  {
+    "id": 2,
+    "source": "/photos/copy, \"2\".jpg",
+    "destination": "",
+    "status": "skipped",
+    "type": "image",
+    "extension": "jpg",
+    "content_format": "",
+    "size": 100,
+    "created": "YYYY-MM-DD hh:mm:ss",
+    "date_source": "",
+    "hash": "h1",
+    "duplicate": true,
+    "duplicate_of": 1,
+    "duplicate_action": "report",
+    "near_duplicate_of": 0,
+    "transfer": "",
+    "camera_make": "",
+    "camera_model": "",
+    "error": "",
+    "run_id": 1,
+    "updated": "YYYY-MM-DD hh:mm:ss"
+  }
+]
+`},
+		{"jsonl", `{"id":1,"source":"/photos/a.jpg","destination":"/out/2019/a.jpg","status":"completed","type":"image","extension":"jpg","content_format":"","size":100,"created":"YYYY-MM-DD hh:mm:ss","date_source":"exif","hash":"h1","duplicate":false,"duplicate_of":0,"duplicate_action":"","near_duplicate_of":0,"transfer":"","camera_make":"Canon","camera_model":"EOS R","error":"","run_id":1,"updated":"YYYY-MM-DD hh:mm:ss"}
+{"id":2,"source":"/photos/copy, \"2\".jpg","destination":"","status":"skipped","type":"image","extension":"jpg","content_format":"","size":100,"created":"YYYY-MM-DD hh:mm:ss","date_source":"","hash":"h1","duplicate":true,"duplicate_of":1,"duplicate_action":"report","near_duplicate_of":0,"transfer":"","camera_make":"","camera_model":"","error":"","run_id":1,"updated":"YYYY-MM-DD hh:mm:ss"}
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			cfg, err := loadConfig(t, "query", "--db", dbPath, "--format", tt.format)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if got := output(t, cfg); got != tt.want {
+				t.Errorf("query printed\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
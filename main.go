@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/signal"
@@ -11,6 +13,7 @@ import (
 
 	"mediaorganizer/pkg/config"
 	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/media"
 	"mediaorganizer/pkg/processor"
 )
 
@@ -25,6 +28,7 @@ func main() {
 	}
 
 	logrus.Debugf("Configuration loaded successfully")
+	media.SetRegistry(cfg.Registry)
 
 	// Print all configuration values
 	logrus.Debugf("Source directory: %s", cfg.SourceDir)
@@ -33,21 +37,58 @@ func main() {
 	}
 	logrus.Debugf("Dry run: %v", cfg.DryRun)
 	logrus.Debugf("Copy files: %v", cfg.CopyFiles)
+	logrus.Debugf("Verify: %v", cfg.Verify)
 	logrus.Debugf("Delete empty dirs: %v", cfg.DeleteEmptyDirs)
 	logrus.Debugf("Verbose: %v", cfg.Verbose)
 	logrus.Debugf("Log file: %s", cfg.LogFile)
 	logrus.Debugf("Concurrent jobs: %d", cfg.ConcurrentJobs)
+	logrus.Debugf("Hash jobs: %d", cfg.HashJobs)
+	logrus.Debugf("Hash cache: %s", cfg.HashCache)
 	logrus.Debugf("Organization scheme: %s", cfg.OrganizationScheme)
 	logrus.Debugf("Database path: %s", cfg.DBPath)
+	logrus.Debugf("Command: %s", cfg.Command)
 
+	if cfg.Command == config.CommandPrune {
+		runPruneCache(cfg)
+		return
+	}
+	if cfg.Command == config.CommandMigrate {
+		runMigrate(cfg)
+		return
+	}
+
+	// Commands that only inspect the journal of earlier runs
+	if cfg.ReadsJournal() {
+		runJournalCommand(cfg)
+		return
+	}
+	runOrganize(cfg)
+}
+
+// runOrganize organizes the source directory, or undoes what earlier runs did
+// for the undo command. Both record a run in the journal.
+func runOrganize(cfg *config.Config) {
 	// Check if the source directory exists
 	logrus.Debugf("Checking if source directory exists...")
-	_, err = os.Stat(cfg.SourceDir)
+	_, err := os.Stat(cfg.SourceDir)
 	if err != nil {
 		logrus.Fatalf("Source directory does not exist: %s", cfg.SourceDir)
 	}
 	logrus.Debugf("Source directory exists")
 
+	// Undo needs the journal of a previous run; don't create an empty one
+	if cfg.Undo {
+		if _, err := os.Stat(cfg.DBPath); err != nil {
+			logrus.Fatalf("No journal database found at %s, nothing to undo", cfg.DBPath)
+		}
+	}
+
+	// A plan leaves the journal alone, so it must not be deleted by --fresh
+	if cfg.PlanFile != "" {
+		runPlan(cfg)
+		return
+	}
+
 	// Handle --fresh: delete existing database
 	if cfg.Fresh {
 		if _, err := os.Stat(cfg.DBPath); err == nil {
@@ -63,7 +104,7 @@ func main() {
 
 	// Determine resume mode: DB exists and not fresh
 	resumeMode := false
-	if !cfg.Fresh {
+	if !cfg.Fresh && !cfg.Undo {
 		if _, err := os.Stat(cfg.DBPath); err == nil {
 			resumeMode = true
 			logrus.Infof("Existing database found, resuming from previous run")
@@ -77,23 +118,57 @@ func main() {
 	}
 	defer journal.Close()
 
-	// Signal handler for graceful shutdown
+	// Record this invocation in the journal's run history
+	cfgJSON, err := json.Marshal(cfg)
+	if err != nil {
+		logrus.Fatalf("Failed to serialize configuration: %v", err)
+	}
+	runID, err := journal.StartRun(version, cfg.Mode(), string(cfgJSON))
+	if err != nil {
+		logrus.Fatalf("Failed to record run in journal: %v", err)
+	}
+	logrus.Debugf("Journal run ID: %d", runID)
+
+	// Signal handler for graceful shutdown. A watch first stops watching and
+	// finishes the files in progress; a second signal exits at once.
+	ctx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigCh
+		if cfg.Watch {
+			logrus.Infof("Received signal %v, stopping watch...", sig)
+			stopWatch()
+			sig = <-sigCh
+		}
 		logrus.Infof("Received signal %v, shutting down gracefully...", sig)
+		// The workers are still writing, so commit what they have written
+		// rather than close the journal under them; resume redoes the rest
+		if err := journal.Sync(); err != nil {
+			logrus.Errorf("Failed to commit journal: %v", err)
+		}
 		logrus.Infof("Journal database saved at: %s", cfg.DBPath)
 		logrus.Infof("Re-run the same command to resume from where it left off.")
-		journal.Close()
 		os.Exit(1)
 	}()
 
+	if cfg.Undo {
+		runUndo(cfg, journal)
+		return
+	}
+
+	// Commit the journal in batches; the movers commit before touching files
+	journal.StartBatching(db.DefaultBatchInterval)
+
 	// Print configuration
 	logrus.Infof("Media Organizer")
 	logrus.Infof("Source directory: %s", cfg.SourceDir)
 	logrus.Infof("Organization scheme: %s", cfg.OrganizationScheme)
-	if cfg.OrganizationScheme == config.SchemeDateFirst && cfg.Destination != "" {
+	if cfg.OrganizationScheme == config.SchemeTemplate {
+		logrus.Infof("Path template: %s", cfg.PathTemplate)
+	}
+	if (cfg.OrganizationScheme == config.SchemeDateFirst || cfg.OrganizationScheme == config.SchemeTemplate) && cfg.Destination != "" {
 		logrus.Infof("Destination: %s", cfg.Destination)
 	} else {
 		for mediaType, destDir := range cfg.DestDirs {
@@ -106,7 +181,9 @@ func main() {
 	if cfg.DryRun {
 		logrus.Infof("Running in DRY-RUN mode (no files will be moved/copied)")
 	} else {
-		if cfg.CopyFiles {
+		if cfg.Link != "" {
+			logrus.Infof("LINK MODE ENABLED (files will be linked with %s links, or copied where that is impossible)", cfg.Link)
+		} else if cfg.CopyFiles {
 			logrus.Infof("COPY MODE ENABLED (files will be copied instead of moved)")
 		} else {
 			logrus.Infof("MOVE MODE ENABLED (files will be moved from source to destination)")
@@ -114,21 +191,44 @@ func main() {
 				logrus.Infof("DELETE EMPTY DIRS ENABLED (empty folders will be removed after moving files)")
 			}
 		}
+		if cfg.Verify {
+			logrus.Infof("VERIFY ENABLED (copies are checksummed; mismatches go to %s)", cfg.QuarantineDir)
+		}
+	}
+
+	if cfg.DuplicatePolicy != config.DuplicateMove {
+		logrus.Infof("Duplicate policy: %s", cfg.DuplicatePolicy)
+	}
+	if cfg.Watch {
+		logrus.Infof("WATCH MODE ENABLED (new files are organized once unchanged for %s; stop with Ctrl-C)", cfg.WatchSettle)
+	}
+
+	if cfg.ApplyFile != "" {
+		runApply(cfg, journal, runID)
+		return
 	}
 
 	// Create and start scanner
 	logrus.Debugf("Creating scanner...")
 	logrus.Debugf("Duplicates directory: %s", cfg.DuplicatesDir)
-	scanner := processor.NewMediaScanner(cfg.SourceDir, cfg.Destination, cfg.DestDirs, cfg.ExtensionDirs, string(cfg.OrganizationScheme), cfg.SpaceReplacement, cfg.NoOriginalName, cfg.DuplicatesDir, cfg.DryRun, cfg.CopyFiles, cfg.ConcurrentJobs, cfg.DeleteEmptyDirs, journal, resumeMode)
+	hashCache := openHashCache(cfg)
+	if hashCache != nil {
+		defer hashCache.Close()
+	}
+	scanner := processor.NewMediaScanner(cfg, journal, hashCache, resumeMode)
 
 	logrus.Infof("Starting scan with %d concurrent workers...", cfg.ConcurrentJobs)
 	startTime := time.Now()
 
 	logrus.Debugf("Beginning scan process...")
 
-	// Start progress reporter in a separate goroutine
+	// Start progress reporter in a separate goroutine; a watch logs each
+	// batch of new files instead
 	done := make(chan struct{})
 	go func() {
+		if cfg.Watch {
+			return
+		}
 		ticker := time.NewTicker(5 * time.Second)
 		defer ticker.Stop()
 
@@ -144,25 +244,283 @@ func main() {
 
 	// Run the scanner
 	logrus.Debugf("Calling scanner.Scan()...")
-	result := scanner.Scan()
+	var result *processor.ScanResult
+	if cfg.Watch {
+		result, err = scanner.Watch(ctx)
+		if err != nil {
+			logrus.Fatalf("Failed to watch source directory: %v", err)
+		}
+	} else {
+		result = scanner.Scan()
+	}
 	close(done)
 
 	// Print results
 	logrus.Infof("Scan completed in %s", time.Since(startTime))
 	logrus.Debugf("Scan complete, printing results...")
+	logScanResult(cfg, result)
+	logrus.Infof("Journal database: %s (run #%d)", cfg.DBPath, runID)
+	finishRun(journal, result)
+
+	// Final message to verify program completed
+	logrus.Infof("Program completed successfully")
+
+	// Check if log file was specified
+	if cfg.LogFile != "" {
+		fmt.Printf("Log file written to: %s\n", cfg.LogFile)
+	}
+}
+
+// logScanResult prints the counts of a scan, plan or apply.
+func logScanResult(cfg *config.Config, result *processor.ScanResult) {
 	logrus.Infof("Total files: %d", result.TotalFiles)
 	logrus.Infof("Processed files: %d", result.ProcessedFiles)
 	logrus.Infof("Organized files: %d", result.OrganizedFiles)
 	logrus.Infof("Skipped files: %d", result.SkippedFiles)
+	for _, reason := range sortedKeys(result.SkippedByReason) {
+		logrus.Infof("  %s: %d", reason, result.SkippedByReason[reason])
+	}
 	logrus.Infof("Errors: %d", result.ErrorCount)
 	logrus.Infof("Duplicates: %d", result.DuplicateCount)
+	// Break duplicates down by action unless they were all moved as usual
+	if cfg.DuplicatePolicy != config.DuplicateMove || len(result.DuplicateActions) > 1 {
+		for _, policy := range config.ValidDuplicatePolicies {
+			if n := result.DuplicateActions[string(policy)]; n > 0 {
+				logrus.Infof("  %s: %d", policy, n)
+			}
+		}
+	}
+	if cfg.NearDuplicates {
+		logrus.Infof("Near-duplicates: %d", result.NearDuplicateCount)
+	}
+	if cfg.Verify || result.QuarantinedCount > 0 {
+		logrus.Infof("Quarantined: %d", result.QuarantinedCount)
+	}
+	if result.SidecarFiles > 0 {
+		logrus.Infof("Sidecar files: %d", result.SidecarFiles)
+	}
+}
+
+// finishRun records the final counts of a scan in the journal's run history.
+func finishRun(journal *db.Journal, result *processor.ScanResult) {
+	if err := journal.FinishRun(db.RunCounts{
+		TotalFiles:     result.TotalFiles,
+		ProcessedFiles: result.ProcessedFiles,
+		SkippedFiles:   result.SkippedFiles + result.RefusedFiles,
+		OrganizedFiles: result.OrganizedFiles,
+		ErrorCount:     result.ErrorCount,
+		DuplicateCount: result.DuplicateCount,
+	}); err != nil {
+		logrus.Errorf("Failed to record run results in journal: %v", err)
+	}
+}
+
+// runPlan computes what a run would do and writes it to cfg.PlanFile. The
+// dry run works on a snapshot of the journal, which itself stays untouched:
+// a plan is only a proposal until it is applied.
+func runPlan(cfg *config.Config) {
+	logrus.Infof("Media Organizer - plan")
+	logrus.Infof("Source directory: %s", cfg.SourceDir)
+	logrus.Infof("Organization scheme: %s", cfg.OrganizationScheme)
+
+	scratch, err := os.CreateTemp("", "mediaorganizer-plan-*.db")
+	if err != nil {
+		logrus.Fatalf("Failed to create scratch journal: %v", err)
+	}
+	scratch.Close()
+	scratchPath := scratch.Name()
+	defer func() {
+		os.Remove(scratchPath)
+		os.Remove(scratchPath + "-wal")
+		os.Remove(scratchPath + "-shm")
+	}()
+
+	// Plan on top of what the journal already knows, unless starting fresh
+	resumeMode := false
+	if _, err := os.Stat(cfg.DBPath); err == nil && !cfg.Fresh {
+		journal, err := db.InitJournal(cfg.DBPath)
+		if err != nil {
+			logrus.Fatalf("Failed to open journal database: %v", err)
+		}
+		err = journal.Snapshot(scratchPath)
+		journal.Close()
+		if err != nil {
+			logrus.Fatalf("Failed to snapshot journal database: %v", err)
+		}
+		resumeMode = true
+	}
+
+	journal, err := db.InitJournal(scratchPath)
+	if err != nil {
+		logrus.Fatalf("Failed to initialize scratch journal: %v", err)
+	}
+	defer journal.Close()
+	if resumeMode {
+		if err := journal.DiscardDryRuns(); err != nil {
+			logrus.Fatalf("Failed to prepare scratch journal: %v", err)
+		}
+	}
+	cfgJSON, err := json.Marshal(cfg)
+	if err != nil {
+		logrus.Fatalf("Failed to serialize configuration: %v", err)
+	}
+	if _, err := journal.StartRun(version, cfg.Mode(), string(cfgJSON)); err != nil {
+		logrus.Fatalf("Failed to record run in scratch journal: %v", err)
+	}
+
+	startTime := time.Now()
+	cfg.DryRun = true
+	journal.StartBatching(db.DefaultBatchInterval)
+	hashCache := openHashCache(cfg)
+	if hashCache != nil {
+		defer hashCache.Close()
+	}
+	scanner := processor.NewMediaScanner(cfg, journal, hashCache, resumeMode)
+	result := scanner.Scan()
+	entries, err := scanner.BuildPlan()
+	if err != nil {
+		logrus.Fatalf("Failed to build plan: %v", err)
+	}
+	if err := processor.WritePlan(cfg.PlanFile, entries); err != nil {
+		logrus.Fatalf("Failed to write plan: %v", err)
+	}
+
+	logrus.Infof("Plan completed in %s", time.Since(startTime))
+	logScanResult(cfg, result)
+	logrus.Infof("Plan written to %s (%d files)", cfg.PlanFile, len(entries))
+	logrus.Infof("Review or edit it, then run again with --apply %s", cfg.PlanFile)
+}
+
+// runApply organizes the files listed in cfg.ApplyFile as planned.
+func runApply(cfg *config.Config, journal *db.Journal, runID int64) {
+	entries, err := processor.ReadPlan(cfg.ApplyFile)
+	if err != nil {
+		logrus.Fatalf("Failed to read plan %s: %v", cfg.ApplyFile, err)
+	}
+	logrus.Infof("Applying plan %s (%d files) with %d concurrent workers...", cfg.ApplyFile, len(entries), cfg.ConcurrentJobs)
+
+	startTime := time.Now()
+	hashCache := openHashCache(cfg)
+	if hashCache != nil {
+		defer hashCache.Close()
+	}
+	scanner := processor.NewMediaScanner(cfg, journal, hashCache, false)
+	result := scanner.Apply(entries)
+
+	logrus.Infof("Apply completed in %s", time.Since(startTime))
+	logScanResult(cfg, result)
+	logrus.Infof("Refused (changed since planning): %d", result.RefusedFiles)
+	logrus.Infof("Journal database: %s (run #%d)", cfg.DBPath, runID)
+	finishRun(journal, result)
+}
+
+// openHashCache opens the hash cache, or returns nil to hash every file when
+// it is disabled or can't be opened.
+func openHashCache(cfg *config.Config) *db.HashCache {
+	if cfg.HashCache == "" {
+		return nil
+	}
+	cache, err := db.OpenHashCache(cfg.HashCache)
+	if err != nil {
+		logrus.Warnf("Hash cache unavailable, hashing all files: %v", err)
+		return nil
+	}
+	return cache
+}
+
+// runPruneCache forgets the cached hashes of files that no longer exist as
+// they were hashed.
+func runPruneCache(cfg *config.Config) {
+	logrus.Infof("Media Organizer - prune hash cache")
+	if cfg.HashCache == "" {
+		logrus.Fatalf("No hash cache configured")
+	}
+	if _, err := os.Stat(cfg.HashCache); err != nil {
+		logrus.Fatalf("No hash cache found at %s", cfg.HashCache)
+	}
+	cache, err := db.OpenHashCache(cfg.HashCache)
+	if err != nil {
+		logrus.Fatalf("Failed to open hash cache: %v", err)
+	}
+	defer cache.Close()
+
+	logrus.Infof("Hash cache: %s", cfg.HashCache)
+	if cfg.DryRun {
+		logrus.Infof("Running in DRY-RUN mode (no entries will be removed)")
+	}
+	stale, kept, err := cache.Prune(cfg.DryRun)
+	if err != nil {
+		cache.Close()
+		logrus.Fatalf("Failed to prune hash cache: %v", err)
+	}
+	if cfg.DryRun {
+		logrus.Infof("Would remove: %d stale entries", stale)
+	} else {
+		logrus.Infof("Removed: %d stale entries", stale)
+	}
+	logrus.Infof("Kept: %d", kept)
+}
+
+// runMigrate brings the journal schema up to date, which opening the journal
+// does anyway, or lists the migrations that would be applied with --dry-run.
+func runMigrate(cfg *config.Config) {
+	logrus.Infof("Media Organizer - migrate journal")
+	if _, err := os.Stat(cfg.DBPath); err != nil {
+		logrus.Fatalf("No journal database found at %s", cfg.DBPath)
+	}
+	logrus.Infof("Journal: %s", cfg.DBPath)
+	if cfg.DryRun {
+		logrus.Infof("Running in DRY-RUN mode (migrations are rolled back)")
+	}
+	from, applied, err := db.MigrateJournal(cfg.DBPath, cfg.DryRun)
+	if err != nil {
+		logrus.Fatalf("Failed to migrate journal: %v", err)
+	}
+	if len(applied) == 0 {
+		logrus.Infof("Schema is up to date (version %d)", from)
+		return
+	}
+	for _, m := range applied {
+		logrus.Infof("  %d: %s", m.Version, m.Description)
+	}
+	if cfg.DryRun {
+		logrus.Infof("Would upgrade schema from version %d to %d", from, db.SchemaVersion)
+	} else {
+		logrus.Infof("Upgraded schema from version %d to %d", from, db.SchemaVersion)
+	}
+}
+
+// runUndo reverses the completed operations recorded in the journal.
+func runUndo(cfg *config.Config, journal *db.Journal) {
+	logrus.Infof("Media Organizer - undo")
 	logrus.Infof("Journal database: %s", cfg.DBPath)
+	if cfg.DryRun {
+		logrus.Infof("Running in DRY-RUN mode (no files will be moved back)")
+	}
 
-	// Final message to verify program completed
-	logrus.Infof("Program completed successfully")
+	startTime := time.Now()
+	result := processor.NewUndoer(journal, cfg.DryRun).Undo()
 
-	// Check if log file was specified
-	if cfg.LogFile != "" {
-		fmt.Printf("Log file written to: %s\n", cfg.LogFile)
+	logrus.Infof("Undo completed in %s", time.Since(startTime))
+	logrus.Infof("Completed files in journal: %d", result.TotalRecords)
+	if cfg.DryRun {
+		logrus.Infof("Would restore: %d", result.Restored)
+	} else {
+		logrus.Infof("Restored: %d", result.Restored)
+	}
+	logrus.Infof("Conflicts: %d", len(result.Conflicts))
+	for _, c := range result.Conflicts {
+		logrus.Infof("  %s: %s", c.SourcePath, c.Reason)
+	}
+	logrus.Infof("Errors: %d", result.ErrorCount)
+
+	if err := journal.FinishRun(db.RunCounts{
+		TotalFiles:     result.TotalRecords,
+		ProcessedFiles: result.TotalRecords,
+		SkippedFiles:   len(result.Conflicts),
+		OrganizedFiles: result.Restored,
+		ErrorCount:     result.ErrorCount,
+	}); err != nil {
+		logrus.Errorf("Failed to record run results in journal: %v", err)
 	}
 }
@@ -3,13 +3,19 @@ package config
 import (
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+
+	"mediaorganizer/pkg/media"
 )
 
 // OrganizationScheme defines how files are organized in the destination
@@ -20,10 +26,16 @@ const (
 	SchemeExtensionFirst OrganizationScheme = "extension_first"
 	// SchemeDateFirst organizes as: <dest>/YYYY/YYYY-MM/YYYY-MM-DD/<ext>/filename
 	SchemeDateFirst OrganizationScheme = "date_first"
+	// SchemeTemplate organizes as: <dest>/<rendered path_template>
+	SchemeTemplate OrganizationScheme = "template"
 )
 
+// AudioSchemeMusic places tagged audio as
+// <audio-dest>/<AlbumArtist>/<Year - Album>/<NN - Title>.ext
+const AudioSchemeMusic = "music"
+
 // ValidSchemes contains all valid organization scheme values
-var ValidSchemes = []OrganizationScheme{SchemeExtensionFirst, SchemeDateFirst}
+var ValidSchemes = []OrganizationScheme{SchemeExtensionFirst, SchemeDateFirst, SchemeTemplate}
 
 // IsValidScheme checks if the given scheme is valid
 func IsValidScheme(scheme string) bool {
@@ -35,23 +47,332 @@ func IsValidScheme(scheme string) bool {
 	return false
 }
 
+// DuplicatePolicy defines what happens to exact duplicates of organized files
+type DuplicatePolicy string
+
+const (
+	// DuplicateMove moves duplicates into the duplicates directory
+	DuplicateMove DuplicatePolicy = "move"
+	// DuplicateSkip leaves duplicates in the source
+	DuplicateSkip DuplicatePolicy = "skip"
+	// DuplicateDelete removes duplicates from the source once the original is verified
+	DuplicateDelete DuplicatePolicy = "delete"
+	// DuplicateHardlink places a hard link to the original in the duplicates directory
+	DuplicateHardlink DuplicatePolicy = "hardlink"
+	// DuplicateReflink places a copy-on-write clone of the original in the duplicates directory
+	DuplicateReflink DuplicatePolicy = "reflink"
+	// DuplicateReport only records duplicates in the journal
+	DuplicateReport DuplicatePolicy = "report"
+)
+
+// ValidDuplicatePolicies contains all valid duplicate policy values
+var ValidDuplicatePolicies = []DuplicatePolicy{DuplicateMove, DuplicateSkip, DuplicateDelete, DuplicateHardlink, DuplicateReflink, DuplicateReport}
+
+// IsValidDuplicatePolicy checks if the given duplicate policy is valid
+func IsValidDuplicatePolicy(policy string) bool {
+	for _, valid := range ValidDuplicatePolicies {
+		if string(valid) == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// Link modes build the organized tree from links to the source files
+// instead of copies or moved files.
+const (
+	LinkHard    = "hard"
+	LinkReflink = "reflink"
+	LinkSymlink = "symlink"
+)
+
+// ValidLinkModes contains all valid link mode values
+var ValidLinkModes = []string{LinkHard, LinkReflink, LinkSymlink}
+
+// IsValidLinkMode checks if the given link mode is valid
+func IsValidLinkMode(mode string) bool {
+	for _, valid := range ValidLinkModes {
+		if valid == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// Commands of the command line. Without a command, the flags are those of
+// organize, as they were before there were commands.
+const (
+	CommandOrganize = "organize"
+	CommandStatus   = "status"
+	CommandReport   = "report"
+	CommandVerify   = "verify"
+	CommandUndo     = "undo"
+	CommandQuery    = "query"
+	CommandPrune    = "prune-cache"
+	CommandMigrate  = "migrate"
+)
+
+// ValidCommands contains all supported commands.
+var ValidCommands = []string{CommandOrganize, CommandStatus, CommandReport, CommandVerify, CommandUndo, CommandQuery, CommandPrune, CommandMigrate}
+
+// IsValidCommand checks if a command name is valid.
+func IsValidCommand(name string) bool {
+	for _, c := range ValidCommands {
+		if name == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Output formats of the query command.
+const (
+	FormatTable = "table"
+	FormatCSV   = "csv"
+	FormatJSON  = "json"
+	FormatJSONL = "jsonl"
+)
+
+// ValidFormats contains all supported output formats.
+var ValidFormats = []string{FormatTable, FormatCSV, FormatJSON, FormatJSONL}
+
+// QueryOptions are the filters and output format of the query command. Empty
+// fields don't filter.
+type QueryOptions struct {
+	Status    string
+	MediaType string
+	Extension string // lower case, without the dot
+	From      string // first creation date, YYYY-MM-DD
+	To        string // last creation date, YYYY-MM-DD
+	Duplicate *bool  // only duplicates, or only files that are not
+	Hash      string
+	Path      string // glob matched against source and destination paths
+	Format    string
+}
+
+// MediaTypeOverride adds an extension to the media type registry or changes
+// how it is handled. Type is image, video, audio or ignore; Extractor is one
+// of media.ValidExtractors, or empty for the default of the type.
+type MediaTypeOverride struct {
+	Type      string `mapstructure:"type" json:"type"`
+	Extractor string `mapstructure:"extractor" json:"extractor,omitempty"`
+}
+
 type Config struct {
-	SourceDir          string                       `mapstructure:"source"`
-	Destination        string                       `mapstructure:"destination"`
-	DestDirs           map[string]string            `mapstructure:"destinations"`
-	ExtensionDirs      map[string]string            `mapstructure:"extension_destinations"`
-	OrganizationScheme OrganizationScheme           `mapstructure:"organization_scheme"`
-	SpaceReplacement   string                       `mapstructure:"space_replacement"`
-	NoOriginalName     bool                         `mapstructure:"no_original_name"`
-	DuplicatesDir      string                       `mapstructure:"duplicates_dir"`
-	DryRun             bool                         `mapstructure:"dry_run"`
-	Verbose            bool                         `mapstructure:"verbose"`
-	LogFile            string                       `mapstructure:"log_file"`
-	ConcurrentJobs     int                          `mapstructure:"concurrent_jobs"`
-	CopyFiles          bool                         `mapstructure:"copy_files"`
-	DeleteEmptyDirs    bool                         `mapstructure:"delete_empty_dirs"`
-	DBPath             string                       `mapstructure:"db_path"`
-	Fresh              bool                         `mapstructure:"fresh"`
+	SourceDir          string                       `mapstructure:"source" json:"source"`
+	Destination        string                       `mapstructure:"destination" json:"destination"`
+	DestDirs           map[string]string            `mapstructure:"destinations" json:"destinations"`
+	ExtensionDirs      map[string]string            `mapstructure:"extension_destinations" json:"extension_destinations"`
+	OrganizationScheme OrganizationScheme           `mapstructure:"organization_scheme" json:"organization_scheme"`
+	SpaceReplacement   string                       `mapstructure:"space_replacement" json:"space_replacement"`
+	NoOriginalName     bool                         `mapstructure:"no_original_name" json:"no_original_name"`
+	FixExtensions      bool                         `mapstructure:"fix_extensions" json:"fix_extensions"`
+	DuplicatesDir      string                       `mapstructure:"duplicates_dir" json:"duplicates_dir"`
+	DuplicatePolicy    DuplicatePolicy              `mapstructure:"duplicate_policy" json:"duplicate_policy"`
+	NearDuplicates     bool                         `mapstructure:"near_duplicates" json:"near_duplicates"`
+	NearDuplicateDist  int                          `mapstructure:"near_duplicate_distance" json:"near_duplicate_distance"`
+	NearDuplicatesDir  string                       `mapstructure:"near_duplicates_dir" json:"near_duplicates_dir"`
+	DryRun             bool                         `mapstructure:"dry_run" json:"dry_run"`
+	Verbose            bool                         `mapstructure:"verbose" json:"verbose"`
+	LogFile            string                       `mapstructure:"log_file" json:"log_file"`
+	ConcurrentJobs     int                          `mapstructure:"concurrent_jobs" json:"concurrent_jobs"`
+	HashJobs           int                          `mapstructure:"hash_jobs" json:"hash_jobs"`
+	HashCache          string                       `mapstructure:"hash_cache" json:"hash_cache"`
+	NoHashCache        bool                         `mapstructure:"no_hash_cache" json:"no_hash_cache"`
+	CopyFiles          bool                         `mapstructure:"copy_files" json:"copy_files"`
+	Link               string                       `mapstructure:"link" json:"link"`
+	Verify             bool                         `mapstructure:"verify" json:"verify"`
+	QuarantineDir      string                       `mapstructure:"quarantine_dir" json:"quarantine_dir"`
+	DeleteEmptyDirs    bool                         `mapstructure:"delete_empty_dirs" json:"delete_empty_dirs"`
+	DBPath             string                       `mapstructure:"db_path" json:"db_path"`
+	Fresh              bool                         `mapstructure:"fresh" json:"fresh"`
+	PathTemplate       string                       `mapstructure:"path_template" json:"path_template"`
+	AudioScheme        string                       `mapstructure:"audio_scheme" json:"audio_scheme"`
+	DatePrecedence     []string                     `mapstructure:"date_precedence" json:"date_precedence"`
+	NoPairing          bool                         `mapstructure:"no_pairing" json:"no_pairing"`
+	PairSameFolder     bool                         `mapstructure:"pair_same_folder" json:"pair_same_folder"`
+	Watch              bool                         `mapstructure:"watch" json:"watch"`
+	WatchSettle        time.Duration                `mapstructure:"watch_settle" json:"watch_settle"`
+	Include            []string                     `mapstructure:"include" json:"include"`
+	Exclude            []string                     `mapstructure:"exclude" json:"exclude"`
+	MinSize            string                       `mapstructure:"min_size" json:"min_size"`
+	MaxSize            string                       `mapstructure:"max_size" json:"max_size"`
+	MaxDepth           int                          `mapstructure:"max_depth" json:"max_depth"`
+	DateFrom           string                       `mapstructure:"date_from" json:"date_from"`
+	DateTo             string                       `mapstructure:"date_to" json:"date_to"`
+	MediaTypes         map[string]MediaTypeOverride `mapstructure:"media_types" json:"media_types"`
+	Undo               bool                         `mapstructure:"-" json:"undo"` // flag only, never read from a config file
+	PlanFile           string                       `mapstructure:"-" json:"plan"`  // flag only: write a plan instead of organizing
+	ApplyFile          string                       `mapstructure:"-" json:"apply"` // flag only: organize as planned in this file
+
+	// Command is the command given on the command line, CommandOrganize by default.
+	Command string `mapstructure:"-" json:"-"`
+	// ReportRun is the run shown by the report command; 0 means the latest.
+	ReportRun int64 `mapstructure:"-" json:"-"`
+	// Query holds the filters and output format of the query command.
+	Query QueryOptions `mapstructure:"-" json:"-"`
+
+	// Template is the parsed PathTemplate, set by LoadConfig for the template scheme.
+	Template *media.PathTemplate `mapstructure:"-" json:"-"`
+	// DateSources is the parsed DatePrecedence, set by LoadConfig.
+	DateSources []media.DateSource `mapstructure:"-" json:"-"`
+	// Registry is the default media type registry with MediaTypes applied, set by LoadConfig.
+	Registry *media.Registry `mapstructure:"-" json:"-"`
+	// MinSizeBytes and MaxSizeBytes are the parsed MinSize and MaxSize, 0 if unset.
+	MinSizeBytes int64 `mapstructure:"-" json:"-"`
+	MaxSizeBytes int64 `mapstructure:"-" json:"-"`
+}
+
+// Run modes recorded in the journal's runs table.
+const (
+	ModeMove       = "move"
+	ModeCopy       = "copy"
+	ModeLink       = "link"
+	ModeDryRun     = "dry-run"
+	ModeUndo       = "undo"
+	ModeUndoDryRun = "undo-dry-run"
+	ModePlan       = "plan"
+	ModeApply      = "apply"
+)
+
+// Mode returns the run mode implied by the configuration.
+func (c *Config) Mode() string {
+	switch {
+	case c.Undo && c.DryRun:
+		return ModeUndoDryRun
+	case c.Undo:
+		return ModeUndo
+	case c.PlanFile != "":
+		return ModePlan
+	case c.ApplyFile != "":
+		return ModeApply
+	case c.DryRun:
+		return ModeDryRun
+	case c.Link != "":
+		return ModeLink
+	case c.CopyFiles:
+		return ModeCopy
+	default:
+		return ModeMove
+	}
+}
+
+// ReadsJournal reports whether the command only works on the journal of
+// earlier runs, so that it needs no source directory when --db is given.
+func (c *Config) ReadsJournal() bool {
+	switch c.Command {
+	case CommandStatus, CommandReport, CommandVerify, CommandQuery, CommandMigrate:
+		return true
+	}
+	return false
+}
+
+// validate checks the query options and normalizes the extension.
+func (q *QueryOptions) validate() error {
+	if !isValidFormat(q.Format) {
+		return &ConfigError{fmt.Sprintf("invalid output format: %s (valid: %s)", q.Format, strings.Join(ValidFormats, ", "))}
+	}
+	switch media.MediaType(q.MediaType) {
+	case "", media.TypeImage, media.TypeVideo, media.TypeAudio:
+	default:
+		return &ConfigError{fmt.Sprintf("invalid media type: %s (valid: image, video, audio)", q.MediaType)}
+	}
+	for _, date := range []string{q.From, q.To} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return &ConfigError{fmt.Sprintf("invalid date: %s (expected YYYY-MM-DD)", date)}
+		}
+	}
+	if q.From != "" && q.To != "" && q.From > q.To {
+		return &ConfigError{fmt.Sprintf("--from %s is after --to %s", q.From, q.To)}
+	}
+	q.Extension = strings.TrimPrefix(strings.ToLower(q.Extension), ".")
+	return nil
+}
+
+// validateFilters checks the file filters and parses the size limits.
+func (c *Config) validateFilters() error {
+	var err error
+	if c.MinSize != "" {
+		if c.MinSizeBytes, err = parseSize(c.MinSize); err != nil {
+			return err
+		}
+	}
+	if c.MaxSize != "" {
+		if c.MaxSizeBytes, err = parseSize(c.MaxSize); err != nil {
+			return err
+		}
+	}
+	if c.MaxSizeBytes > 0 && c.MinSizeBytes > c.MaxSizeBytes {
+		return &ConfigError{fmt.Sprintf("minimum size %s is larger than maximum size %s", c.MinSize, c.MaxSize)}
+	}
+	if c.MaxDepth < 0 {
+		return &ConfigError{fmt.Sprintf("invalid max depth: %d (must be 1 or more, or 0 for no limit)", c.MaxDepth)}
+	}
+	for _, date := range []string{c.DateFrom, c.DateTo} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return &ConfigError{fmt.Sprintf("invalid date: %s (expected YYYY-MM-DD)", date)}
+		}
+	}
+	if c.DateFrom != "" && c.DateTo != "" && c.DateFrom > c.DateTo {
+		return &ConfigError{fmt.Sprintf("--date-from %s is after --date-to %s", c.DateFrom, c.DateTo)}
+	}
+	return nil
+}
+
+// buildRegistry returns the default media type registry with the overrides
+// from the config file applied.
+func buildRegistry(overrides map[string]MediaTypeOverride) (*media.Registry, error) {
+	registry := media.DefaultRegistry()
+	for ext, o := range overrides {
+		if err := registry.Override(ext, strings.ToLower(o.Type), strings.ToLower(o.Extractor)); err != nil {
+			return nil, &ConfigError{fmt.Sprintf("invalid media type override: %v", err)}
+		}
+	}
+	return registry, nil
+}
+
+// parseSize parses a size such as 500KB, 1.5 GiB or 1000 (bytes).
+func parseSize(size string) (int64, error) {
+	n, err := humanize.ParseBytes(size)
+	if err != nil || n > math.MaxInt64 {
+		return 0, &ConfigError{fmt.Sprintf("invalid size: %s (e.g. 500KB, 1.5GB)", size)}
+	}
+	return int64(n), nil
+}
+
+func isValidFormat(format string) bool {
+	for _, f := range ValidFormats {
+		if format == f {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultHashCachePath returns where the hash cache is kept unless configured
+// otherwise, or "" if the user has no cache directory.
+func DefaultHashCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		logrus.Debugf("No user cache directory, hash cache disabled: %v", err)
+		return ""
+	}
+	return filepath.Join(dir, "mediaorganizer", "hashes.db")
+}
+
+// commandFromArgs returns the command named by the positional arguments left
+// after parsing the flags.
+func commandFromArgs(args []string) (string, error) {
+	if len(args) == 0 {
+		return CommandOrganize, nil
+	}
+	if len(args) > 1 {
+		return "", &ConfigError{fmt.Sprintf("unexpected argument: %s", args[1])}
+	}
+	if !IsValidCommand(args[0]) {
+		return "", &ConfigError{fmt.Sprintf("unknown command: %s (valid: %s)", args[0], strings.Join(ValidCommands, ", "))}
+	}
+	return args[0], nil
 }
 
 func LoadConfig(version string) (*Config, error) {
@@ -65,7 +386,14 @@ func LoadConfig(version string) (*Config, error) {
 		ExtensionDirs:      make(map[string]string),
 		OrganizationScheme: SchemeExtensionFirst,
 		DuplicatesDir:      "duplicates",
+		DuplicatePolicy:    DuplicateMove,
+		NearDuplicateDist:  6,
+		NearDuplicatesDir:  "near_duplicates",
+		QuarantineDir:      "quarantine",
 		ConcurrentJobs:     4,
+		HashJobs:           2,
+		WatchSettle:        3 * time.Second,
+		Query:              QueryOptions{Format: FormatTable},
 	}
 
 	// Set up command line flags
@@ -73,7 +401,7 @@ func LoadConfig(version string) (*Config, error) {
 
 	// Define variables to hold command line values
 	var destFlag, imageDestFlag, videoDestFlag, audioDestFlag string
-	var schemeFlag string
+	var schemeFlag, duplicatePolicyFlag string
 	var showVersion bool
 
 	// Define flags with default values
@@ -85,18 +413,58 @@ func LoadConfig(version string) (*Config, error) {
 	pflag.BoolVarP(&config.DryRun, "dry-run", "d", false, "Simulate the organization process without moving files")
 	pflag.BoolVarP(&config.Verbose, "verbose", "v", false, "Enable verbose logging")
 	pflag.BoolVarP(&config.CopyFiles, "copy", "c", false, "Copy files instead of moving them")
+	pflag.StringVar(&config.Link, "link", "", "Link files into the destination instead of moving them: hard, reflink or symlink (falls back to copy)")
+	pflag.BoolVar(&config.Verify, "verify", false, "Verify each copied file against the checksum of its source before removing the source")
+	pflag.StringVar(&config.QuarantineDir, "quarantine-dir", config.QuarantineDir, "Directory name or path for copies that fail verification")
 	pflag.BoolVar(&config.DeleteEmptyDirs, "delete-empty-dirs", false, "Delete empty folders in source directory after moving files")
+	pflag.BoolVar(&config.Watch, "watch", false, "Keep running and organize new files as they arrive in the source directory")
+	pflag.DurationVar(&config.WatchSettle, "watch-settle", config.WatchSettle, "How long a new file's size and modification time must stay unchanged before it is organized")
+	pflag.StringSliceVar(&config.Include, "include", nil, "Only organize files matching these patterns, e.g. '*.jpg,DCIM/**' (.gitignore syntax)")
+	pflag.StringSliceVar(&config.Exclude, "exclude", nil, "Skip files and folders matching these patterns, e.g. '*.tmp,cache/' (.gitignore syntax)")
+	pflag.StringVar(&config.MinSize, "min-size", "", "Skip files smaller than this size, e.g. 100KB")
+	pflag.StringVar(&config.MaxSize, "max-size", "", "Skip files larger than this size, e.g. 4GB")
+	pflag.IntVar(&config.MaxDepth, "max-depth", 0, "Only organize files this many folders deep in the source; 1 for the source folder itself (default: no limit)")
+	pflag.StringVar(&config.DateFrom, "date-from", "", "Only organize files created on or after this date, YYYY-MM-DD")
+	pflag.StringVar(&config.DateTo, "date-to", "", "Only organize files created on or before this date, YYYY-MM-DD")
 	pflag.StringVarP(&config.LogFile, "log-file", "l", "", "Log file path")
 	pflag.IntVarP(&config.ConcurrentJobs, "jobs", "j", config.ConcurrentJobs, "Number of concurrent processing jobs")
+	pflag.IntVar(&config.HashJobs, "hash-jobs", config.HashJobs, "Number of files hashed concurrently for duplicate detection")
+	pflag.StringVar(&config.HashCache, "hash-cache", "", "Path to the hash cache database shared by all runs (default: <user cache dir>/mediaorganizer/hashes.db)")
+	pflag.BoolVar(&config.NoHashCache, "no-hash-cache", false, "Hash files without consulting or filling the hash cache")
 	pflag.StringVar(&schemeFlag, "scheme", string(config.OrganizationScheme),
 		"Organization scheme:\n"+
 		"  extension_first: <type-dest>/<ext>/YYYY/YYYY-MM/YYYY-MM-DD/file (uses --image-dest, --video-dest, --audio-dest)\n"+
-		"  date_first:      <dest>/YYYY/YYYY-MM/YYYY-MM-DD/<ext>/file (uses --dest for all media types)")
+		"  date_first:      <dest>/YYYY/YYYY-MM/YYYY-MM-DD/<ext>/file (uses --dest for all media types)\n"+
+		"  template:        <dest>/<rendered --template> (uses --dest, or the per-type destinations)")
+	pflag.StringVar(&config.PathTemplate, "template", "", "Path template for the template scheme, e.g. {type}/{yyyy}/{yyyy}-{mm}/{yyyymmdd}-{hhmmss}_{dim}{seq}.{ext}")
+	pflag.StringVar(&config.AudioScheme, "audio-scheme", "", "Layout for tagged audio: music = <audio-dest>/<AlbumArtist>/<Year - Album>/<NN - Title>.ext (default: same as --scheme)")
+	pflag.StringSliceVar(&config.DatePrecedence, "date-precedence", nil, "Order of creation date sources: exif, sidecar, filename, mtime (default: exif,sidecar,filename,mtime)")
+	pflag.BoolVar(&config.NoPairing, "no-pairing", false, "Name RAW+JPEG and Live Photo pairs independently")
+	pflag.BoolVar(&config.PairSameFolder, "pair-same-folder", false, "Put paired files (RAW+JPEG, Live Photo photo+video) in the folder of the one that names the pair")
 	pflag.StringVar(&config.SpaceReplacement, "space-replace", "", "Replace spaces in filenames (default: _ when flag is used)")
 	pflag.BoolVar(&config.NoOriginalName, "no-original-name", false, "Discard original filename, use only timestamp and dimension")
+	pflag.BoolVar(&config.FixExtensions, "fix-extensions", false, "Name mislabeled files after the format of their content")
 	pflag.StringVar(&config.DuplicatesDir, "duplicates-dir", config.DuplicatesDir, "Directory name or path for duplicate files")
+	pflag.StringVar(&duplicatePolicyFlag, "duplicate-policy", string(config.DuplicatePolicy), "What to do with exact duplicates: move, skip, delete, hardlink, reflink or report")
+	pflag.BoolVar(&config.NearDuplicates, "near-duplicates", false, "Detect resized or re-saved copies of images by perceptual hash")
+	pflag.IntVar(&config.NearDuplicateDist, "near-duplicate-distance", config.NearDuplicateDist, "Maximum perceptual hash distance (0-64 bits) between near-duplicates")
+	pflag.StringVar(&config.NearDuplicatesDir, "near-duplicates-dir", config.NearDuplicatesDir, "Directory name or path for near-duplicates to review")
 	pflag.StringVar(&config.DBPath, "db", "", "Path to SQLite journal database (default: <source>/.mediaorganizer.db)")
 	pflag.BoolVar(&config.Fresh, "fresh", false, "Force a fresh start, ignore existing database")
+	pflag.BoolVar(&config.Undo, "undo", false, "Reverse a previous run using the journal (combine with --dry-run to preview)")
+	pflag.StringVar(&config.PlanFile, "plan", "", "Write what would be done to a plan file (.csv for CSV, JSON Lines otherwise) without touching any file")
+	pflag.StringVar(&config.ApplyFile, "apply", "", "Organize files exactly as listed in a plan file, refusing files changed since planning")
+	pflag.Int64Var(&config.ReportRun, "run", 0, "Run shown by the report command (default: the latest)")
+	var duplicateFlag bool
+	pflag.StringVar(&config.Query.Status, "status", "", "Only list files with this status (query command)")
+	pflag.StringVar(&config.Query.MediaType, "type", "", "Only list files of this media type: image, video or audio (query command)")
+	pflag.StringVar(&config.Query.Extension, "ext", "", "Only list files with this extension (query command)")
+	pflag.StringVar(&config.Query.From, "from", "", "Only list files created on or after this date, YYYY-MM-DD (query command)")
+	pflag.StringVar(&config.Query.To, "to", "", "Only list files created on or before this date, YYYY-MM-DD (query command)")
+	pflag.BoolVar(&duplicateFlag, "duplicate", false, "Only list duplicates, or with --duplicate=false only files that are not (query command)")
+	pflag.StringVar(&config.Query.Hash, "hash", "", "Only list files with this content hash (query command)")
+	pflag.StringVar(&config.Query.Path, "path", "", "Only list files whose source or destination matches this glob (query command)")
+	pflag.StringVar(&config.Query.Format, "format", config.Query.Format, "Output format of the query command: table, csv, json or jsonl")
 	pflag.BoolVar(&showVersion, "version", false, "Show version and exit")
 
 	configFile := pflag.String("config", "", "Path to configuration file (YAML/JSON)")
@@ -110,7 +478,18 @@ func LoadConfig(version string) (*Config, error) {
 Organize media files by date using EXIF/ffprobe metadata.
 
 Usage:
-  mediaorganizer -s <source> [options]
+  mediaorganizer [organize] -s <source> [options]
+  mediaorganizer <command> -s <source> | --db <path> [options]
+
+Commands:
+  organize                     Organize media files (the default without a command)
+  status                       Show journal counts by status, media type and run
+  report                       List what a run did to each file (default: the latest run)
+  verify                       Check that organized files are still in place and intact
+  undo                         Move organized files back to their source paths
+  query                        List the files in the journal
+  prune-cache                  Forget cached hashes of files that were deleted or changed
+  migrate                      Upgrade the journal schema (--dry-run lists pending migrations)
 
 Source & Destinations:
   -s, --source <path>          Source directory to scan (required)
@@ -120,23 +499,68 @@ Source & Destinations:
       --audio-dest <path>      Audio destination (default: ./output/audio)
 
 Organization:
-      --scheme <scheme>        extension_first (default) or date_first
+      --scheme <scheme>        extension_first (default), date_first or template
+      --template <format>      Path template for the template scheme
+      --audio-scheme music     Organize tagged audio by artist and album
+      --date-precedence <list> Date sources in order (default: exif,sidecar,filename,mtime)
+      --no-pairing             Name RAW+JPEG and Live Photo pairs independently
+      --pair-same-folder       Keep paired files together, e.g. a Live Photo's video with its photo
       --duplicates-dir <name>  Directory for duplicates (default: duplicates)
+      --duplicate-policy <p>   Duplicates: move, skip, delete, hardlink, reflink, report (default: move)
+      --near-duplicates        Set aside resized/re-saved copies of images for review
+      --near-duplicate-distance <n>
+                               Max perceptual hash distance in bits (default: 6)
+      --near-duplicates-dir <name>
+                               Directory for near-duplicates (default: near_duplicates)
       --space-replace[=<ch>]   Replace spaces in filenames (default: _ when used)
       --no-original-name       Discard original filename in output
+      --fix-extensions         Give mislabeled files the extension of their content
 
 Operation Mode:
   -d, --dry-run                Preview changes without moving/copying files
   -c, --copy                   Copy files instead of moving them
+      --link <mode>            Link files instead: hard, reflink or symlink (copy if impossible)
+      --verify                 Checksum each copy before removing its source
+      --quarantine-dir <name>  Directory for copies failing verification (default: quarantine)
       --delete-empty-dirs      Remove empty source folders after moving
+      --watch                  Keep running, organizing new files as they arrive
+      --watch-settle <d>       Wait until a new file is unchanged this long (default: 3s)
+
+Filters:
+      --include <patterns>     Only files matching these patterns, e.g. "*.jpg,DCIM/**"
+      --exclude <patterns>     Skip files and folders matching these patterns, e.g. "*.tmp,cache/"
+      --min-size <size>        Skip files smaller than this, e.g. 100KB
+      --max-size <size>        Skip files larger than this, e.g. 4GB
+      --max-depth <n>          Only files up to n folders deep (1: the source folder itself)
+      --date-from <date>       Only files created on or after YYYY-MM-DD
+      --date-to <date>         Only files created on or before YYYY-MM-DD
+  Patterns use .gitignore syntax, as do .mediaignore files in the source folders.
 
 Database & Resume:
       --db <path>              SQLite journal path (default: <source>/.mediaorganizer.db)
       --fresh                  Ignore existing database, start fresh
+      --undo                   Move completed files back to their source paths
+      --plan <file>            Write a reviewable plan (.csv or JSON Lines) instead of organizing
+      --apply <file>           Execute a plan, refusing files changed since it was made
+      --run <id>               Run to report on (report; default: the latest)
+
+Query Filters:
+      --status <status>        Status: pending, completed, failed, skipped, quarantined, ...
+      --type <type>            Media type: image, video or audio
+      --ext <ext>              Extension, e.g. jpg
+      --from <date>            Created on or after YYYY-MM-DD
+      --to <date>              Created on or before YYYY-MM-DD
+      --duplicate[=false]      Only duplicates (or only non-duplicates)
+      --hash <hash>            Content hash
+      --path <glob>            Source or destination path, e.g. "*/2019/*" (* spans folders)
+      --format <format>        table (default), csv, json or jsonl
 
 General:
       --config <path>          Load settings from YAML/JSON config file
   -j, --jobs <n>               Concurrent workers (default: 4)
+      --hash-jobs <n>          Files hashed concurrently for duplicate detection (default: 2)
+      --hash-cache <path>      Hash cache shared by all runs (default: in the user cache dir)
+      --no-hash-cache          Don't use the hash cache
   -l, --log-file <path>        Write logs to file
   -v, --verbose                Enable debug logging
       --version                Show version and exit
@@ -144,11 +568,23 @@ General:
 Organization Schemes:
   extension_first   <type-dest>/<ext>/YYYY/YYYY-MM/YYYY-MM-DD/file
   date_first        <dest>/YYYY/YYYY-MM/YYYY-MM-DD/<ext>/file
-`, version)
+  template          <dest>/<template>, e.g. --template "{yyyy}/{camera_model}/{timestamp}{seq}.{ext}"
+  --audio-scheme music  <audio-dest>/<AlbumArtist>/<Year - Album>/<NN - Title>.ext
+                        (audio without artist and album/title tags keeps --scheme)
+
+Template Tokens:
+  %s
+`, version, strings.Join(media.TemplateTokens(), " "))
 	}
 
 	pflag.Parse()
 
+	command, err := commandFromArgs(pflag.Args())
+	if err != nil {
+		return nil, err
+	}
+	config.Command = command
+
 	// Handle --version
 	if showVersion {
 		fmt.Printf("mediaorganizer %s\n", version)
@@ -218,14 +654,78 @@ Organization Schemes:
 		}
 	}
 
+	if pflag.Lookup("hash-jobs").Changed {
+		config.HashJobs, _ = pflag.CommandLine.GetInt("hash-jobs")
+	}
+
+	if pflag.Lookup("hash-cache").Changed {
+		config.HashCache = pflag.Lookup("hash-cache").Value.String()
+	}
+
+	if pflag.Lookup("no-hash-cache").Changed {
+		config.NoHashCache = pflag.Lookup("no-hash-cache").Value.String() == "true"
+	}
+
 	if pflag.Lookup("scheme").Changed {
 		config.OrganizationScheme = OrganizationScheme(schemeFlag)
 	}
 
+	if pflag.Lookup("template").Changed {
+		config.PathTemplate = pflag.Lookup("template").Value.String()
+	}
+
+	if pflag.Lookup("audio-scheme").Changed {
+		config.AudioScheme = pflag.Lookup("audio-scheme").Value.String()
+	}
+
+	if pflag.Lookup("date-precedence").Changed {
+		config.DatePrecedence, _ = pflag.CommandLine.GetStringSlice("date-precedence")
+	}
+
+	if pflag.Lookup("no-pairing").Changed {
+		config.NoPairing = pflag.Lookup("no-pairing").Value.String() == "true"
+	}
+
+	if pflag.Lookup("pair-same-folder").Changed {
+		config.PairSameFolder = pflag.Lookup("pair-same-folder").Value.String() == "true"
+	}
+
+	if pflag.Lookup("fix-extensions").Changed {
+		config.FixExtensions = pflag.Lookup("fix-extensions").Value.String() == "true"
+	}
+
 	if pflag.Lookup("duplicates-dir").Changed {
 		config.DuplicatesDir = pflag.Lookup("duplicates-dir").Value.String()
 	}
 
+	if pflag.Lookup("link").Changed {
+		config.Link = pflag.Lookup("link").Value.String()
+	}
+
+	if pflag.Lookup("verify").Changed {
+		config.Verify = pflag.Lookup("verify").Value.String() == "true"
+	}
+
+	if pflag.Lookup("quarantine-dir").Changed {
+		config.QuarantineDir = pflag.Lookup("quarantine-dir").Value.String()
+	}
+
+	if pflag.Lookup("duplicate-policy").Changed {
+		config.DuplicatePolicy = DuplicatePolicy(duplicatePolicyFlag)
+	}
+
+	if pflag.Lookup("near-duplicates").Changed {
+		config.NearDuplicates = pflag.Lookup("near-duplicates").Value.String() == "true"
+	}
+
+	if pflag.Lookup("near-duplicate-distance").Changed {
+		config.NearDuplicateDist, _ = pflag.CommandLine.GetInt("near-duplicate-distance")
+	}
+
+	if pflag.Lookup("near-duplicates-dir").Changed {
+		config.NearDuplicatesDir = pflag.Lookup("near-duplicates-dir").Value.String()
+	}
+
 	if pflag.Lookup("db").Changed {
 		config.DBPath = pflag.Lookup("db").Value.String()
 	}
@@ -234,21 +734,171 @@ Organization Schemes:
 		config.Fresh = pflag.Lookup("fresh").Value.String() == "true"
 	}
 
+	if pflag.Lookup("watch").Changed {
+		config.Watch = pflag.Lookup("watch").Value.String() == "true"
+	}
+
+	if pflag.Lookup("watch-settle").Changed {
+		config.WatchSettle, _ = pflag.CommandLine.GetDuration("watch-settle")
+	}
+
+	if pflag.Lookup("include").Changed {
+		config.Include, _ = pflag.CommandLine.GetStringSlice("include")
+	}
+
+	if pflag.Lookup("exclude").Changed {
+		config.Exclude, _ = pflag.CommandLine.GetStringSlice("exclude")
+	}
+
+	if pflag.Lookup("min-size").Changed {
+		config.MinSize = pflag.Lookup("min-size").Value.String()
+	}
+
+	if pflag.Lookup("max-size").Changed {
+		config.MaxSize = pflag.Lookup("max-size").Value.String()
+	}
+
+	if pflag.Lookup("max-depth").Changed {
+		config.MaxDepth, _ = pflag.CommandLine.GetInt("max-depth")
+	}
+
+	if pflag.Lookup("date-from").Changed {
+		config.DateFrom = pflag.Lookup("date-from").Value.String()
+	}
+
+	if pflag.Lookup("date-to").Changed {
+		config.DateTo = pflag.Lookup("date-to").Value.String()
+	}
+
+	// --undo predates the undo command and stays an alias for it
+	if config.Undo && config.Command != CommandOrganize && config.Command != CommandUndo {
+		return nil, &ConfigError{fmt.Sprintf("--undo cannot be combined with the %s command", config.Command)}
+	}
+	if config.Command == CommandUndo {
+		config.Undo = true
+	} else if config.Undo {
+		config.Command = CommandUndo
+	}
+	if (config.PlanFile != "" || config.ApplyFile != "") && config.Command != CommandOrganize {
+		return nil, &ConfigError{fmt.Sprintf("--plan and --apply cannot be combined with the %s command", config.Command)}
+	}
+	if config.ReportRun != 0 && config.Command != CommandReport {
+		return nil, &ConfigError{"--run only applies to the report command"}
+	}
+	for _, name := range []string{"status", "type", "ext", "from", "to", "duplicate", "hash", "path", "format"} {
+		if pflag.Lookup(name).Changed && config.Command != CommandQuery {
+			return nil, &ConfigError{fmt.Sprintf("--%s only applies to the query command", name)}
+		}
+	}
+	if pflag.Lookup("duplicate").Changed {
+		config.Query.Duplicate = &duplicateFlag
+	}
+	if err := config.Query.validate(); err != nil {
+		return nil, err
+	}
+
+	if config.Undo && config.Fresh {
+		return nil, &ConfigError{"--undo cannot be combined with --fresh"}
+	}
+	if config.PlanFile != "" && config.ApplyFile != "" {
+		return nil, &ConfigError{"--plan cannot be combined with --apply"}
+	}
+	if config.Undo && (config.PlanFile != "" || config.ApplyFile != "") {
+		return nil, &ConfigError{"--undo cannot be combined with --plan or --apply"}
+	}
+	if config.Watch && config.Command != CommandOrganize {
+		return nil, &ConfigError{fmt.Sprintf("--watch cannot be combined with the %s command", config.Command)}
+	}
+	if config.Watch && (config.PlanFile != "" || config.ApplyFile != "") {
+		return nil, &ConfigError{"--watch cannot be combined with --plan or --apply"}
+	}
+	if config.Watch && config.WatchSettle <= 0 {
+		return nil, &ConfigError{fmt.Sprintf("invalid watch settle time: %s (must be positive)", config.WatchSettle)}
+	}
+	if config.ApplyFile != "" && config.DryRun {
+		return nil, &ConfigError{"--apply cannot be combined with --dry-run; use --plan to preview"}
+	}
+
+	if config.Command == CommandPrune && config.NoHashCache {
+		return nil, &ConfigError{"--no-hash-cache cannot be combined with the prune-cache command"}
+	}
+
 	// Validate config
-	if config.SourceDir == "" {
+	if config.SourceDir == "" && config.Command != CommandPrune && (!config.ReadsJournal() || config.DBPath == "") {
+		if config.ReadsJournal() {
+			return nil, &ConfigError{fmt.Sprintf("source directory or --db is required for the %s command", config.Command)}
+		}
 		return nil, &ConfigError{"source directory is required"}
 	}
 
 	if !IsValidScheme(string(config.OrganizationScheme)) {
-		return nil, &ConfigError{fmt.Sprintf("invalid organization scheme: %s (valid: extension_first, date_first)", config.OrganizationScheme)}
+		return nil, &ConfigError{fmt.Sprintf("invalid organization scheme: %s (valid: extension_first, date_first, template)", config.OrganizationScheme)}
 	}
 
-	// Convert relative paths to absolute paths
-	var err error
-	config.SourceDir, err = filepath.Abs(config.SourceDir)
+	// Validate the template up front so an unknown token fails before any file moves
+	if config.OrganizationScheme == SchemeTemplate {
+		tpl, err := media.ParseTemplate(config.PathTemplate)
+		if err != nil {
+			return nil, &ConfigError{fmt.Sprintf("invalid path template: %v", err)}
+		}
+		config.Template = tpl
+	}
+
+	if config.AudioScheme != "" && config.AudioScheme != AudioSchemeMusic {
+		return nil, &ConfigError{fmt.Sprintf("invalid audio scheme: %s (valid: music)", config.AudioScheme)}
+	}
+
+	if !IsValidDuplicatePolicy(string(config.DuplicatePolicy)) {
+		return nil, &ConfigError{fmt.Sprintf("invalid duplicate policy: %s (valid: move, skip, delete, hardlink, reflink, report)", config.DuplicatePolicy)}
+	}
+	if config.Link != "" && !IsValidLinkMode(config.Link) {
+		return nil, &ConfigError{fmt.Sprintf("invalid link mode: %s (valid: hard, reflink, symlink)", config.Link)}
+	}
+	if config.Link != "" && config.CopyFiles {
+		return nil, &ConfigError{"--link cannot be combined with --copy"}
+	}
+	if config.DuplicatePolicy == DuplicateDelete && (config.CopyFiles || config.Link != "") {
+		return nil, &ConfigError{"duplicate policy delete cannot be combined with --copy or --link, which never touch the source"}
+	}
+
+	if config.Verify && config.QuarantineDir == "" {
+		return nil, &ConfigError{"quarantine directory cannot be empty"}
+	}
+
+	if config.HashJobs < 1 {
+		return nil, &ConfigError{fmt.Sprintf("invalid number of hash jobs: %d (must be at least 1)", config.HashJobs)}
+	}
+
+	if config.NearDuplicateDist < 0 || config.NearDuplicateDist > 64 {
+		return nil, &ConfigError{fmt.Sprintf("invalid near-duplicate distance: %d (valid: 0-64)", config.NearDuplicateDist)}
+	}
+	if config.NearDuplicates && config.NearDuplicatesDir == "" {
+		return nil, &ConfigError{"near-duplicates directory cannot be empty"}
+	}
+
+	if err := config.validateFilters(); err != nil {
+		return nil, err
+	}
+
+	registry, err := buildRegistry(config.MediaTypes)
 	if err != nil {
 		return nil, err
 	}
+	config.Registry = registry
+
+	dateSources, err := media.ParseDatePrecedence(config.DatePrecedence)
+	if err != nil {
+		return nil, &ConfigError{fmt.Sprintf("invalid date precedence: %v", err)}
+	}
+	config.DateSources = dateSources
+
+	// Convert relative paths to absolute paths
+	if config.SourceDir != "" {
+		config.SourceDir, err = filepath.Abs(config.SourceDir)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// Default DBPath to <source>/.mediaorganizer.db
 	if config.DBPath == "" {
@@ -260,6 +910,18 @@ Organization Schemes:
 		}
 	}
 
+	// Default HashCache to the user's cache directory, shared by all sources
+	if config.NoHashCache {
+		config.HashCache = ""
+	} else if config.HashCache == "" {
+		config.HashCache = DefaultHashCachePath()
+	} else {
+		config.HashCache, err = filepath.Abs(config.HashCache)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if config.Destination != "" {
 		config.Destination, err = filepath.Abs(config.Destination)
 		if err != nil {
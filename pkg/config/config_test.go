@@ -2,6 +2,8 @@ package config
 
 import (
 	"testing"
+
+	"mediaorganizer/pkg/media"
 )
 
 func TestIsValidScheme(t *testing.T) {
@@ -12,6 +14,7 @@ func TestIsValidScheme(t *testing.T) {
 	}{
 		{"extension_first is valid", "extension_first", true},
 		{"date_first is valid", "date_first", true},
+		{"template is valid", "template", true},
 		{"empty string is invalid", "", false},
 		{"random string is invalid", "random", false},
 		{"similar but wrong is invalid", "date-first", false},
@@ -37,10 +40,13 @@ func TestOrganizationSchemeConstants(t *testing.T) {
 	if SchemeDateFirst != "date_first" {
 		t.Errorf("SchemeDateFirst = %q, want %q", SchemeDateFirst, "date_first")
 	}
+	if SchemeTemplate != "template" {
+		t.Errorf("SchemeTemplate = %q, want %q", SchemeTemplate, "template")
+	}
 }
 
 func TestValidSchemesContainsAllSchemes(t *testing.T) {
-	expectedSchemes := []OrganizationScheme{SchemeExtensionFirst, SchemeDateFirst}
+	expectedSchemes := []OrganizationScheme{SchemeExtensionFirst, SchemeDateFirst, SchemeTemplate}
 
 	if len(ValidSchemes) != len(expectedSchemes) {
 		t.Errorf("ValidSchemes has %d elements, want %d", len(ValidSchemes), len(expectedSchemes))
@@ -59,3 +65,179 @@ func TestValidSchemesContainsAllSchemes(t *testing.T) {
 		}
 	}
 }
+
+func TestConfigMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		expected string
+	}{
+		{"default is move", Config{}, ModeMove},
+		{"copy", Config{CopyFiles: true}, ModeCopy},
+		{"link", Config{Link: LinkHard}, ModeLink},
+		{"dry run wins over link", Config{DryRun: true, Link: LinkSymlink}, ModeDryRun},
+		{"dry run wins over copy", Config{DryRun: true, CopyFiles: true}, ModeDryRun},
+		{"undo", Config{Undo: true}, ModeUndo},
+		{"undo dry run", Config{Undo: true, DryRun: true}, ModeUndoDryRun},
+		{"plan wins over dry run", Config{PlanFile: "plan.jsonl", DryRun: true}, ModePlan},
+		{"apply", Config{ApplyFile: "plan.csv", CopyFiles: true}, ModeApply},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.Mode(); got != tt.expected {
+				t.Errorf("Mode() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsValidDuplicatePolicy(t *testing.T) {
+	for _, policy := range ValidDuplicatePolicies {
+		if !IsValidDuplicatePolicy(string(policy)) {
+			t.Errorf("IsValidDuplicatePolicy(%q) = false, want true", policy)
+		}
+	}
+	for _, policy := range []string{"", "ignore", "hard-link", "MOVE"} {
+		if IsValidDuplicatePolicy(policy) {
+			t.Errorf("IsValidDuplicatePolicy(%q) = true, want false", policy)
+		}
+	}
+}
+
+func TestIsValidLinkMode(t *testing.T) {
+	for _, mode := range []string{"hard", "reflink", "symlink"} {
+		if !IsValidLinkMode(mode) {
+			t.Errorf("IsValidLinkMode(%q) = false, want true", mode)
+		}
+	}
+	for _, mode := range []string{"", "soft", "hardlink", "copy"} {
+		if IsValidLinkMode(mode) {
+			t.Errorf("IsValidLinkMode(%q) = true, want false", mode)
+		}
+	}
+}
+
+func TestCommandFromArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{nil, CommandOrganize, false},
+		{[]string{"organize"}, CommandOrganize, false},
+		{[]string{"status"}, CommandStatus, false},
+		{[]string{"undo"}, CommandUndo, false},
+		{[]string{"query"}, CommandQuery, false},
+		{[]string{"prune-cache"}, CommandPrune, false},
+		{[]string{"migrate"}, CommandMigrate, false},
+		{[]string{"stats"}, "", true},
+		{[]string{"status", "extra"}, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := commandFromArgs(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("commandFromArgs(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("commandFromArgs(%v) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestReadsJournal(t *testing.T) {
+	for _, command := range ValidCommands {
+		want := command != CommandOrganize && command != CommandUndo && command != CommandPrune
+		if got := (&Config{Command: command}).ReadsJournal(); got != want {
+			t.Errorf("ReadsJournal() for %s = %v, want %v", command, got, want)
+		}
+	}
+}
+
+func TestQueryOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       QueryOptions
+		wantErr bool
+	}{
+		{"defaults", QueryOptions{Format: FormatTable}, false},
+		{"all formats", QueryOptions{Format: FormatJSONL}, false},
+		{"unknown format", QueryOptions{Format: "xml"}, true},
+		{"media type", QueryOptions{Format: FormatCSV, MediaType: "video"}, false},
+		{"unknown media type", QueryOptions{Format: FormatCSV, MediaType: "document"}, true},
+		{"date range", QueryOptions{Format: FormatJSON, From: "1970-01-01", To: "1970-12-31"}, false},
+		{"bad date", QueryOptions{Format: FormatJSON, From: "1970"}, true},
+		{"reversed range", QueryOptions{Format: FormatJSON, From: "2020-01-01", To: "2019-12-31"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.q.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	q := QueryOptions{Format: FormatTable, Extension: ".JPG"}
+	if err := q.validate(); err != nil || q.Extension != "jpg" {
+		t.Errorf("validate() extension = %q, %v; want jpg", q.Extension, err)
+	}
+}
+
+func TestValidateFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       Config
+		wantErr bool
+	}{
+		{"none", Config{}, false},
+		{"sizes", Config{MinSize: "100KB", MaxSize: "1.5 GB"}, false},
+		{"bytes", Config{MinSize: "1000"}, false},
+		{"bad size", Config{MinSize: "lots"}, true},
+		{"reversed sizes", Config{MinSize: "2MB", MaxSize: "1MB"}, true},
+		{"depth", Config{MaxDepth: 2}, false},
+		{"negative depth", Config{MaxDepth: -1}, true},
+		{"date range", Config{DateFrom: "2019-01-01", DateTo: "2019-12-31"}, false},
+		{"bad date", Config{DateTo: "31/12/2019"}, true},
+		{"reversed range", Config{DateFrom: "2020-01-01", DateTo: "2019-12-31"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.validateFilters(); (err != nil) != tt.wantErr {
+				t.Errorf("validateFilters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	c := Config{MinSize: "100KB", MaxSize: "1MiB"}
+	if err := c.validateFilters(); err != nil || c.MinSizeBytes != 100000 || c.MaxSizeBytes != 1<<20 {
+		t.Errorf("validateFilters() sizes = %d, %d, %v; want 100000, %d", c.MinSizeBytes, c.MaxSizeBytes, err, 1<<20)
+	}
+}
+
+func TestBuildRegistry(t *testing.T) {
+	registry, err := buildRegistry(map[string]MediaTypeOverride{
+		".NRW": {Type: "Image"},
+		"mts":  {Type: "video", Extractor: "none"},
+		"wav":  {Type: "ignore"},
+	})
+	if err != nil {
+		t.Fatalf("buildRegistry() error: %v", err)
+	}
+	if f, ok := registry.Lookup("nrw"); !ok || f.Type != media.TypeImage {
+		t.Errorf("nrw = %+v, %v; want an image", f, ok)
+	}
+	if f, _ := registry.Lookup("mts"); f.Extractor != media.ExtractorNone {
+		t.Errorf("mts extractor = %q, want none", f.Extractor)
+	}
+	if _, ok := registry.Lookup("wav"); ok {
+		t.Error("wav still registered after ignore")
+	}
+
+	if _, err := buildRegistry(map[string]MediaTypeOverride{"psd": {Type: "document"}}); err == nil {
+		t.Error("buildRegistry() accepted an unknown media type")
+	}
+}
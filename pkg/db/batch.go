@@ -0,0 +1,251 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Without batching, every journal statement is a transaction of its own,
+// and committing dominates a large import. While batching, the journal runs
+// on a single connection with an open transaction, committed every interval,
+// after maxBatchWrites writes and whenever Sync is called. All statements see
+// the writes before them, committed or not, as they share the connection.
+//
+// A crash loses the writes since the last commit, so callers must Sync
+// before acting on what the journal says they are about to do, e.g. before
+// moving a file to the destination recorded for it. Resume then finds every
+// file that may have been touched in the state it had before, as it would
+// after a crash without batching.
+//
+// The single connection is also why reads never leave rows open: a query
+// holds the connection until its rows are closed, and every other statement
+// waits for it meanwhile, writes and commits included. Methods therefore
+// read all rows before returning, and nothing is called on the journal while
+// rows are open; a write there would wait for itself forever.
+
+// DefaultBatchInterval is how long journal writes wait to be committed at
+// most, unless Sync is called.
+const DefaultBatchInterval = time.Second
+
+// maxBatchWrites bounds the writes committed at once, so that a busy run
+// doesn't grow the write-ahead log without limit between commits.
+const maxBatchWrites = 1000
+
+// batch is the open transaction of a batching journal.
+type batch struct {
+	mu     sync.Mutex // held by writes and commits, so a commit never splits a write
+	open   bool       // a transaction is open on the connection
+	writes int        // writes since it began
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// StartBatching groups the journal's writes into transactions committed at
+// least every interval. It must be called before the journal is shared
+// between goroutines. From then on the journal has a single connection, so
+// rows read with query must be closed before any other statement runs.
+func (j *Journal) StartBatching(interval time.Duration) {
+	if j.batch.Load() != nil {
+		return
+	}
+	// Statements outside the transaction wouldn't see its writes
+	j.db.SetMaxOpenConns(1)
+	b := &batch{stop: make(chan struct{}), done: make(chan struct{})}
+	j.batch.Store(b)
+
+	go func() {
+		defer close(b.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.Sync()
+			case <-b.stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopBatching commits the open transaction and goes back to committing
+// every statement on its own. It must not be called concurrently with other
+// methods; Sync is what may be.
+func (j *Journal) StopBatching() error {
+	b := j.batch.Load()
+	if b == nil {
+		return nil
+	}
+	close(b.stop)
+	<-b.done
+	err := j.Sync()
+	j.batch.Store(nil)
+	j.db.SetMaxOpenConns(0)
+	return err
+}
+
+// Sync commits the writes made so far. It is a no-op without batching. It
+// may be called at any time, while other goroutines write, e.g. before
+// exiting on a signal.
+func (j *Journal) Sync() error {
+	b := j.batch.Load()
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return j.commitLocked()
+}
+
+// beginLocked opens a transaction if none is open. b.mu must be held.
+func (j *Journal) beginLocked() error {
+	b := j.batch.Load()
+	if b.open {
+		return nil
+	}
+	if _, err := j.db.Exec(`BEGIN`); err != nil {
+		return fmt.Errorf("begin batch: %w", err)
+	}
+	b.open = true
+	return nil
+}
+
+// commitLocked commits the open transaction, if any. b.mu must be held.
+func (j *Journal) commitLocked() error {
+	b := j.batch.Load()
+	if !b.open {
+		return nil
+	}
+	b.open, b.writes = false, 0
+	if _, err := j.db.Exec(`COMMIT`); err != nil {
+		// Don't leave it open for the next batch; its writes are lost, and
+		// nothing was done on their account without a successful Sync
+		j.db.Exec(`ROLLBACK`)
+		return fmt.Errorf("commit batch: %w", err)
+	}
+	return nil
+}
+
+// prepare returns the prepared statement of a query, preparing it once.
+func (j *Journal) prepare(query string) (*sql.Stmt, error) {
+	j.stmtMu.Lock()
+	defer j.stmtMu.Unlock()
+	if stmt, ok := j.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := j.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if j.stmts == nil {
+		j.stmts = make(map[string]*sql.Stmt)
+	}
+	j.stmts[query] = stmt
+	return stmt, nil
+}
+
+// exec runs a statement that writes, in the open batch if batching.
+func (j *Journal) exec(query string, args ...any) (sql.Result, error) {
+	stmt, err := j.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	b := j.batch.Load()
+	if b == nil {
+		return stmt.Exec(args...)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := j.beginLocked(); err != nil {
+		return nil, err
+	}
+	res, err := stmt.Exec(args...)
+	if b.writes++; b.writes >= maxBatchWrites {
+		if cerr := j.commitLocked(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return res, err
+}
+
+// query runs a statement that reads rows. While batching, the rows hold the
+// only connection: close them before running any other statement.
+func (j *Journal) query(query string, args ...any) (*sql.Rows, error) {
+	stmt, err := j.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Query(args...)
+}
+
+// queryRow runs a statement that reads one row.
+func (j *Journal) queryRow(query string, args ...any) *sql.Row {
+	stmt, err := j.prepare(query)
+	if err != nil {
+		// Let Scan report the error like any other
+		return j.db.QueryRow(query, args...)
+	}
+	return stmt.QueryRow(args...)
+}
+
+// transaction runs fn with an exec function whose writes are committed
+// together: in a transaction of their own, or within the open batch.
+func (j *Journal) transaction(fn func(exec func(query string, args ...any) (sql.Result, error)) error) error {
+	b := j.batch.Load()
+	if b == nil {
+		tx, err := j.db.Begin()
+		if err != nil {
+			return fmt.Errorf("begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+		err = fn(func(query string, args ...any) (sql.Result, error) {
+			stmt, err := j.prepare(query)
+			if err != nil {
+				return nil, err
+			}
+			return tx.Stmt(stmt).Exec(args...)
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	// Holding the lock keeps other writes out of the savepoint
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := j.beginLocked(); err != nil {
+		return err
+	}
+	if _, err := j.db.Exec(`SAVEPOINT batch_transaction`); err != nil {
+		return err
+	}
+	err := fn(func(query string, args ...any) (sql.Result, error) {
+		stmt, err := j.prepare(query)
+		if err != nil {
+			return nil, err
+		}
+		b.writes++
+		return stmt.Exec(args...)
+	})
+	if err != nil {
+		j.db.Exec(`ROLLBACK TO batch_transaction`)
+	}
+	if _, rerr := j.db.Exec(`RELEASE batch_transaction`); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// closeStatements closes the prepared statements.
+func (j *Journal) closeStatements() {
+	j.stmtMu.Lock()
+	defer j.stmtMu.Unlock()
+	for _, stmt := range j.stmts {
+		stmt.Close()
+	}
+	j.stmts = nil
+}
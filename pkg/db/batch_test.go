@@ -0,0 +1,222 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchingCommitsOnSync(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	j, err := InitJournal(dbPath)
+	if err != nil {
+		t.Fatalf("InitJournal: %v", err)
+	}
+	defer j.Close()
+	other, err := InitJournal(dbPath)
+	if err != nil {
+		t.Fatalf("InitJournal: %v", err)
+	}
+	defer other.Close()
+
+	j.StartBatching(time.Hour)
+	id, err := j.InsertFile(sampleRecord("/tmp/a.jpg"))
+	if err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	if _, err := j.InsertFile(sampleRecord("/tmp/a.jpg")); err != ErrAlreadyExists {
+		t.Fatalf("second InsertFile error = %v, want ErrAlreadyExists", err)
+	}
+	j.UpdateDestPath(id, "/dest/a.jpg", 0, false)
+
+	// The batch sees its own writes; other connections only once committed
+	if dest, err := j.GetDestPath(id); err != nil || dest != "/dest/a.jpg" {
+		t.Fatalf("GetDestPath in batch = %q, %v", dest, err)
+	}
+	if n, _ := other.TotalCount(); n != 0 {
+		t.Fatalf("uncommitted batch visible to another connection: %d records", n)
+	}
+	if err := j.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if rec, err := other.GetFile(id); err != nil || rec == nil || rec.DestPath != "/dest/a.jpg" {
+		t.Fatalf("after Sync, other connection got %+v, %v", rec, err)
+	}
+
+	// Dest files are inserted in a savepoint of the batch
+	n, err := j.InsertDestFiles([]DestFile{{Path: "/dest/b.jpg", Size: 1, MediaType: "image", Extension: "jpg"}})
+	if err != nil || n != 1 {
+		t.Fatalf("InsertDestFiles = %d, %v", n, err)
+	}
+	if err := j.StopBatching(); err != nil {
+		t.Fatalf("StopBatching: %v", err)
+	}
+	if n, _ := other.CountByFileSize(1); n != 1 {
+		t.Errorf("StopBatching didn't commit: %d dest files", n)
+	}
+}
+
+func TestBatchingCommitsPeriodically(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	j, err := InitJournal(dbPath)
+	if err != nil {
+		t.Fatalf("InitJournal: %v", err)
+	}
+	other, err := InitJournal(dbPath)
+	if err != nil {
+		t.Fatalf("InitJournal: %v", err)
+	}
+	defer other.Close()
+
+	j.StartBatching(10 * time.Millisecond)
+	j.InsertFile(sampleRecord("/tmp/a.jpg"))
+	deadline := time.Now().Add(5 * time.Second)
+	for n, _ := other.TotalCount(); n != 1; n, _ = other.TotalCount() {
+		if time.Now().After(deadline) {
+			t.Fatal("batch not committed within its interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Close commits what is left
+	j.InsertFile(sampleRecord("/tmp/b.jpg"))
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if n, _ := other.TotalCount(); n != 2 {
+		t.Errorf("Close didn't commit: %d records", n)
+	}
+}
+
+func TestSyncWhileWriting(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	j, err := InitJournal(dbPath)
+	if err != nil {
+		t.Fatalf("InitJournal: %v", err)
+	}
+	defer j.Close()
+	other, err := InitJournal(dbPath)
+	if err != nil {
+		t.Fatalf("InitJournal: %v", err)
+	}
+	defer other.Close()
+
+	// As from a signal handler: before batching starts and while it writes
+	stop := make(chan struct{})
+	synced := make(chan struct{})
+	go func() {
+		defer close(synced)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				if err := j.Sync(); err != nil {
+					t.Errorf("Sync: %v", err)
+					return
+				}
+			}
+		}
+	}()
+	j.StartBatching(time.Hour)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if _, err := j.InsertFile(sampleRecord(fmt.Sprintf("/tmp/%d/%d.jpg", w, i))); err != nil {
+					t.Errorf("InsertFile: %v", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(stop)
+	<-synced
+
+	if err := j.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if n, _ := other.TotalCount(); n != 200 {
+		t.Errorf("%d records committed, want 200", n)
+	}
+}
+
+func TestWriteWaitsForOpenQuery(t *testing.T) {
+	j, err := InitJournal(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitJournal: %v", err)
+	}
+	defer j.Close()
+	j.StartBatching(time.Millisecond)
+	for i := 0; i < 3; i++ {
+		j.InsertFile(sampleRecord(fmt.Sprintf("/tmp/%d.jpg", i)))
+	}
+
+	rows, err := j.query(`SELECT source_path FROM files ORDER BY id`)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	rows.Next()
+
+	// Another goroutine's write and the periodic commit wait for the rows
+	written := make(chan error, 1)
+	go func() {
+		_, err := j.InsertFile(sampleRecord("/tmp/late.jpg"))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		t.Fatalf("InsertFile ran on the connection of open rows: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	n := 1
+	for rows.Next() {
+		n++
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatalf("rows: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("query read %d rows, want 3", n)
+	}
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("InsertFile: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("InsertFile still waiting after the rows were closed")
+	}
+
+	// Methods that read rows close them before returning, so reads and
+	// writes interleave freely
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if _, err := j.InsertFile(sampleRecord(fmt.Sprintf("/tmp/%d/%d.jpg", w, i))); err != nil {
+					t.Errorf("InsertFile: %v", err)
+				}
+				if _, err := j.QueryFiles(FileFilter{}); err != nil {
+					t.Errorf("QueryFiles: %v", err)
+				}
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("reads and writes deadlocked")
+	}
+	if n, _ := j.TotalCount(); n != 84 {
+		t.Errorf("%d records, want 84", n)
+	}
+}
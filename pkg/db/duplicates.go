@@ -0,0 +1,52 @@
+package db
+
+import "time"
+
+// Exact duplicates point to their original through duplicate_of and record
+// the duplicate policy applied to them in duplicate_action, so every move,
+// link or deletion can be audited, and undone where possible.
+
+// SetDuplicate marks a record as a duplicate of originalID, handled with the
+// given duplicate policy.
+func (j *Journal) SetDuplicate(id, originalID int64, action string) error {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := j.exec(
+		`UPDATE files SET is_duplicate = 1, duplicate_of = ?, duplicate_action = ?, updated_at = ?, run_id = ? WHERE id = ?`,
+		originalID, action, now, j.runID, id,
+	)
+	return err
+}
+
+// SetDuplicateAction records the action actually taken for a duplicate, e.g.
+// a move when the file system could not link it.
+func (j *Journal) SetDuplicateAction(id int64, action string) error {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := j.exec(
+		`UPDATE files SET duplicate_action = ?, updated_at = ?, run_id = ? WHERE id = ?`,
+		action, now, j.runID, id,
+	)
+	return err
+}
+
+// DuplicateActionCounts returns the number of handled duplicates per action,
+// ignoring duplicates that are still pending or were undone.
+func (j *Journal) DuplicateActionCounts() (map[string]int, error) {
+	rows, err := j.query(`SELECT duplicate_action, COUNT(*) FROM files
+		WHERE is_duplicate = 1 AND duplicate_action != '' AND status IN ('completed', 'dry_run', 'skipped')
+		GROUP BY duplicate_action`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var action string
+		var count int
+		if err := rows.Scan(&action, &count); err != nil {
+			return nil, err
+		}
+		counts[action] = count
+	}
+	return counts, rows.Err()
+}
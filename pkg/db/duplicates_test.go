@@ -0,0 +1,69 @@
+package db
+
+import "testing"
+
+func TestDuplicateActions(t *testing.T) {
+	j := newTestJournal(t)
+
+	originalID, err := j.InsertFile(sampleRecord("/src/original.jpg"))
+	if err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	j.UpdateDestPath(originalID, "/dst/original.jpg", 0, false)
+	skippedID, _ := j.InsertFile(sampleRecord("/src/skipped.jpg"))
+	deletedID, _ := j.InsertFile(sampleRecord("/src/deleted.jpg"))
+	linkedID, _ := j.InsertFile(sampleRecord("/src/linked.jpg"))
+
+	if err := j.SetDuplicate(skippedID, originalID, "skip"); err != nil {
+		t.Fatalf("SetDuplicate: %v", err)
+	}
+	j.SetDuplicate(deletedID, originalID, "delete")
+	j.SetDuplicate(linkedID, originalID, "hardlink")
+	j.UpdateDestPath(linkedID, "/dst/duplicates/linked.jpg", 0, true)
+
+	// Duplicates without a destination don't take a sequence number
+	key := sampleRecord("").TimestampKey
+	if count, err := j.CountByTimestampKey(key); err != nil || count != 2 {
+		t.Errorf("CountByTimestampKey = %d, %v; want 2", count, err)
+	}
+	j.UpdateDestPath(originalID, "/dst/original.jpg", 1, false)
+	if first, err := j.GetFirstByTimestampKey(key); err != nil || first == nil || first.ID != linkedID {
+		t.Errorf("GetFirstByTimestampKey = %+v, %v; want the linked duplicate", first, err)
+	}
+
+	// A pending deletion is resumed although it has no destination
+	pending, err := j.GetPendingFiles()
+	if err != nil {
+		t.Fatalf("GetPendingFiles: %v", err)
+	}
+	ids := map[int64]bool{}
+	for _, rec := range pending {
+		ids[rec.ID] = true
+	}
+	if !ids[deletedID] || ids[skippedID] {
+		t.Errorf("GetPendingFiles = %v; want the deletion but not the skipped file", ids)
+	}
+
+	j.UpdateStatus(skippedID, StatusSkipped, "")
+	j.UpdateStatus(deletedID, StatusCompleted, "")
+	j.SetDuplicateAction(linkedID, "move")
+	j.UpdateStatus(linkedID, StatusCompleted, "")
+	counts, err := j.DuplicateActionCounts()
+	if err != nil || counts["skip"] != 1 || counts["delete"] != 1 || counts["move"] != 1 || counts["hardlink"] != 0 {
+		t.Errorf("DuplicateActionCounts = %v, %v", counts, err)
+	}
+
+	rec, _ := j.GetFile(deletedID)
+	if !rec.IsDuplicate || rec.DuplicateOf != originalID || rec.DuplicateAction != "delete" {
+		t.Errorf("deleted duplicate = %+v", rec)
+	}
+
+	// A skipped duplicate found again can be organized with another policy
+	id, err := j.InsertFile(sampleRecord("/src/skipped.jpg"))
+	if err != nil || id != skippedID {
+		t.Fatalf("InsertFile of skipped file = %d, %v; want revived row %d", id, err, skippedID)
+	}
+	if rec, _ := j.GetFile(id); rec.Status != StatusPending || rec.IsDuplicate || rec.DuplicateAction != "" {
+		t.Errorf("revived record = %+v", rec)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package db
+
+import "os"
+
+// fileKeyOf reports that files have no key: os.FileInfo carries no device and
+// inode numbers here.
+func fileKeyOf(info os.FileInfo) (FileKey, bool) {
+	return FileKey{}, false
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package db
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileKeyOf returns the key of the file described by info.
+func fileKeyOf(info os.FileInfo) (FileKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileKey{}, false
+	}
+	return FileKey{
+		Device:  uint64(st.Dev),
+		Inode:   uint64(st.Ino),
+		Size:    info.Size(),
+		MtimeNs: info.ModTime().UnixNano(),
+	}, true
+}
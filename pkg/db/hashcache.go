@@ -0,0 +1,171 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// HashCache remembers the hashes of files by device, inode, size and
+// modification time, so that files unchanged since they were hashed aren't
+// read again. It is a database of its own, shared by all source directories,
+// and outlives the journals, which --fresh deletes.
+type HashCache struct {
+	db *sql.DB
+}
+
+// FileKey identifies a version of a file: a file with the same key is taken
+// to have the same content.
+type FileKey struct {
+	Device  uint64
+	Inode   uint64
+	Size    int64
+	MtimeNs int64
+}
+
+// StatFileKey returns the key of the file at path. ok is false on platforms
+// without device and inode numbers, where files can't be cached.
+func StatFileKey(path string) (key FileKey, ok bool, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FileKey{}, false, err
+	}
+	key, ok = fileKeyOf(info)
+	return key, ok, nil
+}
+
+// OpenHashCache opens (or creates) the hash cache database at path, creating
+// its directory if needed.
+func OpenHashCache(path string) (*HashCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("create hash cache directory: %w", err)
+	}
+	// Several runs may share the cache, so wait for each other's writes
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode%%3DWAL&_pragma=synchronous%%3DNORMAL&_pragma=busy_timeout%%3D5000", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open hash cache db: %w", err)
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS hashes (
+		device       INTEGER NOT NULL,
+		inode        INTEGER NOT NULL,
+		size         INTEGER NOT NULL,
+		mtime_ns     INTEGER NOT NULL,
+		path         TEXT NOT NULL,
+		hash         TEXT NOT NULL DEFAULT '',
+		partial_hash TEXT NOT NULL DEFAULT '',
+		updated_at   TEXT NOT NULL DEFAULT (datetime('now')),
+		PRIMARY KEY (device, inode, size, mtime_ns)
+	) WITHOUT ROWID;
+	CREATE INDEX IF NOT EXISTS idx_hashes_path ON hashes(path);
+	`
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create hash cache schema: %w", err)
+	}
+	return &HashCache{db: db}, nil
+}
+
+// Close closes the underlying database connection.
+func (c *HashCache) Close() error {
+	return c.db.Close()
+}
+
+// Lookup returns the cached full and partial hashes of a file, "" for those
+// not cached.
+func (c *HashCache) Lookup(key FileKey) (hash, partialHash string, err error) {
+	err = c.db.QueryRow(`SELECT hash, partial_hash FROM hashes WHERE device = ? AND inode = ? AND size = ? AND mtime_ns = ?`,
+		int64(key.Device), int64(key.Inode), key.Size, key.MtimeNs,
+	).Scan(&hash, &partialHash)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	return hash, partialHash, err
+}
+
+// Store caches the hashes of the file at path; an empty hash leaves a cached
+// one in place. Earlier versions of the same file are forgotten.
+func (c *HashCache) Store(key FileKey, path, hash, partialHash string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	dev, ino := int64(key.Device), int64(key.Inode)
+	if _, err := tx.Exec(`DELETE FROM hashes WHERE device = ? AND inode = ? AND (size != ? OR mtime_ns != ?)`,
+		dev, ino, key.Size, key.MtimeNs); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO hashes (device, inode, size, mtime_ns, path, hash, partial_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (device, inode, size, mtime_ns) DO UPDATE SET
+			path = excluded.path,
+			hash = CASE WHEN excluded.hash != '' THEN excluded.hash ELSE hash END,
+			partial_hash = CASE WHEN excluded.partial_hash != '' THEN excluded.partial_hash ELSE partial_hash END,
+			updated_at = datetime('now')`,
+		dev, ino, key.Size, key.MtimeNs, path, hash, partialHash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Moved records that the file at oldPath was renamed to newPath, so that
+// Prune finds it there.
+func (c *HashCache) Moved(oldPath, newPath string) error {
+	_, err := c.db.Exec(`UPDATE hashes SET path = ? WHERE path = ?`, newPath, oldPath)
+	return err
+}
+
+// Prune forgets the hashes of files that are no longer at their recorded
+// path with the same key: deleted, modified, or moved by other programs. It
+// returns how many entries are stale and how many are kept; with dryRun,
+// stale entries are only counted.
+func (c *HashCache) Prune(dryRun bool) (stale, kept int, err error) {
+	rows, err := c.db.Query(`SELECT device, inode, size, mtime_ns, path FROM hashes`)
+	if err != nil {
+		return 0, 0, err
+	}
+	var staleKeys []FileKey
+	for rows.Next() {
+		var key FileKey
+		var dev, ino int64
+		var path string
+		if err := rows.Scan(&dev, &ino, &key.Size, &key.MtimeNs, &path); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		key.Device, key.Inode = uint64(dev), uint64(ino)
+		// Files that can't be looked at now, e.g. for lack of permission, stay
+		current, ok, err := StatFileKey(path)
+		if os.IsNotExist(err) || (err == nil && (!ok || current != key)) {
+			staleKeys = append(staleKeys, key)
+		} else {
+			kept++
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if dryRun || len(staleKeys) == 0 {
+		return len(staleKeys), kept, nil
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	for _, key := range staleKeys {
+		if _, err := tx.Exec(`DELETE FROM hashes WHERE device = ? AND inode = ? AND size = ? AND mtime_ns = ?`,
+			int64(key.Device), int64(key.Inode), key.Size, key.MtimeNs); err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(staleKeys), kept, tx.Commit()
+}
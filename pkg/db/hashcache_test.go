@@ -0,0 +1,97 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestHashCache(t *testing.T) *HashCache {
+	t.Helper()
+	c, err := OpenHashCache(filepath.Join(t.TempDir(), "cache", "hashes.db"))
+	if err != nil {
+		t.Fatalf("OpenHashCache: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func writeKeyedFile(t *testing.T, path, content string) FileKey {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	key, ok, err := StatFileKey(path)
+	if err != nil {
+		t.Fatalf("StatFileKey: %v", err)
+	}
+	if !ok {
+		t.Skip("no file keys on this platform")
+	}
+	return key
+}
+
+func TestHashCacheStoreAndLookup(t *testing.T) {
+	c := newTestHashCache(t)
+	path := filepath.Join(t.TempDir(), "a.jpg")
+	key := writeKeyedFile(t, path, "content")
+
+	if hash, partial, err := c.Lookup(key); err != nil || hash != "" || partial != "" {
+		t.Fatalf("Lookup before Store = %q, %q, %v", hash, partial, err)
+	}
+	if err := c.Store(key, path, "", "partial"); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := c.Store(key, path, "full", ""); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if hash, partial, _ := c.Lookup(key); hash != "full" || partial != "partial" {
+		t.Errorf("Lookup = %q, %q, want both hashes kept", hash, partial)
+	}
+
+	// A new version of the file replaces the old one
+	changed := key
+	changed.Size++
+	c.Store(changed, path, "new", "")
+	if hash, _, _ := c.Lookup(key); hash != "" {
+		t.Errorf("old version still cached: %q", hash)
+	}
+	if hash, _, _ := c.Lookup(changed); hash != "new" {
+		t.Errorf("Lookup of new version = %q, want new", hash)
+	}
+}
+
+func TestHashCachePrune(t *testing.T) {
+	c := newTestHashCache(t)
+	dir := t.TempDir()
+	kept := filepath.Join(dir, "kept.jpg")
+	moved := filepath.Join(dir, "moved.jpg")
+	deleted := filepath.Join(dir, "deleted.jpg")
+	modified := filepath.Join(dir, "modified.jpg")
+	for _, path := range []string{kept, moved, deleted, modified} {
+		c.Store(writeKeyedFile(t, path, path), path, "h", "")
+	}
+
+	renamed := filepath.Join(dir, "renamed.jpg")
+	if err := os.Rename(moved, renamed); err != nil {
+		t.Fatal(err)
+	}
+	c.Moved(moved, renamed)
+	os.Remove(deleted)
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(modified, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	stale, keep, err := c.Prune(true)
+	if err != nil || stale != 2 || keep != 2 {
+		t.Fatalf("Prune(dry run) = %d, %d, %v; want 2 stale, 2 kept", stale, keep, err)
+	}
+	if stale, keep, err = c.Prune(false); err != nil || stale != 2 || keep != 2 {
+		t.Fatalf("Prune = %d, %d, %v; want 2 stale, 2 kept", stale, keep, err)
+	}
+	if stale, keep, _ = c.Prune(false); stale != 0 || keep != 2 {
+		t.Errorf("second Prune = %d, %d; want nothing stale", stale, keep)
+	}
+}
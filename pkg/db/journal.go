@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -15,13 +17,24 @@ import (
 type FileStatus string
 
 const (
-	StatusPending   FileStatus = "pending"
-	StatusCompleted FileStatus = "completed"
-	StatusFailed    FileStatus = "failed"
-	StatusDryRun    FileStatus = "dry_run"
-	StatusDestIndex FileStatus = "dest_index"
+	StatusPending     FileStatus = "pending"
+	StatusInProgress  FileStatus = "in_progress" // transfer started but not confirmed
+	StatusCompleted   FileStatus = "completed"
+	StatusFailed      FileStatus = "failed"
+	StatusDryRun      FileStatus = "dry_run"
+	StatusDestIndex   FileStatus = "dest_index"
+	StatusUndone      FileStatus = "undone"
+	StatusSkipped     FileStatus = "skipped"     // duplicate left in the source by its duplicate policy
+	StatusQuarantined FileStatus = "quarantined" // copy failed verification and was set aside
 )
 
+// SourceStatuses contains the statuses a source file can have, i.e. all but
+// StatusDestIndex.
+var SourceStatuses = []FileStatus{
+	StatusPending, StatusInProgress, StatusCompleted, StatusFailed, StatusDryRun,
+	StatusUndone, StatusSkipped, StatusQuarantined,
+}
+
 // ErrAlreadyExists is returned when inserting a file with a source_path that already exists.
 var ErrAlreadyExists = errors.New("file already exists in journal")
 
@@ -44,15 +57,85 @@ type FileRecord struct {
 	ErrorMessage     string
 	CreatedAt        string
 	UpdatedAt        string
+	RunID            int64 // run that last inserted, moved or re-filed this record
+	CameraMake       string
+	CameraModel      string
+	Artist           string
+	AlbumArtist      string
+	Album            string
+	Title            string
+	TrackNumber      int
+	Year             int
+	DateSource       string // exif, sidecar, filename or mtime
+	HasGPS           bool
+	Latitude         float64
+	Longitude        float64
+	Description      string
+	ContentID        string // Apple ContentIdentifier of Live Photos
+	PairID           int64  // ID of the record whose base name this one shares, 0 if unpaired
+	PerceptualHash   string // dHash of images, when near-duplicate detection is enabled
+	NearDuplicateOf  int64  // ID of the primary copy of a near-duplicate image, 0 otherwise
+	DuplicateOf      int64  // ID of the original of an exact duplicate, 0 otherwise
+	DuplicateAction  string // duplicate policy applied to an exact duplicate: move, skip, delete, hardlink, reflink or report
+	Transfer         string // how the file was placed in the destination: move, copy, hardlink, reflink or symlink
+	ContentFormat    string // format the content is really in, when the extension is wrong or missing
+	PartialHash      string // hash of the size and both ends of the file, set once another file has its size
 }
 
 // Journal wraps a SQLite database for tracking file operations.
 type Journal struct {
-	db *sql.DB
+	db    *sql.DB
+	runID int64 // current run, stamped on every record this process touches
+
+	stmtMu sync.Mutex
+	stmts  map[string]*sql.Stmt  // prepared statements by query
+	batch  atomic.Pointer[batch] // open transaction while batching, see StartBatching
 }
 
-// InitJournal opens (or creates) the SQLite database and initializes the schema.
+// InitJournal opens (or creates) the SQLite database and brings its schema up
+// to date. Journals written by a newer version are refused with
+// ErrNewerSchema.
 func InitJournal(dbPath string) (*Journal, error) {
+	db, err := openJournalDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := migrate(db, false); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Journal{db: db}, nil
+}
+
+// OpenJournalReadOnly opens an existing journal without changing it, for the
+// commands that only inspect it. Unlike InitJournal it doesn't migrate: a
+// journal with an older schema is refused with ErrOlderSchema, and one with a
+// newer schema with ErrNewerSchema.
+func OpenJournalReadOnly(dbPath string) (*Journal, error) {
+	// mode=rw doesn't create a missing journal; query_only refuses writes
+	dsn := fmt.Sprintf("file:%s?mode=rw&_pragma=busy_timeout%%3D5000&_pragma=query_only%%3D1", dbPath)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open journal db: %w", err)
+	}
+	version, err := schemaVersion(db)
+	if err == nil {
+		switch {
+		case version < SchemaVersion:
+			err = olderSchemaError(version)
+		case version > SchemaVersion:
+			err = newerSchemaError(version)
+		}
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Journal{db: db}, nil
+}
+
+// openJournalDB opens the SQLite database of a journal.
+func openJournalDB(dbPath string) (*sql.DB, error) {
 	// Pass pragmas via DSN so they apply to every connection in the pool,
 	// not just the first one. This prevents SQLITE_BUSY errors from connections
 	// that miss the busy_timeout pragma.
@@ -61,44 +144,18 @@ func InitJournal(dbPath string) (*Journal, error) {
 	if err != nil {
 		return nil, fmt.Errorf("open journal db: %w", err)
 	}
-
-	// Create schema
-	schema := `
-	CREATE TABLE IF NOT EXISTS files (
-		id               INTEGER PRIMARY KEY AUTOINCREMENT,
-		source_path      TEXT NOT NULL UNIQUE,
-		file_size        INTEGER NOT NULL,
-		media_type       TEXT NOT NULL,
-		extension        TEXT NOT NULL,
-		creation_time    TEXT NOT NULL,
-		larger_dimension INTEGER NOT NULL DEFAULT 0,
-		original_name    TEXT NOT NULL,
-		timestamp_key    TEXT NOT NULL,
-		hash             TEXT NOT NULL DEFAULT '',
-		dest_path        TEXT NOT NULL DEFAULT '',
-		sequence_num     INTEGER NOT NULL DEFAULT 0,
-		is_duplicate     INTEGER NOT NULL DEFAULT 0,
-		status           TEXT NOT NULL DEFAULT 'pending',
-		error_message    TEXT NOT NULL DEFAULT '',
-		created_at       TEXT NOT NULL DEFAULT (datetime('now')),
-		updated_at       TEXT NOT NULL DEFAULT (datetime('now'))
-	);
-	CREATE INDEX IF NOT EXISTS idx_files_status ON files(status);
-	CREATE INDEX IF NOT EXISTS idx_files_file_size ON files(file_size);
-	CREATE INDEX IF NOT EXISTS idx_files_hash ON files(hash) WHERE hash != '';
-	CREATE INDEX IF NOT EXISTS idx_files_timestamp_key ON files(timestamp_key);
-	`
-	if _, err := db.Exec(schema); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("create schema: %w", err)
-	}
-
-	return &Journal{db: db}, nil
+	return db, nil
 }
 
-// Close closes the underlying database connection.
+// Close commits any batched writes and closes the underlying database
+// connection.
 func (j *Journal) Close() error {
-	return j.db.Close()
+	err := j.StopBatching()
+	j.closeStatements()
+	if cerr := j.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
 
 // InsertFile inserts a new file record. Returns ErrAlreadyExists if source_path is taken.
@@ -110,18 +167,36 @@ func (j *Journal) InsertFile(rec *FileRecord) (int64, error) {
 	}
 	now := time.Now().UTC().Format("2006-01-02 15:04:05")
 
-	res, err := j.db.Exec(`
+	res, err := j.exec(`
 		INSERT INTO files (source_path, file_size, media_type, extension, creation_time,
 			larger_dimension, original_name, timestamp_key, hash, dest_path,
-			sequence_num, is_duplicate, status, error_message, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			sequence_num, is_duplicate, status, error_message, created_at, updated_at, run_id,
+			camera_make, camera_model, artist, album_artist, album, title, track_number, year,
+			date_source, has_gps, latitude, longitude, description, content_id, pair_id,
+			phash, near_duplicate_of, duplicate_of, duplicate_action, content_format, partial_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		rec.SourcePath, rec.FileSize, rec.MediaType, rec.Extension, rec.CreationTime,
 		rec.LargerDimension, rec.OriginalName, rec.TimestampKey, rec.Hash, rec.DestPath,
-		rec.SequenceNum, isDup, string(rec.Status), rec.ErrorMessage, now, now,
+		rec.SequenceNum, isDup, string(rec.Status), rec.ErrorMessage, now, now, j.runID,
+		rec.CameraMake, rec.CameraModel,
+		rec.Artist, rec.AlbumArtist, rec.Album, rec.Title, rec.TrackNumber, rec.Year,
+		rec.DateSource, boolToInt(rec.HasGPS), rec.Latitude, rec.Longitude, rec.Description,
+		rec.ContentID, rec.PairID, rec.PerceptualHash, rec.NearDuplicateOf,
+		rec.DuplicateOf, rec.DuplicateAction, rec.ContentFormat, rec.PartialHash,
 	)
 	if err != nil {
 		// Check for UNIQUE constraint violation on source_path
 		if isUniqueViolation(err) {
+			// A file whose previous organization was undone, or a duplicate
+			// left in place or quarantined, is in the source tree; reuse its
+			// row so it can be organized again.
+			id, revived, rerr := j.reviveUndone(rec, isDup, now)
+			if rerr != nil {
+				return 0, fmt.Errorf("revive undone file: %w", rerr)
+			}
+			if revived {
+				return id, nil
+			}
 			return 0, ErrAlreadyExists
 		}
 		return 0, fmt.Errorf("insert file: %w", err)
@@ -134,12 +209,56 @@ func (j *Journal) InsertFile(rec *FileRecord) (int64, error) {
 	return id, nil
 }
 
+// reviveUndone overwrites an 'undone', 'skipped' or 'quarantined' row for rec.SourcePath with the new record data.
+// Returns the row ID and true if such a row existed.
+func (j *Journal) reviveUndone(rec *FileRecord, isDup int, now string) (int64, bool, error) {
+	res, err := j.exec(`
+		UPDATE files SET file_size = ?, media_type = ?, extension = ?, creation_time = ?,
+			larger_dimension = ?, original_name = ?, timestamp_key = ?, hash = ?, dest_path = ?,
+			sequence_num = ?, is_duplicate = ?, status = ?, error_message = ?, updated_at = ?, run_id = ?,
+			camera_make = ?, camera_model = ?, artist = ?, album_artist = ?, album = ?, title = ?,
+			track_number = ?, year = ?, date_source = ?, has_gps = ?, latitude = ?, longitude = ?,
+			description = ?, content_id = ?, pair_id = ?, phash = ?, near_duplicate_of = ?,
+			duplicate_of = ?, duplicate_action = ?, content_format = ?, partial_hash = ?
+		WHERE source_path = ? AND status IN ('undone', 'skipped', 'quarantined')`,
+		rec.FileSize, rec.MediaType, rec.Extension, rec.CreationTime,
+		rec.LargerDimension, rec.OriginalName, rec.TimestampKey, rec.Hash, rec.DestPath,
+		rec.SequenceNum, isDup, string(rec.Status), rec.ErrorMessage, now, j.runID,
+		rec.CameraMake, rec.CameraModel,
+		rec.Artist, rec.AlbumArtist, rec.Album, rec.Title, rec.TrackNumber, rec.Year,
+		rec.DateSource, boolToInt(rec.HasGPS), rec.Latitude, rec.Longitude, rec.Description,
+		rec.ContentID, rec.PairID, rec.PerceptualHash, rec.NearDuplicateOf,
+		rec.DuplicateOf, rec.DuplicateAction, rec.ContentFormat, rec.PartialHash, rec.SourcePath,
+	)
+	if err != nil {
+		return 0, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, false, nil
+	}
+	var id int64
+	if err := j.queryRow(`SELECT id FROM files WHERE source_path = ?`, rec.SourcePath).Scan(&id); err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
 // UpdateStatus sets the status and optional error message for a record.
 func (j *Journal) UpdateStatus(id int64, status FileStatus, errMsg string) error {
 	now := time.Now().UTC().Format("2006-01-02 15:04:05")
-	_, err := j.db.Exec(
-		`UPDATE files SET status = ?, error_message = ?, updated_at = ? WHERE id = ?`,
-		string(status), errMsg, now, id,
+	_, err := j.exec(
+		`UPDATE files SET status = ?, error_message = ?, updated_at = ?, run_id = ? WHERE id = ?`,
+		string(status), errMsg, now, j.runID, id,
+	)
+	return err
+}
+
+// UpdateTransfer records how a record's file was placed in the destination.
+func (j *Journal) UpdateTransfer(id int64, transfer string) error {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := j.exec(
+		`UPDATE files SET transfer = ?, updated_at = ?, run_id = ? WHERE id = ?`,
+		transfer, now, j.runID, id,
 	)
 	return err
 }
@@ -147,7 +266,7 @@ func (j *Journal) UpdateStatus(id int64, status FileStatus, errMsg string) error
 // UpdateHash sets the hash for a record.
 func (j *Journal) UpdateHash(id int64, hash string) error {
 	now := time.Now().UTC().Format("2006-01-02 15:04:05")
-	_, err := j.db.Exec(
+	_, err := j.exec(
 		`UPDATE files SET hash = ?, updated_at = ? WHERE id = ?`,
 		hash, now, id,
 	)
@@ -161,9 +280,9 @@ func (j *Journal) UpdateDestPath(id int64, destPath string, seqNum int, isDuplic
 		isDup = 1
 	}
 	now := time.Now().UTC().Format("2006-01-02 15:04:05")
-	_, err := j.db.Exec(
-		`UPDATE files SET dest_path = ?, sequence_num = ?, is_duplicate = ?, updated_at = ? WHERE id = ?`,
-		destPath, seqNum, isDup, now, id,
+	_, err := j.exec(
+		`UPDATE files SET dest_path = ?, sequence_num = ?, is_duplicate = ?, updated_at = ?, run_id = ? WHERE id = ?`,
+		destPath, seqNum, isDup, now, j.runID, id,
 	)
 	return err
 }
@@ -171,30 +290,32 @@ func (j *Journal) UpdateDestPath(id int64, destPath string, seqNum int, isDuplic
 // GetDestPath returns the current dest_path for a record.
 func (j *Journal) GetDestPath(id int64) (string, error) {
 	var destPath string
-	err := j.db.QueryRow(`SELECT dest_path FROM files WHERE id = ?`, id).Scan(&destPath)
+	err := j.queryRow(`SELECT dest_path FROM files WHERE id = ?`, id).Scan(&destPath)
 	return destPath, err
 }
 
-// CountByFileSize returns how many records have the given file_size.
+// CountByFileSize returns how many records have the given file_size, ignoring undone records.
 func (j *Journal) CountByFileSize(size int64) (int, error) {
 	var count int
-	err := j.db.QueryRow(`SELECT COUNT(*) FROM files WHERE file_size = ?`, size).Scan(&count)
+	err := j.queryRow(`SELECT COUNT(*) FROM files WHERE file_size = ? AND status != 'undone'`, size).Scan(&count)
 	return count, err
 }
 
-// CountByTimestampKey returns how many records share the given timestamp_key.
+// CountByTimestampKey returns how many records share the given timestamp_key, ignoring undone
+// records and duplicates that are not placed in the destination.
 func (j *Journal) CountByTimestampKey(key string) (int, error) {
 	var count int
-	err := j.db.QueryRow(`SELECT COUNT(*) FROM files WHERE timestamp_key = ?`, key).Scan(&count)
+	err := j.queryRow(`SELECT COUNT(*) FROM files WHERE timestamp_key = ? AND status != 'undone' AND `+placedInDestination, key).Scan(&count)
 	return count, err
 }
 
-// GetByHash returns all records with the given non-empty hash.
+// GetByHash returns all records with the given non-empty hash, ignoring undone
+// and quarantined records.
 func (j *Journal) GetByHash(hash string) ([]*FileRecord, error) {
 	if hash == "" {
 		return nil, nil
 	}
-	rows, err := j.db.Query(`SELECT `+fileColumns+` FROM files WHERE hash = ?`, hash)
+	rows, err := j.query(`SELECT `+fileColumns+` FROM files WHERE hash = ? AND status NOT IN ('undone', 'quarantined')`, hash)
 	if err != nil {
 		return nil, err
 	}
@@ -204,7 +325,7 @@ func (j *Journal) GetByHash(hash string) ([]*FileRecord, error) {
 
 // GetCompletedSourcePaths returns a set of source paths with status 'completed' or 'dry_run'.
 func (j *Journal) GetCompletedSourcePaths() (map[string]bool, error) {
-	rows, err := j.db.Query(`SELECT source_path FROM files WHERE status IN ('completed', 'dry_run')`)
+	rows, err := j.query(`SELECT source_path FROM files WHERE status IN ('completed', 'dry_run')`)
 	if err != nil {
 		return nil, err
 	}
@@ -221,9 +342,11 @@ func (j *Journal) GetCompletedSourcePaths() (map[string]bool, error) {
 	return paths, rows.Err()
 }
 
-// GetPendingFiles returns all records with status 'pending' that have a dest_path set.
+// GetPendingFiles returns all records with status 'pending' that have a dest_path set,
+// or that are duplicates waiting to be deleted, and those whose transfer was
+// interrupted ('in_progress').
 func (j *Journal) GetPendingFiles() ([]*FileRecord, error) {
-	rows, err := j.db.Query(`SELECT `+fileColumns+` FROM files WHERE status = 'pending' AND dest_path != ''`)
+	rows, err := j.query(`SELECT `+fileColumns+` FROM files WHERE status IN ('pending', 'in_progress') AND (dest_path != '' OR duplicate_action = 'delete')`)
 	if err != nil {
 		return nil, err
 	}
@@ -231,28 +354,57 @@ func (j *Journal) GetPendingFiles() ([]*FileRecord, error) {
 	return scanRecords(rows)
 }
 
-// ResetFailed changes all 'failed' records back to 'pending' for retry. Returns count affected.
+// GetCompletedFiles returns all records with status 'completed', newest first,
+// so that an undo reverses operations in the opposite order they were applied.
+func (j *Journal) GetCompletedFiles() ([]*FileRecord, error) {
+	rows, err := j.query(`SELECT `+fileColumns+` FROM files WHERE status = 'completed' ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRecords(rows)
+}
+
+// ResetFailed changes all 'failed' file and sidecar records back to 'pending' for retry.
+// Returns count affected.
 func (j *Journal) ResetFailed() (int64, error) {
 	now := time.Now().UTC().Format("2006-01-02 15:04:05")
-	res, err := j.db.Exec(
+	res, err := j.exec(
 		`UPDATE files SET status = 'pending', error_message = '', updated_at = ? WHERE status = 'failed'`,
 		now,
 	)
 	if err != nil {
 		return 0, err
 	}
-	return res.RowsAffected()
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err = j.exec(
+		`UPDATE sidecars SET status = 'pending', error_message = '', updated_at = ? WHERE status = 'failed'`,
+		now,
+	)
+	if err != nil {
+		return 0, err
+	}
+	sidecars, err := res.RowsAffected()
+	return n + sidecars, err
 }
 
-// DropAll deletes all records from the files table.
+// DropAll deletes all records from the files and sidecars tables.
 func (j *Journal) DropAll() error {
-	_, err := j.db.Exec(`DELETE FROM files`)
-	return err
+	for _, stmt := range []string{`DELETE FROM sidecars`, `DELETE FROM files`} {
+		if _, err := j.exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Stats returns a map of status → count for all records (excluding dest_index).
 func (j *Journal) Stats() (map[FileStatus]int, error) {
-	rows, err := j.db.Query(`SELECT status, COUNT(*) FROM files WHERE status != 'dest_index' GROUP BY status`)
+	rows, err := j.query(`SELECT status, COUNT(*) FROM files WHERE status != 'dest_index' GROUP BY status`)
 	if err != nil {
 		return nil, err
 	}
@@ -270,16 +422,51 @@ func (j *Journal) Stats() (map[FileStatus]int, error) {
 	return stats, rows.Err()
 }
 
+// UpdatePartialHash sets the partial hash for a record.
+func (j *Journal) UpdatePartialHash(id int64, partialHash string) error {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := j.exec(
+		`UPDATE files SET partial_hash = ?, updated_at = ? WHERE id = ?`,
+		partialHash, now, id,
+	)
+	return err
+}
+
 // DuplicateCount returns the number of records marked as duplicates.
 func (j *Journal) DuplicateCount() (int, error) {
 	var count int
-	err := j.db.QueryRow(`SELECT COUNT(*) FROM files WHERE is_duplicate = 1`).Scan(&count)
+	err := j.queryRow(`SELECT COUNT(*) FROM files WHERE is_duplicate = 1`).Scan(&count)
 	return count, err
 }
 
 // GetUnhashedByFileSize returns records with matching file_size that have no hash set.
 func (j *Journal) GetUnhashedByFileSize(size int64) ([]*FileRecord, error) {
-	rows, err := j.db.Query(`SELECT `+fileColumns+` FROM files WHERE file_size = ? AND hash = ''`, size)
+	rows, err := j.query(`SELECT `+fileColumns+` FROM files WHERE file_size = ? AND hash = '' AND status != 'undone'`, size)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRecords(rows)
+}
+
+// GetWithoutPartialHashByFileSize returns records with matching file_size
+// that have no partial hash set.
+func (j *Journal) GetWithoutPartialHashByFileSize(size int64) ([]*FileRecord, error) {
+	rows, err := j.query(`SELECT `+fileColumns+` FROM files WHERE file_size = ? AND partial_hash = '' AND status != 'undone'`, size)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRecords(rows)
+}
+
+// GetByPartialHash returns all records with the given non-empty partial hash,
+// ignoring undone and quarantined records.
+func (j *Journal) GetByPartialHash(partialHash string) ([]*FileRecord, error) {
+	if partialHash == "" {
+		return nil, nil
+	}
+	rows, err := j.query(`SELECT `+fileColumns+` FROM files WHERE partial_hash = ? AND status NOT IN ('undone', 'quarantined')`, partialHash)
 	if err != nil {
 		return nil, err
 	}
@@ -290,7 +477,7 @@ func (j *Journal) GetUnhashedByFileSize(size int64) ([]*FileRecord, error) {
 // TotalCount returns the total number of records in the journal (excluding dest_index).
 func (j *Journal) TotalCount() (int, error) {
 	var count int
-	err := j.db.QueryRow(`SELECT COUNT(*) FROM files WHERE status != 'dest_index'`).Scan(&count)
+	err := j.queryRow(`SELECT COUNT(*) FROM files WHERE status != 'dest_index'`).Scan(&count)
 	return count, err
 }
 
@@ -305,34 +492,25 @@ type DestFile struct {
 // ClearDestIndex removes dest_index rows that have no computed hash.
 // Rows with hashes are preserved so they don't need to be re-hashed on the next run.
 func (j *Journal) ClearDestIndex() error {
-	_, err := j.db.Exec(`DELETE FROM files WHERE status = 'dest_index' AND hash = ''`)
+	_, err := j.exec(`DELETE FROM files WHERE status = 'dest_index' AND hash = ''`)
 	return err
 }
 
 // GetFirstByTimestampKey returns the first record (lowest ID) with the given
 // timestamp_key that has sequence_num = 0 (i.e., was filed without a sequence suffix).
+// Pair members are skipped: their name follows their pair leader.
 func (j *Journal) GetFirstByTimestampKey(key string) (*FileRecord, error) {
-	row := j.db.QueryRow(
-		`SELECT `+fileColumns+` FROM files WHERE timestamp_key = ? AND sequence_num = 0 AND status != 'dest_index' ORDER BY id LIMIT 1`,
+	row := j.queryRow(
+		`SELECT `+fileColumns+` FROM files WHERE timestamp_key = ? AND sequence_num = 0 AND pair_id = 0 AND status NOT IN ('dest_index', 'undone') AND `+placedInDestination+` ORDER BY id LIMIT 1`,
 		key,
 	)
-	r := &FileRecord{}
-	var isDup int
-	var status string
-	err := row.Scan(
-		&r.ID, &r.SourcePath, &r.FileSize, &r.MediaType, &r.Extension,
-		&r.CreationTime, &r.LargerDimension, &r.OriginalName, &r.TimestampKey,
-		&r.Hash, &r.DestPath, &r.SequenceNum, &isDup, &status,
-		&r.ErrorMessage, &r.CreatedAt, &r.UpdatedAt,
-	)
+	r, err := scanRecord(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	r.IsDuplicate = isDup == 1
-	r.Status = FileStatus(status)
 	return r, nil
 }
 
@@ -343,65 +521,91 @@ func (j *Journal) InsertDestFiles(files []DestFile) (int, error) {
 		return 0, nil
 	}
 
-	tx, err := j.db.Begin()
-	if err != nil {
-		return 0, fmt.Errorf("begin transaction: %w", err)
-	}
-	defer tx.Rollback()
-
-	stmt, err := tx.Prepare(`
-		INSERT OR IGNORE INTO files (source_path, file_size, media_type, extension, creation_time,
-			larger_dimension, original_name, timestamp_key, hash, dest_path,
-			sequence_num, is_duplicate, status, error_message, created_at, updated_at)
-		VALUES (?, ?, ?, ?, '1970-01-01 00:00:00', 0, ?, 'dest_index', '', '', 0, 0, 'dest_index', '', datetime('now'), datetime('now'))`)
-	if err != nil {
-		return 0, fmt.Errorf("prepare statement: %w", err)
-	}
-	defer stmt.Close()
-
 	inserted := 0
-	for _, f := range files {
-		res, err := stmt.Exec(f.Path, f.Size, f.MediaType, f.Extension, filepath.Base(f.Path))
-		if err != nil {
-			continue
+	err := j.transaction(func(exec func(query string, args ...any) (sql.Result, error)) error {
+		for _, f := range files {
+			res, err := exec(`
+				INSERT OR IGNORE INTO files (source_path, file_size, media_type, extension, creation_time,
+					larger_dimension, original_name, timestamp_key, hash, dest_path,
+					sequence_num, is_duplicate, status, error_message, created_at, updated_at, run_id)
+				VALUES (?, ?, ?, ?, '1970-01-01 00:00:00', 0, ?, 'dest_index', '', '', 0, 0, 'dest_index', '', datetime('now'), datetime('now'), ?)`,
+				f.Path, f.Size, f.MediaType, f.Extension, filepath.Base(f.Path), j.runID)
+			if err != nil {
+				continue
+			}
+			n, _ := res.RowsAffected()
+			inserted += int(n)
 		}
-		n, _ := res.RowsAffected()
-		inserted += int(n)
-	}
-
-	if err := tx.Commit(); err != nil {
-		return 0, fmt.Errorf("commit transaction: %w", err)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("insert destination files: %w", err)
 	}
 	return inserted, nil
 }
 
 // --- helpers ---
 
+// placedInDestination excludes duplicates that stay in the source or are
+// deleted, and quarantined copies: they take no name in the destination, so
+// no sequence number.
+const placedInDestination = `duplicate_action NOT IN ('skip', 'delete', 'report') AND status != 'quarantined'`
+
 const fileColumns = `id, source_path, file_size, media_type, extension, creation_time,
 	larger_dimension, original_name, timestamp_key, hash, dest_path,
-	sequence_num, is_duplicate, status, error_message, created_at, updated_at`
+	sequence_num, is_duplicate, status, error_message, created_at, updated_at, run_id,
+	camera_make, camera_model, artist, album_artist, album, title, track_number, year,
+	date_source, has_gps, latitude, longitude, description, content_id, pair_id,
+	phash, near_duplicate_of, duplicate_of, duplicate_action, transfer, content_format, partial_hash`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanRecord reads one row selected with fileColumns.
+func scanRecord(row rowScanner) (*FileRecord, error) {
+	r := &FileRecord{}
+	var isDup, hasGPS int
+	var status string
+	if err := row.Scan(
+		&r.ID, &r.SourcePath, &r.FileSize, &r.MediaType, &r.Extension,
+		&r.CreationTime, &r.LargerDimension, &r.OriginalName, &r.TimestampKey,
+		&r.Hash, &r.DestPath, &r.SequenceNum, &isDup, &status,
+		&r.ErrorMessage, &r.CreatedAt, &r.UpdatedAt, &r.RunID,
+		&r.CameraMake, &r.CameraModel,
+		&r.Artist, &r.AlbumArtist, &r.Album, &r.Title, &r.TrackNumber, &r.Year,
+		&r.DateSource, &hasGPS, &r.Latitude, &r.Longitude, &r.Description,
+		&r.ContentID, &r.PairID, &r.PerceptualHash, &r.NearDuplicateOf,
+		&r.DuplicateOf, &r.DuplicateAction, &r.Transfer, &r.ContentFormat, &r.PartialHash,
+	); err != nil {
+		return nil, err
+	}
+	r.IsDuplicate = isDup == 1
+	r.HasGPS = hasGPS == 1
+	r.Status = FileStatus(status)
+	return r, nil
+}
 
 func scanRecords(rows *sql.Rows) ([]*FileRecord, error) {
 	var records []*FileRecord
 	for rows.Next() {
-		r := &FileRecord{}
-		var isDup int
-		var status string
-		if err := rows.Scan(
-			&r.ID, &r.SourcePath, &r.FileSize, &r.MediaType, &r.Extension,
-			&r.CreationTime, &r.LargerDimension, &r.OriginalName, &r.TimestampKey,
-			&r.Hash, &r.DestPath, &r.SequenceNum, &isDup, &status,
-			&r.ErrorMessage, &r.CreatedAt, &r.UpdatedAt,
-		); err != nil {
+		r, err := scanRecord(rows)
+		if err != nil {
 			return nil, err
 		}
-		r.IsDuplicate = isDup == 1
-		r.Status = FileStatus(status)
 		records = append(records, r)
 	}
 	return records, rows.Err()
 }
 
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 func isUniqueViolation(err error) bool {
 	// modernc.org/sqlite returns error messages containing "UNIQUE constraint failed"
 	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"database/sql"
 	"path/filepath"
 	"testing"
 )
@@ -233,6 +234,23 @@ func TestGetPendingFiles(t *testing.T) {
 	}
 }
 
+func TestGetPendingFilesInProgress(t *testing.T) {
+	j := newTestJournal(t)
+
+	// A transfer interrupted by a killed run is resumed
+	id, _ := j.InsertFile(sampleRecord("/tmp/a.jpg"))
+	j.UpdateDestPath(id, "/dest/a.jpg", 0, false)
+	j.UpdateStatus(id, StatusInProgress, "")
+
+	pending, err := j.GetPendingFiles()
+	if err != nil {
+		t.Fatalf("GetPendingFiles: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != id || pending[0].Status != StatusInProgress {
+		t.Errorf("GetPendingFiles = %+v; want the in-progress record", pending)
+	}
+}
+
 func TestResetFailed(t *testing.T) {
 	j := newTestJournal(t)
 
@@ -304,6 +322,39 @@ func TestGetUnhashedByFileSize(t *testing.T) {
 	}
 }
 
+func TestPartialHashLookups(t *testing.T) {
+	j := newTestJournal(t)
+
+	r1 := sampleRecord("/tmp/a.mp4")
+	r1.FileSize = 5000
+	r1.PartialHash = "partial"
+	id1, _ := j.InsertFile(r1)
+
+	r2 := sampleRecord("/tmp/b.mp4")
+	r2.FileSize = 5000
+	id2, _ := j.InsertFile(r2)
+
+	missing, err := j.GetWithoutPartialHashByFileSize(5000)
+	if err != nil {
+		t.Fatalf("GetWithoutPartialHashByFileSize: %v", err)
+	}
+	if len(missing) != 1 || missing[0].ID != id2 {
+		t.Fatalf("expected record %d without partial hash, got %+v", id2, missing)
+	}
+
+	j.UpdatePartialHash(id2, "partial")
+	matches, err := j.GetByPartialHash("partial")
+	if err != nil {
+		t.Fatalf("GetByPartialHash: %v", err)
+	}
+	if len(matches) != 2 || matches[0].ID != id1 || matches[1].PartialHash != "partial" {
+		t.Errorf("expected both records, got %+v", matches)
+	}
+	if matches, _ := j.GetByPartialHash(""); matches != nil {
+		t.Errorf("empty partial hash matched %d records", len(matches))
+	}
+}
+
 func TestGetByHashEmpty(t *testing.T) {
 	j := newTestJournal(t)
 
@@ -328,3 +379,219 @@ func TestStatsEmpty(t *testing.T) {
 		t.Errorf("expected empty stats, got %v", stats)
 	}
 }
+
+func TestGetCompletedFiles(t *testing.T) {
+	j := newTestJournal(t)
+
+	id1, _ := j.InsertFile(sampleRecord("/tmp/a.jpg"))
+	j.UpdateStatus(id1, StatusCompleted, "")
+
+	j.InsertFile(sampleRecord("/tmp/b.jpg")) // stays pending
+
+	id3, _ := j.InsertFile(sampleRecord("/tmp/c.jpg"))
+	j.UpdateStatus(id3, StatusCompleted, "")
+
+	completed, err := j.GetCompletedFiles()
+	if err != nil {
+		t.Fatalf("GetCompletedFiles: %v", err)
+	}
+	if len(completed) != 2 {
+		t.Fatalf("expected 2 completed, got %d", len(completed))
+	}
+	// Newest first
+	if completed[0].ID != id3 || completed[1].ID != id1 {
+		t.Errorf("expected IDs [%d %d], got [%d %d]", id3, id1, completed[0].ID, completed[1].ID)
+	}
+}
+
+func TestInsertFileRevivesUndone(t *testing.T) {
+	j := newTestJournal(t)
+
+	rec := sampleRecord("/tmp/photo.jpg")
+	id, _ := j.InsertFile(rec)
+	j.UpdateHash(id, "abc123")
+	j.UpdateDestPath(id, "/dest/photo.jpg", 1, false)
+	j.UpdateStatus(id, StatusUndone, "")
+
+	// Undone rows are invisible to grouping queries
+	if count, _ := j.CountByTimestampKey(rec.TimestampKey); count != 0 {
+		t.Errorf("expected undone row to be ignored by CountByTimestampKey, got %d", count)
+	}
+	if matches, _ := j.GetByHash("abc123"); len(matches) != 0 {
+		t.Errorf("expected undone row to be ignored by GetByHash, got %d", len(matches))
+	}
+
+	// Re-inserting the same source path reuses the row
+	newID, err := j.InsertFile(sampleRecord("/tmp/photo.jpg"))
+	if err != nil {
+		t.Fatalf("InsertFile after undo: %v", err)
+	}
+	if newID != id {
+		t.Errorf("expected revived ID %d, got %d", id, newID)
+	}
+
+	pending, _ := j.GetPendingFiles()
+	if len(pending) != 0 {
+		t.Errorf("expected revived row to have no dest_path, got %d pending", len(pending))
+	}
+	stats, _ := j.Stats()
+	if stats[StatusPending] != 1 || stats[StatusUndone] != 0 {
+		t.Errorf("expected 1 pending and 0 undone, got %v", stats)
+	}
+
+	// A live row still reports ErrAlreadyExists
+	if _, err := j.InsertFile(sampleRecord("/tmp/photo.jpg")); err != ErrAlreadyExists {
+		t.Errorf("expected ErrAlreadyExists, got %v", err)
+	}
+}
+
+func TestInsertFileRevivesQuarantined(t *testing.T) {
+	j := newTestJournal(t)
+
+	id, _ := j.InsertFile(sampleRecord("/tmp/photo.jpg"))
+	j.UpdateDestPath(id, "/dest/quarantine/photo.jpg", 0, false)
+	j.UpdateHash(id, "abc123")
+	j.UpdateStatus(id, StatusQuarantined, "checksum mismatch")
+
+	// A quarantined copy is neither numbered nor the original of a duplicate
+	if count, _ := j.CountByTimestampKey(sampleRecord("").TimestampKey); count != 0 {
+		t.Errorf("expected quarantined row to be ignored by CountByTimestampKey, got %d", count)
+	}
+	if matches, _ := j.GetByHash("abc123"); len(matches) != 0 {
+		t.Errorf("expected quarantined row to be ignored by GetByHash, got %d", len(matches))
+	}
+
+	// Quarantined files are neither pending nor retried as failed
+	if pending, _ := j.GetPendingFiles(); len(pending) != 0 {
+		t.Errorf("expected quarantined row not to be pending, got %d", len(pending))
+	}
+	if n, _ := j.ResetFailed(); n != 0 {
+		t.Errorf("expected ResetFailed to leave quarantined row, reset %d", n)
+	}
+
+	// Found again in the source, the file is organized anew
+	newID, err := j.InsertFile(sampleRecord("/tmp/photo.jpg"))
+	if err != nil || newID != id {
+		t.Fatalf("InsertFile after quarantine = %d, %v; want revived row %d", newID, err, id)
+	}
+	if rec, _ := j.GetFile(id); rec.Status != StatusPending || rec.DestPath != "" || rec.ErrorMessage != "" {
+		t.Errorf("revived record = %+v", rec)
+	}
+}
+
+func TestInitJournalUpgradesOldSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "old.db")
+
+	// Journal created before the run_id column existed
+	old, err := sql.Open("sqlite", "file:"+dbPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_, err = old.Exec(`
+	CREATE TABLE files (
+		id               INTEGER PRIMARY KEY AUTOINCREMENT,
+		source_path      TEXT NOT NULL UNIQUE,
+		file_size        INTEGER NOT NULL,
+		media_type       TEXT NOT NULL,
+		extension        TEXT NOT NULL,
+		creation_time    TEXT NOT NULL,
+		larger_dimension INTEGER NOT NULL DEFAULT 0,
+		original_name    TEXT NOT NULL,
+		timestamp_key    TEXT NOT NULL,
+		hash             TEXT NOT NULL DEFAULT '',
+		dest_path        TEXT NOT NULL DEFAULT '',
+		sequence_num     INTEGER NOT NULL DEFAULT 0,
+		is_duplicate     INTEGER NOT NULL DEFAULT 0,
+		status           TEXT NOT NULL DEFAULT 'pending',
+		error_message    TEXT NOT NULL DEFAULT '',
+		created_at       TEXT NOT NULL DEFAULT (datetime('now')),
+		updated_at       TEXT NOT NULL DEFAULT (datetime('now'))
+	);
+	INSERT INTO files (source_path, file_size, media_type, extension, creation_time, original_name, timestamp_key, status)
+	VALUES ('/tmp/old.jpg', 1, 'image', 'jpg', '2024-01-01 00:00:00', 'old.jpg', 'k', 'completed');`)
+	if err != nil {
+		t.Fatalf("create old schema: %v", err)
+	}
+	old.Close()
+
+	j, err := InitJournal(dbPath)
+	if err != nil {
+		t.Fatalf("InitJournal on old schema: %v", err)
+	}
+	defer j.Close()
+
+	completed, err := j.GetCompletedFiles()
+	if err != nil {
+		t.Fatalf("GetCompletedFiles: %v", err)
+	}
+	if len(completed) != 1 || completed[0].RunID != 0 {
+		t.Errorf("expected 1 completed record with run_id 0, got %+v", completed)
+	}
+}
+
+func TestAudioTagsRoundTrip(t *testing.T) {
+	j := newTestJournal(t)
+
+	rec := sampleRecord("/tmp/song.mp3")
+	rec.MediaType = "audio"
+	rec.Status = StatusCompleted
+	rec.Artist, rec.AlbumArtist, rec.Album, rec.Title = "Artist", "Band", "Album", "Song"
+	rec.TrackNumber, rec.Year = 7, 1999
+	if _, err := j.InsertFile(rec); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+
+	completed, err := j.GetCompletedFiles()
+	if err != nil {
+		t.Fatalf("GetCompletedFiles: %v", err)
+	}
+	if len(completed) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(completed))
+	}
+	got := completed[0]
+	if got.Artist != "Artist" || got.AlbumArtist != "Band" || got.Album != "Album" || got.Title != "Song" ||
+		got.TrackNumber != 7 || got.Year != 1999 {
+		t.Errorf("audio tags not preserved: %+v", got)
+	}
+}
+
+func TestDateSourceAndGPSRoundTrip(t *testing.T) {
+	j := newTestJournal(t)
+
+	rec := sampleRecord("/tmp/takeout.jpg")
+	rec.Status = StatusCompleted
+	rec.DateSource = "sidecar"
+	rec.HasGPS, rec.Latitude, rec.Longitude = true, 47.6062, -122.3321
+	rec.Description = "Sunset"
+	if _, err := j.InsertFile(rec); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+
+	completed, err := j.GetCompletedFiles()
+	if err != nil || len(completed) != 1 {
+		t.Fatalf("GetCompletedFiles: %v, %d records", err, len(completed))
+	}
+	got := completed[0]
+	if got.DateSource != "sidecar" || !got.HasGPS || got.Latitude != 47.6062 || got.Longitude != -122.3321 || got.Description != "Sunset" {
+		t.Errorf("sidecar metadata not preserved: %+v", got)
+	}
+}
+
+func TestContentFormatRoundTrip(t *testing.T) {
+	j := newTestJournal(t)
+
+	rec := sampleRecord("/tmp/really-a-png.jpg")
+	rec.Status = StatusCompleted
+	rec.ContentFormat = "png"
+	if _, err := j.InsertFile(rec); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+
+	completed, err := j.GetCompletedFiles()
+	if err != nil || len(completed) != 1 {
+		t.Fatalf("GetCompletedFiles: %v, %d records", err, len(completed))
+	}
+	if got := completed[0].ContentFormat; got != "png" {
+		t.Errorf("ContentFormat = %q, want png", got)
+	}
+}
@@ -0,0 +1,269 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// The journal's schema version is kept in SQLite's user_version header field
+// and raised by the migrations below, in order, when a journal is opened.
+// Journals from before versioning have version 0 however far they were
+// upgraded, so every migration skips tables, columns and indexes that already
+// exist. Migrations are never changed once released; a schema change is a new
+// migration at the end of the list.
+
+// Migration is a step from one journal schema version to the next.
+type Migration struct {
+	Version     int
+	Description string
+	columns     []column // added to files unless already there, before the statements run
+	statements  []string
+}
+
+// column is a column of the files table.
+type column struct {
+	name, definition string
+}
+
+var migrations = []Migration{
+	{Version: 1, Description: "create the files table", statements: []string{
+		`CREATE TABLE IF NOT EXISTS files (
+			id               INTEGER PRIMARY KEY AUTOINCREMENT,
+			source_path      TEXT NOT NULL UNIQUE,
+			file_size        INTEGER NOT NULL,
+			media_type       TEXT NOT NULL,
+			extension        TEXT NOT NULL,
+			creation_time    TEXT NOT NULL,
+			larger_dimension INTEGER NOT NULL DEFAULT 0,
+			original_name    TEXT NOT NULL,
+			timestamp_key    TEXT NOT NULL,
+			hash             TEXT NOT NULL DEFAULT '',
+			dest_path        TEXT NOT NULL DEFAULT '',
+			sequence_num     INTEGER NOT NULL DEFAULT 0,
+			is_duplicate     INTEGER NOT NULL DEFAULT 0,
+			status           TEXT NOT NULL DEFAULT 'pending',
+			error_message    TEXT NOT NULL DEFAULT '',
+			created_at       TEXT NOT NULL DEFAULT (datetime('now')),
+			updated_at       TEXT NOT NULL DEFAULT (datetime('now'))
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_files_status ON files(status)`,
+		`CREATE INDEX IF NOT EXISTS idx_files_file_size ON files(file_size)`,
+		`CREATE INDEX IF NOT EXISTS idx_files_hash ON files(hash) WHERE hash != ''`,
+		`CREATE INDEX IF NOT EXISTS idx_files_timestamp_key ON files(timestamp_key)`,
+	}},
+	{Version: 2, Description: "record runs",
+		columns: []column{{"run_id", "INTEGER NOT NULL DEFAULT 0"}},
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS runs (
+				id               INTEGER PRIMARY KEY AUTOINCREMENT,
+				started_at       TEXT NOT NULL,
+				ended_at         TEXT NOT NULL DEFAULT '',
+				version          TEXT NOT NULL DEFAULT '',
+				mode             TEXT NOT NULL DEFAULT '',
+				config           TEXT NOT NULL DEFAULT '',
+				total_files      INTEGER NOT NULL DEFAULT 0,
+				processed_files  INTEGER NOT NULL DEFAULT 0,
+				skipped_files    INTEGER NOT NULL DEFAULT 0,
+				organized_files  INTEGER NOT NULL DEFAULT 0,
+				error_count      INTEGER NOT NULL DEFAULT 0,
+				duplicate_count  INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_files_run_id ON files(run_id)`,
+		}},
+	{Version: 3, Description: "record camera make and model", columns: []column{
+		{"camera_make", "TEXT NOT NULL DEFAULT ''"},
+		{"camera_model", "TEXT NOT NULL DEFAULT ''"},
+	}},
+	{Version: 4, Description: "record audio tags", columns: []column{
+		{"artist", "TEXT NOT NULL DEFAULT ''"},
+		{"album_artist", "TEXT NOT NULL DEFAULT ''"},
+		{"album", "TEXT NOT NULL DEFAULT ''"},
+		{"title", "TEXT NOT NULL DEFAULT ''"},
+		{"track_number", "INTEGER NOT NULL DEFAULT 0"},
+		{"year", "INTEGER NOT NULL DEFAULT 0"},
+	}},
+	{Version: 5, Description: "record date sources, GPS coordinates and descriptions", columns: []column{
+		{"date_source", "TEXT NOT NULL DEFAULT ''"},
+		{"has_gps", "INTEGER NOT NULL DEFAULT 0"},
+		{"latitude", "REAL NOT NULL DEFAULT 0"},
+		{"longitude", "REAL NOT NULL DEFAULT 0"},
+		{"description", "TEXT NOT NULL DEFAULT ''"},
+	}},
+	{Version: 6, Description: "create the sidecars table", statements: []string{
+		`CREATE TABLE IF NOT EXISTS sidecars (
+			id               INTEGER PRIMARY KEY AUTOINCREMENT,
+			parent_id        INTEGER NOT NULL REFERENCES files(id),
+			source_path      TEXT NOT NULL UNIQUE,
+			dest_path        TEXT NOT NULL DEFAULT '',
+			status           TEXT NOT NULL DEFAULT 'pending',
+			error_message    TEXT NOT NULL DEFAULT '',
+			run_id           INTEGER NOT NULL DEFAULT 0,
+			created_at       TEXT NOT NULL DEFAULT (datetime('now')),
+			updated_at       TEXT NOT NULL DEFAULT (datetime('now'))
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_sidecars_parent_id ON sidecars(parent_id)`,
+	}},
+	{Version: 7, Description: "record RAW+JPEG and Live Photo pairs",
+		columns: []column{
+			{"content_id", "TEXT NOT NULL DEFAULT ''"},
+			{"pair_id", "INTEGER NOT NULL DEFAULT 0"},
+		},
+		statements: []string{
+			`CREATE INDEX IF NOT EXISTS idx_files_content_id ON files(content_id) WHERE content_id != ''`,
+			`CREATE INDEX IF NOT EXISTS idx_files_pair_id ON files(pair_id) WHERE pair_id != 0`,
+		}},
+	{Version: 8, Description: "record perceptual hashes and near-duplicates", columns: []column{
+		{"phash", "TEXT NOT NULL DEFAULT ''"},
+		{"near_duplicate_of", "INTEGER NOT NULL DEFAULT 0"},
+	}},
+	{Version: 9, Description: "record the original and policy action of duplicates", columns: []column{
+		{"duplicate_of", "INTEGER NOT NULL DEFAULT 0"},
+		{"duplicate_action", "TEXT NOT NULL DEFAULT ''"},
+	}},
+	{Version: 10, Description: "record the transfer method", columns: []column{
+		{"transfer", "TEXT NOT NULL DEFAULT ''"},
+	}},
+	{Version: 11, Description: "record the content format of mislabeled files", columns: []column{
+		{"content_format", "TEXT NOT NULL DEFAULT ''"},
+	}},
+	{Version: 12, Description: "record partial hashes",
+		columns: []column{{"partial_hash", "TEXT NOT NULL DEFAULT ''"}},
+		statements: []string{
+			`CREATE INDEX IF NOT EXISTS idx_files_partial_hash ON files(partial_hash) WHERE partial_hash != ''`,
+		}},
+}
+
+// SchemaVersion is the journal schema version of this build.
+var SchemaVersion = len(migrations)
+
+// ErrNewerSchema is returned when opening a journal whose schema was written
+// by a newer version of the program.
+var ErrNewerSchema = errors.New("journal schema is newer than this version supports")
+
+// ErrOlderSchema is returned when opening a journal read-only whose schema
+// needs migrations first.
+var ErrOlderSchema = errors.New("journal schema is older than this version uses")
+
+// MigrateJournal brings the schema of the journal at dbPath up to
+// SchemaVersion. It returns the version the journal had and the migrations
+// applied. With dryRun, the migrations are run and rolled back, so that
+// they are known to succeed but the journal is left as it was.
+func MigrateJournal(dbPath string, dryRun bool) (from int, applied []Migration, err error) {
+	db, err := openJournalDB(dbPath)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer db.Close()
+	return migrate(db, dryRun)
+}
+
+// migrate applies the migrations the journal hasn't had yet in a single
+// transaction.
+func migrate(db *sql.DB, dryRun bool) (int, []Migration, error) {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return 0, nil, err
+	}
+	if version == SchemaVersion {
+		return version, nil, nil
+	}
+	if version > SchemaVersion {
+		return version, nil, newerSchemaError(version)
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return version, nil, err
+	}
+	defer conn.Close()
+	// Take the write lock before reading the version again, so that two
+	// processes opening an old journal at once don't both migrate it
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return version, nil, fmt.Errorf("begin migration: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(ctx, `ROLLBACK`)
+		}
+	}()
+	if err := conn.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return version, nil, fmt.Errorf("read schema version: %w", err)
+	}
+	if version > SchemaVersion {
+		return version, nil, newerSchemaError(version)
+	}
+
+	pending := migrations[version:]
+	for _, m := range pending {
+		for _, col := range m.columns {
+			if err := addColumnIfMissing(ctx, conn, "files", col.name, col.definition); err != nil {
+				return version, nil, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+			}
+		}
+		for _, stmt := range m.statements {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return version, nil, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+			}
+		}
+	}
+	// PRAGMA takes no parameters; the version is an integer of our own
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, SchemaVersion)); err != nil {
+		return version, nil, fmt.Errorf("set schema version: %w", err)
+	}
+	if dryRun {
+		return version, pending, nil
+	}
+	if _, err := conn.ExecContext(ctx, `COMMIT`); err != nil {
+		return version, nil, fmt.Errorf("commit migration: %w", err)
+	}
+	committed = true
+	return version, pending, nil
+}
+
+// schemaVersion returns the schema version of a journal, 0 for a new one.
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return version, nil
+}
+
+func newerSchemaError(version int) error {
+	return fmt.Errorf("%w (version %d, supported up to %d); upgrade mediaorganizer to use this journal",
+		ErrNewerSchema, version, SchemaVersion)
+}
+
+func olderSchemaError(version int) error {
+	return fmt.Errorf("%w (version %d, now %d); run the migrate command to upgrade it",
+		ErrOlderSchema, version, SchemaVersion)
+}
+
+// addColumnIfMissing adds a column to table unless it already exists.
+func addColumnIfMissing(ctx context.Context, conn *sql.Conn, table, column, definition string) error {
+	rows, err := conn.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = conn.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrationsAreNumberedInOrder(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d (%s) has version %d", i+1, m.Description, m.Version)
+		}
+	}
+}
+
+// createJournal creates a journal database with the statements given and
+// closes it.
+func createJournal(t *testing.T, statements string) string {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "old.db")
+	old, err := sql.Open("sqlite", "file:"+dbPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer old.Close()
+	if _, err := old.Exec(statements); err != nil {
+		t.Fatalf("create journal: %v", err)
+	}
+	return dbPath
+}
+
+func userVersion(t *testing.T, dbPath string) int {
+	t.Helper()
+	d, err := openJournalDB(dbPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer d.Close()
+	version, err := schemaVersion(d)
+	if err != nil {
+		t.Fatalf("schemaVersion: %v", err)
+	}
+	return version
+}
+
+func TestInitJournalSetsSchemaVersion(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	j, err := InitJournal(dbPath)
+	if err != nil {
+		t.Fatalf("InitJournal: %v", err)
+	}
+	j.Close()
+	if v := userVersion(t, dbPath); v != SchemaVersion {
+		t.Errorf("schema version = %d, want %d", v, SchemaVersion)
+	}
+
+	// Opening it again has nothing to do
+	from, applied, err := MigrateJournal(dbPath, false)
+	if err != nil || from != SchemaVersion || len(applied) != 0 {
+		t.Errorf("MigrateJournal on current journal = %d, %d migrations, %v", from, len(applied), err)
+	}
+}
+
+func TestInitJournalRefusesNewerSchema(t *testing.T) {
+	dbPath := createJournal(t, fmt.Sprintf(`CREATE TABLE files (id INTEGER PRIMARY KEY, future TEXT); PRAGMA user_version = %d`, SchemaVersion+1))
+
+	if _, err := InitJournal(dbPath); !errors.Is(err, ErrNewerSchema) {
+		t.Fatalf("InitJournal error = %v, want ErrNewerSchema", err)
+	}
+	if _, _, err := MigrateJournal(dbPath, true); !errors.Is(err, ErrNewerSchema) {
+		t.Fatalf("MigrateJournal error = %v, want ErrNewerSchema", err)
+	}
+	if v := userVersion(t, dbPath); v != SchemaVersion+1 {
+		t.Errorf("schema version changed to %d", v)
+	}
+}
+
+func TestMigrateJournalDryRun(t *testing.T) {
+	// Journal of a version that had pairs but no duplicate policy yet,
+	// written before the schema was versioned
+	dbPath := createJournal(t, `
+	CREATE TABLE files (
+		id               INTEGER PRIMARY KEY AUTOINCREMENT,
+		source_path      TEXT NOT NULL UNIQUE,
+		file_size        INTEGER NOT NULL,
+		media_type       TEXT NOT NULL,
+		extension        TEXT NOT NULL,
+		creation_time    TEXT NOT NULL,
+		larger_dimension INTEGER NOT NULL DEFAULT 0,
+		original_name    TEXT NOT NULL,
+		timestamp_key    TEXT NOT NULL,
+		hash             TEXT NOT NULL DEFAULT '',
+		dest_path        TEXT NOT NULL DEFAULT '',
+		sequence_num     INTEGER NOT NULL DEFAULT 0,
+		is_duplicate     INTEGER NOT NULL DEFAULT 0,
+		status           TEXT NOT NULL DEFAULT 'pending',
+		error_message    TEXT NOT NULL DEFAULT '',
+		created_at       TEXT NOT NULL DEFAULT (datetime('now')),
+		updated_at       TEXT NOT NULL DEFAULT (datetime('now')),
+		run_id           INTEGER NOT NULL DEFAULT 0,
+		content_id       TEXT NOT NULL DEFAULT '',
+		pair_id          INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX idx_files_pair_id ON files(pair_id) WHERE pair_id != 0;
+	INSERT INTO files (source_path, file_size, media_type, extension, creation_time, original_name, timestamp_key, status, pair_id)
+	VALUES ('/tmp/old.jpg', 1, 'image', 'jpg', '2024-01-01 00:00:00', 'old.jpg', 'k', 'completed', 7);`)
+
+	from, applied, err := MigrateJournal(dbPath, true)
+	if err != nil {
+		t.Fatalf("MigrateJournal dry run: %v", err)
+	}
+	if from != 0 || len(applied) != SchemaVersion {
+		t.Errorf("dry run = version %d, %d migrations, want 0 and %d", from, len(applied), SchemaVersion)
+	}
+	if v := userVersion(t, dbPath); v != 0 {
+		t.Errorf("dry run changed schema version to %d", v)
+	}
+
+	if _, _, err := MigrateJournal(dbPath, false); err != nil {
+		t.Fatalf("MigrateJournal: %v", err)
+	}
+	if v := userVersion(t, dbPath); v != SchemaVersion {
+		t.Errorf("schema version = %d, want %d", v, SchemaVersion)
+	}
+	j, err := InitJournal(dbPath)
+	if err != nil {
+		t.Fatalf("InitJournal: %v", err)
+	}
+	defer j.Close()
+	rec, err := j.GetBySourcePath("/tmp/old.jpg")
+	if err != nil || rec == nil {
+		t.Fatalf("GetBySourcePath = %v, %v", rec, err)
+	}
+	if rec.PairID != 7 || rec.DuplicateAction != "" || rec.PartialHash != "" {
+		t.Errorf("migrated record = %+v", rec)
+	}
+}
+
+func TestOpenJournalReadOnly(t *testing.T) {
+	// A journal from before the last migration
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	j, err := InitJournal(dbPath)
+	if err != nil {
+		t.Fatalf("InitJournal: %v", err)
+	}
+	if _, err := j.db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, SchemaVersion-1)); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	// An older journal is left for migrate to upgrade
+	if _, err := OpenJournalReadOnly(dbPath); !errors.Is(err, ErrOlderSchema) {
+		t.Fatalf("OpenJournalReadOnly error = %v, want ErrOlderSchema", err)
+	}
+	if v := userVersion(t, dbPath); v != SchemaVersion-1 {
+		t.Errorf("schema version changed to %d", v)
+	}
+
+	if _, _, err := MigrateJournal(dbPath, false); err != nil {
+		t.Fatalf("MigrateJournal: %v", err)
+	}
+	j, err = OpenJournalReadOnly(dbPath)
+	if err != nil {
+		t.Fatalf("OpenJournalReadOnly: %v", err)
+	}
+	defer j.Close()
+	if _, err := j.TotalCount(); err != nil {
+		t.Errorf("TotalCount: %v", err)
+	}
+	if _, err := j.InsertFile(&FileRecord{SourcePath: "/tmp/a.jpg", Status: StatusPending}); err == nil || !strings.Contains(err.Error(), "readonly") {
+		t.Errorf("InsertFile on a read-only journal: %v", err)
+	}
+
+	// A missing journal is not created
+	missing := filepath.Join(t.TempDir(), "missing.db")
+	if _, err := OpenJournalReadOnly(missing); err == nil {
+		t.Errorf("OpenJournalReadOnly of a missing journal succeeded")
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("missing journal created: %v", err)
+	}
+}
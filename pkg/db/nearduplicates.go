@@ -0,0 +1,48 @@
+package db
+
+import "time"
+
+// Near-duplicates are re-encoded, resized or re-saved copies of an image,
+// found by comparing perceptual hashes. Each points to its primary copy, the
+// highest-resolution one, through near_duplicate_of.
+
+// GetPerceptualPrimaries returns the records with a perceptual hash that are
+// neither exact nor near-duplicates, ignoring undone and dest_index records.
+func (j *Journal) GetPerceptualPrimaries() ([]*FileRecord, error) {
+	rows, err := j.query(`SELECT ` + fileColumns + ` FROM files
+		WHERE phash != '' AND near_duplicate_of = 0 AND is_duplicate = 0 AND status NOT IN ('dest_index', 'undone')
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRecords(rows)
+}
+
+// SetNearDuplicateOf marks a record as a near-duplicate of primaryID.
+func (j *Journal) SetNearDuplicateOf(id, primaryID int64) error {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := j.exec(
+		`UPDATE files SET near_duplicate_of = ?, updated_at = ?, run_id = ? WHERE id = ?`,
+		primaryID, now, j.runID, id,
+	)
+	return err
+}
+
+// ReassignNearDuplicates points the near-duplicates of oldPrimaryID to
+// newPrimaryID, when a higher-resolution copy replaces the primary.
+func (j *Journal) ReassignNearDuplicates(oldPrimaryID, newPrimaryID int64) error {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := j.exec(
+		`UPDATE files SET near_duplicate_of = ?, updated_at = ?, run_id = ? WHERE near_duplicate_of = ?`,
+		newPrimaryID, now, j.runID, oldPrimaryID,
+	)
+	return err
+}
+
+// NearDuplicateCount returns the number of records marked as near-duplicates.
+func (j *Journal) NearDuplicateCount() (int, error) {
+	var count int
+	err := j.queryRow(`SELECT COUNT(*) FROM files WHERE near_duplicate_of != 0`).Scan(&count)
+	return count, err
+}
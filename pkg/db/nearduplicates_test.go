@@ -0,0 +1,46 @@
+package db
+
+import "testing"
+
+func TestNearDuplicates(t *testing.T) {
+	j := newTestJournal(t)
+
+	original := sampleRecord("/src/original.jpg")
+	original.PerceptualHash = "f0e1d2c3b4a59687"
+	originalID, err := j.InsertFile(original)
+	if err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	resized := sampleRecord("/src/resized.jpg")
+	resized.PerceptualHash = "f0e1d2c3b4a59685"
+	resizedID, _ := j.InsertFile(resized)
+	larger := sampleRecord("/src/larger.jpg")
+	larger.PerceptualHash = "f0e1d2c3b4a59687"
+	largerID, _ := j.InsertFile(larger)
+	j.InsertFile(sampleRecord("/src/unhashed.jpg"))
+
+	primaries, err := j.GetPerceptualPrimaries()
+	if err != nil || len(primaries) != 3 || primaries[0].PerceptualHash != original.PerceptualHash {
+		t.Fatalf("GetPerceptualPrimaries = %+v, %v; want the 3 hashed records", primaries, err)
+	}
+
+	if err := j.SetNearDuplicateOf(resizedID, originalID); err != nil {
+		t.Fatalf("SetNearDuplicateOf: %v", err)
+	}
+	// A higher-resolution copy takes over as primary
+	if err := j.ReassignNearDuplicates(originalID, largerID); err != nil {
+		t.Fatalf("ReassignNearDuplicates: %v", err)
+	}
+	j.SetNearDuplicateOf(originalID, largerID)
+
+	primaries, _ = j.GetPerceptualPrimaries()
+	if len(primaries) != 1 || primaries[0].ID != largerID {
+		t.Errorf("GetPerceptualPrimaries = %+v; want only the larger copy", primaries)
+	}
+	if got, _ := j.GetFile(resizedID); got.NearDuplicateOf != largerID {
+		t.Errorf("resized copy points to %d, want %d", got.NearDuplicateOf, largerID)
+	}
+	if count, err := j.NearDuplicateCount(); err != nil || count != 2 {
+		t.Errorf("NearDuplicateCount = %d, %v; want 2", count, err)
+	}
+}
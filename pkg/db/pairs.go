@@ -0,0 +1,89 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Paired files (RAW+JPEG, Live Photo photo+video) share the base name of one
+// of them, the pair leader. The other members point to the leader through
+// pair_id and take its sequence number. They still count towards the files
+// sharing their own timestamp_key, so those get numbered around them, but
+// are never renumbered with them.
+
+// GetFile returns the record with the given ID, or nil if there is none.
+func (j *Journal) GetFile(id int64) (*FileRecord, error) {
+	r, err := scanRecord(j.queryRow(`SELECT `+fileColumns+` FROM files WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// GetNamesakes returns the records of the other files in the directory of
+// sourcePath that have the same base name, e.g. IMG_1234.JPG for
+// IMG_1234.CR2, ignoring undone and dest_index records.
+func (j *Journal) GetNamesakes(sourcePath string) ([]*FileRecord, error) {
+	name := filepath.Base(sourcePath)
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	// Range scan over the source_path index: '/' sorts right after '.'
+	prefix := filepath.Join(filepath.Dir(sourcePath), stem)
+	rows, err := j.query(`SELECT `+fileColumns+` FROM files
+		WHERE source_path >= ? AND source_path < ? AND source_path != ? AND status NOT IN ('dest_index', 'undone')
+		ORDER BY id`, prefix+".", prefix+"/", sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records, err := scanRecords(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip names with more dots, e.g. IMG_1234.edit.jpg
+	namesakes := records[:0]
+	for _, r := range records {
+		other := filepath.Base(r.SourcePath)
+		if strings.TrimSuffix(other, filepath.Ext(other)) == stem {
+			namesakes = append(namesakes, r)
+		}
+	}
+	return namesakes, nil
+}
+
+// GetByContentID returns the records with the given Apple ContentIdentifier,
+// ignoring undone and dest_index records.
+func (j *Journal) GetByContentID(contentID string) ([]*FileRecord, error) {
+	if contentID == "" {
+		return nil, nil
+	}
+	rows, err := j.query(`SELECT `+fileColumns+` FROM files WHERE content_id = ? AND status NOT IN ('dest_index', 'undone') ORDER BY id`, contentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRecords(rows)
+}
+
+// GetPairMembers returns the records that share the base name of leaderID,
+// ignoring undone records.
+func (j *Journal) GetPairMembers(leaderID int64) ([]*FileRecord, error) {
+	rows, err := j.query(`SELECT `+fileColumns+` FROM files WHERE pair_id = ? AND status != 'undone' ORDER BY id`, leaderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRecords(rows)
+}
+
+// SetPairLeader makes a record a member of the pair led by leaderID.
+func (j *Journal) SetPairLeader(id, leaderID int64) error {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := j.exec(
+		`UPDATE files SET pair_id = ?, updated_at = ?, run_id = ? WHERE id = ?`,
+		leaderID, now, j.runID, id,
+	)
+	return err
+}
@@ -0,0 +1,57 @@
+package db
+
+import "testing"
+
+func TestPairLookups(t *testing.T) {
+	j := newTestJournal(t)
+
+	photo := sampleRecord("/src/IMG_1234.HEIC")
+	photo.ContentID = "ABC"
+	photoID, err := j.InsertFile(photo)
+	if err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	video := sampleRecord("/src/IMG_1234.MOV")
+	video.MediaType = "video"
+	video.ContentID = "ABC"
+	videoID, _ := j.InsertFile(video)
+	j.InsertFile(sampleRecord("/src/other.jpg"))
+	j.InsertFile(sampleRecord("/src/IMG_1234.edit.jpg"))
+	j.InsertFile(sampleRecord("/src/IMG_1234 copy.jpg"))
+	j.InsertFile(sampleRecord("/src/IMG_1234/nested.jpg"))
+	j.InsertFile(sampleRecord("/src/sub/IMG_1234.jpg"))
+
+	namesakes, err := j.GetNamesakes("/src/IMG_1234.HEIC")
+	if err != nil || len(namesakes) != 1 || namesakes[0].ID != videoID || namesakes[0].ContentID != "ABC" {
+		t.Fatalf("GetNamesakes = %+v, %v; want only the video", namesakes, err)
+	}
+	if got, err := j.GetFile(photoID); err != nil || got == nil || got.SourcePath != "/src/IMG_1234.HEIC" {
+		t.Errorf("GetFile = %+v, %v", got, err)
+	}
+
+	matches, err := j.GetByContentID("ABC")
+	if err != nil || len(matches) != 2 {
+		t.Fatalf("GetByContentID = %d records, %v; want 2", len(matches), err)
+	}
+	if matches, _ := j.GetByContentID(""); len(matches) != 0 {
+		t.Errorf("empty content ID should match nothing, got %d", len(matches))
+	}
+
+	// The video leads here so that the member is the first record
+	if err := j.SetPairLeader(photoID, videoID); err != nil {
+		t.Fatalf("SetPairLeader: %v", err)
+	}
+	members, err := j.GetPairMembers(videoID)
+	if err != nil || len(members) != 1 || members[0].ID != photoID {
+		t.Fatalf("GetPairMembers = %+v, %v", members, err)
+	}
+	if members[0].PairID != videoID {
+		t.Errorf("member not linked to leader: %+v", members[0])
+	}
+
+	// A pair member is never renumbered with the files sharing its timestamp
+	first, err := j.GetFirstByTimestampKey(photo.TimestampKey)
+	if err != nil || first == nil || first.ID != videoID {
+		t.Errorf("GetFirstByTimestampKey = %+v, %v; want the video", first, err)
+	}
+}
@@ -0,0 +1,43 @@
+package db
+
+import "database/sql"
+
+// A plan is computed by a dry run against a snapshot of the journal, so the
+// real journal is left untouched until the plan is applied.
+
+// Snapshot writes a consistent copy of the journal database to path, which
+// must not exist or be empty. It can't be taken while batching.
+func (j *Journal) Snapshot(path string) error {
+	_, err := j.db.Exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+// DiscardDryRuns forgets the records of earlier dry runs, which would
+// otherwise be taken for organized files and skipped.
+func (j *Journal) DiscardDryRuns() error {
+	for _, stmt := range []string{
+		`DELETE FROM sidecars WHERE parent_id IN (SELECT id FROM files WHERE status = 'dry_run')`,
+		`DELETE FROM files WHERE status = 'dry_run'`,
+		`UPDATE sidecars SET status = 'pending', dest_path = '' WHERE status = 'dry_run'`,
+	} {
+		if _, err := j.exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRunFiles returns the records touched by the current run, ordered by
+// source path.
+func (j *Journal) GetRunFiles() ([]*FileRecord, error) {
+	return j.GetFilesOfRun(j.runID)
+}
+
+// GetBySourcePath returns the record for a source path, or nil if there is none.
+func (j *Journal) GetBySourcePath(path string) (*FileRecord, error) {
+	r, err := scanRecord(j.queryRow(`SELECT `+fileColumns+` FROM files WHERE source_path = ?`, path))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
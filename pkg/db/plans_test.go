@@ -0,0 +1,64 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotAndDiscardDryRuns(t *testing.T) {
+	j := newTestJournal(t)
+	j.StartRun("test", "dry-run", "{}")
+
+	doneID, _ := j.InsertFile(sampleRecord("/src/done.jpg"))
+	j.UpdateStatus(doneID, StatusCompleted, "")
+	dryID, _ := j.InsertFile(sampleRecord("/src/dry.jpg"))
+	j.InsertSidecars(dryID, []string{"/src/dry.xmp"})
+	j.UpdateStatus(dryID, StatusDryRun, "")
+
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := j.Snapshot(path); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	snap, err := InitJournal(path)
+	if err != nil {
+		t.Fatalf("InitJournal of snapshot: %v", err)
+	}
+	defer snap.Close()
+
+	if err := snap.DiscardDryRuns(); err != nil {
+		t.Fatalf("DiscardDryRuns: %v", err)
+	}
+	if rec, _ := snap.GetBySourcePath("/src/dry.jpg"); rec != nil {
+		t.Errorf("dry run record survived: %+v", rec)
+	}
+	if rec, _ := snap.GetBySourcePath("/src/done.jpg"); rec == nil || rec.Status != StatusCompleted {
+		t.Errorf("completed record = %+v; want it kept", rec)
+	}
+	if sidecars, _ := snap.GetSidecars(dryID); len(sidecars) != 0 {
+		t.Errorf("sidecars of dry run record survived: %d", len(sidecars))
+	}
+
+	// The original journal is untouched
+	if rec, _ := j.GetBySourcePath("/src/dry.jpg"); rec == nil {
+		t.Error("DiscardDryRuns on the snapshot changed the original journal")
+	}
+}
+
+func TestGetRunFiles(t *testing.T) {
+	j := newTestJournal(t)
+	j.StartRun("test", "move", "{}")
+	j.InsertFile(sampleRecord("/src/old.jpg"))
+
+	j.StartRun("test", "dry-run", "{}")
+	j.InsertFile(sampleRecord("/src/b.jpg"))
+	j.InsertFile(sampleRecord("/src/a.jpg"))
+	j.InsertDestFiles([]DestFile{{Path: "/dst/x.jpg", Size: 1, MediaType: "image", Extension: "jpg"}})
+
+	recs, err := j.GetRunFiles()
+	if err != nil {
+		t.Fatalf("GetRunFiles: %v", err)
+	}
+	if len(recs) != 2 || recs[0].SourcePath != "/src/a.jpg" || recs[1].SourcePath != "/src/b.jpg" {
+		t.Errorf("GetRunFiles = %+v; want a.jpg and b.jpg of the current run, in order", recs)
+	}
+}
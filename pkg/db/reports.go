@@ -0,0 +1,87 @@
+package db
+
+// MediaTypeStats returns the number of source records per media type.
+func (j *Journal) MediaTypeStats() (map[string]int, error) {
+	rows, err := j.query(`SELECT media_type, COUNT(*) FROM files WHERE status != 'dest_index' GROUP BY media_type`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]int)
+	for rows.Next() {
+		var mediaType string
+		var count int
+		if err := rows.Scan(&mediaType, &count); err != nil {
+			return nil, err
+		}
+		stats[mediaType] = count
+	}
+	return stats, rows.Err()
+}
+
+// GetFilesOfRun returns the records last touched by the run runID, ordered by
+// source path. Files handled again by a later run belong to that run instead.
+func (j *Journal) GetFilesOfRun(runID int64) ([]*FileRecord, error) {
+	rows, err := j.query(`SELECT `+fileColumns+` FROM files WHERE run_id = ? AND status != 'dest_index' ORDER BY source_path`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRecords(rows)
+}
+
+// FileFilter selects records in QueryFiles. Empty fields don't filter.
+type FileFilter struct {
+	Status      FileStatus
+	MediaType   string
+	Extension   string
+	CreatedFrom string // first creation date, YYYY-MM-DD
+	CreatedTo   string // last creation date, YYYY-MM-DD
+	Duplicate   *bool  // only duplicates, or only files that are not
+	Hash        string
+	PathGlob    string // SQLite GLOB pattern matched against source and destination paths
+}
+
+// QueryFiles returns the source records matching filter, ordered by source
+// path.
+func (j *Journal) QueryFiles(filter FileFilter) ([]*FileRecord, error) {
+	query := `SELECT ` + fileColumns + ` FROM files WHERE status != 'dest_index'`
+	var args []any
+	where := func(cond string, arg any) {
+		query += ` AND ` + cond
+		args = append(args, arg)
+	}
+	if filter.Status != "" {
+		where(`status = ?`, filter.Status)
+	}
+	if filter.MediaType != "" {
+		where(`media_type = ?`, filter.MediaType)
+	}
+	if filter.Extension != "" {
+		where(`extension = ?`, filter.Extension)
+	}
+	if filter.CreatedFrom != "" {
+		where(`substr(creation_time, 1, 10) >= ?`, filter.CreatedFrom)
+	}
+	if filter.CreatedTo != "" {
+		where(`substr(creation_time, 1, 10) <= ?`, filter.CreatedTo)
+	}
+	if filter.Duplicate != nil {
+		where(`is_duplicate = ?`, boolToInt(*filter.Duplicate))
+	}
+	if filter.Hash != "" {
+		where(`hash = ?`, filter.Hash)
+	}
+	if filter.PathGlob != "" {
+		query += ` AND (source_path GLOB ? OR dest_path GLOB ?)`
+		args = append(args, filter.PathGlob, filter.PathGlob)
+	}
+
+	rows, err := j.query(query+` ORDER BY source_path`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRecords(rows)
+}
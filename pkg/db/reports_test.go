@@ -0,0 +1,99 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMediaTypeStats(t *testing.T) {
+	j := newTestJournal(t)
+	j.InsertFile(sampleRecord("/src/a.jpg"))
+	j.InsertFile(sampleRecord("/src/b.jpg"))
+	video := sampleRecord("/src/c.mp4")
+	video.MediaType = "video"
+	j.InsertFile(video)
+	j.InsertDestFiles([]DestFile{{Path: "/dest/d.jpg", Size: 1, MediaType: "image"}})
+
+	stats, err := j.MediaTypeStats()
+	if err != nil {
+		t.Fatalf("MediaTypeStats: %v", err)
+	}
+	if stats["image"] != 2 || stats["video"] != 1 {
+		t.Errorf("MediaTypeStats() = %v; want 2 images and 1 video, without indexed destination files", stats)
+	}
+}
+
+func TestGetFilesOfRun(t *testing.T) {
+	j := newTestJournal(t)
+	first, _ := j.StartRun("test", "move", "{}")
+	j.InsertFile(sampleRecord("/src/b.jpg"))
+	j.InsertFile(sampleRecord("/src/a.jpg"))
+	second, _ := j.StartRun("test", "move", "{}")
+	j.InsertFile(sampleRecord("/src/c.jpg"))
+
+	recs, err := j.GetFilesOfRun(first)
+	if err != nil {
+		t.Fatalf("GetFilesOfRun: %v", err)
+	}
+	if len(recs) != 2 || recs[0].SourcePath != "/src/a.jpg" || recs[1].SourcePath != "/src/b.jpg" {
+		t.Errorf("GetFilesOfRun(%d) = %d records; want a.jpg and b.jpg in order", first, len(recs))
+	}
+	if recs, _ := j.GetFilesOfRun(second); len(recs) != 1 {
+		t.Errorf("GetFilesOfRun(%d) = %d records; want 1", second, len(recs))
+	}
+}
+
+func TestQueryFiles(t *testing.T) {
+	j := newTestJournal(t)
+	failedID, _ := j.InsertFile(sampleRecord("/src/2019/a.jpg"))
+	j.UpdateStatus(failedID, StatusFailed, "boom")
+	j.UpdateHash(failedID, "aaaa")
+	old := sampleRecord("/src/old.png")
+	old.Extension = "png"
+	old.CreationTime = "1970-01-01 00:00:00"
+	oldID, _ := j.InsertFile(old)
+	j.UpdateDestPath(oldID, "/dest/1970/old.png", 0, false)
+	video := sampleRecord("/src/c.mp4")
+	video.MediaType = "video"
+	video.Extension = "mp4"
+	dupID, _ := j.InsertFile(video)
+	j.UpdateHash(dupID, "aaaa")
+	j.UpdateDestPath(dupID, "/dest/duplicates/c.mp4", 0, true)
+	j.InsertDestFiles([]DestFile{{Path: "/dest/d.jpg", Size: 1, MediaType: "image", Extension: "jpg"}})
+
+	yes, no := true, false
+	tests := []struct {
+		name   string
+		filter FileFilter
+		want   []string
+	}{
+		{"all", FileFilter{}, []string{"/src/2019/a.jpg", "/src/c.mp4", "/src/old.png"}},
+		{"status", FileFilter{Status: StatusFailed}, []string{"/src/2019/a.jpg"}},
+		{"media type", FileFilter{MediaType: "video"}, []string{"/src/c.mp4"}},
+		{"extension", FileFilter{Extension: "jpg"}, []string{"/src/2019/a.jpg"}},
+		{"date range", FileFilter{CreatedFrom: "1970-01-01", CreatedTo: "1970-12-31"}, []string{"/src/old.png"}},
+		{"from date", FileFilter{CreatedFrom: "2024-01-15"}, []string{"/src/2019/a.jpg", "/src/c.mp4"}},
+		{"duplicates", FileFilter{Duplicate: &yes}, []string{"/src/c.mp4"}},
+		{"not duplicates", FileFilter{Duplicate: &no}, []string{"/src/2019/a.jpg", "/src/old.png"}},
+		{"hash", FileFilter{Hash: "aaaa"}, []string{"/src/2019/a.jpg", "/src/c.mp4"}},
+		{"duplicates of hash", FileFilter{Hash: "aaaa", Duplicate: &yes}, []string{"/src/c.mp4"}},
+		{"source glob", FileFilter{PathGlob: "*/2019/*"}, []string{"/src/2019/a.jpg"}},
+		{"destination glob", FileFilter{PathGlob: "/dest/1970/*"}, []string{"/src/old.png"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recs, err := j.QueryFiles(tt.filter)
+			if err != nil {
+				t.Fatalf("QueryFiles: %v", err)
+			}
+			var got []string
+			for _, r := range recs {
+				got = append(got, r.SourcePath)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("QueryFiles(%+v) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RunCounts holds the final counters of a run.
+type RunCounts struct {
+	TotalFiles     int
+	ProcessedFiles int
+	SkippedFiles   int
+	OrganizedFiles int
+	ErrorCount     int
+	DuplicateCount int
+}
+
+// RunRecord represents a row in the runs table: one invocation of the program.
+// EndedAt is empty for runs that are still active or were interrupted.
+type RunRecord struct {
+	ID        int64
+	StartedAt string
+	EndedAt   string
+	Version   string
+	Mode      string
+	Config    string // JSON-serialized config.Config
+	RunCounts
+}
+
+// StartRun records the start of a new run and makes it the current run.
+// Every file record inserted or updated afterwards points to this run.
+func (j *Journal) StartRun(version, mode, configJSON string) (int64, error) {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	res, err := j.exec(
+		`INSERT INTO runs (started_at, version, mode, config) VALUES (?, ?, ?, ?)`,
+		now, version, mode, configJSON,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("insert run: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("last insert id: %w", err)
+	}
+	j.runID = id
+	return id, nil
+}
+
+// FinishRun records the end time and final counts of the current run.
+func (j *Journal) FinishRun(counts RunCounts) error {
+	if j.runID == 0 {
+		return nil
+	}
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := j.exec(`
+		UPDATE runs SET ended_at = ?, total_files = ?, processed_files = ?, skipped_files = ?,
+			organized_files = ?, error_count = ?, duplicate_count = ?
+		WHERE id = ?`,
+		now, counts.TotalFiles, counts.ProcessedFiles, counts.SkippedFiles,
+		counts.OrganizedFiles, counts.ErrorCount, counts.DuplicateCount, j.runID,
+	)
+	return err
+}
+
+// CurrentRunID returns the ID of the run started by StartRun, or 0 if none.
+func (j *Journal) CurrentRunID() int64 {
+	return j.runID
+}
+
+// GetRun returns the run with the given ID, or nil if it does not exist.
+func (j *Journal) GetRun(id int64) (*RunRecord, error) {
+	r, err := scanRun(j.queryRow(`SELECT `+runColumns+` FROM runs WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// ListRuns returns all runs, oldest first.
+func (j *Journal) ListRuns() ([]*RunRecord, error) {
+	rows, err := j.query(`SELECT ` + runColumns + ` FROM runs ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []*RunRecord
+	for rows.Next() {
+		r, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+const runColumns = `id, started_at, ended_at, version, mode, config,
+	total_files, processed_files, skipped_files, organized_files, error_count, duplicate_count`
+
+func scanRun(row rowScanner) (*RunRecord, error) {
+	r := &RunRecord{}
+	err := row.Scan(
+		&r.ID, &r.StartedAt, &r.EndedAt, &r.Version, &r.Mode, &r.Config,
+		&r.TotalFiles, &r.ProcessedFiles, &r.SkippedFiles, &r.OrganizedFiles,
+		&r.ErrorCount, &r.DuplicateCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
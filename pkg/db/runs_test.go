@@ -0,0 +1,82 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestStartAndFinishRun(t *testing.T) {
+	j := newTestJournal(t)
+
+	runID, err := j.StartRun("v1.2.0", "move", `{"source":"/src"}`)
+	if err != nil {
+		t.Fatalf("StartRun: %v", err)
+	}
+	if j.CurrentRunID() != runID {
+		t.Errorf("CurrentRunID = %d, want %d", j.CurrentRunID(), runID)
+	}
+
+	run, err := j.GetRun(runID)
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+	if run.EndedAt != "" {
+		t.Errorf("expected empty ended_at for active run, got %q", run.EndedAt)
+	}
+
+	err = j.FinishRun(RunCounts{TotalFiles: 10, OrganizedFiles: 8, ErrorCount: 1, DuplicateCount: 2})
+	if err != nil {
+		t.Fatalf("FinishRun: %v", err)
+	}
+
+	run, _ = j.GetRun(runID)
+	if run.EndedAt == "" {
+		t.Errorf("expected ended_at to be set")
+	}
+	if run.Version != "v1.2.0" || run.Mode != "move" || run.Config != `{"source":"/src"}` {
+		t.Errorf("unexpected run metadata: %+v", run)
+	}
+	if run.TotalFiles != 10 || run.OrganizedFiles != 8 || run.ErrorCount != 1 || run.DuplicateCount != 2 {
+		t.Errorf("unexpected run counts: %+v", run.RunCounts)
+	}
+}
+
+func TestGetRunMissing(t *testing.T) {
+	j := newTestJournal(t)
+
+	run, err := j.GetRun(42)
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+	if run != nil {
+		t.Errorf("expected nil for missing run, got %+v", run)
+	}
+}
+
+func TestRecordsPointToLastRun(t *testing.T) {
+	j := newTestJournal(t)
+
+	run1, _ := j.StartRun("dev", "dry-run", "{}")
+	id, _ := j.InsertFile(sampleRecord("/tmp/a.jpg"))
+	j.UpdateStatus(id, StatusDryRun, "")
+	j.FinishRun(RunCounts{})
+
+	run2, _ := j.StartRun("dev", "move", "{}")
+	j.UpdateDestPath(id, "/dest/a.jpg", 0, false)
+	j.UpdateStatus(id, StatusCompleted, "")
+
+	completed, _ := j.GetCompletedFiles()
+	if len(completed) != 1 {
+		t.Fatalf("expected 1 completed, got %d", len(completed))
+	}
+	if completed[0].RunID != run2 {
+		t.Errorf("expected record to point to run %d, got %d (first run was %d)", run2, completed[0].RunID, run1)
+	}
+
+	runs, err := j.ListRuns()
+	if err != nil {
+		t.Fatalf("ListRuns: %v", err)
+	}
+	if len(runs) != 2 || runs[0].ID != run1 || runs[1].ID != run2 {
+		t.Errorf("expected runs [%d %d], got %v", run1, run2, runs)
+	}
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// SidecarRecord represents a row in the sidecars table: a companion file
+// (.xmp, .THM, .AAE, .srt, .json) that follows its parent file record.
+type SidecarRecord struct {
+	ID           int64
+	ParentID     int64
+	SourcePath   string
+	DestPath     string
+	Status       FileStatus
+	ErrorMessage string
+	RunID        int64
+
+	// Parent paths, joined from the files table
+	ParentSourcePath string
+	ParentDestPath   string
+}
+
+// InsertSidecars records the sidecars of a file record as pending. Sidecars
+// already in the journal are left alone unless a previous organization of
+// them was undone, in which case they are attached to parentID again.
+func (j *Journal) InsertSidecars(parentID int64, sourcePaths []string) error {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	for _, path := range sourcePaths {
+		_, err := j.exec(`
+			INSERT INTO sidecars (parent_id, source_path, status, run_id, created_at, updated_at)
+			VALUES (?, ?, 'pending', ?, ?, ?)
+			ON CONFLICT(source_path) DO UPDATE SET
+				parent_id = excluded.parent_id, dest_path = '', status = 'pending',
+				error_message = '', run_id = excluded.run_id, updated_at = excluded.updated_at
+			WHERE sidecars.status = 'undone'`,
+			parentID, path, j.runID, now, now,
+		)
+		if err != nil {
+			return fmt.Errorf("insert sidecar %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// GetSidecars returns the sidecars of a file record.
+func (j *Journal) GetSidecars(parentID int64) ([]*SidecarRecord, error) {
+	return j.querySidecars(`WHERE s.parent_id = ? ORDER BY s.id`, parentID)
+}
+
+// GetCompletedSidecars returns the completed sidecars of a file record.
+func (j *Journal) GetCompletedSidecars(parentID int64) ([]*SidecarRecord, error) {
+	return j.querySidecars(`WHERE s.parent_id = ? AND s.status = 'completed' ORDER BY s.id`, parentID)
+}
+
+// GetPendingSidecarsOfCompleted returns pending sidecars whose parent file was
+// already organized, e.g. because the run was interrupted between the two moves.
+// Sidecars of deleted duplicates, which have no destination, stay in the source.
+func (j *Journal) GetPendingSidecarsOfCompleted() ([]*SidecarRecord, error) {
+	return j.querySidecars(`WHERE s.status = 'pending' AND p.status = 'completed' AND p.dest_path != '' ORDER BY s.id`)
+}
+
+// UpdateSidecar sets the destination, status and error message of a sidecar.
+func (j *Journal) UpdateSidecar(id int64, destPath string, status FileStatus, errMsg string) error {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := j.exec(
+		`UPDATE sidecars SET dest_path = ?, status = ?, error_message = ?, updated_at = ?, run_id = ? WHERE id = ?`,
+		destPath, string(status), errMsg, now, j.runID, id,
+	)
+	return err
+}
+
+// SidecarStats returns a map of status → count for all sidecars.
+func (j *Journal) SidecarStats() (map[FileStatus]int, error) {
+	rows, err := j.query(`SELECT status, COUNT(*) FROM sidecars GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[FileStatus]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		stats[FileStatus(status)] = count
+	}
+	return stats, rows.Err()
+}
+
+const sidecarColumns = `s.id, s.parent_id, s.source_path, s.dest_path, s.status, s.error_message, s.run_id,
+	p.source_path, p.dest_path`
+
+func (j *Journal) querySidecars(where string, args ...any) ([]*SidecarRecord, error) {
+	rows, err := j.query(`SELECT `+sidecarColumns+` FROM sidecars s JOIN files p ON p.id = s.parent_id `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*SidecarRecord
+	for rows.Next() {
+		r, err := scanSidecar(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+func scanSidecar(row rowScanner) (*SidecarRecord, error) {
+	r := &SidecarRecord{}
+	var status string
+	if err := row.Scan(
+		&r.ID, &r.ParentID, &r.SourcePath, &r.DestPath, &status, &r.ErrorMessage, &r.RunID,
+		&r.ParentSourcePath, &r.ParentDestPath,
+	); err != nil {
+		return nil, err
+	}
+	r.Status = FileStatus(status)
+	return r, nil
+}
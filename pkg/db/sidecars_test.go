@@ -0,0 +1,107 @@
+package db
+
+import "testing"
+
+func TestInsertAndGetSidecars(t *testing.T) {
+	j := newTestJournal(t)
+
+	id, err := j.InsertFile(sampleRecord("/src/IMG_1234.jpg"))
+	if err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	if err := j.InsertSidecars(id, []string{"/src/IMG_1234.xmp", "/src/IMG_1234.jpg.json"}); err != nil {
+		t.Fatalf("InsertSidecars: %v", err)
+	}
+	// Inserting again is a no-op
+	if err := j.InsertSidecars(id, []string{"/src/IMG_1234.xmp"}); err != nil {
+		t.Fatalf("InsertSidecars (again): %v", err)
+	}
+
+	sidecars, err := j.GetSidecars(id)
+	if err != nil {
+		t.Fatalf("GetSidecars: %v", err)
+	}
+	if len(sidecars) != 2 {
+		t.Fatalf("expected 2 sidecars, got %d", len(sidecars))
+	}
+	sc := sidecars[0]
+	if sc.ParentID != id || sc.SourcePath != "/src/IMG_1234.xmp" || sc.Status != StatusPending {
+		t.Errorf("unexpected sidecar: %+v", sc)
+	}
+	if sc.ParentSourcePath != "/src/IMG_1234.jpg" {
+		t.Errorf("ParentSourcePath = %q", sc.ParentSourcePath)
+	}
+
+	if err := j.UpdateSidecar(sc.ID, "/dst/x.xmp", StatusCompleted, ""); err != nil {
+		t.Fatalf("UpdateSidecar: %v", err)
+	}
+	completed, err := j.GetCompletedSidecars(id)
+	if err != nil {
+		t.Fatalf("GetCompletedSidecars: %v", err)
+	}
+	if len(completed) != 1 || completed[0].DestPath != "/dst/x.xmp" {
+		t.Errorf("expected 1 completed sidecar at /dst/x.xmp, got %+v", completed)
+	}
+
+	stats, err := j.SidecarStats()
+	if err != nil {
+		t.Fatalf("SidecarStats: %v", err)
+	}
+	if stats[StatusCompleted] != 1 || stats[StatusPending] != 1 {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+}
+
+func TestGetPendingSidecarsOfCompleted(t *testing.T) {
+	j := newTestJournal(t)
+
+	done, _ := j.InsertFile(sampleRecord("/src/done.jpg"))
+	j.UpdateDestPath(done, "/dst/done.jpg", 0, false)
+	j.UpdateStatus(done, StatusCompleted, "")
+	pending, _ := j.InsertFile(sampleRecord("/src/pending.jpg"))
+
+	j.InsertSidecars(done, []string{"/src/done.xmp"})
+	j.InsertSidecars(pending, []string{"/src/pending.xmp"})
+
+	leftovers, err := j.GetPendingSidecarsOfCompleted()
+	if err != nil {
+		t.Fatalf("GetPendingSidecarsOfCompleted: %v", err)
+	}
+	if len(leftovers) != 1 || leftovers[0].SourcePath != "/src/done.xmp" || leftovers[0].ParentDestPath != "/dst/done.jpg" {
+		t.Errorf("unexpected leftovers: %+v", leftovers)
+	}
+}
+
+func TestSidecarResetRevivalAndDrop(t *testing.T) {
+	j := newTestJournal(t)
+
+	id, _ := j.InsertFile(sampleRecord("/src/a.jpg"))
+	j.InsertSidecars(id, []string{"/src/a.xmp"})
+	sidecars, _ := j.GetSidecars(id)
+
+	j.UpdateSidecar(sidecars[0].ID, "/dst/a.xmp", StatusFailed, "boom")
+	if n, err := j.ResetFailed(); err != nil || n != 1 {
+		t.Errorf("ResetFailed = %d, %v; want 1 sidecar reset", n, err)
+	}
+	sidecars, _ = j.GetSidecars(id)
+	if sidecars[0].Status != StatusPending || sidecars[0].ErrorMessage != "" {
+		t.Errorf("sidecar not reset: %+v", sidecars[0])
+	}
+
+	// An undone sidecar is reattached when it is found again
+	j.UpdateSidecar(sidecars[0].ID, "/dst/a.xmp", StatusUndone, "")
+	if err := j.InsertSidecars(id, []string{"/src/a.xmp"}); err != nil {
+		t.Fatalf("InsertSidecars: %v", err)
+	}
+	sidecars, _ = j.GetSidecars(id)
+	if len(sidecars) != 1 || sidecars[0].Status != StatusPending || sidecars[0].DestPath != "" {
+		t.Errorf("undone sidecar not revived: %+v", sidecars)
+	}
+
+	if err := j.DropAll(); err != nil {
+		t.Fatalf("DropAll: %v", err)
+	}
+	if stats, _ := j.SidecarStats(); len(stats) != 0 {
+		t.Errorf("expected no sidecars after DropAll, got %v", stats)
+	}
+}
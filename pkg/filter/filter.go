@@ -0,0 +1,200 @@
+// Package filter decides which files under a directory a scan considers:
+// include and exclude patterns, size limits, a maximum depth and the
+// .mediaignore files found along the way.
+package filter
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Reasons for skipping a file, as counted in the scan result.
+const (
+	ReasonExcluded    = "excluded"     // matches an exclude pattern
+	ReasonNotIncluded = "not_included" // matches none of the include patterns
+	ReasonIgnored     = "mediaignore"  // listed in a .mediaignore file
+	ReasonTooSmall    = "too_small"
+	ReasonTooLarge    = "too_large"
+	ReasonTooDeep     = "too_deep"
+	ReasonDateRange   = "date_range" // created outside the date range
+)
+
+// Rules are the user's filters. Include and exclude patterns use the syntax
+// of .gitignore lines and are relative to the directory being scanned.
+type Rules struct {
+	Include  []string
+	Exclude  []string
+	MinSize  int64 // bytes; 0 for no minimum
+	MaxSize  int64 // bytes; 0 for no maximum
+	MaxDepth int   // 1 for the files directly in the directory; 0 for no limit
+}
+
+// Matcher applies Rules and .mediaignore files below a root directory. It is
+// safe for concurrent use.
+type Matcher struct {
+	root    string
+	rules   Rules
+	include []*pattern
+	exclude []*pattern
+
+	mu      sync.Mutex
+	ignores map[string][]*pattern // .mediaignore patterns per directory
+	dirs    map[string]string     // skip reason per directory, "" to descend
+}
+
+// New returns a Matcher for the files below root.
+func New(root string, rules Rules) *Matcher {
+	m := &Matcher{root: filepath.Clean(root), rules: rules}
+	for _, line := range rules.Include {
+		if p := parsePattern(line); p != nil {
+			m.include = append(m.include, p)
+		}
+	}
+	for _, line := range rules.Exclude {
+		if p := parsePattern(line); p != nil {
+			m.exclude = append(m.exclude, p)
+		}
+	}
+	m.Reset()
+	return m
+}
+
+// Reset forgets the .mediaignore files read so far, so that they are read
+// again when they may have changed.
+func (m *Matcher) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ignores = make(map[string][]*pattern)
+	m.dirs = make(map[string]string)
+}
+
+// NeedsSize reports whether SkipFile looks at the size, so callers can avoid
+// a stat when it doesn't.
+func (m *Matcher) NeedsSize() bool {
+	return m.rules.MinSize > 0 || m.rules.MaxSize > 0
+}
+
+// SkipDir returns why the directory at path and everything below it is
+// skipped, or "" if it is scanned.
+func (m *Matcher) SkipDir(path string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.skipDir(filepath.Clean(path))
+}
+
+// SkipFile returns why the file at path, of the given size, is skipped, or ""
+// if it is scanned. A file is skipped if its directory is.
+func (m *Matcher) SkipFile(path string, size int64) string {
+	path = filepath.Clean(path)
+	rel, ok := m.rel(path)
+	if !ok {
+		return ""
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if reason := m.skipDir(filepath.Dir(path)); reason != "" {
+		return reason
+	}
+	if m.rules.MaxDepth > 0 && strings.Count(rel, "/")+1 > m.rules.MaxDepth {
+		return ReasonTooDeep
+	}
+	if matchesAny(m.exclude, rel, false) {
+		return ReasonExcluded
+	}
+	if m.ignored(path, false) {
+		return ReasonIgnored
+	}
+	if len(m.include) > 0 && !matchesAny(m.include, rel, false) {
+		return ReasonNotIncluded
+	}
+	if m.rules.MinSize > 0 && size < m.rules.MinSize {
+		return ReasonTooSmall
+	}
+	if m.rules.MaxSize > 0 && size > m.rules.MaxSize {
+		return ReasonTooLarge
+	}
+	return ""
+}
+
+// skipDir is SkipDir with m.mu held. Decisions are cached, so checking the
+// ancestors of every file stays cheap.
+func (m *Matcher) skipDir(dir string) string {
+	if reason, ok := m.dirs[dir]; ok {
+		return reason
+	}
+	rel, ok := m.rel(dir)
+	if !ok || rel == "." {
+		return ""
+	}
+
+	reason := m.skipDir(filepath.Dir(dir))
+	switch {
+	case reason != "":
+	case m.rules.MaxDepth > 0 && strings.Count(rel, "/")+1 >= m.rules.MaxDepth:
+		reason = ReasonTooDeep
+	case matchesAny(m.exclude, rel, true):
+		reason = ReasonExcluded
+	case m.ignored(dir, true):
+		reason = ReasonIgnored
+	}
+	m.dirs[dir] = reason
+	return reason
+}
+
+// ignored reports whether the .mediaignore files from the root down to the
+// parent of path exclude it. As in git, the last matching pattern decides,
+// and deeper files come later.
+func (m *Matcher) ignored(path string, isDir bool) bool {
+	var dirs []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == m.root || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		patterns, ok := m.ignores[dir]
+		if !ok {
+			patterns = readIgnoreFile(dir)
+			m.ignores[dir] = patterns
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, p := range patterns {
+			if p.matches(rel, isDir) {
+				ignored = !p.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// rel returns path relative to the root, with slashes, and whether path is
+// below the root at all.
+func (m *Matcher) rel(path string) (string, bool) {
+	rel, err := filepath.Rel(m.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// matchesAny reports whether any of the patterns matches rel, honoring
+// negations in order.
+func matchesAny(patterns []*pattern, rel string, isDir bool) bool {
+	matched := false
+	for _, p := range patterns {
+		if p.matches(rel, isDir) {
+			matched = !p.negate
+		}
+	}
+	return matched
+}
@@ -0,0 +1,156 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		isDir   bool
+		want    bool
+	}{
+		{"*.tmp", "a.tmp", false, true},
+		{"*.tmp", "sub/dir/a.tmp", false, true},
+		{"*.tmp", "a.jpg", false, false},
+		{"cache/", "cache", true, true},
+		{"cache/", "cache", false, false},
+		{"/top.jpg", "top.jpg", false, true},
+		{"/top.jpg", "sub/top.jpg", false, false},
+		{"sub/*.jpg", "sub/a.jpg", false, true},
+		{"sub/*.jpg", "other/sub/a.jpg", false, false},
+		{"**/thumbs", "a/b/thumbs", true, true},
+		{"**/thumbs", "thumbs", true, true},
+		{"raw/**", "raw/2020/a.cr2", false, true},
+		{"a/**/b.jpg", "a/b.jpg", false, true},
+		{"a/**/b.jpg", "a/x/y/b.jpg", false, true},
+		{"a/**/b.jpg", "c/a/b.jpg", false, false},
+	}
+	for _, tt := range tests {
+		p := parsePattern(tt.pattern)
+		if p == nil {
+			t.Fatalf("parsePattern(%q) = nil", tt.pattern)
+		}
+		if got := p.matches(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("%q matches %q (dir %v) = %v, want %v", tt.pattern, tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestParsePatternSkipsComments(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comment", "/"} {
+		if p := parsePattern(line); p != nil {
+			t.Errorf("parsePattern(%q) = %+v, want nil", line, p)
+		}
+	}
+	if p := parsePattern(`\#hash.jpg`); p == nil || p.segments[0] != "#hash.jpg" {
+		t.Errorf("escaped # not parsed as a pattern: %+v", p)
+	}
+	if p := parsePattern("!keep.jpg"); p == nil || !p.negate {
+		t.Errorf("! not parsed as a negation: %+v", p)
+	}
+}
+
+func TestMatcherRules(t *testing.T) {
+	root := t.TempDir()
+	m := New(root, Rules{
+		Include:  []string{"*.jpg", "*.mp4"},
+		Exclude:  []string{"private/", "*_edit.jpg"},
+		MinSize:  100,
+		MaxSize:  1000,
+		MaxDepth: 2,
+	})
+	path := func(rel string) string { return filepath.Join(root, filepath.FromSlash(rel)) }
+
+	tests := []struct {
+		rel  string
+		size int64
+		want string
+	}{
+		{"a.jpg", 500, ""},
+		{"sub/a.mp4", 500, ""},
+		{"sub/deeper/a.jpg", 500, ReasonTooDeep},
+		{"a.png", 500, ReasonNotIncluded},
+		{"a_edit.jpg", 500, ReasonExcluded},
+		{"private/a.jpg", 500, ReasonExcluded},
+		{"a.jpg", 99, ReasonTooSmall},
+		{"a.jpg", 1001, ReasonTooLarge},
+	}
+	for _, tt := range tests {
+		if got := m.SkipFile(path(tt.rel), tt.size); got != tt.want {
+			t.Errorf("SkipFile(%s, %d) = %q, want %q", tt.rel, tt.size, got, tt.want)
+		}
+	}
+
+	if got := m.SkipDir(root); got != "" {
+		t.Errorf("SkipDir(root) = %q, want it scanned", got)
+	}
+	if got := m.SkipDir(path("private")); got != ReasonExcluded {
+		t.Errorf("SkipDir(private) = %q, want %q", got, ReasonExcluded)
+	}
+	if got := m.SkipDir(path("sub/deeper")); got != ReasonTooDeep {
+		t.Errorf("SkipDir(sub/deeper) = %q, want %q", got, ReasonTooDeep)
+	}
+	if got := m.SkipFile("/elsewhere/a.png", 0); got != "" {
+		t.Errorf("SkipFile outside the root = %q, want it not filtered", got)
+	}
+	if !m.NeedsSize() || New(root, Rules{}).NeedsSize() {
+		t.Error("NeedsSize should only be true with a size limit")
+	}
+}
+
+func TestMatcherMediaignore(t *testing.T) {
+	root := t.TempDir()
+	write := func(rel, content string) {
+		t.Helper()
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(IgnoreFileName, "# screenshots are not photos\nScreenshot*\nscratch/\n*.png\n")
+	write("album/"+IgnoreFileName, "!keep.png\n/local.jpg\n")
+	path := func(rel string) string { return filepath.Join(root, filepath.FromSlash(rel)) }
+
+	m := New(root, Rules{})
+	tests := []struct {
+		rel  string
+		want string
+	}{
+		{"a.jpg", ""},
+		{"Screenshot 1.jpg", ReasonIgnored},
+		{"album/Screenshot 2.jpg", ReasonIgnored},
+		{"scratch/a.jpg", ReasonIgnored},
+		{"a.png", ReasonIgnored},
+		{"album/a.png", ReasonIgnored},
+		{"album/keep.png", ""},
+		{"album/local.jpg", ReasonIgnored},
+		{"album/sub/local.jpg", ""},
+		{"local.jpg", ""},
+	}
+	for _, tt := range tests {
+		if got := m.SkipFile(path(tt.rel), 0); got != tt.want {
+			t.Errorf("SkipFile(%s) = %q, want %q", tt.rel, got, tt.want)
+		}
+	}
+
+	// A .mediaignore written after its directory was read takes effect after Reset
+	write("late/a.jpg", "")
+	if got := m.SkipFile(path("late/a.jpg"), 0); got != "" {
+		t.Fatalf("SkipFile(late/a.jpg) = %q, want it scanned", got)
+	}
+	write("late/"+IgnoreFileName, "*.jpg\n")
+	if got := m.SkipFile(path("late/a.jpg"), 0); got != "" {
+		t.Errorf("before Reset: SkipFile(late/a.jpg) = %q, want the cached decision", got)
+	}
+	m.Reset()
+	if got := m.SkipFile(path("late/a.jpg"), 0); got != ReasonIgnored {
+		t.Errorf("after Reset: SkipFile(late/a.jpg) = %q, want %q", got, ReasonIgnored)
+	}
+}
@@ -0,0 +1,108 @@
+package filter
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+)
+
+// IgnoreFileName is the name of the per-directory files listing paths to
+// leave alone, with the syntax of .gitignore.
+const IgnoreFileName = ".mediaignore"
+
+// pattern is one line of a .mediaignore file, or an include or exclude rule.
+type pattern struct {
+	segments []string // slash-separated glob, "**" matching any number of directories
+	negate   bool     // "!pattern" re-includes what an earlier pattern excluded
+	dirOnly  bool     // "pattern/" only matches directories
+	anchored bool     // contains a slash, so matched against the whole relative path
+}
+
+// parsePattern parses a line in gitignore syntax. It returns nil for blank
+// lines and comments.
+func parsePattern(line string) *pattern {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	p := &pattern{}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:] // \# and \! escape a leading # or !
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+	p.segments = strings.Split(line, "/")
+	return p
+}
+
+// matches reports whether the pattern matches rel, a slash-separated path
+// relative to the directory the pattern applies to.
+func (p *pattern) matches(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if !p.anchored {
+		ok, _ := path.Match(p.segments[0], path.Base(rel))
+		return ok
+	}
+	return matchSegments(p.segments, strings.Split(rel, "/"))
+}
+
+// matchSegments matches path segments against glob segments, where "**"
+// matches zero or more segments.
+func matchSegments(globs, segs []string) bool {
+	for len(globs) > 0 {
+		if globs[0] == "**" {
+			rest := globs[1:]
+			if len(rest) == 0 {
+				return true
+			}
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(rest, segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(globs[0], segs[0]); !ok {
+			return false
+		}
+		globs, segs = globs[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// readIgnoreFile returns the patterns of the .mediaignore file in dir, or nil
+// if it has none.
+func readIgnoreFile(dir string) []*pattern {
+	f, err := os.Open(dir + string(os.PathSeparator) + IgnoreFileName)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var patterns []*pattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if p := parsePattern(scanner.Text()); p != nil {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
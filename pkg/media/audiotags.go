@@ -0,0 +1,511 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Audio tag reading: ID3v2 (2.2, 2.3, 2.4) and ID3v1 for MP3, Vorbis comments
+// for FLAC and Ogg (Vorbis and Opus). MP4/M4A tags come from the ilst atoms
+// read by parseMP4.
+
+// maxAudioTagSize bounds how much tag data is read into memory. Embedded cover
+// art can make tags large; text frames come first in practice.
+const maxAudioTagSize = 1 << 20
+
+var errNoAudioTags = errors.New("no audio tags found")
+
+// audioTags holds the tag values relevant for organizing music.
+type audioTags struct {
+	Artist      string
+	AlbumArtist string
+	Album       string
+	Title       string
+	Track       int
+	Date        string // raw date/year as written in the tag
+}
+
+func (t *audioTags) empty() bool {
+	return t.Artist == "" && t.AlbumArtist == "" && t.Album == "" && t.Title == "" && t.Track == 0 && t.Date == ""
+}
+
+// applyTo copies the tags into m and returns the recording time if the tag
+// date is precise to the day. Year-only dates only set m.Year.
+func (t *audioTags) applyTo(m *MediaFile) time.Time {
+	m.Artist = t.Artist
+	m.AlbumArtist = t.AlbumArtist
+	m.Album = t.Album
+	m.Title = t.Title
+	m.TrackNumber = t.Track
+
+	year, recorded := parseTagDate(t.Date)
+	m.Year = year
+	return recorded
+}
+
+// readAudioTags reads the tags of an MP3, FLAC or Ogg file.
+func readAudioTags(filePath string) (*audioTags, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var magic [4]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return nil, errNoAudioTags
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var tags *audioTags
+	switch {
+	case string(magic[:3]) == "ID3":
+		// ID3v2 also shows up in front of FLAC streams
+		tags, err = readID3v2(f)
+		if err == nil && !tags.empty() {
+			return tags, nil
+		}
+		if flac, ferr := readFLACAfterID3(f); ferr == nil {
+			return flac, nil
+		}
+	case string(magic[:]) == "fLaC":
+		tags, err = readFLACTags(f)
+	case string(magic[:]) == "OggS":
+		tags, err = readOggTags(f)
+	}
+	if err == nil && tags != nil && !tags.empty() {
+		return tags, nil
+	}
+
+	// ID3v1 lives in the last 128 bytes of MP3 files
+	if v1, err := readID3v1(f); err == nil {
+		return v1, nil
+	}
+	return nil, errNoAudioTags
+}
+
+// --- ID3v2 ---
+
+func readID3v2(r io.ReadSeeker) (*audioTags, error) {
+	var hdr [10]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if string(hdr[:3]) != "ID3" {
+		return nil, errNoAudioTags
+	}
+	version := hdr[3]
+	flags := hdr[5]
+	size := syncsafe(hdr[6:10])
+	if size > maxAudioTagSize {
+		size = maxAudioTagSize
+	}
+
+	data := make([]byte, size)
+	n, err := io.ReadFull(r, data)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	data = data[:n]
+
+	// Tag-wide unsynchronisation (v2.2/v2.3): 0xFF 0x00 -> 0xFF
+	if flags&0x80 != 0 && version < 4 {
+		data = bytes.ReplaceAll(data, []byte{0xff, 0x00}, []byte{0xff})
+	}
+
+	// Skip the extended header
+	if flags&0x40 != 0 && version >= 3 && len(data) >= 4 {
+		extSize := int(binary.BigEndian.Uint32(data[0:4]))
+		if version == 3 {
+			extSize += 4 // v2.3 size excludes itself
+		} else {
+			extSize = int(syncsafe(data[0:4]))
+		}
+		if extSize > len(data) {
+			return nil, errNoAudioTags
+		}
+		data = data[extSize:]
+	}
+
+	tags := &audioTags{}
+	var year, dayMonth string
+	idLen, hdrLen := 4, 10
+	if version == 2 {
+		idLen, hdrLen = 3, 6
+	}
+
+	for off := 0; off+hdrLen <= len(data); {
+		id := string(data[off : off+idLen])
+		if id[0] == 0 {
+			break // padding
+		}
+		var frameSize int
+		switch version {
+		case 2:
+			frameSize = int(data[off+3])<<16 | int(data[off+4])<<8 | int(data[off+5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(data[off+4 : off+8]))
+		default:
+			frameSize = int(syncsafe(data[off+4 : off+8]))
+		}
+		body := off + hdrLen
+		if frameSize <= 0 || body+frameSize > len(data) {
+			break
+		}
+		frame := data[body : body+frameSize]
+		off = body + frameSize
+
+		if version == 4 && data[body-1]&0x02 != 0 {
+			// Per-frame unsynchronisation
+			frame = bytes.ReplaceAll(frame, []byte{0xff, 0x00}, []byte{0xff})
+		}
+
+		switch id {
+		case "TPE1", "TP1":
+			tags.Artist = decodeID3Text(frame)
+		case "TPE2", "TP2":
+			tags.AlbumArtist = decodeID3Text(frame)
+		case "TALB", "TAL":
+			tags.Album = decodeID3Text(frame)
+		case "TIT2", "TT2":
+			tags.Title = decodeID3Text(frame)
+		case "TRCK", "TRK":
+			tags.Track = parseTrackNumber(decodeID3Text(frame))
+		case "TDRC", "TDOR":
+			if tags.Date == "" {
+				tags.Date = decodeID3Text(frame)
+			}
+		case "TYER", "TYE":
+			year = decodeID3Text(frame)
+		case "TDAT", "TDA":
+			dayMonth = decodeID3Text(frame) // DDMM
+		}
+	}
+
+	// v2.3 splits the date into TYER and TDAT
+	if tags.Date == "" && year != "" {
+		tags.Date = year
+		if len(dayMonth) == 4 {
+			tags.Date = year + "-" + dayMonth[2:4] + "-" + dayMonth[0:2]
+		}
+	}
+	return tags, nil
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
+
+// decodeID3Text decodes a text frame body. Only the first value of
+// multi-value frames is returned.
+func decodeID3Text(frame []byte) string {
+	if len(frame) < 1 {
+		return ""
+	}
+	enc, text := frame[0], frame[1:]
+
+	var s string
+	switch enc {
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		bigEndian := enc == 2
+		if len(text) >= 2 {
+			if text[0] == 0xff && text[1] == 0xfe {
+				bigEndian, text = false, text[2:]
+			} else if text[0] == 0xfe && text[1] == 0xff {
+				bigEndian, text = true, text[2:]
+			}
+		}
+		units := make([]uint16, 0, len(text)/2)
+		for i := 0; i+1 < len(text); i += 2 {
+			var u uint16
+			if bigEndian {
+				u = uint16(text[i])<<8 | uint16(text[i+1])
+			} else {
+				u = uint16(text[i+1])<<8 | uint16(text[i])
+			}
+			if u == 0 {
+				break
+			}
+			units = append(units, u)
+		}
+		s = string(utf16.Decode(units))
+	case 3: // UTF-8
+		s = string(text)
+	default: // ISO-8859-1
+		s = latin1(text)
+	}
+
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// --- ID3v1 ---
+
+func readID3v1(r io.ReadSeeker) (*audioTags, error) {
+	if _, err := r.Seek(-128, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	var tag [128]byte
+	if _, err := io.ReadFull(r, tag[:]); err != nil {
+		return nil, err
+	}
+	if string(tag[:3]) != "TAG" {
+		return nil, errNoAudioTags
+	}
+
+	field := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return strings.TrimSpace(latin1(b))
+	}
+	tags := &audioTags{
+		Title:  field(tag[3:33]),
+		Artist: field(tag[33:63]),
+		Album:  field(tag[63:93]),
+		Date:   field(tag[93:97]),
+	}
+	// ID3v1.1 stores the track number in the last comment byte
+	if tag[125] == 0 && tag[126] != 0 {
+		tags.Track = int(tag[126])
+	}
+	if tags.empty() {
+		return nil, errNoAudioTags
+	}
+	return tags, nil
+}
+
+// --- FLAC ---
+
+// readFLACAfterID3 skips a leading ID3v2 tag and reads FLAC metadata.
+func readFLACAfterID3(r io.ReadSeeker) (*audioTags, error) {
+	var hdr [10]byte
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(int64(10+syncsafe(hdr[6:10])), io.SeekStart); err != nil {
+		return nil, err
+	}
+	return readFLACTags(r)
+}
+
+func readFLACTags(r io.Reader) (*audioTags, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if string(magic[:]) != "fLaC" {
+		return nil, errNoAudioTags
+	}
+
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, err
+		}
+		last := hdr[0]&0x80 != 0
+		blockType := hdr[0] & 0x7f
+		length := int(hdr[1])<<16 | int(hdr[2])<<8 | int(hdr[3])
+
+		if blockType == 4 { // VORBIS_COMMENT
+			block := make([]byte, min(length, maxAudioTagSize))
+			if _, err := io.ReadFull(r, block); err != nil {
+				return nil, err
+			}
+			return parseVorbisComments(block)
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+			return nil, err
+		}
+		if last {
+			return nil, errNoAudioTags
+		}
+	}
+}
+
+// parseVorbisComments parses a Vorbis comment block (little-endian lengths).
+func parseVorbisComments(block []byte) (*audioTags, error) {
+	if len(block) < 8 {
+		return nil, errNoAudioTags
+	}
+	vendorLen := int(binary.LittleEndian.Uint32(block[0:4]))
+	off := 4 + vendorLen
+	if off+4 > len(block) {
+		return nil, errNoAudioTags
+	}
+	count := int(binary.LittleEndian.Uint32(block[off : off+4]))
+	off += 4
+
+	tags := &audioTags{}
+	for i := 0; i < count && off+4 <= len(block); i++ {
+		n := int(binary.LittleEndian.Uint32(block[off : off+4]))
+		off += 4
+		if n < 0 || off+n > len(block) {
+			break // truncated, keep what we have
+		}
+		comment := string(block[off : off+n])
+		off += n
+
+		key, value, ok := strings.Cut(comment, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToUpper(key) {
+		case "ARTIST":
+			if tags.Artist == "" {
+				tags.Artist = value
+			}
+		case "ALBUMARTIST", "ALBUM ARTIST", "ALBUM_ARTIST":
+			if tags.AlbumArtist == "" {
+				tags.AlbumArtist = value
+			}
+		case "ALBUM":
+			tags.Album = value
+		case "TITLE":
+			tags.Title = value
+		case "TRACKNUMBER":
+			tags.Track = parseTrackNumber(value)
+		case "DATE", "YEAR":
+			if tags.Date == "" {
+				tags.Date = value
+			}
+		}
+	}
+	return tags, nil
+}
+
+// --- Ogg ---
+
+// readOggTags reassembles the second logical packet of an Ogg stream, which
+// is the comment header for both Vorbis and Opus.
+func readOggTags(r io.Reader) (*audioTags, error) {
+	var packets [][]byte
+	var current []byte
+	total := 0
+
+	for len(packets) < 2 {
+		var hdr [27]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			break
+		}
+		if string(hdr[:4]) != "OggS" {
+			return nil, errNoAudioTags
+		}
+		segTable := make([]byte, hdr[26])
+		if _, err := io.ReadFull(r, segTable); err != nil {
+			break
+		}
+		for _, seg := range segTable {
+			data := make([]byte, seg)
+			if _, err := io.ReadFull(r, data); err != nil {
+				break
+			}
+			current = append(current, data...)
+			total += int(seg)
+			if seg < 255 {
+				packets = append(packets, current)
+				current = nil
+			}
+		}
+		if total > maxAudioTagSize {
+			break
+		}
+	}
+	// A comment packet cut short by the size limit is still usable
+	if len(packets) == 1 && current != nil {
+		packets = append(packets, current)
+	}
+	if len(packets) < 2 {
+		return nil, errNoAudioTags
+	}
+
+	comment := packets[1]
+	switch {
+	case bytes.HasPrefix(comment, []byte("\x03vorbis")):
+		return parseVorbisComments(comment[7:])
+	case bytes.HasPrefix(comment, []byte("OpusTags")):
+		return parseVorbisComments(comment[8:])
+	}
+	return nil, errNoAudioTags
+}
+
+// --- MP4 ---
+
+// mp4AudioTags extracts iTunes-style tags from parsed ilst atoms.
+func mp4AudioTags(info *mp4Info) *audioTags {
+	tags := &audioTags{
+		Artist:      info.Metadata["\xa9ART"],
+		AlbumArtist: info.Metadata["aART"],
+		Album:       info.Metadata["\xa9alb"],
+		Title:       info.Metadata["\xa9nam"],
+		Date:        info.Metadata["\xa9day"],
+	}
+	// trkn: 2 bytes padding, 2 bytes track, 2 bytes total. setMetadata
+	// trims trailing zero bytes, so pad before decoding.
+	if trkn := info.Metadata["trkn"]; len(trkn) >= 3 {
+		b := append([]byte(trkn), 0, 0, 0)
+		tags.Track = int(binary.BigEndian.Uint16(b[2:4]))
+	}
+	return tags
+}
+
+// --- helpers ---
+
+// parseTrackNumber parses "3" or "3/12".
+func parseTrackNumber(s string) int {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "/")
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// parseTagDate parses tag dates like "2019", "2019-07-15" or
+// "2019-07-15T12:34:56". It returns the year, and the full time only when
+// the date is precise to the day.
+func parseTagDate(s string) (int, time.Time) {
+	s = strings.TrimSpace(s)
+	if len(s) < 4 {
+		return 0, time.Time{}
+	}
+	year, err := strconv.Atoi(s[:4])
+	if err != nil || year < 1000 {
+		return 0, time.Time{}
+	}
+	for _, layout := range []string{
+		"2006-01-02T15:04:05Z07:00",
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+	} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return year, t
+		}
+	}
+	return year, time.Time{}
+}
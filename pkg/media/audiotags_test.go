@@ -0,0 +1,282 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// id3Frame builds an ID3v2.3/2.4 frame. v2.4 sizes are syncsafe.
+func id3Frame(version byte, id string, body []byte) []byte {
+	hdr := make([]byte, 10)
+	copy(hdr, id)
+	if version == 4 {
+		putSyncsafe(hdr[4:8], uint32(len(body)))
+	} else {
+		binary.BigEndian.PutUint32(hdr[4:8], uint32(len(body)))
+	}
+	return append(hdr, body...)
+}
+
+func putSyncsafe(b []byte, n uint32) {
+	b[0] = byte(n>>21) & 0x7f
+	b[1] = byte(n>>14) & 0x7f
+	b[2] = byte(n>>7) & 0x7f
+	b[3] = byte(n) & 0x7f
+}
+
+// id3Tag wraps frames in an ID3v2 header followed by some padding.
+func id3Tag(version byte, frames ...[]byte) []byte {
+	body := append(bytes.Join(frames, nil), make([]byte, 16)...)
+	hdr := []byte{'I', 'D', '3', version, 0, 0, 0, 0, 0, 0}
+	putSyncsafe(hdr[6:10], uint32(len(body)))
+	return append(hdr, body...)
+}
+
+func utf8Text(s string) []byte { return append([]byte{3}, s...) }
+
+func latin1Text(s string) []byte { return append([]byte{0}, s...) }
+
+func utf16Text(s string) []byte {
+	out := []byte{1, 0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(u), byte(u>>8))
+	}
+	return append(out, 0, 0)
+}
+
+// vorbisComments builds a Vorbis comment block.
+func vorbisComments(comments ...string) []byte {
+	le := func(n int) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(n))
+		return b
+	}
+	vendor := "test vendor"
+	out := append(le(len(vendor)), vendor...)
+	out = append(out, le(len(comments))...)
+	for _, c := range comments {
+		out = append(out, le(len(c))...)
+		out = append(out, c...)
+	}
+	return out
+}
+
+// oggPage builds a single Ogg page holding one complete packet.
+func oggPage(packet []byte) []byte {
+	hdr := make([]byte, 27)
+	copy(hdr, "OggS")
+	var segs []byte
+	n := len(packet)
+	for n >= 255 {
+		segs = append(segs, 255)
+		n -= 255
+	}
+	segs = append(segs, byte(n))
+	hdr[26] = byte(len(segs))
+	return append(append(hdr, segs...), packet...)
+}
+
+func TestReadAudioTags_ID3v24(t *testing.T) {
+	data := id3Tag(4,
+		id3Frame(4, "TPE1", utf8Text("Björk")),
+		id3Frame(4, "TPE2", utf8Text("Björk")),
+		id3Frame(4, "TALB", utf8Text("Homogenic")),
+		id3Frame(4, "TIT2", utf8Text("Jóga")),
+		id3Frame(4, "TRCK", utf8Text("3/10")),
+		id3Frame(4, "TDRC", utf8Text("1997-09-22")),
+	)
+	path := writeTempFile(t, "song.mp3", append(data, make([]byte, 64)...))
+
+	tags, err := readAudioTags(path)
+	if err != nil {
+		t.Fatalf("readAudioTags: %v", err)
+	}
+	want := audioTags{Artist: "Björk", AlbumArtist: "Björk", Album: "Homogenic", Title: "Jóga", Track: 3, Date: "1997-09-22"}
+	if *tags != want {
+		t.Errorf("tags = %+v, want %+v", *tags, want)
+	}
+}
+
+func TestReadAudioTags_ID3v23UTF16AndSplitDate(t *testing.T) {
+	data := id3Tag(3,
+		id3Frame(3, "TPE1", utf16Text("Sigur Rós")),
+		id3Frame(3, "TALB", latin1Text("Takk...")),
+		id3Frame(3, "TIT2", utf16Text("Hoppípolla")),
+		id3Frame(3, "TRCK", latin1Text("2")),
+		id3Frame(3, "TYER", latin1Text("2005")),
+		id3Frame(3, "TDAT", latin1Text("1209")), // DDMM
+	)
+	path := writeTempFile(t, "song.mp3", data)
+
+	tags, err := readAudioTags(path)
+	if err != nil {
+		t.Fatalf("readAudioTags: %v", err)
+	}
+	if tags.Artist != "Sigur Rós" || tags.Title != "Hoppípolla" || tags.Album != "Takk..." {
+		t.Errorf("text frames = %+v", *tags)
+	}
+	if tags.Track != 2 {
+		t.Errorf("Track = %d, want 2", tags.Track)
+	}
+	if tags.Date != "2005-09-12" {
+		t.Errorf("Date = %q, want 2005-09-12", tags.Date)
+	}
+}
+
+func TestReadAudioTags_ID3v22(t *testing.T) {
+	frame := func(id, text string) []byte {
+		body := latin1Text(text)
+		return append([]byte{id[0], id[1], id[2], 0, 0, byte(len(body))}, body...)
+	}
+	data := id3Tag(2, frame("TP1", "Artist"), frame("TAL", "Album"), frame("TT2", "Title"), frame("TYE", "1988"))
+	path := writeTempFile(t, "old.mp3", data)
+
+	tags, err := readAudioTags(path)
+	if err != nil {
+		t.Fatalf("readAudioTags: %v", err)
+	}
+	want := audioTags{Artist: "Artist", Album: "Album", Title: "Title", Date: "1988"}
+	if *tags != want {
+		t.Errorf("tags = %+v, want %+v", *tags, want)
+	}
+}
+
+func TestReadAudioTags_ID3v1(t *testing.T) {
+	tag := make([]byte, 128)
+	copy(tag, "TAG")
+	copy(tag[3:], "Title")
+	copy(tag[33:], "Artist")
+	copy(tag[63:], "Album")
+	copy(tag[93:], "1999")
+	tag[126] = 7
+	path := writeTempFile(t, "v1.mp3", append(make([]byte, 512), tag...))
+
+	tags, err := readAudioTags(path)
+	if err != nil {
+		t.Fatalf("readAudioTags: %v", err)
+	}
+	want := audioTags{Artist: "Artist", Album: "Album", Title: "Title", Track: 7, Date: "1999"}
+	if *tags != want {
+		t.Errorf("tags = %+v, want %+v", *tags, want)
+	}
+}
+
+func TestReadAudioTags_FLAC(t *testing.T) {
+	streamInfo := append([]byte{0x00, 0, 0, 34}, make([]byte, 34)...)
+	comments := vorbisComments("ARTIST=Track Artist", "ALBUMARTIST=Various Artists", "ALBUM=Mix", "TITLE=Song", "TRACKNUMBER=12", "DATE=2020")
+	block := append([]byte{0x80 | 4, byte(len(comments) >> 16), byte(len(comments) >> 8), byte(len(comments))}, comments...)
+	data := append(append([]byte("fLaC"), streamInfo...), block...)
+	path := writeTempFile(t, "song.flac", data)
+
+	tags, err := readAudioTags(path)
+	if err != nil {
+		t.Fatalf("readAudioTags: %v", err)
+	}
+	want := audioTags{Artist: "Track Artist", AlbumArtist: "Various Artists", Album: "Mix", Title: "Song", Track: 12, Date: "2020"}
+	if *tags != want {
+		t.Errorf("tags = %+v, want %+v", *tags, want)
+	}
+}
+
+func TestReadAudioTags_OggOpus(t *testing.T) {
+	head := append([]byte("OpusHead"), make([]byte, 11)...)
+	comment := append([]byte("OpusTags"), vorbisComments("artist=Someone", "title=Voice Note")...)
+	data := append(oggPage(head), oggPage(comment)...)
+	path := writeTempFile(t, "note.opus", data)
+
+	tags, err := readAudioTags(path)
+	if err != nil {
+		t.Fatalf("readAudioTags: %v", err)
+	}
+	if tags.Artist != "Someone" || tags.Title != "Voice Note" {
+		t.Errorf("tags = %+v", *tags)
+	}
+}
+
+func TestReadAudioTags_Untagged(t *testing.T) {
+	path := writeTempFile(t, "memo.mp3", make([]byte, 1024))
+	if _, err := readAudioTags(path); err == nil {
+		t.Error("expected error for untagged file")
+	}
+}
+
+func TestMP4AudioTags(t *testing.T) {
+	item := func(name string, dataType uint32, value []byte) []byte {
+		return mp4Box(name, mp4Box("data", u32(dataType), u32(0), value))
+	}
+	data := bytes.Join([][]byte{
+		mp4Box("ftyp", []byte("M4A "), u32(0)),
+		mp4Box("moov",
+			mvhdV0(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), 1000, 1000),
+			mp4Box("udta", mp4Box("meta", u32(0),
+				mp4Box("hdlr", make([]byte, 24)),
+				mp4Box("ilst",
+					item("\xa9ART", 1, []byte("Artist")),
+					item("aART", 1, []byte("Album Artist")),
+					item("\xa9alb", 1, []byte("Album")),
+					item("\xa9nam", 1, []byte("Title")),
+					item("\xa9day", 1, []byte("2014-06-01T00:00:00Z")),
+					item("trkn", 0, []byte{0, 0, 0, 5, 0, 12, 0, 0}),
+				),
+			)),
+		),
+	}, nil)
+	path := writeTempFile(t, "song.m4a", data)
+
+	mf, err := ExtractFileMetadata(path)
+	if err != nil {
+		t.Fatalf("ExtractFileMetadata: %v", err)
+	}
+	if mf.Artist != "Artist" || mf.AlbumArtist != "Album Artist" || mf.Album != "Album" || mf.Title != "Title" {
+		t.Errorf("tags = %q %q %q %q", mf.Artist, mf.AlbumArtist, mf.Album, mf.Title)
+	}
+	if mf.TrackNumber != 5 {
+		t.Errorf("TrackNumber = %d, want 5", mf.TrackNumber)
+	}
+	if mf.Year != 2014 || mf.CreationTime.Year() != 2014 {
+		t.Errorf("Year = %d, CreationTime = %v, want 2014 from the tag", mf.Year, mf.CreationTime)
+	}
+}
+
+func TestExtractFileMetadata_TagDate(t *testing.T) {
+	data := id3Tag(4, id3Frame(4, "TPE1", utf8Text("A")), id3Frame(4, "TDRC", utf8Text("2012-03-04T05:06:07")))
+	path := writeTempFile(t, "song.mp3", data)
+
+	mf, err := ExtractFileMetadata(path)
+	if err != nil {
+		t.Fatalf("ExtractFileMetadata: %v", err)
+	}
+	want := time.Date(2012, 3, 4, 5, 6, 7, 0, time.Local)
+	if !mf.CreationTime.Equal(want) {
+		t.Errorf("CreationTime = %v, want %v", mf.CreationTime, want)
+	}
+}
+
+func TestParseTagDate(t *testing.T) {
+	tests := []struct {
+		in       string
+		year     int
+		hasTime  bool
+		wantDate string
+	}{
+		{"1997", 1997, false, ""},
+		{"1997-09", 1997, false, ""},
+		{"1997-09-22", 1997, true, "1997-09-22"},
+		{"1997-09-22T10:11:12", 1997, true, "1997-09-22"},
+		{"", 0, false, ""},
+		{"abcd", 0, false, ""},
+	}
+	for _, tt := range tests {
+		year, ts := parseTagDate(tt.in)
+		if year != tt.year || ts.IsZero() == tt.hasTime {
+			t.Errorf("parseTagDate(%q) = %d, %v", tt.in, year, ts)
+			continue
+		}
+		if tt.hasTime && ts.Format("2006-01-02") != tt.wantDate {
+			t.Errorf("parseTagDate(%q) date = %s, want %s", tt.in, ts.Format("2006-01-02"), tt.wantDate)
+		}
+	}
+}
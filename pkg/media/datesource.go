@@ -0,0 +1,114 @@
+package media
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateSource names where a file's creation time came from.
+type DateSource string
+
+const (
+	// DateSourceExif is metadata embedded in the file: EXIF, MP4/QuickTime
+	// atoms, audio tags or ffprobe container tags.
+	DateSourceExif DateSource = "exif"
+	// DateSourceSidecar is a Google Takeout JSON or XMP file next to the media file.
+	DateSourceSidecar DateSource = "sidecar"
+	// DateSourceFilename is a date embedded in the filename, e.g. IMG_20190715_123456.jpg.
+	DateSourceFilename DateSource = "filename"
+	// DateSourceMtime is the file modification time.
+	DateSourceMtime DateSource = "mtime"
+)
+
+// DefaultDatePrecedence is the order in which date sources are consulted.
+var DefaultDatePrecedence = []DateSource{DateSourceExif, DateSourceSidecar, DateSourceFilename, DateSourceMtime}
+
+// ParseDatePrecedence validates a list of date source names. Sources may not
+// repeat. The modification time is always the last resort, so it is appended
+// when missing.
+func ParseDatePrecedence(names []string) ([]DateSource, error) {
+	if len(names) == 0 {
+		return DefaultDatePrecedence, nil
+	}
+
+	seen := make(map[DateSource]bool)
+	var sources []DateSource
+	for _, name := range names {
+		src := DateSource(strings.ToLower(strings.TrimSpace(name)))
+		switch src {
+		case DateSourceExif, DateSourceSidecar, DateSourceFilename, DateSourceMtime:
+		default:
+			return nil, fmt.Errorf("unknown date source %q (valid: exif, sidecar, filename, mtime)", name)
+		}
+		if seen[src] {
+			return nil, fmt.Errorf("date source %q listed twice", name)
+		}
+		seen[src] = true
+		sources = append(sources, src)
+	}
+	if !seen[DateSourceMtime] {
+		sources = append(sources, DateSourceMtime)
+	}
+	return sources, nil
+}
+
+// precedes reports whether a comes before b in the precedence list.
+func precedes(precedence []DateSource, a, b DateSource) bool {
+	for _, src := range precedence {
+		switch src {
+		case a:
+			return true
+		case b:
+			return false
+		}
+	}
+	return false
+}
+
+// Filename date patterns, tried in order. The date must not be preceded by a
+// digit so counters like DSC01234567 don't match.
+var (
+	filenameDateTimeRe = regexp.MustCompile(`(?:^|\D)((?:19|20)\d{2})[-_.]?(\d{2})[-_.]?(\d{2})[-_. T]?(\d{2})[-_.:h]?(\d{2})[-_.:m]?(\d{2})`)
+	filenameDateRe     = regexp.MustCompile(`(?:^|\D)((?:19|20)\d{2})[-_.]?(\d{2})[-_.]?(\d{2})(?:\D|$)`)
+)
+
+// parseFilenameDate extracts a capture date from names such as
+// "IMG_20190715_123456.jpg", "PXL_20210101_123456789.jpg",
+// "2019-07-15 12.34.56.jpg" or "IMG-20190715-WA0001.jpg" (date only, at
+// midnight). Returns the zero time when the name holds no plausible date.
+func parseFilenameDate(name string) time.Time {
+	if m := filenameDateTimeRe.FindStringSubmatch(name); m != nil {
+		if t, ok := filenameTime(m[1:]); ok {
+			return t
+		}
+	}
+	if m := filenameDateRe.FindStringSubmatch(name); m != nil {
+		if t, ok := filenameTime(m[1:]); ok {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func filenameTime(fields []string) (time.Time, bool) {
+	var v [6]int
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return time.Time{}, false
+		}
+		v[i] = n
+	}
+	t := time.Date(v[0], time.Month(v[1]), v[2], v[3], v[4], v[5], 0, time.Local)
+	// time.Date normalizes out-of-range values; reject them instead
+	if t.Year() != v[0] || int(t.Month()) != v[1] || t.Day() != v[2] || t.Hour() != v[3] || t.Minute() != v[4] || t.Second() != v[5] {
+		return time.Time{}, false
+	}
+	if t.After(time.Now().AddDate(1, 0, 0)) {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,93 @@
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDatePrecedence(t *testing.T) {
+	got, err := ParseDatePrecedence(nil)
+	if err != nil || !reflect.DeepEqual(got, DefaultDatePrecedence) {
+		t.Errorf("ParseDatePrecedence(nil) = %v, %v; want default", got, err)
+	}
+
+	got, err = ParseDatePrecedence([]string{"Sidecar", "exif"})
+	want := []DateSource{DateSourceSidecar, DateSourceExif, DateSourceMtime}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseDatePrecedence(sidecar,exif) = %v, %v; want %v", got, err, want)
+	}
+
+	for _, bad := range [][]string{{"gps"}, {"exif", "exif"}} {
+		if _, err := ParseDatePrecedence(bad); err == nil {
+			t.Errorf("ParseDatePrecedence(%v): expected error", bad)
+		}
+	}
+}
+
+func TestParseFilenameDate(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // "" for no date
+	}{
+		{"IMG_20190715_123456.jpg", "2019-07-15 12:34:56"},
+		{"PXL_20210101_235959123.jpg", "2021-01-01 23:59:59"},
+		{"2019-07-15 12.34.56.jpg", "2019-07-15 12:34:56"},
+		{"Screenshot_20200229-080000.png", "2020-02-29 08:00:00"},
+		{"IMG-20190715-WA0001.jpg", "2019-07-15 00:00:00"},
+		{"VID_20190230_101010.mp4", ""}, // February 30th
+		{"DSC01234567.jpg", ""},
+		{"IMG_1234.jpg", ""},
+	}
+	for _, tt := range tests {
+		got := parseFilenameDate(tt.name)
+		if tt.want == "" {
+			if !got.IsZero() {
+				t.Errorf("parseFilenameDate(%q) = %v, want none", tt.name, got)
+			}
+			continue
+		}
+		if got.Format("2006-01-02 15:04:05") != tt.want {
+			t.Errorf("parseFilenameDate(%q) = %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtractorDatePrecedence(t *testing.T) {
+	dir := t.TempDir()
+	mediaPath := filepath.Join(dir, "IMG_20200101_120000.png")
+	if err := os.WriteFile(mediaPath, []byte("not really a png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2022, 5, 5, 5, 5, 5, 0, time.Local)
+	if err := os.Chtimes(mediaPath, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mediaPath+".json", []byte(takeoutSample), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		precedence []DateSource
+		source     DateSource
+		want       time.Time
+	}{
+		{nil, DateSourceSidecar, time.Date(2019, 7, 15, 10, 34, 56, 0, time.UTC)},
+		{[]DateSource{DateSourceFilename, DateSourceSidecar}, DateSourceFilename, time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)},
+		{[]DateSource{DateSourceMtime}, DateSourceMtime, mtime},
+	}
+	for _, tt := range tests {
+		mf, err := NewExtractor(tt.precedence).Extract(mediaPath)
+		if err != nil {
+			t.Fatalf("Extract: %v", err)
+		}
+		if mf.DateSource != tt.source || !mf.CreationTime.Equal(tt.want) {
+			t.Errorf("precedence %v: got %s %v, want %s %v", tt.precedence, mf.DateSource, mf.CreationTime, tt.source, tt.want)
+		}
+		if !mf.HasGPS || mf.Description != "Sunset at the lake" {
+			t.Errorf("precedence %v: sidecar GPS/description not applied: %+v", tt.precedence, mf)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// HEIF stores Exif as an item of the top-level meta box: iinf names the
+// items and their types, iloc says where each item's bytes are in the file.
+// The item starts with a 32-bit offset to the TIFF header, which is
+// usually preceded by "Exif\0\0".
+
+// heifExtensions lists extensions of HEIF images, AVIF included.
+var heifExtensions = map[string]bool{
+	"heic": true,
+	"heif": true,
+	"avif": true,
+}
+
+var errNoHEIFExif = errors.New("no Exif item")
+
+// decodeHEIFExif reads the Exif metadata of a HEIF image.
+func decodeHEIFExif(filePath string) (*exif.Exif, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := readHEIFExif(f, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+	return exif.Decode(bytes.NewReader(data))
+}
+
+// readHEIFExif returns the Exif item of a HEIF file, starting at the TIFF header.
+func readHEIFExif(r io.ReaderAt, size int64) ([]byte, error) {
+	var meta *box
+	err := walkBoxes(r, 0, size, func(b box) error {
+		if b.typ == "meta" && meta == nil {
+			meta = &b
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, fmt.Errorf("no meta box found")
+	}
+
+	// meta is a full box: skip version and flags
+	var exifItem uint32
+	var locations map[uint32][]heifExtent
+	err = walkBoxes(r, meta.dataStart+4, meta.end, func(b box) error {
+		switch b.typ {
+		case "iinf":
+			data, err := readBoxData(r, b)
+			if err != nil {
+				return err
+			}
+			exifItem = findHEIFItem(r, b, data, "Exif")
+		case "iloc":
+			data, err := readBoxData(r, b)
+			if err != nil {
+				return err
+			}
+			locations, err = parseILOC(data)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	extents := locations[exifItem]
+	if exifItem == 0 || len(extents) == 0 {
+		return nil, errNoHEIFExif
+	}
+
+	var data []byte
+	for _, e := range extents {
+		if e.length > maxMP4MetaBox || int64(len(data))+int64(e.length) > maxMP4MetaBox {
+			return nil, fmt.Errorf("Exif item too large")
+		}
+		buf := make([]byte, e.length)
+		if _, err := r.ReadAt(buf, int64(e.offset)); err != nil && err != io.EOF {
+			return nil, err
+		}
+		data = append(data, buf...)
+	}
+
+	if len(data) < 4 {
+		return nil, errNoHEIFExif
+	}
+	start := 4 + uint64(binary.BigEndian.Uint32(data[0:4]))
+	if start >= uint64(len(data)) {
+		return nil, fmt.Errorf("invalid Exif header offset")
+	}
+	return data[start:], nil
+}
+
+// findHEIFItem returns the ID of the first item of the given type in an
+// iinf box, or 0.
+func findHEIFItem(r io.ReaderAt, iinf box, data []byte, itemType string) uint32 {
+	if len(data) < 6 {
+		return 0
+	}
+	// Entry count is 16 bits in version 0, 32 bits otherwise
+	start := iinf.dataStart + 6
+	if data[0] != 0 {
+		start += 2
+	}
+
+	var id uint32
+	walkBoxes(r, start, iinf.end, func(b box) error {
+		if b.typ != "infe" || id != 0 {
+			return nil
+		}
+		infe, err := readBoxData(r, b)
+		if err != nil {
+			return nil
+		}
+		// Versions 2 and 3: item ID (16 or 32 bits), protection index, type
+		switch {
+		case len(infe) >= 12 && infe[0] == 2:
+			if string(infe[8:12]) == itemType {
+				id = uint32(binary.BigEndian.Uint16(infe[4:6]))
+			}
+		case len(infe) >= 14 && infe[0] == 3:
+			if string(infe[10:14]) == itemType {
+				id = binary.BigEndian.Uint32(infe[4:8])
+			}
+		}
+		return nil
+	})
+	return id
+}
+
+type heifExtent struct {
+	offset uint64
+	length uint64
+}
+
+// parseILOC reads the item locations of an iloc box. Items stored in the
+// idat box or in other files are skipped.
+func parseILOC(data []byte) (map[uint32][]heifExtent, error) {
+	p := &byteParser{data: data}
+	version := p.uint(1)
+	p.skip(3) // flags
+	sizes := p.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0xf)
+	sizes = p.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), int(sizes&0xf)
+	if version == 0 {
+		indexSize = 0
+	}
+
+	var itemCount uint64
+	if version < 2 {
+		itemCount = p.uint(2)
+	} else {
+		itemCount = p.uint(4)
+	}
+
+	locations := make(map[uint32][]heifExtent)
+	for i := uint64(0); i < itemCount && p.err == nil; i++ {
+		var id uint32
+		if version < 2 {
+			id = uint32(p.uint(2))
+		} else {
+			id = uint32(p.uint(4))
+		}
+		constructionMethod := uint64(0)
+		if version > 0 {
+			constructionMethod = p.uint(2) & 0xf
+		}
+		dataReference := p.uint(2)
+		baseOffset := p.uint(baseOffsetSize)
+		extentCount := p.uint(2)
+
+		var extents []heifExtent
+		for k := uint64(0); k < extentCount && p.err == nil; k++ {
+			p.uint(indexSize)
+			offset := p.uint(offsetSize)
+			length := p.uint(lengthSize)
+			extents = append(extents, heifExtent{offset: baseOffset + offset, length: length})
+		}
+		if constructionMethod == 0 && dataReference == 0 {
+			locations[id] = extents
+		}
+	}
+	if p.err != nil {
+		return nil, fmt.Errorf("invalid iloc box: %w", p.err)
+	}
+	return locations, nil
+}
+
+// byteParser reads big-endian integers of variable width from a buffer,
+// remembering the first out-of-bounds read.
+type byteParser struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (p *byteParser) uint(n int) uint64 {
+	if p.err != nil {
+		return 0
+	}
+	if n < 0 || p.pos+n > len(p.data) {
+		p.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	var v uint64
+	for _, b := range p.data[p.pos : p.pos+n] {
+		v = v<<8 | uint64(b)
+	}
+	p.pos += n
+	return v
+}
+
+func (p *byteParser) skip(n int) {
+	p.uint(n)
+}
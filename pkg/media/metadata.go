@@ -1,9 +1,11 @@
 package media
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"image"
 	"io"
 	"os"
@@ -21,42 +23,111 @@ import (
 	_ "image/png"
 )
 
+// Extractor reads file metadata, choosing the creation time by a configurable
+// precedence of date sources.
+type Extractor struct {
+	datePrecedence []DateSource
+}
+
+// NewExtractor creates an Extractor. A nil precedence uses DefaultDatePrecedence.
+func NewExtractor(datePrecedence []DateSource) *Extractor {
+	if len(datePrecedence) == 0 {
+		datePrecedence = DefaultDatePrecedence
+	}
+	return &Extractor{datePrecedence: datePrecedence}
+}
+
+var defaultExtractor = NewExtractor(nil)
+
+// ExtractFileMetadata extracts metadata using the default date precedence.
 func ExtractFileMetadata(filePath string) (*MediaFile, error) {
+	return defaultExtractor.Extract(filePath)
+}
+
+// Extract reads the metadata of filePath. The creation time comes from the
+// first date source in the precedence list that yields one; the winner is
+// recorded in DateSource. GPS and description come from whichever of the
+// embedded metadata and the sidecar ranks higher.
+func (e *Extractor) Extract(filePath string) (*MediaFile, error) {
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		return nil, err
 	}
 
-	mediaType := DetermineMediaType(filePath)
-	if mediaType == TypeUnknown {
+	// The content decides the parser; a PNG named .jpg is read as a PNG
+	format, contentFormat, ok := identify(filePath)
+	if !ok {
 		return nil, errors.New("unsupported file type")
 	}
+	mediaType := format.Type
+	if contentFormat != "" {
+		if ext := filepath.Ext(filePath); ext != "" {
+			logrus.Warnf("%s is a %s file, not %s", filePath, strings.ToUpper(contentFormat), ext)
+		} else {
+			logrus.Debugf("%s is a %s file", filePath, strings.ToUpper(contentFormat))
+		}
+	}
 
 	mediaFile := &MediaFile{
-		SourcePath:   filePath,
-		Type:         mediaType,
-		FileSize:     fileInfo.Size(),
-		OriginalName: filepath.Base(filePath),
+		SourcePath:    filePath,
+		Type:          mediaType,
+		FileSize:      fileInfo.Size(),
+		OriginalName:  filepath.Base(filePath),
+		ContentFormat: contentFormat,
 	}
 
-	// Get creation time
-	var creationTime time.Time
+	// Get embedded creation time
+	var embeddedTime time.Time
 	var timeErr error
 
-	switch mediaType {
-	case TypeImage:
-		creationTime, timeErr = extractImageMetadata(filePath, mediaFile)
-	case TypeVideo, TypeAudio:
-		creationTime, timeErr = extractMediaMetadata(filePath, mediaFile)
+	switch format.Extractor {
+	case ExtractorExif:
+		embeddedTime, timeErr = extractImageMetadata(filePath, mediaFile)
+	case ExtractorMP4, ExtractorTags, ExtractorFFprobe:
+		embeddedTime, timeErr = extractMediaMetadata(filePath, mediaFile, format.Extractor)
+	}
+	if timeErr != nil {
+		logrus.Debugf("Could not extract time from metadata for %s: %v", filePath, timeErr)
 	}
 
-	// Fallback to file creation time if metadata extraction failed
-	if timeErr != nil || creationTime.IsZero() {
-		logrus.Debugf("Could not extract time from metadata for %s: %v. Using file info time.", filePath, timeErr)
-		creationTime = fileInfo.ModTime()
+	sidecar := readMetadataSidecar(filePath)
+	if sidecar != nil {
+		logrus.Debugf("Using metadata sidecar %s for %s", sidecar.Path, filePath)
+		sidecarFirst := precedes(e.datePrecedence, DateSourceSidecar, DateSourceExif)
+		if sidecar.HasGPS && (!mediaFile.HasGPS || sidecarFirst) {
+			mediaFile.HasGPS, mediaFile.Latitude, mediaFile.Longitude = true, sidecar.Latitude, sidecar.Longitude
+		}
+		if sidecar.Description != "" && (mediaFile.Description == "" || sidecarFirst) {
+			mediaFile.Description = sidecar.Description
+		}
 	}
 
-	mediaFile.CreationTime = creationTime
+	for _, src := range e.datePrecedence {
+		var t time.Time
+		switch src {
+		case DateSourceExif:
+			t = embeddedTime
+		case DateSourceSidecar:
+			if sidecar != nil {
+				t = sidecar.DateTaken
+			}
+		case DateSourceFilename:
+			t = parseFilenameDate(mediaFile.OriginalName)
+		case DateSourceMtime:
+			t = fileInfo.ModTime()
+		}
+		if !t.IsZero() {
+			mediaFile.CreationTime = t
+			mediaFile.DateSource = src
+			break
+		}
+	}
+
+	// Modification time is always the last resort
+	if mediaFile.CreationTime.IsZero() {
+		mediaFile.CreationTime = fileInfo.ModTime()
+		mediaFile.DateSource = DateSourceMtime
+	}
 
 	return mediaFile, nil
 }
@@ -71,11 +142,57 @@ func ComputeFileHash(filePath string) (string, error) {
 	}
 	defer f.Close()
 
-	h := xxhash.New()
+	h := NewFileHash()
 	if _, err := io.Copy(h, f); err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("%016x", h.Sum64()), nil
+	return FormatFileHash(h), nil
+}
+
+// PartialHashSize is how much of each end of a file ComputePartialHash reads.
+const PartialHashSize = 64 * 1024
+
+// ComputePartialHash returns a hash of the size and the first and last
+// PartialHashSize bytes of the file at filePath, reading at most 128 KiB of
+// it however large it is. Files with different partial hashes differ; only
+// ComputeFileHash tells whether files with the same one are identical.
+func ComputePartialHash(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+
+	h := NewFileHash()
+	binary.Write(h, binary.LittleEndian, size)
+	if size <= 2*PartialHashSize {
+		_, err = io.Copy(h, f)
+	} else if _, err = io.CopyN(h, f, PartialHashSize); err == nil {
+		if _, err = f.Seek(-PartialHashSize, io.SeekEnd); err == nil {
+			_, err = io.Copy(h, f)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return FormatFileHash(h), nil
+}
+
+// NewFileHash returns the hash used by ComputeFileHash, to hash a file while
+// it is being read for something else, like a copy.
+func NewFileHash() hash.Hash64 {
+	return xxhash.New()
+}
+
+// FormatFileHash formats the sum of a NewFileHash like ComputeFileHash.
+func FormatFileHash(h hash.Hash64) string {
+	return fmt.Sprintf("%016x", h.Sum64())
 }
 
 func extractImageMetadata(filePath string, mediaFile *MediaFile) (time.Time, error) {
@@ -100,9 +217,21 @@ func extractImageMetadata(filePath string, mediaFile *MediaFile) (time.Time, err
 	// Rewind file for EXIF reading
 	file.Seek(0, io.SeekStart)
 	
-	// First try with rwcarlsen/goexif
-	exifData, err := exif.Decode(file)
+	// First try with rwcarlsen/goexif; HEIF keeps Exif in an item of its own
+	var exifData *exif.Exif
+	if heifExtensions[mediaFile.contentExtension()] {
+		exifData, err = decodeHEIFExif(filePath)
+	} else {
+		exifData, err = exif.Decode(file)
+	}
 	if err == nil {
+		mediaFile.CameraMake = exifString(exifData, exif.Make)
+		mediaFile.CameraModel = exifString(exifData, exif.Model)
+		mediaFile.ContentIdentifier = appleContentIdentifier(exifData)
+		if lat, lon, err := exifData.LatLong(); err == nil && (lat != 0 || lon != 0) {
+			mediaFile.HasGPS, mediaFile.Latitude, mediaFile.Longitude = true, lat, lon
+		}
+
 		dateTime, err := exifData.DateTime()
 		if err == nil {
 			return dateTime, nil
@@ -120,20 +249,21 @@ func extractImageMetadata(filePath string, mediaFile *MediaFile) (time.Time, err
 		}
 	}
 	
-	// Try to extract creation time from file modification time as a fallback
-	fileInfo, err := os.Stat(filePath)
+	// No embedded date; the caller falls back to the other date sources
+	return time.Time{}, nil
+}
+
+// exifString returns the trimmed string value of an EXIF tag, or "" if absent.
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
 	if err != nil {
-		return time.Time{}, err
+		return ""
 	}
-	
-	// For now, return the file's modification time
-	// In a production environment, you would want to improve this with more
-	// robust metadata extraction techniques
-	modTime := fileInfo.ModTime()
-	
-	// For simplicity, we're returning the modification time
-	// You could enhance this with specific libraries for media metadata extraction
-	return modTime, nil
+	str, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(str, "\x00"))
 }
 
 // ffprobeFormat represents the relevant fields from ffprobe JSON output.
@@ -143,18 +273,37 @@ type ffprobeOutput struct {
 	} `json:"format"`
 }
 
-func extractMediaMetadata(filePath string, mediaFile *MediaFile) (time.Time, error) {
-	// Try ffprobe first for accurate creation dates
-	if t, err := extractCreationTimeViaFFprobe(filePath); err == nil && !t.IsZero() {
-		return t, nil
+func extractMediaMetadata(filePath string, mediaFile *MediaFile, extractor string) (time.Time, error) {
+	// Parse MP4/QuickTime containers natively; ffprobe is only needed for
+	// containers the native parser can't handle
+	if extractor == ExtractorMP4 {
+		info, err := parseMP4(filePath)
+		if err == nil {
+			mediaFile.Duration = info.Duration
+			mediaFile.LargerDimension = max(info.Width, info.Height)
+			mediaFile.ContentIdentifier = info.Metadata[mp4ContentIdentifierKey]
+			if mediaFile.Type == TypeAudio {
+				if t := mp4AudioTags(info).applyTo(mediaFile); !t.IsZero() {
+					return t, nil
+				}
+			}
+			// A parsed container without a date won't do better with ffprobe
+			return info.BestCreationTime(), nil
+		}
+		logrus.Debugf("Native container parser failed for %s: %v", filePath, err)
 	}
 
-	// Fall back to file modification time
-	fileInfo, err := os.Stat(filePath)
-	if err != nil {
-		return time.Time{}, err
+	// A recording date in the tags beats container and filesystem times
+	if extractor != ExtractorFFprobe && mediaFile.Type == TypeAudio {
+		if tags, err := readAudioTags(filePath); err == nil {
+			if t := tags.applyTo(mediaFile); !t.IsZero() {
+				return t, nil
+			}
+		}
 	}
-	return fileInfo.ModTime(), nil
+
+	// Try ffprobe for accurate creation dates
+	return extractCreationTimeViaFFprobe(filePath)
 }
 
 // extractCreationTimeViaFFprobe shells out to ffprobe to get the creation_time
@@ -0,0 +1,56 @@
+package media
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestComputePartialHash(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content []byte) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	partial := func(path string) string {
+		t.Helper()
+		h, err := ComputePartialHash(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	big := bytes.Repeat([]byte("0123456789abcdef"), PartialHashSize/4)
+	middle := append([]byte(nil), big...)
+	middle[len(middle)/2] ^= 0xff
+	end := append([]byte(nil), big...)
+	end[len(end)-1] ^= 0xff
+
+	original := partial(write("original", big))
+	if got := partial(write("copy", big)); got != original {
+		t.Errorf("identical files have partial hashes %s and %s", original, got)
+	}
+	if got := partial(write("middle", middle)); got != original {
+		t.Errorf("a change in the middle changed the partial hash; only the ends should be read")
+	}
+	if got := partial(write("end", end)); got == original {
+		t.Errorf("a change in the last bytes kept the partial hash")
+	}
+	if got := partial(write("longer", append(big, 0))); got == original {
+		t.Errorf("a different size kept the partial hash")
+	}
+
+	small := partial(write("small", []byte("small file")))
+	if got := partial(write("small2", []byte("small fill"))); got == small {
+		t.Errorf("small files are hashed whole, but a change kept the partial hash")
+	}
+	if _, err := ComputePartialHash(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
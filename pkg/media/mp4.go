@@ -0,0 +1,366 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Native parsing of ISO base media / QuickTime containers (MP4, MOV, M4V, 3GP).
+// Only the boxes needed for organizing are read: moov/mvhd for the creation
+// time and duration, moov/trak/tkhd for pixel dimensions, and the QuickTime
+// keys/ilst metadata (com.apple.quicktime.creationdate and friends).
+
+// mp4Epoch is the reference time of QuickTime/MP4 timestamps.
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// maxMP4MetaBox bounds how much of a metadata box is read into memory.
+const maxMP4MetaBox = 4 << 20
+
+var errNotMP4 = errors.New("not an ISO base media file")
+
+// mp4Info holds the values read from an MP4/QuickTime container.
+type mp4Info struct {
+	CreationTime time.Time // moov/mvhd creation time (UTC)
+	Duration     time.Duration
+	Width        int
+	Height       int
+	// Metadata holds QuickTime mdta keys (e.g. "com.apple.quicktime.creationdate")
+	// and iTunes-style ilst atoms (e.g. "©day") with their string values.
+	Metadata map[string]string
+}
+
+// BestCreationTime prefers the Apple creation date, which carries the local
+// timezone, over the mvhd creation time, which is UTC.
+func (i *mp4Info) BestCreationTime() time.Time {
+	if v := i.Metadata["com.apple.quicktime.creationdate"]; v != "" {
+		if t, err := parseMP4Date(v); err == nil {
+			return t
+		}
+	}
+	return i.CreationTime
+}
+
+func parseMP4(filePath string) (*mp4Info, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return parseMP4Reader(f, fi.Size())
+}
+
+func parseMP4Reader(r io.ReaderAt, size int64) (*mp4Info, error) {
+	info := &mp4Info{Metadata: make(map[string]string)}
+
+	// The first box must be one of the usual top-level types
+	first, err := readBoxHeader(r, 0, size)
+	if err != nil {
+		return nil, errNotMP4
+	}
+	switch first.typ {
+	case "ftyp", "moov", "mdat", "wide", "free", "skip", "pnot":
+	default:
+		return nil, errNotMP4
+	}
+
+	foundMoov := false
+	err = walkBoxes(r, 0, size, func(b box) error {
+		if b.typ != "moov" {
+			return nil
+		}
+		foundMoov = true
+		return parseMoov(r, b, info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !foundMoov {
+		return nil, fmt.Errorf("no moov box found")
+	}
+	return info, nil
+}
+
+type box struct {
+	typ       string
+	offset    int64 // start of the box header
+	dataStart int64 // start of the payload
+	end       int64 // end of the box
+}
+
+func readBoxHeader(r io.ReaderAt, offset, limit int64) (box, error) {
+	var hdr [16]byte
+	if offset+8 > limit {
+		return box{}, io.ErrUnexpectedEOF
+	}
+	if _, err := r.ReadAt(hdr[:8], offset); err != nil {
+		return box{}, err
+	}
+	size := int64(binary.BigEndian.Uint32(hdr[0:4]))
+	b := box{typ: string(hdr[4:8]), offset: offset, dataStart: offset + 8}
+
+	switch size {
+	case 0: // box extends to the end of its container
+		size = limit - offset
+	case 1: // 64-bit largesize follows the type
+		if offset+16 > limit {
+			return box{}, io.ErrUnexpectedEOF
+		}
+		if _, err := r.ReadAt(hdr[8:16], offset+8); err != nil {
+			return box{}, err
+		}
+		size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+		b.dataStart = offset + 16
+	}
+	if size < b.dataStart-offset || offset+size > limit {
+		return box{}, fmt.Errorf("invalid size %d for box %q", size, b.typ)
+	}
+	b.end = offset + size
+	return b, nil
+}
+
+// walkBoxes calls fn for each box in [start, end).
+func walkBoxes(r io.ReaderAt, start, end int64, fn func(b box) error) error {
+	for off := start; off+8 <= end; {
+		b, err := readBoxHeader(r, off, end)
+		if err != nil {
+			return err
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+		off = b.end
+	}
+	return nil
+}
+
+func readBoxData(r io.ReaderAt, b box) ([]byte, error) {
+	n := b.end - b.dataStart
+	if n > maxMP4MetaBox {
+		return nil, fmt.Errorf("box %q too large (%d bytes)", b.typ, n)
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, b.dataStart); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func parseMoov(r io.ReaderAt, moov box, info *mp4Info) error {
+	return walkBoxes(r, moov.dataStart, moov.end, func(b box) error {
+		switch b.typ {
+		case "mvhd":
+			data, err := readBoxData(r, b)
+			if err != nil {
+				return err
+			}
+			parseMvhd(data, info)
+		case "trak":
+			if err := parseTrak(r, b, info); err != nil {
+				return err
+			}
+		case "meta":
+			return parseMeta(r, b, info)
+		case "udta":
+			return walkBoxes(r, b.dataStart, b.end, func(c box) error {
+				if c.typ == "meta" {
+					return parseMeta(r, c, info)
+				}
+				// Old-style QuickTime user data such as ©day holds a
+				// 16-bit length and language followed by the text.
+				if strings.HasPrefix(c.typ, "\xa9") && c.end-c.dataStart > 4 && c.end-c.dataStart < 1024 {
+					data, err := readBoxData(r, c)
+					if err != nil {
+						return err
+					}
+					n := int(binary.BigEndian.Uint16(data[0:2]))
+					if 4+n <= len(data) {
+						setMetadata(info, c.typ, string(data[4:4+n]))
+					}
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+func parseMvhd(data []byte, info *mp4Info) {
+	if len(data) < 4 {
+		return
+	}
+	var created uint64
+	var timescale uint32
+	var duration uint64
+	if data[0] == 1 {
+		if len(data) < 32 {
+			return
+		}
+		created = binary.BigEndian.Uint64(data[4:12])
+		timescale = binary.BigEndian.Uint32(data[20:24])
+		duration = binary.BigEndian.Uint64(data[24:32])
+	} else {
+		if len(data) < 20 {
+			return
+		}
+		created = uint64(binary.BigEndian.Uint32(data[4:8]))
+		timescale = binary.BigEndian.Uint32(data[12:16])
+		duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	}
+
+	// Zero means "not set"; anything before 1970 is bogus for media files
+	if created != 0 {
+		t := mp4Epoch.Add(time.Duration(created) * time.Second)
+		if t.Year() >= 1970 {
+			info.CreationTime = t
+		}
+	}
+	if timescale != 0 && duration != 0 && duration != ^uint64(0) && duration != 0xffffffff {
+		info.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+	}
+}
+
+func parseTrak(r io.ReaderAt, trak box, info *mp4Info) error {
+	return walkBoxes(r, trak.dataStart, trak.end, func(b box) error {
+		if b.typ != "tkhd" {
+			return nil
+		}
+		data, err := readBoxData(r, b)
+		if err != nil {
+			return err
+		}
+		// Width and height are the last two 16.16 fixed-point fields
+		if len(data) < 84 {
+			return nil
+		}
+		width := int(binary.BigEndian.Uint32(data[len(data)-8:len(data)-4]) >> 16)
+		height := int(binary.BigEndian.Uint32(data[len(data)-4:]) >> 16)
+		// Audio tracks have zero dimensions; keep the largest video track
+		if width*height > info.Width*info.Height {
+			info.Width = width
+			info.Height = height
+		}
+		return nil
+	})
+}
+
+// parseMeta reads a meta box. QuickTime writes it as a plain box containing
+// hdlr/keys/ilst; ISO (and iTunes) write it as a full box with 4 bytes of
+// version and flags before the children.
+func parseMeta(r io.ReaderAt, meta box, info *mp4Info) error {
+	start := meta.dataStart
+	var peek [8]byte
+	if meta.end-start >= 8 {
+		if _, err := r.ReadAt(peek[:], start); err != nil {
+			return err
+		}
+		if string(peek[4:8]) != "hdlr" {
+			start += 4
+		}
+	}
+
+	var keys []string
+	return walkBoxes(r, start, meta.end, func(b box) error {
+		switch b.typ {
+		case "keys":
+			data, err := readBoxData(r, b)
+			if err != nil {
+				return err
+			}
+			keys = parseKeys(data)
+		case "ilst":
+			return walkBoxes(r, b.dataStart, b.end, func(item box) error {
+				name := item.typ
+				// With a keys box, item types are 1-based indexes into it
+				if idx := int(binary.BigEndian.Uint32([]byte(item.typ))); len(keys) > 0 && idx >= 1 && idx <= len(keys) {
+					name = keys[idx-1]
+				}
+				return walkBoxes(r, item.dataStart, item.end, func(d box) error {
+					if d.typ != "data" {
+						return nil
+					}
+					data, err := readBoxData(r, d)
+					if err != nil {
+						return err
+					}
+					// data: 1 byte version, 3 bytes type, 4 bytes locale, value
+					if len(data) < 8 {
+						return nil
+					}
+					setDataValue(info, name, binary.BigEndian.Uint32(data[0:4])&0xffffff, data[8:])
+					return nil
+				})
+			})
+		}
+		return nil
+	})
+}
+
+func parseKeys(data []byte) []string {
+	if len(data) < 8 {
+		return nil
+	}
+	count := int(binary.BigEndian.Uint32(data[4:8]))
+	keys := make([]string, 0, count)
+	for off := 8; off+8 <= len(data) && len(keys) < count; {
+		size := int(binary.BigEndian.Uint32(data[off : off+4]))
+		if size < 8 || off+size > len(data) {
+			break
+		}
+		keys = append(keys, string(data[off+8:off+size]))
+		off += size
+	}
+	return keys
+}
+
+// setDataValue stores an ilst data value. Type 1 is UTF-8 text; type 0 is
+// "implicit" and used by iTunes for binary values such as track numbers,
+// which are kept raw for callers that know the layout.
+func setDataValue(info *mp4Info, name string, dataType uint32, value []byte) {
+	switch dataType {
+	case 0, 1:
+		setMetadata(info, name, string(value))
+	case 21, 22: // signed / unsigned big-endian integer
+		var n uint64
+		for _, b := range value {
+			n = n<<8 | uint64(b)
+		}
+		setMetadata(info, name, fmt.Sprintf("%d", n))
+	}
+}
+
+func setMetadata(info *mp4Info, name, value string) {
+	value = strings.TrimRight(value, "\x00")
+	if _, exists := info.Metadata[name]; !exists && value != "" {
+		info.Metadata[name] = value
+	}
+}
+
+// parseMP4Date parses the date formats found in QuickTime metadata,
+// e.g. "2019-07-15T12:34:56+0200" or "2019-07-15T12:34:56Z".
+func parseMP4Date(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{
+		"2006-01-02T15:04:05-0700",
+		"2006-01-02T15:04:05.000-0700",
+		time.RFC3339,
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", s)
+}
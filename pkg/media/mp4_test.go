@@ -0,0 +1,203 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mp4Box builds a box with the given type and payload.
+func mp4Box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	buf := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(8+len(body)))
+	copy(buf[4:8], typ)
+	return append(buf, body...)
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func mp4Seconds(t time.Time) uint64 {
+	return uint64(t.Sub(mp4Epoch) / time.Second)
+}
+
+// mvhdV0 builds a version 0 movie header.
+func mvhdV0(created time.Time, timescale, duration uint32) []byte {
+	return mp4Box("mvhd",
+		u32(0), // version + flags
+		u32(uint32(mp4Seconds(created))), u32(uint32(mp4Seconds(created))),
+		u32(timescale), u32(duration),
+		make([]byte, 80), // rate, volume, matrix, next track ID...
+	)
+}
+
+// tkhdV0 builds a version 0 track header with the given dimensions.
+func tkhdV0(width, height uint32) []byte {
+	// version + flags, times, track ID, reserved, duration,
+	// then reserved, layer, group, volume and matrix
+	return mp4Box("tkhd",
+		u32(0),
+		u32(0), u32(0), u32(1), u32(0), u32(0),
+		make([]byte, 52),
+		u32(width<<16), u32(height<<16),
+	)
+}
+
+func quickTimeMeta(key, value string) []byte {
+	keyEntry := append(u32(uint32(8+len(key))), append([]byte("mdta"), key...)...)
+	return mp4Box("meta",
+		mp4Box("hdlr", make([]byte, 24)),
+		mp4Box("keys", u32(0), u32(1), keyEntry),
+		mp4Box("ilst",
+			mp4Box(string(u32(1)),
+				mp4Box("data", u32(1), u32(0), []byte(value)),
+			),
+		),
+	)
+}
+
+func writeTempFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return path
+}
+
+func TestParseMP4_MvhdAndTkhd(t *testing.T) {
+	created := time.Date(2019, 7, 15, 10, 34, 56, 0, time.UTC)
+	data := bytes.Join([][]byte{
+		mp4Box("ftyp", []byte("isom"), u32(0x200), []byte("isommp41")),
+		mp4Box("moov",
+			mvhdV0(created, 1000, 12500),
+			mp4Box("trak", tkhdV0(0, 0)), // audio track
+			mp4Box("trak", tkhdV0(1920, 1080)),
+		),
+		mp4Box("mdat", make([]byte, 16)),
+	}, nil)
+
+	info, err := parseMP4Reader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("parseMP4Reader: %v", err)
+	}
+	if !info.CreationTime.Equal(created) {
+		t.Errorf("CreationTime = %v, want %v", info.CreationTime, created)
+	}
+	if info.Duration != 12500*time.Millisecond {
+		t.Errorf("Duration = %v, want 12.5s", info.Duration)
+	}
+	if info.Width != 1920 || info.Height != 1080 {
+		t.Errorf("dimensions = %dx%d, want 1920x1080", info.Width, info.Height)
+	}
+	if !info.BestCreationTime().Equal(created) {
+		t.Errorf("BestCreationTime = %v, want mvhd time %v", info.BestCreationTime(), created)
+	}
+}
+
+func TestParseMP4_AppleCreationDateWithTimezone(t *testing.T) {
+	data := bytes.Join([][]byte{
+		mp4Box("ftyp", []byte("qt  "), u32(0), []byte("qt  ")),
+		mp4Box("moov",
+			mvhdV0(time.Date(2019, 7, 15, 10, 34, 56, 0, time.UTC), 600, 600),
+			quickTimeMeta("com.apple.quicktime.creationdate", "2019-07-15T12:34:56+0200"),
+		),
+	}, nil)
+
+	info, err := parseMP4Reader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("parseMP4Reader: %v", err)
+	}
+
+	best := info.BestCreationTime()
+	if got := best.Format("2006-01-02 15:04:05 -0700"); got != "2019-07-15 12:34:56 +0200" {
+		t.Errorf("BestCreationTime = %s, want local time with +0200 offset", got)
+	}
+}
+
+func TestParseMP4_MvhdVersion1AndLargeSize(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mvhd := mp4Box("mvhd",
+		u32(1<<24), // version 1
+		u64(mp4Seconds(created)), u64(mp4Seconds(created)),
+		u32(90000), u64(90000*3),
+		make([]byte, 80),
+	)
+	moov := mp4Box("moov", mvhd)
+
+	// Re-encode moov with a 64-bit largesize header
+	large := append(append(u32(1), []byte("moov")...), u64(uint64(len(moov)+8))...)
+	large = append(large, moov[8:]...)
+
+	data := append(mp4Box("ftyp", []byte("isom"), u32(0)), large...)
+	info, err := parseMP4Reader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("parseMP4Reader: %v", err)
+	}
+	if !info.CreationTime.Equal(created) {
+		t.Errorf("CreationTime = %v, want %v", info.CreationTime, created)
+	}
+	if info.Duration != 3*time.Second {
+		t.Errorf("Duration = %v, want 3s", info.Duration)
+	}
+}
+
+func TestParseMP4_ZeroCreationTimeIsUnset(t *testing.T) {
+	data := mp4Box("moov", mp4Box("mvhd", u32(0), u32(0), u32(0), u32(1000), u32(1000), make([]byte, 80)))
+
+	info, err := parseMP4Reader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("parseMP4Reader: %v", err)
+	}
+	if !info.BestCreationTime().IsZero() {
+		t.Errorf("expected zero creation time, got %v", info.BestCreationTime())
+	}
+}
+
+func TestParseMP4_RejectsOtherFormats(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"jpeg":  {0xff, 0xd8, 0xff, 0xe0, 0, 0x10, 'J', 'F', 'I', 'F', 0, 1},
+		"empty": {},
+		"avi":   append([]byte("RIFF\x00\x00\x00\x00AVI LIST"), make([]byte, 8)...),
+	} {
+		if _, err := parseMP4Reader(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestExtractFileMetadata_NativeVideo(t *testing.T) {
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	data := bytes.Join([][]byte{
+		mp4Box("ftyp", []byte("isom"), u32(0)),
+		mp4Box("moov", mvhdV0(created, 1000, 2000), mp4Box("trak", tkhdV0(1080, 1920))),
+	}, nil)
+	path := writeTempFile(t, "clip.mp4", data)
+
+	mf, err := ExtractFileMetadata(path)
+	if err != nil {
+		t.Fatalf("ExtractFileMetadata: %v", err)
+	}
+	if !mf.CreationTime.Equal(created) {
+		t.Errorf("CreationTime = %v, want %v", mf.CreationTime, created)
+	}
+	if mf.LargerDimension != 1920 {
+		t.Errorf("LargerDimension = %d, want 1920", mf.LargerDimension)
+	}
+	if mf.Duration != 2*time.Second {
+		t.Errorf("Duration = %v, want 2s", mf.Duration)
+	}
+}
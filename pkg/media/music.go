@@ -0,0 +1,65 @@
+package media
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// HasMusicTags reports whether the file carries enough tags to be placed in
+// the music layout: an artist plus an album or a title. Untagged audio such
+// as voice memos keeps the regular date-based layout.
+func (m *MediaFile) HasMusicTags() bool {
+	if m.Type != TypeAudio {
+		return false
+	}
+	return m.musicArtist() != "" && (strings.TrimSpace(m.Album) != "" || strings.TrimSpace(m.Title) != "")
+}
+
+// GetMusicPath returns the music layout path relative to the audio
+// destination: <AlbumArtist>/<Year - Album>/<NN - Title>.ext. The year,
+// track number and album folder are left out when the tags lack them, and
+// the original filename stands in for a missing title. seqNum >= 1 appends
+// "_001", "_002", ... to resolve collisions.
+func (m *MediaFile) GetMusicPath(seqNum int) string {
+	artist := musicPathElement(m.musicArtist())
+
+	album := musicPathElement(m.Album)
+	if album != "" && m.Year > 0 {
+		album = fmt.Sprintf("%d - %s", m.Year, album)
+	}
+
+	title := musicPathElement(m.Title)
+	if title == "" {
+		title = musicPathElement(m.baseOriginalName(""))
+	}
+	if m.TrackNumber > 0 {
+		title = fmt.Sprintf("%02d - %s", m.TrackNumber, title)
+	}
+	fileName := title + formatSequenceSuffix(seqNum) + "." + m.GetExtension()
+
+	if album == "" {
+		return filepath.Join(artist, fileName)
+	}
+	return filepath.Join(artist, album, fileName)
+}
+
+// musicArtist prefers the album artist so compilations stay in one folder.
+func (m *MediaFile) musicArtist() string {
+	if s := strings.TrimSpace(m.AlbumArtist); s != "" {
+		return s
+	}
+	return strings.TrimSpace(m.Artist)
+}
+
+// musicPathElement makes a tag value safe as a single path element. Tags
+// routinely contain characters such as "/" ("AC/DC") or "?" that are invalid
+// on common filesystems.
+func musicPathElement(s string) string {
+	s = strings.NewReplacer(
+		"/", "_", "\\", "_", ":", "_", "*", "_", "?", "_",
+		"\"", "_", "<", "_", ">", "_", "|", "_", "\x00", "",
+	).Replace(strings.TrimSpace(s))
+	// Trailing dots and spaces are stripped by Windows filesystems
+	return strings.TrimRight(s, ". ")
+}
@@ -0,0 +1,84 @@
+package media
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestHasMusicTags(t *testing.T) {
+	tests := []struct {
+		name string
+		m    MediaFile
+		want bool
+	}{
+		{"artist and album", MediaFile{Type: TypeAudio, Artist: "A", Album: "B"}, true},
+		{"album artist and title", MediaFile{Type: TypeAudio, AlbumArtist: "A", Title: "T"}, true},
+		{"no artist", MediaFile{Type: TypeAudio, Album: "B", Title: "T"}, false},
+		{"artist only", MediaFile{Type: TypeAudio, Artist: "A"}, false},
+		{"untagged", MediaFile{Type: TypeAudio}, false},
+		{"video", MediaFile{Type: TypeVideo, Artist: "A", Album: "B"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.m.HasMusicTags(); got != tt.want {
+			t.Errorf("%s: HasMusicTags() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetMusicPath(t *testing.T) {
+	tests := []struct {
+		name string
+		m    MediaFile
+		seq  int
+		want string
+	}{
+		{
+			name: "full tags",
+			m:    MediaFile{SourcePath: "/in/x.MP3", Artist: "Track Artist", AlbumArtist: "Band", Album: "Album", Title: "Song", TrackNumber: 3, Year: 1999},
+			want: "Band/1999 - Album/03 - Song.mp3",
+		},
+		{
+			name: "artist fallback, no year",
+			m:    MediaFile{SourcePath: "/in/x.flac", Artist: "Band", Album: "Album", Title: "Song", TrackNumber: 12},
+			want: "Band/Album/12 - Song.flac",
+		},
+		{
+			name: "no album, no track",
+			m:    MediaFile{SourcePath: "/in/x.m4a", Artist: "Band", Title: "Single"},
+			want: "Band/Single.m4a",
+		},
+		{
+			name: "title from filename",
+			m:    MediaFile{SourcePath: "/in/track01.ogg", OriginalName: "track01.ogg", Artist: "Band", Album: "Album", TrackNumber: 1},
+			want: "Band/Album/01 - track01.ogg",
+		},
+		{
+			name: "unsafe characters",
+			m:    MediaFile{SourcePath: "/in/x.mp3", Artist: "AC/DC", Album: "Who Made Who?", Title: "D.T.", Year: 1986},
+			want: "AC_DC/1986 - Who Made Who_/D.T.mp3",
+		},
+		{
+			name: "sequence",
+			m:    MediaFile{SourcePath: "/in/x.mp3", Artist: "Band", Album: "Album", Title: "Song", TrackNumber: 1},
+			seq:  2,
+			want: "Band/Album/01 - Song_002.mp3",
+		},
+	}
+	for _, tt := range tests {
+		tt.m.Type = TypeAudio
+		if got := tt.m.GetMusicPath(tt.seq); got != filepath.FromSlash(tt.want) {
+			t.Errorf("%s: GetMusicPath() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateMusicTokens(t *testing.T) {
+	tpl, err := ParseTemplate("{album_artist}/{album}/{track} - {title}.{ext}")
+	if err != nil {
+		t.Fatalf("ParseTemplate: %v", err)
+	}
+	m := &MediaFile{SourcePath: "/in/a.mp3", Type: TypeAudio, Artist: "Band", Album: "Album", Title: "Song", TrackNumber: 4}
+	if got, err := tpl.Render(m, 0, "", false); err != nil || got != filepath.FromSlash("Band/Album/04 - Song.mp3") {
+		t.Errorf("Render = %q, %v", got, err)
+	}
+}
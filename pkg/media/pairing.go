@@ -0,0 +1,140 @@
+package media
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Some shots produce several files: cameras shooting RAW+JPEG write a RAW
+// file and its JPEG rendering, iPhones write a Live Photo as a HEIC (or
+// JPEG) photo and a short MOV. Such pairs share a base name and capture
+// time, and Live Photos also share an Apple ContentIdentifier.
+
+// PairTimeTolerance is how far apart the capture times of two files with
+// the same base name may be for them to count as one shot. The video of a
+// Live Photo starts a moment before the photo is taken.
+const PairTimeTolerance = 2 * time.Second
+
+// mp4ContentIdentifierKey is the QuickTime metadata key holding the
+// ContentIdentifier of a Live Photo video.
+const mp4ContentIdentifierKey = "com.apple.quicktime.content.identifier"
+
+// appleMakerNoteContentIdentifier is the Apple MakerNote tag holding the
+// ContentIdentifier of a Live Photo image.
+const appleMakerNoteContentIdentifier = 0x0011
+
+// appleContentIdentifier reads the ContentIdentifier from the Apple
+// MakerNote of an image. The MakerNote starts with "Apple iOS\0", a
+// version and a byte order mark, followed by an IFD whose offsets are
+// relative to the start of the MakerNote.
+func appleContentIdentifier(x *exif.Exif) string {
+	tag, err := x.Get(exif.MakerNote)
+	if err != nil {
+		return ""
+	}
+	note := tag.Val
+	if len(note) < 16 || !strings.HasPrefix(string(note), "Apple iOS\x00") {
+		return ""
+	}
+
+	var order binary.ByteOrder
+	switch string(note[12:14]) {
+	case "MM":
+		order = binary.BigEndian
+	case "II":
+		order = binary.LittleEndian
+	default:
+		return ""
+	}
+
+	count := int(order.Uint16(note[14:16]))
+	for i := 0; i < count; i++ {
+		entry := 16 + i*12
+		if entry+12 > len(note) {
+			break
+		}
+		if order.Uint16(note[entry:entry+2]) != appleMakerNoteContentIdentifier {
+			continue
+		}
+		// ASCII string; values longer than 4 bytes are stored at an offset
+		size := int(order.Uint32(note[entry+4 : entry+8]))
+		start := entry + 8
+		if size > 4 {
+			start = int(order.Uint32(note[entry+8 : entry+12]))
+		}
+		if order.Uint16(note[entry+2:entry+4]) != 2 || start < 0 || start+size > len(note) {
+			return ""
+		}
+		return strings.TrimSpace(strings.TrimRight(string(note[start:start+size]), "\x00"))
+	}
+	return ""
+}
+
+// pairRank orders the members of a pair by which one names it: RAW images
+// first, then other images, then videos.
+func pairRank(m *MediaFile) int {
+	switch {
+	case m.Type == TypeImage && rawExtensions[m.GetExtension()]:
+		return 0
+	case m.Type == TypeImage:
+		return 1
+	case m.Type == TypeVideo:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// IsPair reports whether a and b are files of the same shot: a RAW image
+// and its JPEG (or HEIC) rendering, or the photo and the video of a Live
+// Photo. Such files pair when they have the same ContentIdentifier, or when
+// they have the same base name and capture times within PairTimeTolerance.
+// Callers are expected to only compare files from the same directory by name.
+func IsPair(a, b *MediaFile) bool {
+	ra, rb := pairRank(a), pairRank(b)
+	if ra > rb {
+		ra, rb = rb, ra
+	}
+	// RAW+image, RAW+video or image+video
+	if ra == rb || rb > 2 {
+		return false
+	}
+
+	if a.ContentIdentifier != "" && a.ContentIdentifier == b.ContentIdentifier {
+		return true
+	}
+	if baseStem(a.OriginalName) != baseStem(b.OriginalName) {
+		return false
+	}
+	diff := wallClock(a.CreationTime).Sub(wallClock(b.CreationTime))
+	return diff <= PairTimeTolerance && diff >= -PairTimeTolerance
+}
+
+// PairLeads reports whether a names the pair it forms with b, i.e. b takes
+// a's base name.
+func PairLeads(a, b *MediaFile) bool {
+	return pairRank(a) < pairRank(b)
+}
+
+// PairedFilename returns the name of a file that takes the base name of its
+// pair leader's destination: "<leader stem>.<own extension>".
+func PairedFilename(leaderDest string, m *MediaFile) string {
+	name := filepath.Base(leaderDest)
+	return strings.TrimSuffix(name, filepath.Ext(name)) + "." + m.GetExtension()
+}
+
+// baseStem returns name without its extension.
+func baseStem(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// wallClock drops the time zone, keeping the local date and time. EXIF
+// times have no zone while QuickTime dates usually carry one, and both
+// describe the local time of the shot.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
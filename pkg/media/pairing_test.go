@@ -0,0 +1,152 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+const testContentID = "2F6D1A52-8C3E-4B8A-9D0F-5E6A7B8C9D0E"
+
+// u16 returns v as big-endian bytes.
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+// ifdEntry builds a big-endian TIFF IFD entry.
+func ifdEntry(tag, typ uint16, count, value uint32) []byte {
+	return bytes.Join([][]byte{u16(tag), u16(typ), u32(count), u32(value)}, nil)
+}
+
+// appleMakerNote builds an Apple MakerNote holding a ContentIdentifier.
+func appleMakerNote(contentID string) []byte {
+	value := append([]byte(contentID), 0)
+	return bytes.Join([][]byte{
+		[]byte("Apple iOS\x00"), u16(1), []byte("MM"),
+		u16(1), ifdEntry(0x0011, 2, uint32(len(value)), 16+12+4), u32(0),
+		value,
+	}, nil)
+}
+
+// tiffWithExif builds a big-endian TIFF whose Exif IFD holds
+// DateTimeOriginal and a MakerNote.
+func tiffWithExif(dateTime string, makerNote []byte) []byte {
+	const ifd0 = 8
+	const exifIFD = ifd0 + 2 + 12 + 4
+	const dataStart = exifIFD + 2 + 2*12 + 4
+	date := append([]byte(dateTime), 0)
+	return bytes.Join([][]byte{
+		[]byte("MM\x00*"), u32(ifd0),
+		u16(1), ifdEntry(0x8769, 4, 1, exifIFD), u32(0),
+		u16(2),
+		ifdEntry(0x9003, 2, uint32(len(date)), dataStart),
+		ifdEntry(0x927C, 7, uint32(len(makerNote)), dataStart+uint32(len(date))),
+		u32(0),
+		date, makerNote,
+	}, nil)
+}
+
+// heifWithExif builds a HEIF file whose Exif item holds tiff.
+func heifWithExif(tiff []byte) []byte {
+	payload := append(append(u32(6), "Exif\x00\x00"...), tiff...)
+	ftyp := mp4Box("ftyp", []byte("heic"), u32(0), []byte("mif1heic"))
+
+	build := func(exifOffset uint32) []byte {
+		infe := mp4Box("infe", []byte{2, 0, 0, 0}, u16(1), u16(0), []byte("Exif"))
+		iloc := mp4Box("iloc", u32(0), []byte{0x44, 0x00}, u16(1),
+			u16(1), u16(0), u16(1), u32(exifOffset), u32(uint32(len(payload))))
+		meta := mp4Box("meta", u32(0),
+			mp4Box("hdlr", make([]byte, 24)),
+			mp4Box("iinf", u32(0), u16(1), infe),
+			iloc,
+		)
+		return bytes.Join([][]byte{ftyp, meta, mp4Box("mdat", payload)}, nil)
+	}
+	// The Exif item is at the start of the mdat payload, whose offset
+	// depends on the size of the boxes before it
+	probe := build(0)
+	return build(uint32(len(probe) - len(payload)))
+}
+
+func TestAppleContentIdentifierFromHEIF(t *testing.T) {
+	path := writeTempFile(t, "IMG_1234.HEIC", heifWithExif(tiffWithExif("2019:07:15 12:34:56", appleMakerNote(testContentID))))
+
+	mf, err := ExtractFileMetadata(path)
+	if err != nil {
+		t.Fatalf("ExtractFileMetadata: %v", err)
+	}
+	if mf.ContentIdentifier != testContentID {
+		t.Errorf("ContentIdentifier = %q, want %q", mf.ContentIdentifier, testContentID)
+	}
+	want := time.Date(2019, 7, 15, 12, 34, 56, 0, time.Local)
+	if !mf.CreationTime.Equal(want) || mf.DateSource != DateSourceExif {
+		t.Errorf("CreationTime = %v (%s), want %v from exif", mf.CreationTime, mf.DateSource, want)
+	}
+}
+
+func TestContentIdentifierFromQuickTime(t *testing.T) {
+	data := append(mp4Box("ftyp", []byte("qt  "), u32(0)),
+		mp4Box("moov", mvhdV0(time.Date(2019, 7, 15, 10, 34, 55, 0, time.UTC), 600, 1800),
+			quickTimeMeta("com.apple.quicktime.content.identifier", testContentID))...)
+	path := writeTempFile(t, "IMG_1234.MOV", data)
+
+	mf, err := ExtractFileMetadata(path)
+	if err != nil {
+		t.Fatalf("ExtractFileMetadata: %v", err)
+	}
+	if mf.ContentIdentifier != testContentID {
+		t.Errorf("ContentIdentifier = %q, want %q", mf.ContentIdentifier, testContentID)
+	}
+}
+
+func TestIsPair(t *testing.T) {
+	shot := time.Date(2019, 7, 15, 12, 34, 56, 0, time.UTC)
+	file := func(name string, mediaType MediaType, offset time.Duration, contentID string) *MediaFile {
+		return &MediaFile{
+			SourcePath:        "/in/" + name,
+			OriginalName:      name,
+			Type:              mediaType,
+			CreationTime:      shot.Add(offset),
+			ContentIdentifier: contentID,
+		}
+	}
+
+	tests := []struct {
+		name string
+		a, b *MediaFile
+		want bool
+	}{
+		{"raw+jpeg", file("IMG_1.CR2", TypeImage, 0, ""), file("IMG_1.JPG", TypeImage, 0, ""), true},
+		{"live photo", file("IMG_2.HEIC", TypeImage, 0, ""), file("IMG_2.MOV", TypeVideo, -time.Second, ""), true},
+		{"too far apart", file("IMG_3.CR2", TypeImage, 0, ""), file("IMG_3.JPG", TypeImage, 5*time.Second, ""), false},
+		{"different names", file("IMG_4.CR2", TypeImage, 0, ""), file("IMG_5.JPG", TypeImage, 0, ""), false},
+		{"two jpegs", file("IMG_6.JPG", TypeImage, 0, ""), file("IMG_6.jpeg", TypeImage, 0, ""), false},
+		{"audio", file("IMG_7.JPG", TypeImage, 0, ""), file("IMG_7.m4a", TypeAudio, 0, ""), false},
+		{"content id", file("IMG_8.HEIC", TypeImage, 0, "X"), file("IMG_E8.MOV", TypeVideo, time.Hour, "X"), true},
+		{"different content ids", file("IMG_9.HEIC", TypeImage, 0, "X"), file("IMG_E9.MOV", TypeVideo, 0, "Y"), false},
+	}
+	for _, tt := range tests {
+		if got := IsPair(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: IsPair = %v, want %v", tt.name, got, tt.want)
+		}
+		if got := IsPair(tt.b, tt.a); got != tt.want {
+			t.Errorf("%s: IsPair (swapped) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	raw, jpeg, video := file("a.NEF", TypeImage, 0, ""), file("a.jpg", TypeImage, 0, ""), file("a.mov", TypeVideo, 0, "")
+	if !PairLeads(raw, jpeg) || PairLeads(jpeg, raw) || !PairLeads(jpeg, video) {
+		t.Errorf("RAW should lead JPEG, and photos should lead videos")
+	}
+}
+
+func TestPairedFilename(t *testing.T) {
+	m := &MediaFile{SourcePath: "/in/IMG_1234.MOV"}
+	got := PairedFilename("/out/heic/2019/20190715-123456 (IMG_1234)_001.heic", m)
+	if want := "20190715-123456 (IMG_1234)_001.mov"; got != want {
+		t.Errorf("PairedFilename = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,139 @@
+package media
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+	"os"
+	"strconv"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Perceptual hashing finds re-encoded, resized or re-saved copies of a photo,
+// which exact hashing can't. The difference hash (dHash) shrinks the image to
+// 9x8 grayscale cells and records, for each row, whether brightness increases
+// from one cell to the next. Copies of one photo end up a few bits apart.
+
+// dHash grid: one more column than bits per row, since bits compare neighbors.
+const (
+	dhashCols = 9
+	dhashRows = 8
+	// dhashSamples is the number of samples per cell along each axis.
+	// Averaging a grid of samples instead of reading every pixel keeps
+	// hashing cheap while smoothing out noise and compression artifacts.
+	dhashSamples = 8
+)
+
+// ComputePerceptualHash returns the 64-bit dHash of the image at filePath as
+// 16 hex digits. The image is hashed as displayed, following its EXIF
+// orientation, so a rotated re-save matches its original. Only formats with
+// a registered decoder (JPEG, PNG, GIF, TIFF) can be hashed.
+func ComputePerceptualHash(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", fmt.Errorf("decode image: %w", err)
+	}
+
+	orientation := 1
+	if _, err := f.Seek(0, io.SeekStart); err == nil {
+		if x, err := exif.Decode(f); err == nil {
+			if tag, err := x.Get(exif.Orientation); err == nil {
+				if v, err := tag.Int(0); err == nil && v >= 1 && v <= 8 {
+					orientation = v
+				}
+			}
+		}
+	}
+
+	return fmt.Sprintf("%016x", dHash(img, orientation)), nil
+}
+
+// ParsePerceptualHash parses a hash returned by ComputePerceptualHash.
+func ParsePerceptualHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// HammingDistance returns the number of bits in which two hashes differ.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func dHash(img image.Image, orientation int) uint64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return 0
+	}
+	// Orientations 5-8 swap width and height
+	displayW, displayH := w, h
+	if orientation >= 5 {
+		displayW, displayH = h, w
+	}
+
+	var cells [dhashRows][dhashCols]float64
+	for row := 0; row < dhashRows; row++ {
+		for col := 0; col < dhashCols; col++ {
+			var sum float64
+			for j := 0; j < dhashSamples; j++ {
+				v := int((float64(row) + (float64(j)+0.5)/dhashSamples) * float64(displayH) / dhashRows)
+				for i := 0; i < dhashSamples; i++ {
+					u := int((float64(col) + (float64(i)+0.5)/dhashSamples) * float64(displayW) / dhashCols)
+					x, y := orientedToSource(u, v, w, h, orientation)
+					sum += luminance(img, b.Min.X+x, b.Min.Y+y)
+				}
+			}
+			cells[row][col] = sum
+		}
+	}
+
+	var hash uint64
+	for row := 0; row < dhashRows; row++ {
+		for col := 0; col < dhashCols-1; col++ {
+			hash <<= 1
+			if cells[row][col] < cells[row][col+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// orientedToSource maps a pixel of the displayed image to the stored one
+// for the given EXIF orientation of a w x h image.
+func orientedToSource(u, v, w, h, orientation int) (x, y int) {
+	switch orientation {
+	case 2: // mirrored horizontally
+		return w - 1 - u, v
+	case 3: // rotated 180°
+		return w - 1 - u, h - 1 - v
+	case 4: // mirrored vertically
+		return u, h - 1 - v
+	case 5: // transposed
+		return v, u
+	case 6: // rotated 90° clockwise for display
+		return v, h - 1 - u
+	case 7: // transversed
+		return w - 1 - v, h - 1 - u
+	case 8: // rotated 90° counter-clockwise for display
+		return w - 1 - v, u
+	default:
+		return u, v
+	}
+}
+
+func luminance(img image.Image, x, y int) float64 {
+	// JPEGs decode to YCbCr, whose Y is the luminance already
+	if ycc, ok := img.(*image.YCbCr); ok {
+		return float64(ycc.Y[ycc.YOffset(x, y)])
+	}
+	return float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+}
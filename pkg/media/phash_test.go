@@ -0,0 +1,121 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"testing"
+)
+
+// testPhoto draws a w x h image with some structure: a diagonal gradient,
+// a bright disc and a dark bar. seed varies the layout.
+func testPhoto(w, h int, seed float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			v := 80 + 100*fx*fy + 40*math.Sin(6*fx+seed)
+			if dx, dy := fx-0.3-seed/10, fy-0.4; dx*dx+dy*dy < 0.04 {
+				v = 240
+			}
+			if fy > 0.7 && fy < 0.8 && fx > seed/10 {
+				v = 20
+			}
+			c := uint8(math.Max(0, math.Min(255, v)))
+			img.Set(x, y, color.RGBA{c, c / 2, 255 - c, 255})
+		}
+	}
+	return img
+}
+
+// resize scales img to w x h by nearest neighbor.
+func resize(img image.Image, w, h int) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			out.Set(x, y, img.At(b.Min.X+x*b.Dx()/w, b.Min.Y+y*b.Dy()/h))
+		}
+	}
+	return out
+}
+
+// rotateCW rotates img by 90° clockwise.
+func rotateCW(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dy(), b.Dx()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			out.Set(b.Dy()-1-y, x, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return out
+}
+
+func encodeJPEG(t *testing.T, img image.Image, quality int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatalf("jpeg.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func perceptualHash(t *testing.T, name string, data []byte) uint64 {
+	t.Helper()
+	s, err := ComputePerceptualHash(writeTempFile(t, name, data))
+	if err != nil {
+		t.Fatalf("ComputePerceptualHash(%s): %v", name, err)
+	}
+	h, err := ParsePerceptualHash(s)
+	if err != nil {
+		t.Fatalf("ParsePerceptualHash(%q): %v", s, err)
+	}
+	return h
+}
+
+func TestPerceptualHashNearDuplicates(t *testing.T) {
+	photo := testPhoto(1200, 800, 0)
+	original := perceptualHash(t, "original.jpg", encodeJPEG(t, photo, 95))
+
+	var pngData bytes.Buffer
+	png.Encode(&pngData, resize(photo, 300, 200))
+	copies := map[string]uint64{
+		"resaved.jpg": perceptualHash(t, "resaved.jpg", encodeJPEG(t, photo, 40)),
+		"resized.jpg": perceptualHash(t, "resized.jpg", encodeJPEG(t, resize(photo, 480, 320), 60)),
+		"small.png":   perceptualHash(t, "small.png", pngData.Bytes()),
+	}
+	for name, h := range copies {
+		if d := HammingDistance(original, h); d > 4 {
+			t.Errorf("%s: distance %d from the original, want <= 4", name, d)
+		}
+	}
+
+	other := perceptualHash(t, "other.jpg", encodeJPEG(t, testPhoto(1200, 800, 3), 95))
+	if d := HammingDistance(original, other); d < 12 {
+		t.Errorf("different photo: distance %d, want >= 12", d)
+	}
+}
+
+func TestDHashOrientation(t *testing.T) {
+	photo := testPhoto(600, 400, 1)
+	want := dHash(photo, 1)
+
+	// Stored rotated 90° counter-clockwise, displayed with orientation 6
+	stored := rotateCW(rotateCW(rotateCW(photo)))
+	if got := dHash(stored, 6); HammingDistance(got, want) > 2 {
+		t.Errorf("orientation 6: distance %d, want <= 2", HammingDistance(got, want))
+	}
+	if got := dHash(stored, 1); HammingDistance(got, want) < 12 {
+		t.Errorf("ignoring orientation should not match, distance %d", HammingDistance(got, want))
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	if d := HammingDistance(0xff00, 0x0f0f); d != 8 {
+		t.Errorf("HammingDistance = %d, want 8", d)
+	}
+}
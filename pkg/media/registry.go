@@ -0,0 +1,216 @@
+package media
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metadata extractors a file format can be read with.
+const (
+	// ExtractorExif decodes the image dimensions and the Exif metadata.
+	ExtractorExif = "exif"
+	// ExtractorMP4 parses ISO base media / QuickTime containers natively,
+	// falling back to audio tags and ffprobe.
+	ExtractorMP4 = "mp4"
+	// ExtractorTags reads ID3, FLAC and Ogg tags, falling back to ffprobe.
+	ExtractorTags = "tags"
+	// ExtractorFFprobe asks ffprobe for the container's creation time.
+	ExtractorFFprobe = "ffprobe"
+	// ExtractorNone reads nothing from the file; the date comes from a
+	// sidecar, the filename or the modification time.
+	ExtractorNone = "none"
+)
+
+// ValidExtractors lists the extractor names accepted by Registry.Override.
+var ValidExtractors = []string{ExtractorExif, ExtractorMP4, ExtractorTags, ExtractorFFprobe, ExtractorNone}
+
+// TypeIgnore is the media type that removes an extension from the registry
+// in Registry.Override.
+const TypeIgnore = "ignore"
+
+// Format is what the registry knows about a file extension.
+type Format struct {
+	Type      MediaType
+	Extractor string
+}
+
+// defaultExtractors is the extractor of each media type when none is given.
+var defaultExtractors = map[MediaType]string{
+	TypeImage: ExtractorExif,
+	TypeVideo: ExtractorFFprobe,
+	TypeAudio: ExtractorTags,
+}
+
+// defaultFormats are the formats organized out of the box, by extension.
+var defaultFormats = map[string]Format{
+	// Images
+	"jpg": {TypeImage, ExtractorExif}, "jpeg": {TypeImage, ExtractorExif},
+	"png": {TypeImage, ExtractorExif}, "gif": {TypeImage, ExtractorExif},
+	"bmp": {TypeImage, ExtractorExif}, "webp": {TypeImage, ExtractorExif},
+	"tiff": {TypeImage, ExtractorExif}, "tif": {TypeImage, ExtractorExif},
+	"heic": {TypeImage, ExtractorExif}, "heif": {TypeImage, ExtractorExif},
+	"avif": {TypeImage, ExtractorExif}, "jxl": {TypeImage, ExtractorExif},
+	// Camera RAW
+	"nef": {TypeImage, ExtractorExif}, "arw": {TypeImage, ExtractorExif},
+	"cr2": {TypeImage, ExtractorExif}, "cr3": {TypeImage, ExtractorExif},
+	"dng": {TypeImage, ExtractorExif}, "raf": {TypeImage, ExtractorExif},
+	"orf": {TypeImage, ExtractorExif}, "rw2": {TypeImage, ExtractorExif},
+	"pef": {TypeImage, ExtractorExif}, "srw": {TypeImage, ExtractorExif},
+
+	// Videos
+	"mp4": {TypeVideo, ExtractorMP4}, "mov": {TypeVideo, ExtractorMP4},
+	"m4v": {TypeVideo, ExtractorMP4}, "3gp": {TypeVideo, ExtractorMP4},
+	"3g2": {TypeVideo, ExtractorMP4}, "insv": {TypeVideo, ExtractorMP4},
+	"avi": {TypeVideo, ExtractorFFprobe}, "mkv": {TypeVideo, ExtractorFFprobe},
+	"wmv": {TypeVideo, ExtractorFFprobe}, "flv": {TypeVideo, ExtractorFFprobe},
+	"webm": {TypeVideo, ExtractorFFprobe}, "mpeg": {TypeVideo, ExtractorFFprobe},
+	"mpg": {TypeVideo, ExtractorFFprobe}, "asf": {TypeVideo, ExtractorFFprobe},
+	"m2v": {TypeVideo, ExtractorFFprobe}, "vob": {TypeVideo, ExtractorFFprobe},
+	"m2t": {TypeVideo, ExtractorFFprobe}, "mts": {TypeVideo, ExtractorFFprobe},
+	"braw": {TypeVideo, ExtractorFFprobe},
+
+	// Audio
+	"mp3": {TypeAudio, ExtractorTags}, "flac": {TypeAudio, ExtractorTags},
+	"ogg": {TypeAudio, ExtractorTags}, "opus": {TypeAudio, ExtractorTags},
+	"wav": {TypeAudio, ExtractorTags}, "aac": {TypeAudio, ExtractorTags},
+	"wma": {TypeAudio, ExtractorTags}, "amr": {TypeAudio, ExtractorTags},
+	"aiff": {TypeAudio, ExtractorTags}, "aif": {TypeAudio, ExtractorTags},
+	"m4a": {TypeAudio, ExtractorMP4},
+}
+
+// Registry maps file extensions to media types and the extractor that reads
+// their metadata.
+type Registry struct {
+	formats map[string]Format
+}
+
+// DefaultRegistry returns a registry of the formats organized out of the box.
+func DefaultRegistry() *Registry {
+	r := &Registry{formats: make(map[string]Format, len(defaultFormats))}
+	for ext, f := range defaultFormats {
+		r.formats[ext] = f
+	}
+	return r
+}
+
+// Lookup returns the format of an extension, given with or without the dot
+// and in any case.
+func (r *Registry) Lookup(ext string) (Format, bool) {
+	f, ok := r.formats[normalizeExtension(ext)]
+	return f, ok
+}
+
+// Override adds or replaces the format of an extension. mediaType is image,
+// video, audio, or ignore to remove the extension; an empty extractor picks
+// the default one of the media type.
+func (r *Registry) Override(ext, mediaType, extractor string) error {
+	ext = normalizeExtension(ext)
+	if ext == "" {
+		return fmt.Errorf("empty extension")
+	}
+	if sidecarExtensions[ext] {
+		return fmt.Errorf(".%s is a sidecar extension", ext)
+	}
+	if mediaType == TypeIgnore {
+		if extractor != "" {
+			return fmt.Errorf(".%s: an ignored extension takes no extractor", ext)
+		}
+		delete(r.formats, ext)
+		return nil
+	}
+
+	t := MediaType(mediaType)
+	if _, ok := defaultExtractors[t]; !ok {
+		return fmt.Errorf(".%s: unknown media type %q (valid: image, video, audio, ignore)", ext, mediaType)
+	}
+	if extractor == "" {
+		extractor = defaultExtractors[t]
+	}
+	if !isValidExtractor(extractor) {
+		return fmt.Errorf(".%s: unknown extractor %q (valid: %s)", ext, extractor, strings.Join(ValidExtractors, ", "))
+	}
+	r.formats[ext] = Format{Type: t, Extractor: extractor}
+	return nil
+}
+
+// Extensions returns the extensions of a media type, sorted.
+func (r *Registry) Extensions(t MediaType) []string {
+	var exts []string
+	for ext, f := range r.formats {
+		if f.Type == t {
+			exts = append(exts, ext)
+		}
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+func isValidExtractor(name string) bool {
+	for _, e := range ValidExtractors {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeExtension(ext string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = DefaultRegistry()
+)
+
+// SetRegistry makes r the registry used to recognize media files.
+func SetRegistry(r *Registry) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = r
+}
+
+func currentRegistry() *Registry {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return registry
+}
+
+// DetermineMediaType returns the media type of a file by its extension alone.
+func DetermineMediaType(filePath string) MediaType {
+	if f, ok := currentRegistry().Lookup(filepath.Ext(filePath)); ok {
+		return f.Type
+	}
+	return TypeUnknown
+}
+
+// DetectFormat returns the format of the file at filePath by its extension
+// or, when the extension is missing or unknown, by its content.
+func DetectFormat(filePath string) (Format, bool) {
+	reg := currentRegistry()
+	ext := filepath.Ext(filePath)
+	if f, ok := reg.Lookup(ext); ok {
+		return f, true
+	}
+	// Sidecars are never media, whatever they contain
+	if sidecarExtensions[normalizeExtension(ext)] {
+		return Format{}, false
+	}
+	sniffed, err := SniffFormat(filePath)
+	if err != nil || sniffed == "" {
+		return Format{}, false
+	}
+	return reg.Lookup(sniffed)
+}
+
+// DetectMediaType returns the media type of the file at filePath like
+// DetectFormat, or TypeUnknown.
+func DetectMediaType(filePath string) MediaType {
+	if f, ok := DetectFormat(filePath); ok {
+		return f.Type
+	}
+	return TypeUnknown
+}
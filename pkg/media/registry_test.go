@@ -0,0 +1,232 @@
+package media
+
+import (
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegistryOverride(t *testing.T) {
+	r := DefaultRegistry()
+
+	if err := r.Override(".NRW", "image", ""); err != nil {
+		t.Fatalf("Override(.NRW) error: %v", err)
+	}
+	if f, ok := r.Lookup("nrw"); !ok || f.Type != TypeImage || f.Extractor != ExtractorExif {
+		t.Errorf("Lookup(nrw) = %+v, %v; want image with the exif extractor", f, ok)
+	}
+
+	if err := r.Override("mts", "video", ExtractorNone); err != nil {
+		t.Fatalf("Override(mts) error: %v", err)
+	}
+	if f, _ := r.Lookup(".MTS"); f.Extractor != ExtractorNone {
+		t.Errorf("Lookup(.MTS) extractor = %q, want %q", f.Extractor, ExtractorNone)
+	}
+
+	if err := r.Override("wav", TypeIgnore, ""); err != nil {
+		t.Fatalf("Override(wav) error: %v", err)
+	}
+	if _, ok := r.Lookup("wav"); ok {
+		t.Error("Lookup(wav) found an ignored extension")
+	}
+
+	for _, tt := range []struct{ ext, mediaType, extractor string }{
+		{"", "image", ""},
+		{"xmp", "image", ""},
+		{"doc", "document", ""},
+		{"raw", "image", "dcraw"},
+		{"tmp", TypeIgnore, ExtractorExif},
+	} {
+		if err := r.Override(tt.ext, tt.mediaType, tt.extractor); err == nil {
+			t.Errorf("Override(%q, %q, %q) succeeded, want an error", tt.ext, tt.mediaType, tt.extractor)
+		}
+	}
+
+	if _, ok := DefaultRegistry().Lookup("nrw"); ok {
+		t.Error("Override changed the default registry")
+	}
+}
+
+// mp3Frame is the header of an MPEG-1 layer III frame.
+const mp3Frame = "\xff\xfb\x90\x64"
+
+func TestSniffFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"JPEG", "\xff\xd8\xff\xe1\x00\x10Exif", "jpg"},
+		{"PNG", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "png"},
+		{"GIF", "GIF89a\x01\x00", "gif"},
+		{"WebP", "RIFF\x00\x00\x00\x00WEBPVP8 ", "webp"},
+		{"WAV", "RIFF\x00\x00\x00\x00WAVEfmt ", "wav"},
+		{"AIFF", "FORM\x00\x00\x00\x00AIFFCOMM", "aiff"},
+		{"HEIC", "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00", "heic"},
+		{"AVIF", "\x00\x00\x00\x1cftypavif\x00\x00\x00\x00", "avif"},
+		{"CR3", "\x00\x00\x00\x18ftypcrx \x00\x00\x00\x01", "cr3"},
+		{"MOV", "\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00", "mov"},
+		{"MP4", "\x00\x00\x00\x20ftypisom\x00\x00\x02\x00", "mp4"},
+		{"M4A", "\x00\x00\x00\x20ftypM4A \x00\x00\x00\x00", "m4a"},
+		{"3GP", "\x00\x00\x00\x14ftyp3gp5\x00\x00\x00\x00", "3gp"},
+		{"JPEG XL", "\x00\x00\x00\x0cJXL \r\n\x87\n\x00\x00\x00\x14ftypjxl ", "jxl"},
+		{"JPEG XL codestream", "\xff\x0a\xfa\x1f", ""},
+		{"CR2", "II*\x00\x10\x00\x00\x00CR\x02\x00", "cr2"},
+		{"ORF", "IIRO\x08\x00\x00\x00", "orf"},
+		{"RW2", "IIU\x00\x18\x00\x00\x00", "rw2"},
+		{"TIFF", "MM\x00*\x00\x00\x00\x08", "tiff"},
+		{"Matroska", "\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x88matroska", "mkv"},
+		{"WebM", "\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm", "webm"},
+		{"MP3 with ID3", "ID3\x04\x00\x00\x00\x00\x00\x00", "mp3"},
+		{"ID3 of no version", "ID3\x09\x00\x00\x00\x00\x00\x00", ""},
+		// 128 kbit/s at 44.1 kHz: 417 bytes a frame
+		{"MP3 frames", mp3Frame + strings.Repeat("\x00", 413) + mp3Frame, "mp3"},
+		{"MP3 frame sync only", mp3Frame + strings.Repeat("\x00", 600), ""},
+		{"MP3 frames of different rates", mp3Frame + strings.Repeat("\x00", 413) + "\xff\xfb\x94\x64", ""},
+		{"FLAC", "fLaC\x00\x00\x00\x22", "flac"},
+		{"Opus", "OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x13OpusHead", "opus"},
+		{"Ogg", "OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1e\x01vorbis", "ogg"},
+		{"Text", "hello, world\n", ""},
+		{"Empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffFormat([]byte(tt.header)); got != tt.want {
+				t.Errorf("sniffFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSniffRandomData checks that data which merely starts like a media file
+// is not taken for one.
+func TestSniffRandomData(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	prefixes := []string{"", "\xff\x0a", "\xff\xfb", "\xff\xf3", "\xff\xe2", mp3Frame, "ID3"}
+	for _, prefix := range prefixes {
+		for i := 0; i < 2000; i++ {
+			b := make([]byte, sniffLen)
+			for j := range b {
+				b[j] = byte(rng.Uint32())
+			}
+			copy(b, prefix)
+			if got := sniffFormat(b); got != "" {
+				t.Errorf("random data after %q sniffed as %q: % x", prefix, got, b[:16])
+				break
+			}
+		}
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"data.bin", "data", "data.jxl.part"} {
+		path := filepath.Join(dir, name)
+		b := make([]byte, 4096)
+		for j := range b {
+			b[j] = byte(rng.Uint32())
+		}
+		copy(b, "\xff\x0a")
+		if err := os.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if f, ok := DetectFormat(path); ok {
+			t.Errorf("DetectFormat(%s) = %+v", name, f)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want MediaType
+	}{
+		{"known extension wins", write("clip.mov", "\xff\xd8\xff\xe0"), TypeVideo},
+		{"no extension", write("IMG_0001", "\xff\xd8\xff\xe0"), TypeImage},
+		{"unknown extension", write("IMG_0002.bak", "\x00\x00\x00\x18ftypqt  "), TypeVideo},
+		{"sidecar never sniffed", write("IMG_0003.xmp", "\xff\xd8\xff\xe0"), TypeUnknown},
+		{"unrecognized content", write("notes", "hello"), TypeUnknown},
+		{"empty file", write("empty", ""), TypeUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectMediaType(tt.path); got != tt.want {
+				t.Errorf("DetectMediaType(%s) = %v, want %v", filepath.Base(tt.path), got, tt.want)
+			}
+		})
+	}
+
+	if got := DetermineMediaType(filepath.Join(dir, "IMG_0001")); got != TypeUnknown {
+		t.Errorf("DetermineMediaType() = %v, want it to go by the extension only", got)
+	}
+}
+
+func TestIdentifyMislabeled(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		wantType    MediaType
+		wantContent string
+	}{
+		{"PNG named .jpg", write("a.jpg", "\x89PNG\r\n\x1a\n"), TypeImage, "png"},
+		{"HEIC named .JPG", write("b.JPG", "\x00\x00\x00\x18ftypheic"), TypeImage, "heic"},
+		{"MP4 named .mov", write("c.mov", "\x00\x00\x00\x18ftypisom"), TypeVideo, "mp4"},
+		{"JPEG named .mov", write("d.mov", "\xff\xd8\xff\xe0"), TypeImage, "jpg"},
+		{"matching content", write("e.jpeg", "\xff\xd8\xff\xe0"), TypeImage, ""},
+		{"RAW sniffed as TIFF", write("f.nef", "MM\x00*\x00\x00\x00\x08"), TypeImage, ""},
+		{"M4V brand in .mp4", write("g.mp4", "\x00\x00\x00\x18ftypM4V "), TypeVideo, ""},
+		{"unrecognized content trusted", write("h.jpg", "hello"), TypeImage, ""},
+		{"unchecked extension trusted", write("i.mts", "\xff\xd8\xff\xe0"), TypeVideo, ""},
+		{"no extension", write("IMG_0001", "\xff\xd8\xff\xe0"), TypeImage, "jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, content, ok := identify(tt.path)
+			if !ok || format.Type != tt.wantType || content != tt.wantContent {
+				t.Errorf("identify(%s) = %v, %q, %v; want %v, %q", filepath.Base(tt.path), format.Type, content, ok, tt.wantType, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestExtractMislabeled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(path, []byte("\x00\x00\x00\x18ftypisom"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mf, err := ExtractFileMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mf.Type != TypeVideo || mf.ContentFormat != "mp4" {
+		t.Errorf("Type, ContentFormat = %v, %q; want video, mp4", mf.Type, mf.ContentFormat)
+	}
+	if got := mf.GetExtension(); got != "jpg" {
+		t.Errorf("GetExtension() = %q, want the source's until corrected", got)
+	}
+	mf.Extension = mf.ContentFormat
+	if got := mf.GetNewFilename("", "", true); filepath.Ext(got) != ".mp4" {
+		t.Errorf("GetNewFilename() = %q, want the corrected extension", got)
+	}
+}
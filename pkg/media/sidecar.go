@@ -0,0 +1,303 @@
+package media
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata sidecars: Google Takeout JSON files (photo.jpg.json) and XMP files
+// written by Lightroom, darktable and friends (photo.xmp or photo.jpg.xmp).
+
+// maxSidecarSize bounds how much of a sidecar is read; real ones are a few KiB.
+const maxSidecarSize = 4 << 20
+
+// sidecarMetadata holds the values read from a metadata sidecar.
+type sidecarMetadata struct {
+	Path        string
+	DateTaken   time.Time
+	HasGPS      bool
+	Latitude    float64
+	Longitude   float64
+	Description string
+}
+
+// readMetadataSidecar looks for a Takeout JSON or XMP sidecar next to
+// mediaPath. Takeout JSON is checked first. Returns nil if none is found or
+// none can be parsed.
+func readMetadataSidecar(mediaPath string) *sidecarMetadata {
+	for _, candidate := range takeoutCandidates(mediaPath) {
+		if meta, err := parseTakeoutFile(candidate); err == nil {
+			return meta
+		}
+	}
+	for _, candidate := range xmpCandidates(mediaPath) {
+		if meta, err := parseXMPFile(candidate); err == nil {
+			return meta
+		}
+	}
+	return nil
+}
+
+// takeoutCandidates lists the names Google Takeout uses for the JSON of
+// mediaPath: "IMG_1234.jpg.json", the newer "IMG_1234.jpg.supplemental-metadata.json",
+// "IMG_1234.json", and for numbered copies "IMG_1234(1).jpg" -> "IMG_1234.jpg(1).json".
+// Edited copies ("IMG_1234-edited.jpg") share the original's JSON.
+func takeoutCandidates(mediaPath string) []string {
+	dir, name := filepath.Split(mediaPath)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	var candidates []string
+	add := func(base string) {
+		candidates = append(candidates,
+			base+ext+".json",
+			base+ext+".supplemental-metadata.json",
+			base+".json",
+		)
+		// Numbered copy: "IMG_1234(1)" -> "IMG_1234.jpg(1).json"
+		if i := strings.LastIndex(base, "("); i > 0 && strings.HasSuffix(base, ")") {
+			candidates = append(candidates, base[:i]+ext+base[i:]+".json")
+		}
+	}
+	add(base)
+	if edited := strings.TrimSuffix(base, "-edited"); edited != base {
+		add(edited)
+	}
+
+	for i := range candidates {
+		candidates[i] = filepath.Join(dir, candidates[i])
+	}
+	return candidates
+}
+
+// xmpCandidates lists "IMG_1234.xmp" and "IMG_1234.jpg.xmp" in both cases.
+func xmpCandidates(mediaPath string) []string {
+	base := strings.TrimSuffix(mediaPath, filepath.Ext(mediaPath))
+	return []string{base + ".xmp", base + ".XMP", mediaPath + ".xmp", mediaPath + ".XMP"}
+}
+
+func readSidecarFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, maxSidecarSize))
+}
+
+// --- Google Takeout JSON ---
+
+type takeoutGeo struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type takeoutJSON struct {
+	Description    string `json:"description"`
+	PhotoTakenTime *struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"photoTakenTime"`
+	GeoData     *takeoutGeo `json:"geoData"`
+	GeoDataExif *takeoutGeo `json:"geoDataExif"`
+}
+
+func parseTakeoutFile(path string) (*sidecarMetadata, error) {
+	data, err := readSidecarFile(path)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := parseTakeoutJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	meta.Path = path
+	return meta, nil
+}
+
+func parseTakeoutJSON(data []byte) (*sidecarMetadata, error) {
+	var doc takeoutJSON
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.PhotoTakenTime == nil && doc.GeoData == nil && doc.GeoDataExif == nil {
+		return nil, errors.New("not a Google Takeout metadata file")
+	}
+
+	meta := &sidecarMetadata{Description: strings.TrimSpace(doc.Description)}
+	if doc.PhotoTakenTime != nil {
+		// Unix seconds (UTC), as a string
+		if secs, err := strconv.ParseInt(doc.PhotoTakenTime.Timestamp, 10, 64); err == nil && secs > 0 {
+			meta.DateTaken = time.Unix(secs, 0)
+		}
+	}
+	// geoData is the user-edited location; 0,0 means "unset"
+	for _, geo := range []*takeoutGeo{doc.GeoData, doc.GeoDataExif} {
+		if geo != nil && (geo.Latitude != 0 || geo.Longitude != 0) {
+			meta.HasGPS, meta.Latitude, meta.Longitude = true, geo.Latitude, geo.Longitude
+			break
+		}
+	}
+	return meta, nil
+}
+
+// --- XMP ---
+
+const (
+	nsExif      = "http://ns.adobe.com/exif/1.0/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	nsXMP       = "http://ns.adobe.com/xap/1.0/"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+)
+
+func parseXMPFile(path string) (*sidecarMetadata, error) {
+	data, err := readSidecarFile(path)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := parseXMP(data)
+	if err != nil {
+		return nil, err
+	}
+	meta.Path = path
+	return meta, nil
+}
+
+// parseXMP reads the capture date, GPS position and description from an XMP
+// packet. Properties may be written as attributes of rdf:Description or as
+// child elements; both forms are accepted.
+func parseXMP(data []byte) (*sidecarMetadata, error) {
+	values := make(map[xml.Name]string)
+	var stack []xml.Name
+	var text strings.Builder
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	sawRDF := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "RDF" {
+				sawRDF = true
+			}
+			for _, attr := range t.Attr {
+				if _, ok := values[attr.Name]; !ok {
+					values[attr.Name] = strings.TrimSpace(attr.Value)
+				}
+			}
+			stack = append(stack, t.Name)
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			value := strings.TrimSpace(text.String())
+			text.Reset()
+			if value == "" {
+				continue
+			}
+			// The first rdf:li of an Alt/Seq/Bag belongs to the property above it
+			name := t.Name
+			if name.Local == "li" && len(stack) >= 2 {
+				name = stack[len(stack)-2]
+			}
+			if _, ok := values[name]; !ok {
+				values[name] = value
+			}
+		}
+	}
+	if !sawRDF {
+		return nil, errors.New("not an XMP packet")
+	}
+
+	meta := &sidecarMetadata{Description: values[xml.Name{Space: nsDC, Local: "description"}]}
+	for _, name := range []xml.Name{
+		{Space: nsExif, Local: "DateTimeOriginal"},
+		{Space: nsPhotoshop, Local: "DateCreated"},
+		{Space: nsXMP, Local: "CreateDate"},
+	} {
+		if t, err := parseXMPDate(values[name]); err == nil {
+			meta.DateTaken = t
+			break
+		}
+	}
+
+	lat, latErr := parseXMPCoordinate(values[xml.Name{Space: nsExif, Local: "GPSLatitude"}])
+	lon, lonErr := parseXMPCoordinate(values[xml.Name{Space: nsExif, Local: "GPSLongitude"}])
+	if latErr == nil && lonErr == nil {
+		meta.HasGPS, meta.Latitude, meta.Longitude = true, lat, lon
+	}
+	return meta, nil
+}
+
+// parseXMPDate parses XMP dates. Without a timezone the time is local.
+func parseXMPDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, errors.New("empty date")
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04Z07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02T15:04", "2006-01-02", "2006:01:02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("unrecognized XMP date " + strconv.Quote(s))
+}
+
+// parseXMPCoordinate parses the XMP GPS format "DDD,MM.mmmk" or "DDD,MM,SSk"
+// where k is N, S, E or W. Plain decimal degrees are accepted too.
+func parseXMPCoordinate(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty coordinate")
+	}
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v, nil
+	}
+
+	sign := 1.0
+	switch s[len(s)-1] {
+	case 'S', 's', 'W', 'w':
+		sign = -1
+		s = s[:len(s)-1]
+	case 'N', 'n', 'E', 'e':
+		s = s[:len(s)-1]
+	default:
+		return 0, errors.New("coordinate without direction " + strconv.Quote(s))
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, errors.New("malformed coordinate " + strconv.Quote(s))
+	}
+	value := 0.0
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return 0, err
+		}
+		value += v / []float64{1, 60, 3600}[i]
+	}
+	return sign * value, nil
+}
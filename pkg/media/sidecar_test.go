@@ -0,0 +1,166 @@
+package media
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const takeoutSample = `{
+  "title": "IMG_1234.jpg",
+  "description": "Sunset at the lake",
+  "photoTakenTime": {"timestamp": "1563186896", "formatted": "15 Jul 2019, 10:34:56 UTC"},
+  "geoData": {"latitude": 0.0, "longitude": 0.0, "altitude": 0.0},
+  "geoDataExif": {"latitude": 47.6062, "longitude": -122.3321, "altitude": 10.0}
+}`
+
+const xmpAttributeSample = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmp:CreateDate="2018-01-01T00:00:00"
+    exif:DateTimeOriginal="2019-07-15T12:34:56.50+02:00"
+    exif:GPSLatitude="47,36.372N"
+    exif:GPSLongitude="122,19.926W">
+   <dc:description>
+    <rdf:Alt>
+     <rdf:li xml:lang="x-default">Lake trip</rdf:li>
+    </rdf:Alt>
+   </dc:description>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+const xmpElementSample = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/">
+   <photoshop:DateCreated>2020-02-29T08:00:00</photoshop:DateCreated>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+func approx(a, b float64) bool { return math.Abs(a-b) < 1e-4 }
+
+func TestParseTakeoutJSON(t *testing.T) {
+	meta, err := parseTakeoutJSON([]byte(takeoutSample))
+	if err != nil {
+		t.Fatalf("parseTakeoutJSON: %v", err)
+	}
+	if !meta.DateTaken.Equal(time.Date(2019, 7, 15, 10, 34, 56, 0, time.UTC)) {
+		t.Errorf("DateTaken = %v", meta.DateTaken)
+	}
+	// geoData is 0,0, so geoDataExif is used
+	if !meta.HasGPS || !approx(meta.Latitude, 47.6062) || !approx(meta.Longitude, -122.3321) {
+		t.Errorf("GPS = %v %f,%f", meta.HasGPS, meta.Latitude, meta.Longitude)
+	}
+	if meta.Description != "Sunset at the lake" {
+		t.Errorf("Description = %q", meta.Description)
+	}
+
+	if _, err := parseTakeoutJSON([]byte(`{"foo": 1}`)); err == nil {
+		t.Error("expected error for unrelated JSON")
+	}
+}
+
+func TestParseXMP_Attributes(t *testing.T) {
+	meta, err := parseXMP([]byte(xmpAttributeSample))
+	if err != nil {
+		t.Fatalf("parseXMP: %v", err)
+	}
+	want := time.Date(2019, 7, 15, 10, 34, 56, 500000000, time.UTC)
+	if !meta.DateTaken.Equal(want) {
+		t.Errorf("DateTaken = %v, want DateTimeOriginal %v", meta.DateTaken, want)
+	}
+	if !meta.HasGPS || !approx(meta.Latitude, 47.6062) || !approx(meta.Longitude, -122.3321) {
+		t.Errorf("GPS = %v %f,%f", meta.HasGPS, meta.Latitude, meta.Longitude)
+	}
+	if meta.Description != "Lake trip" {
+		t.Errorf("Description = %q", meta.Description)
+	}
+}
+
+func TestParseXMP_Elements(t *testing.T) {
+	meta, err := parseXMP([]byte(xmpElementSample))
+	if err != nil {
+		t.Fatalf("parseXMP: %v", err)
+	}
+	want := time.Date(2020, 2, 29, 8, 0, 0, 0, time.Local)
+	if !meta.DateTaken.Equal(want) {
+		t.Errorf("DateTaken = %v, want %v", meta.DateTaken, want)
+	}
+	if meta.HasGPS {
+		t.Error("expected no GPS")
+	}
+
+	if _, err := parseXMP([]byte(`<html><body/></html>`)); err == nil {
+		t.Error("expected error for non-XMP XML")
+	}
+}
+
+func TestParseXMPCoordinate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"47,36.372N", 47.6062},
+		{"122,19,55.56W", -122.3321},
+		{"33,52.0S", -33.866667},
+		{"-12.5", -12.5},
+	}
+	for _, tt := range tests {
+		got, err := parseXMPCoordinate(tt.in)
+		if err != nil || !approx(got, tt.want) {
+			t.Errorf("parseXMPCoordinate(%q) = %f, %v; want %f", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "47,36", "abc N"} {
+		if _, err := parseXMPCoordinate(bad); err == nil {
+			t.Errorf("parseXMPCoordinate(%q): expected error", bad)
+		}
+	}
+}
+
+func TestReadMetadataSidecar_TakeoutNames(t *testing.T) {
+	tests := []struct {
+		media   string
+		sidecar string
+	}{
+		{"IMG_1234.jpg", "IMG_1234.jpg.json"},
+		{"IMG_1234.jpg", "IMG_1234.jpg.supplemental-metadata.json"},
+		{"IMG_1234.jpg", "IMG_1234.json"},
+		{"IMG_1234(1).jpg", "IMG_1234.jpg(1).json"},
+		{"IMG_1234-edited.jpg", "IMG_1234.jpg.json"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		mediaPath := filepath.Join(dir, tt.media)
+		if err := os.WriteFile(filepath.Join(dir, tt.sidecar), []byte(takeoutSample), 0644); err != nil {
+			t.Fatal(err)
+		}
+		meta := readMetadataSidecar(mediaPath)
+		if meta == nil || meta.Path != filepath.Join(dir, tt.sidecar) {
+			t.Errorf("%s: expected sidecar %s, got %+v", tt.media, tt.sidecar, meta)
+		}
+	}
+}
+
+func TestReadMetadataSidecar_XMP(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "DSC_0001.xmp"), []byte(xmpElementSample), 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta := readMetadataSidecar(filepath.Join(dir, "DSC_0001.NEF"))
+	if meta == nil || meta.DateTaken.IsZero() {
+		t.Fatalf("expected XMP sidecar, got %+v", meta)
+	}
+
+	if meta := readMetadataSidecar(filepath.Join(dir, "other.jpg")); meta != nil {
+		t.Errorf("expected no sidecar, got %+v", meta)
+	}
+}
@@ -0,0 +1,145 @@
+package media
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Sidecar files are companions of a media file that should travel with it:
+// XMP edits, THM thumbnails, Apple AAE adjustments, SRT drone telemetry and
+// Google Takeout JSON.
+
+// sidecarExtensions lists the (lowercase) extensions of sidecar files.
+var sidecarExtensions = map[string]bool{
+	"xmp":  true,
+	"thm":  true,
+	"aae":  true,
+	"srt":  true,
+	"json": true,
+}
+
+// rawExtensions lists camera RAW image formats.
+var rawExtensions = map[string]bool{
+	"nef": true, "arw": true, "cr2": true, "cr3": true, "dng": true, "raf": true,
+	"orf": true, "rw2": true, "pef": true, "srw": true,
+}
+
+// IsSidecarFile reports whether path has a sidecar extension.
+func IsSidecarFile(path string) bool {
+	return sidecarExtensions[strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")]
+}
+
+// takeoutNumberedRe matches Takeout JSON names for numbered copies, whose
+// counter follows the extension: "IMG_1234.jpg(1)" belongs to "IMG_1234(1).jpg".
+var takeoutNumberedRe = regexp.MustCompile(`^(.+)(\.[^.()]+)(\(\d+\))$`)
+
+// MatchSidecars assigns the sidecar files among names (the entries of one
+// directory) to the media files they belong to. It returns a map from media
+// file name to its sidecar names. A sidecar named after the full media file
+// name ("IMG_1234.jpg.xmp", "IMG_1234.jpg.json") belongs to that file. A
+// sidecar sharing only the stem ("IMG_1234.xmp") goes to the most likely
+// owner when several media files share it: videos for THM and SRT, RAW over
+// other images for everything else.
+func MatchSidecars(names []string) map[string][]string {
+	primaries := make(map[string]bool)
+	byStem := make(map[string][]string)
+	var sidecars []string
+	for _, name := range names {
+		switch {
+		case DetermineMediaType(name) != TypeUnknown:
+			primaries[name] = true
+			stem := strings.TrimSuffix(name, filepath.Ext(name))
+			byStem[stem] = append(byStem[stem], name)
+		case IsSidecarFile(name):
+			sidecars = append(sidecars, name)
+		}
+	}
+
+	matches := make(map[string][]string)
+	for _, sidecar := range sidecars {
+		ext := filepath.Ext(sidecar)
+		inner := strings.TrimSuffix(sidecar, ext)
+		sidecarExt := strings.ToLower(strings.TrimPrefix(ext, "."))
+
+		// Full media file name, possibly followed by more suffixes such as
+		// Takeout's (sometimes truncated) ".supplemental-metadata"
+		owner := ""
+		for name := inner; name != ""; {
+			if primaries[name] {
+				owner = name
+				break
+			}
+			if filepath.Ext(name) == "" {
+				break
+			}
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+		}
+		if owner == "" {
+			if m := takeoutNumberedRe.FindStringSubmatch(inner); m != nil && primaries[m[1]+m[3]+m[2]] {
+				owner = m[1] + m[3] + m[2]
+			} else if candidates := byStem[inner]; len(candidates) > 0 {
+				owner = preferredSidecarOwner(candidates, sidecarExt)
+			}
+		}
+		if owner != "" {
+			matches[owner] = append(matches[owner], sidecar)
+		}
+	}
+	return matches
+}
+
+func preferredSidecarOwner(candidates []string, sidecarExt string) string {
+	rank := func(name string) int {
+		ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+		mediaType := DetermineMediaType(name)
+		if sidecarExt == "thm" || sidecarExt == "srt" {
+			if mediaType == TypeVideo {
+				return 0
+			}
+			return 1
+		}
+		switch {
+		case mediaType == TypeImage && rawExtensions[ext]:
+			return 0
+		case mediaType == TypeImage:
+			return 1
+		case mediaType == TypeVideo:
+			return 2
+		default:
+			return 3
+		}
+	}
+	sorted := append([]string(nil), candidates...)
+	sort.Slice(sorted, func(i, k int) bool {
+		if ri, rk := rank(sorted[i]), rank(sorted[k]); ri != rk {
+			return ri < rk
+		}
+		return sorted[i] < sorted[k]
+	})
+	return sorted[0]
+}
+
+// SidecarDestPath returns where a sidecar goes when its media file moves from
+// primarySource to primaryDest. The sidecar takes the media file's new name
+// and keeps its own suffix: "IMG_1234.xmp" -> "<new stem>.xmp",
+// "IMG_1234.jpg.json" -> "<new name>.json".
+func SidecarDestPath(primarySource, primaryDest, sidecarSource string) string {
+	primaryName := filepath.Base(primarySource)
+	primaryStem := strings.TrimSuffix(primaryName, filepath.Ext(primaryName))
+	sidecarName := filepath.Base(sidecarSource)
+	newName := filepath.Base(primaryDest)
+	newStem := strings.TrimSuffix(newName, filepath.Ext(newName))
+
+	var name string
+	switch {
+	case strings.HasPrefix(sidecarName, primaryName):
+		name = newName + strings.ToLower(sidecarName[len(primaryName):])
+	case strings.HasPrefix(sidecarName, primaryStem+"."):
+		name = newStem + strings.ToLower(sidecarName[len(primaryStem):])
+	default:
+		name = newStem + strings.ToLower(filepath.Ext(sidecarName))
+	}
+	return filepath.Join(filepath.Dir(primaryDest), name)
+}
@@ -0,0 +1,60 @@
+package media
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMatchSidecars(t *testing.T) {
+	names := []string{
+		"IMG_1234.CR2", "IMG_1234.JPG", "IMG_1234.xmp", // XMP goes to the RAW
+		"IMG_2000.HEIC", "IMG_2000.MOV", "IMG_2000.AAE", // AAE goes to the photo
+		"MVI_0001.MOV", "MVI_0001.THM",
+		"DJI_0001.MP4", "DJI_0001.SRT",
+		"photo.jpg", "photo.jpg.json", "photo.jpg.xmp",
+		"long.jpg", "long.jpg.supplemental-metad.json",
+		"copy(1).jpg", "copy.jpg(1).json",
+		"orphan.xmp", "notes.txt", "data.json",
+	}
+	want := map[string][]string{
+		"IMG_1234.CR2":  {"IMG_1234.xmp"},
+		"IMG_2000.HEIC": {"IMG_2000.AAE"},
+		"MVI_0001.MOV":  {"MVI_0001.THM"},
+		"DJI_0001.MP4":  {"DJI_0001.SRT"},
+		"photo.jpg":     {"photo.jpg.json", "photo.jpg.xmp"},
+		"long.jpg":      {"long.jpg.supplemental-metad.json"},
+		"copy(1).jpg":   {"copy.jpg(1).json"},
+	}
+
+	got := MatchSidecars(names)
+	for _, v := range got {
+		sort.Strings(v)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchSidecars() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestSidecarDestPath(t *testing.T) {
+	dest := filepath.FromSlash("/out/cr2/2019/2019-07/2019-07-15/20190715-123456_6000 (IMG_1234)_001.cr2")
+	tests := []struct {
+		primary string
+		sidecar string
+		want    string
+	}{
+		{"/in/IMG_1234.CR2", "/in/IMG_1234.xmp", "20190715-123456_6000 (IMG_1234)_001.xmp"},
+		{"/in/IMG_1234.CR2", "/in/IMG_1234.XMP", "20190715-123456_6000 (IMG_1234)_001.xmp"},
+		{"/in/IMG_1234.CR2", "/in/IMG_1234.CR2.json", "20190715-123456_6000 (IMG_1234)_001.cr2.json"},
+		{"/in/IMG_1234.CR2", "/in/IMG_1234.CR2.supplemental-metadata.json", "20190715-123456_6000 (IMG_1234)_001.cr2.supplemental-metadata.json"},
+		{"/in/IMG_1234(1).CR2", "/in/IMG_1234.CR2(1).json", "20190715-123456_6000 (IMG_1234)_001.json"},
+	}
+	for _, tt := range tests {
+		got := SidecarDestPath(filepath.FromSlash(tt.primary), dest, filepath.FromSlash(tt.sidecar))
+		want := filepath.Join(filepath.Dir(dest), tt.want)
+		if got != want {
+			t.Errorf("SidecarDestPath(%s, %s) = %q, want %q", tt.primary, tt.sidecar, got, want)
+		}
+	}
+}
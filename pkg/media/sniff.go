@@ -0,0 +1,237 @@
+package media
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// headerLen is how much of a file the signatures are looked for in, and
+// sniffLen how much SniffFormat reads: enough for two MPEG audio frames at
+// the highest bit rate, since a frame sync alone is too short to go by.
+const (
+	headerLen = 64
+	sniffLen  = 2048
+)
+
+// SniffFormat returns the usual extension, without the dot, of the format the
+// content of the file at filePath is in, or "" if it recognizes none.
+func SniffFormat(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return "", nil
+		}
+		return "", err
+	}
+	return sniffFormat(buf[:n]), nil
+}
+
+// sniffFormat recognizes a format by the magic bytes at the start of a file.
+// Files with an unknown extension are organized by what it returns, so a
+// signature short enough for other data to start with is only accepted
+// together with a check of the header it begins.
+func sniffFormat(b []byte) string {
+	frames := b
+	b = b[:min(len(b), headerLen)]
+	has := func(off int, magic string) bool {
+		return len(b) >= off+len(magic) && string(b[off:off+len(magic)]) == magic
+	}
+	switch {
+	case has(0, "\xff\xd8\xff"):
+		return "jpg"
+	case has(0, "\x89PNG\r\n\x1a\n"):
+		return "png"
+	case has(0, "GIF87a"), has(0, "GIF89a"):
+		return "gif"
+	case has(0, "BM") && has(6, "\x00\x00\x00\x00"):
+		return "bmp"
+	case has(0, "RIFF") && has(8, "WEBP"):
+		return "webp"
+	case has(0, "RIFF") && has(8, "AVI "):
+		return "avi"
+	case has(0, "RIFF") && has(8, "WAVE"):
+		return "wav"
+	case has(0, "FORM") && (has(8, "AIFF") || has(8, "AIFC")):
+		return "aiff"
+	case has(0, "\x00\x00\x00\x0cJXL \r\n\x87\n"):
+		// A bare codestream starts with just \xff\x0a and is left to its
+		// extension
+		return "jxl"
+	case has(4, "ftyp"):
+		return sniffISOBrand(b)
+	case has(0, "IIRO"), has(0, "IIRS"), has(0, "MMOR"):
+		return "orf"
+	case has(0, "IIU\x00"):
+		return "rw2"
+	case has(0, "II*\x00") && has(8, "CR"):
+		return "cr2"
+	case has(0, "II*\x00"), has(0, "MM\x00*"):
+		// NEF, ARW, DNG, PEF and SRW are TIFF files too; organize them as such
+		return "tiff"
+	case has(0, "FUJIFILMCCD-RAW"):
+		return "raf"
+	case has(0, "\x1a\x45\xdf\xa3"):
+		if bytes.Contains(b, []byte("webm")) {
+			return "webm"
+		}
+		return "mkv"
+	case has(0, "\x30\x26\xb2\x75\x8e\x66\xcf\x11"):
+		return "asf"
+	case has(0, "FLV\x01"):
+		return "flv"
+	case has(0, "\x00\x00\x01\xba"), has(0, "\x00\x00\x01\xb3"):
+		return "mpg"
+	case has(0, "ID3") && id3Header(b), mp3Frames(frames):
+		return "mp3"
+	case has(0, "fLaC"):
+		return "flac"
+	case has(0, "OggS"):
+		if bytes.Contains(b, []byte("OpusHead")) {
+			return "opus"
+		}
+		return "ogg"
+	case has(0, "#!AMR"):
+		return "amr"
+	}
+	return ""
+}
+
+// id3Header reports whether b starts with an ID3v2 tag header: "ID3", a
+// major version of 2 to 4, revision 0, flags and a syncsafe size.
+func id3Header(b []byte) bool {
+	if len(b) < 10 || b[3] < 2 || b[3] > 4 || b[4] != 0 || b[5]&0x0f != 0 {
+		return false
+	}
+	for _, c := range b[6:10] {
+		if c&0x80 != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Layer III bit rates in kbit/s by bit rate index, for MPEG-1 and for
+// MPEG-2 and 2.5, and sample rates by version and sample rate index.
+var (
+	mp3Bitrates = [2][15]int{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+	mp3SampleRates = [4][3]int{
+		0: {11025, 12000, 8000},  // MPEG-2.5
+		2: {22050, 24000, 16000}, // MPEG-2
+		3: {44100, 48000, 32000}, // MPEG-1
+	}
+)
+
+// mp3FrameLen returns the length of the MPEG audio layer III frame whose
+// header starts b, or 0 if b starts with no valid frame header.
+func mp3FrameLen(b []byte) int {
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe6 != 0xe2 {
+		return 0
+	}
+	version, bitrate, rate := b[1]>>3&3, int(b[2]>>4), int(b[2]>>2&3)
+	if version == 1 || bitrate == 0 || bitrate == 15 || rate == 3 {
+		return 0
+	}
+	padding := int(b[2] >> 1 & 1)
+	if version == 3 {
+		return 144*mp3Bitrates[0][bitrate]*1000/mp3SampleRates[version][rate] + padding
+	}
+	return 72*mp3Bitrates[1][bitrate]*1000/mp3SampleRates[version][rate] + padding
+}
+
+// mp3Frames reports whether b starts with two consecutive MPEG audio layer
+// III frames of the same version and sample rate, as a stream without an ID3
+// tag does.
+func mp3Frames(b []byte) bool {
+	n := mp3FrameLen(b)
+	if n == 0 || mp3FrameLen(b[n:]) == 0 {
+		return false
+	}
+	return b[n+1] == b[1] && b[n+2]&0x0c == b[2]&0x0c
+}
+
+// sniffISOBrand names the format of an ISO base media file by the major brand
+// of its ftyp box.
+func sniffISOBrand(b []byte) string {
+	if len(b) < 12 {
+		return ""
+	}
+	switch brand := string(b[8:12]); brand {
+	case "heic", "heix", "hevc", "hevx", "heim", "heis", "hevm", "hevs", "mif1", "msf1":
+		return "heic"
+	case "avif", "avis":
+		return "avif"
+	case "crx ":
+		return "cr3"
+	case "qt  ":
+		return "mov"
+	case "M4A ", "M4B ":
+		return "m4a"
+	case "M4V ", "M4VH", "M4VP":
+		return "m4v"
+	default:
+		switch brand[:3] {
+		case "3gp":
+			return "3gp"
+		case "3g2":
+			return "3g2"
+		}
+		return "mp4"
+	}
+}
+
+// extensionContent lists, for each extension whose content SniffFormat
+// recognizes, the formats it reports for correctly named files. TIFF-based
+// RAW files sniff as TIFF, and MP4 variants may carry a generic brand.
+var extensionContent = map[string][]string{
+	"jpg": {"jpg"}, "jpeg": {"jpg"}, "png": {"png"}, "gif": {"gif"}, "bmp": {"bmp"},
+	"webp": {"webp"}, "tif": {"tiff"}, "tiff": {"tiff"}, "jxl": {"jxl"},
+	"heic": {"heic"}, "heif": {"heic", "avif"}, "avif": {"avif", "heic"},
+	"nef": {"tiff"}, "arw": {"tiff"}, "dng": {"tiff"}, "pef": {"tiff"}, "srw": {"tiff"},
+	"cr2": {"cr2"}, "cr3": {"cr3"}, "raf": {"raf"}, "orf": {"orf"}, "rw2": {"rw2"},
+	"mp4": {"mp4", "m4v"}, "m4v": {"m4v", "mp4"}, "mov": {"mov"},
+	"3gp": {"3gp", "mp4"}, "3g2": {"3g2", "3gp", "mp4"},
+	"avi": {"avi"}, "mkv": {"mkv", "webm"}, "webm": {"webm", "mkv"}, "flv": {"flv"},
+	"wmv": {"asf"}, "asf": {"asf"}, "mpeg": {"mpg"}, "mpg": {"mpg"}, "m2v": {"mpg"}, "vob": {"mpg"},
+	"mp3": {"mp3"}, "flac": {"flac", "mp3"}, "ogg": {"ogg", "opus"}, "opus": {"opus"},
+	"wav": {"wav"}, "aiff": {"aiff"}, "aif": {"aiff"}, "wma": {"asf"}, "amr": {"amr"},
+	"m4a": {"m4a", "mp4"},
+}
+
+// identify returns the format to read the file at filePath as and, when that
+// is not what its extension says, the format its content is in. Extensions
+// the sniffer can't check are trusted.
+func identify(filePath string) (Format, string, bool) {
+	reg := currentRegistry()
+	ext := normalizeExtension(filepath.Ext(filePath))
+	if sidecarExtensions[ext] {
+		return Format{}, "", false
+	}
+	format, known := reg.Lookup(ext)
+	if known && extensionContent[ext] == nil {
+		return format, "", true
+	}
+
+	sniffed, err := SniffFormat(filePath)
+	if err != nil || sniffed == "" || (known && slices.Contains(extensionContent[ext], sniffed)) {
+		return format, "", known
+	}
+	real, ok := reg.Lookup(sniffed)
+	if !ok {
+		// Content in a format that isn't organized; go by the extension
+		return format, "", known
+	}
+	return real, sniffed, true
+}
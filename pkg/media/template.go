@@ -0,0 +1,230 @@
+package media
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// templateFields maps each template token to a function producing its value.
+// Values never contain path separators; empty values are allowed and are
+// cleaned up by Render.
+var templateFields = map[string]func(m *MediaFile, o templateOptions) string{
+	"type":         func(m *MediaFile, o templateOptions) string { return string(m.Type) },
+	"ext":          func(m *MediaFile, o templateOptions) string { return m.GetExtension() },
+	"yyyy":         func(m *MediaFile, o templateOptions) string { return m.CreationTime.Format("2006") },
+	"yy":           func(m *MediaFile, o templateOptions) string { return m.CreationTime.Format("06") },
+	"mm":           func(m *MediaFile, o templateOptions) string { return m.CreationTime.Format("01") },
+	"dd":           func(m *MediaFile, o templateOptions) string { return m.CreationTime.Format("02") },
+	"hh":           func(m *MediaFile, o templateOptions) string { return m.CreationTime.Format("15") },
+	"min":          func(m *MediaFile, o templateOptions) string { return m.CreationTime.Format("04") },
+	"ss":           func(m *MediaFile, o templateOptions) string { return m.CreationTime.Format("05") },
+	"yyyymmdd":     func(m *MediaFile, o templateOptions) string { return m.CreationTime.Format("20060102") },
+	"hhmmss":       func(m *MediaFile, o templateOptions) string { return m.CreationTime.Format("150405") },
+	"date":         func(m *MediaFile, o templateOptions) string { return m.CreationTime.Format("2006-01-02") },
+	"timestamp":    func(m *MediaFile, o templateOptions) string { return m.CreationTime.Format("20060102-150405") },
+	"dim":          func(m *MediaFile, o templateOptions) string { return formatDimension(m.LargerDimension) },
+	"name":         func(m *MediaFile, o templateOptions) string { return o.name(m) },
+	"camera_make":  func(m *MediaFile, o templateOptions) string { return o.text(m.CameraMake) },
+	"camera_model": func(m *MediaFile, o templateOptions) string { return o.text(m.CameraModel) },
+	"seq":          func(m *MediaFile, o templateOptions) string { return formatSequenceSuffix(o.seq) },
+	"artist":       func(m *MediaFile, o templateOptions) string { return o.text(m.Artist) },
+	"album_artist": func(m *MediaFile, o templateOptions) string { return o.text(m.musicArtist()) },
+	"album":        func(m *MediaFile, o templateOptions) string { return o.text(m.Album) },
+	"title":        func(m *MediaFile, o templateOptions) string { return o.text(m.Title) },
+	"track":        func(m *MediaFile, o templateOptions) string { return formatTrackNumber(m.TrackNumber) },
+}
+
+// TemplateTokens returns the sorted list of tokens accepted in path templates.
+func TemplateTokens() []string {
+	tokens := make([]string, 0, len(templateFields))
+	for token := range templateFields {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+type templatePart struct {
+	literal string
+	token   string // empty for literal parts
+}
+
+// PathTemplate is a parsed destination path format such as
+// "{type}/{yyyy}/{yyyy}-{mm}/{yyyymmdd}-{hhmmss}_{dim}{seq}.{ext}".
+// The rendered path is relative to the destination directory; the last
+// element is the filename.
+type PathTemplate struct {
+	raw   string
+	parts []templatePart
+}
+
+// ParseTemplate parses and validates a path template. Unknown tokens,
+// unbalanced braces, absolute paths and ".." elements are rejected.
+func ParseTemplate(raw string) (*PathTemplate, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, fmt.Errorf("path template is empty")
+	}
+	if filepath.IsAbs(raw) || strings.HasPrefix(raw, "/") {
+		return nil, fmt.Errorf("path template must be relative: %q", raw)
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(raw), "/") {
+		if elem == ".." {
+			return nil, fmt.Errorf("path template must not contain '..': %q", raw)
+		}
+	}
+
+	t := &PathTemplate{raw: raw}
+	rest := raw
+	for rest != "" {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if rest[open] == '}' {
+			return nil, fmt.Errorf("unexpected '}' in path template %q", raw)
+		}
+		if open > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:open]})
+		}
+		end := strings.IndexAny(rest[open+1:], "{}")
+		if end < 0 || rest[open+1+end] != '}' {
+			return nil, fmt.Errorf("unclosed '{' in path template %q", raw)
+		}
+		token := rest[open+1 : open+1+end]
+		if _, ok := templateFields[token]; !ok {
+			return nil, fmt.Errorf("unknown token {%s} in path template (valid: %s)", token, strings.Join(TemplateTokens(), ", "))
+		}
+		t.parts = append(t.parts, templatePart{token: token})
+		rest = rest[open+1+end+1:]
+	}
+
+	if strings.HasSuffix(filepath.ToSlash(raw), "/") {
+		return nil, fmt.Errorf("path template must end with a filename: %q", raw)
+	}
+	return t, nil
+}
+
+// String returns the template as written by the user.
+func (t *PathTemplate) String() string {
+	return t.raw
+}
+
+// HasSequence reports whether the template places the {seq} token itself.
+func (t *PathTemplate) HasSequence() bool {
+	for _, p := range t.parts {
+		if p.token == "seq" {
+			return true
+		}
+	}
+	return false
+}
+
+// Render expands the template for m. seqNum >= 1 renders {seq} as "_001",
+// "_002", ...; 0 renders it empty. When a token renders empty, the separator
+// next to it is dropped too, so "{hhmmss}_{dim}.{ext}" on a file without
+// dimensions yields "103622.jpg" rather than "103622_.jpg". Renders that
+// would leave the destination directory, or name no file at all, are errors.
+func (t *PathTemplate) Render(m *MediaFile, seqNum int, spaceReplacement string, noOriginalName bool) (string, error) {
+	opts := templateOptions{seq: seqNum, spaceReplacement: spaceReplacement, noOriginalName: noOriginalName}
+
+	var out []byte
+	dropLeading := false
+	for _, p := range t.parts {
+		if p.token == "" {
+			lit := p.literal
+			if dropLeading && len(lit) > 0 && isTemplateSeparator(lit[0]) {
+				lit = lit[1:]
+			}
+			dropLeading = false
+			out = append(out, lit...)
+			continue
+		}
+
+		value := sanitizePathValue(templateFields[p.token](m, opts))
+		if value != "" {
+			dropLeading = false
+			out = append(out, value...)
+			continue
+		}
+		if n := len(out); n > 0 && isTemplateSeparator(out[n-1]) {
+			out = out[:n-1]
+		} else if n == 0 || out[n-1] == '/' {
+			dropLeading = true
+		}
+	}
+
+	var elems []string
+	for _, elem := range strings.Split(filepath.ToSlash(string(out)), "/") {
+		// Values next to each other may still spell ".."
+		if elem == ".." {
+			return "", fmt.Errorf("path template %q renders %q for %s, which is outside the destination", t.raw, string(out), m.SourcePath)
+		}
+		if elem != "" && elem != "." {
+			elems = append(elems, elem)
+		}
+	}
+	if len(elems) == 0 {
+		return "", fmt.Errorf("path template %q renders an empty path for %s", t.raw, m.SourcePath)
+	}
+	return filepath.Join(elems...), nil
+}
+
+type templateOptions struct {
+	seq              int
+	spaceReplacement string
+	noOriginalName   bool
+}
+
+func (o templateOptions) name(m *MediaFile) string {
+	if o.noOriginalName {
+		return ""
+	}
+	return m.baseOriginalName(o.spaceReplacement)
+}
+
+func (o templateOptions) text(s string) string {
+	s = strings.TrimSpace(s)
+	if o.spaceReplacement != "" && o.spaceReplacement != " " {
+		s = strings.ReplaceAll(s, " ", o.spaceReplacement)
+	}
+	return s
+}
+
+func formatDimension(dim int) string {
+	if dim <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", dim)
+}
+
+func formatTrackNumber(track int) string {
+	if track <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%02d", track)
+}
+
+func formatSequenceSuffix(seq int) string {
+	if seq < 1 {
+		return ""
+	}
+	return fmt.Sprintf("_%03d", seq)
+}
+
+// sanitizePathValue keeps field values from introducing extra path elements.
+// Values made of dots only, which would name the current or parent directory,
+// are dropped.
+func sanitizePathValue(s string) string {
+	s = strings.NewReplacer("/", "-", "\\", "-", "\x00", "").Replace(s)
+	if strings.Trim(s, ".") == "" {
+		return ""
+	}
+	return s
+}
+
+func isTemplateSeparator(c byte) bool {
+	return c == '_' || c == '-' || c == ' '
+}
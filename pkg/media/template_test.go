@@ -0,0 +1,178 @@
+package media
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  string
+	}{
+		{"full example", "{type}/{yyyy}/{yyyy}-{mm}/{camera_model}/{yyyymmdd}-{hhmmss}_{dim}{seq}.{ext}", ""},
+		{"literal only", "photos/file.jpg", ""},
+		{"empty", "", "empty"},
+		{"unknown token", "{yyyy}/{camera}.{ext}", "unknown token {camera}"},
+		{"unclosed brace", "{yyyy/{name}.{ext}", "unclosed"},
+		{"stray closing brace", "yyyy}/{name}.{ext}", "unexpected '}'"},
+		{"absolute", "/{yyyy}/{name}.{ext}", "relative"},
+		{"parent dir", "{yyyy}/../{name}.{ext}", "'..'"},
+		{"no filename", "{yyyy}/{mm}/", "filename"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTemplate(tt.template)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ParseTemplate(%q) unexpected error: %v", tt.template, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseTemplate(%q) error = %v, want containing %q", tt.template, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPathTemplateRender(t *testing.T) {
+	creationTime := time.Date(2019, 7, 15, 12, 34, 56, 0, time.UTC)
+	image := &MediaFile{
+		SourcePath:      "/source/IMG 1234.JPG",
+		Type:            TypeImage,
+		CreationTime:    creationTime,
+		LargerDimension: 6000,
+		OriginalName:    "IMG 1234.JPG",
+		CameraModel:     "Canon EOS R5",
+	}
+	video := &MediaFile{
+		SourcePath:   "/source/clip.mov",
+		Type:         TypeVideo,
+		CreationTime: creationTime,
+		OriginalName: "clip.mov",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		file     *MediaFile
+		seq      int
+		spaceRep string
+		expected string
+	}{
+		{
+			name:     "full example",
+			template: "{type}/{yyyy}/{yyyy}-{mm}/{camera_model}/{yyyymmdd}-{hhmmss}_{dim}{seq}.{ext}",
+			file:     image,
+			expected: filepath.Join("image", "2019", "2019-07", "Canon EOS R5", "20190715-123456_6000.jpg"),
+		},
+		{
+			name:     "sequence suffix",
+			template: "{yyyy}/{timestamp}_{dim}{seq}.{ext}",
+			file:     image,
+			seq:      2,
+			expected: filepath.Join("2019", "20190715-123456_6000_002.jpg"),
+		},
+		{
+			name:     "empty dimension drops separator",
+			template: "{yyyy}/{timestamp}_{dim}{seq}.{ext}",
+			file:     video,
+			expected: filepath.Join("2019", "20190715-123456.mov"),
+		},
+		{
+			name:     "empty folder element is removed",
+			template: "{yyyy}/{camera_model}/{name}.{ext}",
+			file:     video,
+			expected: filepath.Join("2019", "clip.mov"),
+		},
+		{
+			name:     "empty leading token drops following separator",
+			template: "{yyyy}/{camera_model}_{name}.{ext}",
+			file:     video,
+			expected: filepath.Join("2019", "clip.mov"),
+		},
+		{
+			name:     "space replacement applies to text fields",
+			template: "{camera_model}/{name}.{ext}",
+			file:     image,
+			spaceRep: "_",
+			expected: filepath.Join("Canon_EOS_R5", "IMG_1234.jpg"),
+		},
+		{
+			name:     "time fields",
+			template: "{yy}{mm}{dd}/{hh}-{min}-{ss}.{ext}",
+			file:     image,
+			expected: filepath.Join("190715", "12-34-56.jpg"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl, err := ParseTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("ParseTemplate: %v", err)
+			}
+			result, err := tpl.Render(tt.file, tt.seq, tt.spaceRep, false)
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Render() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPathTemplateValuesCannotAddDirectories(t *testing.T) {
+	tpl, _ := ParseTemplate("{camera_model}/{name}.{ext}")
+	m := &MediaFile{SourcePath: "/src/a.jpg", OriginalName: "a.jpg", CameraModel: "AC/DC"}
+
+	if result, err := tpl.Render(m, 0, "", false); err != nil || result != filepath.Join("AC-DC", "a.jpg") {
+		t.Errorf("Render() = %q, %v, want %q", result, err, filepath.Join("AC-DC", "a.jpg"))
+	}
+}
+
+func TestPathTemplateValuesCannotLeaveDestination(t *testing.T) {
+	tpl, _ := ParseTemplate("{camera_model}/{name}.{ext}")
+	for _, model := range []string{"..", ".", " .. "} {
+		m := &MediaFile{SourcePath: "/src/IMG.jpg", OriginalName: "IMG.jpg", CameraModel: model}
+		if result, err := tpl.Render(m, 0, "", false); err != nil || result != "IMG.jpg" {
+			t.Errorf("Render() with camera model %q = %q, %v, want %q", model, result, err, "IMG.jpg")
+		}
+	}
+
+	// A literal next to an empty value may still spell ".."
+	dots, err := ParseTemplate("..{camera_model}/{name}.{ext}")
+	if err != nil {
+		t.Fatalf("ParseTemplate: %v", err)
+	}
+	m := &MediaFile{SourcePath: "/src/IMG.jpg", OriginalName: "IMG.jpg"}
+	if result, err := dots.Render(m, 0, "", false); err == nil {
+		t.Errorf("Render() = %q, want an error for a '..' element", result)
+	}
+}
+
+func TestPathTemplateRefusesEmptyRender(t *testing.T) {
+	tpl, _ := ParseTemplate("{camera_make}/{camera_model}")
+	m := &MediaFile{SourcePath: "/src/IMG.jpg", OriginalName: "IMG.jpg"}
+	if result, err := tpl.Render(m, 0, "", false); err == nil {
+		t.Errorf("Render() = %q, want an error for an empty path", result)
+	}
+}
+
+func TestPathTemplateHasSequence(t *testing.T) {
+	withSeq, _ := ParseTemplate("{timestamp}{seq}.{ext}")
+	withoutSeq, _ := ParseTemplate("{timestamp}.{ext}")
+
+	if !withSeq.HasSequence() {
+		t.Errorf("expected HasSequence() = true for %q", withSeq)
+	}
+	if withoutSeq.HasSequence() {
+		t.Errorf("expected HasSequence() = false for %q", withoutSeq)
+	}
+}
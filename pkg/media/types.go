@@ -24,12 +24,48 @@ type MediaFile struct {
 	FileSize        int64
 	Hash            string
 	OriginalName    string
+	CameraMake      string
+	CameraModel     string
+	Duration        time.Duration // Video/audio duration, when known
+
+	// Audio tags
+	Artist      string
+	AlbumArtist string
+	Album       string
+	Title       string
+	TrackNumber int
+	Year        int // Recording year from tags; may be set when CreationTime isn't
+
+	DateSource  DateSource // Which source CreationTime came from
+	HasGPS      bool
+	Latitude    float64
+	Longitude   float64
+	Description string
+
+	ContentIdentifier string // Apple ContentIdentifier shared by the photo and video of a Live Photo
+	PerceptualHash    string // dHash of an image, set only when near-duplicate detection is on
+
+	Sidecars []string // Source paths of companion files (.xmp, .THM, .AAE, .srt, .json)
+
+	ContentFormat string // Format the content is really in, when the extension is wrong or missing
+	Extension     string // Extension to organize the file under instead of the source's
 }
 
 func (m *MediaFile) GetExtension() string {
+	if m.Extension != "" {
+		return m.Extension
+	}
 	return strings.TrimPrefix(strings.ToLower(filepath.Ext(m.SourcePath)), ".")
 }
 
+// contentExtension returns the extension of the format the content is in.
+func (m *MediaFile) contentExtension() string {
+	if m.ContentFormat != "" {
+		return m.ContentFormat
+	}
+	return m.GetExtension()
+}
+
 func (m *MediaFile) GetDestinationPath(baseDir string, extensionDir string, isDuplicate bool, scheme string, duplicatesDir string) string {
 	year := m.CreationTime.Format("2006")
 	month := m.CreationTime.Format("01")
@@ -80,27 +116,15 @@ func (m *MediaFile) GetDestinationPath(baseDir string, extensionDir string, isDu
 
 func (m *MediaFile) GetNewFilename(scheme string, spaceReplacement string, noOriginalName bool) string {
 	ext := strings.ToLower(filepath.Ext(m.SourcePath))
+	if m.Extension != "" {
+		ext = "." + m.Extension
+	}
 	timestamp := m.CreationTime.Format("20060102-150405")
 
 	// Get original name without extension for suffix
 	origNameWithoutExt := ""
 	if !noOriginalName {
-		origNameWithoutExt = m.OriginalName
-		if len(origNameWithoutExt) > 0 {
-			// Remove extension(s)
-			for {
-				fileExt := filepath.Ext(origNameWithoutExt)
-				if fileExt == "" {
-					break
-				}
-				origNameWithoutExt = strings.TrimSuffix(origNameWithoutExt, fileExt)
-			}
-		}
-
-		// Replace spaces in the original name
-		if spaceReplacement != "" && spaceReplacement != " " {
-			origNameWithoutExt = strings.ReplaceAll(origNameWithoutExt, " ", spaceReplacement)
-		}
+		origNameWithoutExt = m.baseOriginalName(spaceReplacement)
 	}
 
 	if scheme == "date_first" {
@@ -135,17 +159,20 @@ func (m *MediaFile) GetNewFilename(scheme string, spaceReplacement string, noOri
 	return fmt.Sprintf("%s%s%s%s", timestamp, dimension, namePart, ext)
 }
 
-func DetermineMediaType(filePath string) MediaType {
-	ext := strings.ToLower(filepath.Ext(filePath))
-	
-	switch ext {
-	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp", ".tiff", ".tif", ".nef", ".arw", ".cr2", ".cr3", ".dng", ".heic", ".raf":
-		return TypeImage
-	case ".mp4", ".avi", ".mov", ".mkv", ".wmv", ".flv", ".webm", ".m4v", ".mpeg", ".mpg", ".3gp", ".asf", ".m2v", ".vob", ".m2t", ".mts":
-		return TypeVideo
-	case ".mp3", ".wav", ".aac", ".ogg", ".flac", ".m4a", ".wma", ".amr":
-		return TypeAudio
-	default:
-		return TypeUnknown
+// baseOriginalName returns the original filename with all extensions removed
+// and spaces replaced by spaceReplacement (if set).
+func (m *MediaFile) baseOriginalName(spaceReplacement string) string {
+	name := m.OriginalName
+	for {
+		fileExt := filepath.Ext(name)
+		if fileExt == "" {
+			break
+		}
+		name = strings.TrimSuffix(name, fileExt)
+	}
+
+	if spaceReplacement != "" && spaceReplacement != " " {
+		name = strings.ReplaceAll(name, " ", spaceReplacement)
 	}
-}
\ No newline at end of file
+	return name
+}
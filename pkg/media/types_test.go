@@ -29,6 +29,12 @@ func TestDetermineMediaType(t *testing.T) {
 		{"DNG", "raw.dng", TypeImage},
 		{"HEIC", "photo.heic", TypeImage},
 		{"RAF (Fuji RAW)", "raw.raf", TypeImage},
+		{"ORF (Olympus RAW)", "raw.orf", TypeImage},
+		{"RW2 (Panasonic RAW)", "raw.rw2", TypeImage},
+		{"PEF (Pentax RAW)", "raw.pef", TypeImage},
+		{"SRW (Samsung RAW)", "raw.srw", TypeImage},
+		{"AVIF", "photo.avif", TypeImage},
+		{"JPEG XL", "photo.jxl", TypeImage},
 
 		// Videos
 		{"MP4", "video.mp4", TypeVideo},
@@ -43,6 +49,8 @@ func TestDetermineMediaType(t *testing.T) {
 		{"MPG", "video.mpg", TypeVideo},
 		{"3GP", "video.3gp", TypeVideo},
 		{"MTS", "video.mts", TypeVideo},
+		{"INSV (Insta360)", "video.insv", TypeVideo},
+		{"BRAW (Blackmagic RAW)", "clip.braw", TypeVideo},
 
 		// Audio
 		{"MP3", "song.mp3", TypeAudio},
@@ -52,6 +60,8 @@ func TestDetermineMediaType(t *testing.T) {
 		{"FLAC", "audio.flac", TypeAudio},
 		{"M4A", "audio.m4a", TypeAudio},
 		{"WMA", "audio.wma", TypeAudio},
+		{"Opus", "audio.opus", TypeAudio},
+		{"AIFF", "audio.aiff", TypeAudio},
 
 		// Unknown
 		{"Text file", "document.txt", TypeUnknown},
@@ -0,0 +1,216 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+
+	"mediaorganizer/pkg/config"
+	"mediaorganizer/pkg/db"
+)
+
+// originalOf returns the ID of the file that the record id duplicates, given
+// the records sharing its hash: preferably one that is not a duplicate itself.
+func originalOf(matches []*db.FileRecord, id int64) int64 {
+	var fallback int64
+	for _, m := range matches {
+		if m.ID == id {
+			continue
+		}
+		if !m.IsDuplicate {
+			return m.ID
+		}
+		if fallback == 0 {
+			fallback = m.DuplicateOf
+			if fallback == 0 {
+				fallback = m.ID
+			}
+		}
+	}
+	return fallback
+}
+
+// placesDuplicate reports whether duplicates handled with policy get a
+// destination in the duplicates directory.
+func placesDuplicate(policy config.DuplicatePolicy) bool {
+	switch policy {
+	case config.DuplicateSkip, config.DuplicateDelete, config.DuplicateReport:
+		return false
+	}
+	return true
+}
+
+// locateOriginal returns the current path of the journaled file id: its
+// destination once organized, or its source while it is still waiting to be
+// moved. Pre-indexed destination files are found at their source_path.
+func locateOriginal(journal *db.Journal, id int64) (string, error) {
+	rec, err := journal.GetFile(id)
+	if err != nil {
+		return "", err
+	}
+	if rec == nil {
+		return "", errors.New("original not in journal")
+	}
+	candidates := []string{rec.SourcePath}
+	if rec.Status == db.StatusCompleted && rec.DestPath != "" {
+		candidates = []string{rec.DestPath, rec.SourcePath}
+	}
+	for _, path := range candidates {
+		if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("original %s not found", rec.SourcePath)
+}
+
+// identicalFiles reports whether two files have the same content, comparing
+// them byte by byte rather than trusting their hashes.
+func identicalFiles(path, other string) (bool, error) {
+	f1, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f1.Close()
+	f2, err := os.Open(other)
+	if err != nil {
+		return false, err
+	}
+	defer f2.Close()
+
+	info1, err := f1.Stat()
+	if err != nil {
+		return false, err
+	}
+	info2, err := f2.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info1.Size() != info2.Size() {
+		return false, nil
+	}
+
+	buf1 := make([]byte, 64*1024)
+	buf2 := make([]byte, 64*1024)
+	for {
+		n1, err1 := io.ReadFull(f1, buf1)
+		n2, err2 := io.ReadFull(f2, buf2)
+		if !bytes.Equal(buf1[:n1], buf2[:n2]) {
+			return false, nil
+		}
+		if err1 == io.EOF || err1 == io.ErrUnexpectedEOF {
+			return err2 == io.EOF || err2 == io.ErrUnexpectedEOF, nil
+		}
+		if err1 != nil {
+			return false, err1
+		}
+		if err2 != nil {
+			return false, err2
+		}
+	}
+}
+
+// executeDuplicateJob applies the duplicate policy of job, unless it is move.
+// It returns false if the duplicate should be moved after all, because the
+// file system can't link it to its original.
+func (s *MediaScanner) executeDuplicateJob(job moveJob) bool {
+	switch job.DuplicateAction {
+	case config.DuplicateSkip, config.DuplicateReport:
+		if job.DuplicateAction == config.DuplicateReport {
+			original, _ := locateOriginal(s.journal, job.DuplicateOf)
+			logrus.Infof("Duplicate: %s (original: %s)", job.File.SourcePath, original)
+		} else {
+			logrus.Infof("Skipped duplicate: %s", job.File.SourcePath)
+		}
+		s.journal.UpdateStatus(job.RecordID, db.StatusSkipped, "")
+		return true
+	}
+
+	// Delete and link only once the original is known to be identical
+	original, err := locateOriginal(s.journal, job.DuplicateOf)
+	if err == nil {
+		var same bool
+		if same, err = identicalFiles(job.File.SourcePath, original); err == nil && !same {
+			err = fmt.Errorf("content differs from original %s", original)
+		}
+	}
+	if err != nil {
+		logrus.Errorf("Cannot %s duplicate %s: %v", job.DuplicateAction, job.File.SourcePath, err)
+		s.journal.UpdateStatus(job.RecordID, db.StatusFailed, err.Error())
+		return true
+	}
+
+	if job.DuplicateAction == config.DuplicateDelete {
+		if s.dryRun {
+			logrus.Infof("[DRY RUN] Would delete duplicate: %s (original: %s)", job.File.SourcePath, original)
+			s.journal.UpdateStatus(job.RecordID, db.StatusDryRun, "")
+			atomic.AddInt32(&s.organized, 1)
+			return true
+		}
+		if !s.syncJournal() {
+			return true
+		}
+		if err := os.Remove(job.File.SourcePath); err != nil {
+			logrus.Errorf("Failed to delete duplicate %s: %v", job.File.SourcePath, err)
+			s.journal.UpdateStatus(job.RecordID, db.StatusFailed, err.Error())
+			return true
+		}
+		logrus.Infof("Deleted duplicate: %s (original: %s)", job.File.SourcePath, original)
+		s.journal.UpdateStatus(job.RecordID, db.StatusCompleted, "")
+		atomic.AddInt32(&s.organized, 1)
+		return true
+	}
+
+	// Hard link or reflink
+	if s.dryRun {
+		logrus.Infof("[DRY RUN] Would %s duplicate: %s -> \n%s (original: %s)", job.DuplicateAction, job.File.SourcePath, job.DestPath, original)
+		s.journal.UpdateStatus(job.RecordID, db.StatusDryRun, "")
+		atomic.AddInt32(&s.organized, 1)
+		s.moveSidecars(job)
+		return true
+	}
+	if err := os.MkdirAll(filepath.Dir(job.DestPath), 0755); err != nil {
+		logrus.Errorf("Failed to create directory %s: %v", filepath.Dir(job.DestPath), err)
+		s.journal.UpdateStatus(job.RecordID, db.StatusFailed, err.Error())
+		return true
+	}
+	if _, statErr := os.Lstat(job.DestPath); statErr == nil {
+		logrus.Errorf("Destination already exists, refusing to overwrite: %s", job.DestPath)
+		s.journal.UpdateStatus(job.RecordID, db.StatusFailed, "destination file already exists")
+		return true
+	}
+
+	if !s.syncJournal() {
+		return true
+	}
+	if job.DuplicateAction == config.DuplicateHardlink {
+		err = os.Link(original, job.DestPath)
+	} else {
+		err = cloneFile(original, job.DestPath)
+	}
+	if err != nil {
+		logrus.Warnf("Could not %s duplicate %s, using %s instead: %v", job.DuplicateAction, job.File.SourcePath, s.transferMethod(), err)
+		s.journal.SetDuplicateAction(job.RecordID, string(config.DuplicateMove))
+		return false
+	}
+
+	if !s.keepsSource() {
+		if err := os.Remove(job.File.SourcePath); err != nil {
+			logrus.Errorf("Failed to remove duplicate %s after linking: %v", job.File.SourcePath, err)
+			os.Remove(job.DestPath)
+			s.journal.UpdateStatus(job.RecordID, db.StatusFailed, err.Error())
+			return true
+		}
+	}
+	logrus.Infof("Linked duplicate (%s): %s -> \n%s", job.DuplicateAction, job.File.SourcePath, job.DestPath)
+	s.journal.UpdateTransfer(job.RecordID, string(job.DuplicateAction))
+	s.journal.UpdateStatus(job.RecordID, db.StatusCompleted, "")
+	atomic.AddInt32(&s.organized, 1)
+	s.moveSidecars(job)
+	return true
+}
@@ -0,0 +1,144 @@
+package processor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mediaorganizer/pkg/config"
+	"mediaorganizer/pkg/db"
+)
+
+// journalFile extracts and journals the file at path as the organizer would,
+// pending at destPath.
+func journalFile(t *testing.T, s *MediaScanner, path, destPath string) moveJob {
+	t.Helper()
+	mf, err := s.extractor.Extract(path)
+	if err != nil {
+		t.Fatalf("Extract %s: %v", path, err)
+	}
+	id, err := s.journal.InsertFile(newFileRecord(mf, "key"))
+	if err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	if destPath != "" {
+		s.journal.UpdateDestPath(id, destPath, 0, false)
+	}
+	return moveJob{RecordID: id, File: mf, DestPath: destPath}
+}
+
+func TestDuplicateWithDifferentContentIsKept(t *testing.T) {
+	policies := []config.DuplicatePolicy{config.DuplicateDelete, config.DuplicateHardlink, config.DuplicateReflink, config.DuplicateReport}
+	for _, policy := range policies {
+		t.Run(string(policy), func(t *testing.T) {
+			e := newTestEnv(t)
+			e.cfg.DuplicatePolicy = policy
+			s := NewMediaScanner(e.cfg, e.openJournal(), nil, false)
+
+			// Files whose hashes collide: the organizer takes the second for a
+			// duplicate of the first
+			original := e.write("a.jpg", jpegBytes(5000, 1), baseTime)
+			content := jpegBytes(5000, 2)
+			path := e.write("b.jpg", content, baseTime)
+			orig := journalFile(t, s, original, "")
+			dest := ""
+			if placesDuplicate(policy) {
+				dest = filepath.Join(e.cfg.DestDirs["image"], "duplicates", "b.jpg")
+			}
+			job := journalFile(t, s, path, dest)
+			job.IsDuplicate, job.DuplicateOf, job.DuplicateAction = true, orig.RecordID, policy
+			s.executeMoveJob(job)
+
+			rec, err := s.journal.GetFile(job.RecordID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy == config.DuplicateReport {
+				if rec.Status != db.StatusSkipped {
+					t.Errorf("reported duplicate %s: %s", rec.Status, rec.ErrorMessage)
+				}
+			} else if rec.Status != db.StatusFailed || !strings.Contains(rec.ErrorMessage, "content differs") {
+				t.Errorf("duplicate %s: %s", rec.Status, rec.ErrorMessage)
+			}
+			if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, content) {
+				t.Errorf("duplicate not kept: %v", err)
+			}
+			if dest != "" && exists(dest) {
+				t.Errorf("duplicate linked to %s", dest)
+			}
+			if !exists(original) {
+				t.Errorf("original %s gone", original)
+			}
+		})
+	}
+}
+
+func TestReportLeavesDuplicates(t *testing.T) {
+	e := newTestEnv(t)
+	e.cfg.DuplicatePolicy = config.DuplicateReport
+	content := jpegBytes(5000, 1)
+	e.write("a/img.jpg", content, baseTime)
+	dup := e.write("b/img.jpg", content, baseTime)
+
+	result, j := e.scan()
+	if result.ErrorCount != 0 {
+		t.Fatalf("%d errors", result.ErrorCount)
+	}
+	recs := e.records(j)
+	original, rec := recs["a/img.jpg"], recs["b/img.jpg"]
+	if !rec.IsDuplicate || rec.DuplicateOf != original.ID || rec.Status != db.StatusSkipped {
+		t.Errorf("duplicate %s of %d, want skipped duplicate of %d", rec.Status, rec.DuplicateOf, original.ID)
+	}
+	if rec.DestPath != "" {
+		t.Errorf("reported duplicate given destination %s", rec.DestPath)
+	}
+	if got, err := os.ReadFile(dup); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("reported duplicate not left in place: %v", err)
+	}
+	if files := dirFiles(t, e.cfg.DestDirs["image"]); len(files) != 1 {
+		t.Errorf("destination holds %v", files)
+	}
+}
+
+func TestPreIndexAppliesFilters(t *testing.T) {
+	e := newTestEnv(t)
+	e.cfg.Include = []string{"*.jpg"}
+	e.cfg.Exclude = []string{"tmp/"}
+	e.cfg.MinSizeBytes = 1000
+	e.cfg.MaxDepth = 2
+	e.cfg.DuplicatesDir = filepath.Join(e.root, "dups")
+	images := e.cfg.DestDirs["image"]
+	files := map[string]bool{
+		filepath.Join(images, "top.jpg"):             true,
+		filepath.Join(images, "2023", "a.jpg"):       true,
+		filepath.Join(images, "2023", "05", "b.jpg"): false, // too deep
+		filepath.Join(images, "2023", "c.png"):       false, // not included
+		filepath.Join(images, "tmp", "d.jpg"):        false, // excluded
+		filepath.Join(images, "2023", "small.jpg"):   false, // too small
+		filepath.Join(images, "2023", "ignored.jpg"): false, // in .mediaignore
+		// Depth counts from each destination, not from the one it is in
+		filepath.Join(e.root, "dups", "2023", "e.jpg"): true,
+	}
+	for path := range files {
+		size := 5000
+		if strings.HasPrefix(filepath.Base(path), "small") {
+			size = 500
+		}
+		writeFile(t, path, jpegBytes(size, 1), baseTime)
+	}
+	writeFile(t, filepath.Join(images, "2023", ".mediaignore"), []byte("ignored.jpg\n"), baseTime)
+
+	s := NewMediaScanner(e.cfg, e.openJournal(), nil, false)
+	s.preIndexDestinations()
+	for path, indexed := range files {
+		rec, err := s.journal.GetBySourcePath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rec != nil && rec.Status == db.StatusDestIndex; got != indexed {
+			t.Errorf("%s indexed: %v, want %v", e.rel(path), got, indexed)
+		}
+	}
+}
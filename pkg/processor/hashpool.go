@@ -0,0 +1,157 @@
+package processor
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/media"
+)
+
+// hashKey names a hash of a file: the full hash of its content, or its
+// partial hash.
+type hashKey struct {
+	Path    string
+	Partial bool
+}
+
+// hashJob is a hash to compute of the file at Path or, once that is gone, at
+// Fallback. A file being moved is at one of its source and destination at any
+// time, as copies are written under a temporary name.
+type hashJob struct {
+	hashKey
+	Fallback string
+}
+
+// hashResult is a hash computed by a hashPool worker.
+type hashResult struct {
+	hashKey
+	Hash string
+	Err  error
+}
+
+// hashPool hashes files on workers of its own. It is driven by a single
+// goroutine, which submits jobs and, in one select, hands them out with next
+// and receives results, so that neither side ever blocks the other.
+type hashPool struct {
+	jobs    chan hashJob
+	results chan hashResult
+	backlog []hashJob        // submitted jobs not handed to a worker yet
+	pending map[hashKey]bool // submitted hashes whose result isn't collected yet
+}
+
+// newHashPool starts workers that hash files with hash.
+func newHashPool(workers int, hash func(path string, partial bool) (string, error)) *hashPool {
+	p := &hashPool{
+		jobs:    make(chan hashJob),
+		results: make(chan hashResult),
+		pending: make(map[hashKey]bool),
+	}
+	for i := 0; i < max(workers, 1); i++ {
+		go func() {
+			for job := range p.jobs {
+				h, err := hash(job.Path, job.Partial)
+				if os.IsNotExist(err) && job.Fallback != "" {
+					h, err = hash(job.Fallback, job.Partial)
+				}
+				p.results <- hashResult{hashKey: job.hashKey, Hash: h, Err: err}
+			}
+		}()
+	}
+	return p
+}
+
+// submit queues a hash, unless it already is. fallback, if not empty, is
+// hashed instead when the file at key.Path no longer exists.
+func (p *hashPool) submit(key hashKey, fallback string) {
+	if p.pending[key] {
+		return
+	}
+	p.pending[key] = true
+	p.backlog = append(p.backlog, hashJob{hashKey: key, Fallback: fallback})
+}
+
+// next returns the channel to hand the oldest submitted job to a worker on,
+// and that job. The channel is nil when there is nothing to hand out, which
+// disables its case in a select.
+func (p *hashPool) next() (chan<- hashJob, hashJob) {
+	if len(p.backlog) == 0 {
+		return nil, hashJob{}
+	}
+	return p.jobs, p.backlog[0]
+}
+
+// handedOut records that the job returned by next went to a worker.
+func (p *hashPool) handedOut() {
+	p.backlog = p.backlog[1:]
+}
+
+// collected records that the result for key was received.
+func (p *hashPool) collected(key hashKey) {
+	delete(p.pending, key)
+}
+
+// close stops the workers once they are idle. All results must have been
+// collected.
+func (p *hashPool) close() {
+	close(p.jobs)
+}
+
+// hashFile returns the full or partial hash of the file at path, taken from
+// the hash cache when the file is unchanged since it was last hashed. It is
+// safe for concurrent use.
+func (s *MediaScanner) hashFile(path string, partial bool) (string, error) {
+	compute := media.ComputeFileHash
+	if partial {
+		compute = media.ComputePartialHash
+	}
+	if s.hashCache == nil {
+		return compute(path)
+	}
+	key, ok, err := db.StatFileKey(path)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return compute(path)
+	}
+
+	full, partialHash, err := s.hashCache.Lookup(key)
+	if err != nil {
+		logrus.Debugf("Hash cache lookup failed for %s: %v", path, err)
+	}
+	if partial && partialHash != "" {
+		return partialHash, nil
+	}
+	if !partial && full != "" {
+		return full, nil
+	}
+
+	h, err := compute(path)
+	if err != nil {
+		return "", err
+	}
+	// A file changed while it was hashed is cached the next time
+	if after, _, err := db.StatFileKey(path); err == nil && after == key {
+		if partial {
+			err = s.hashCache.Store(key, path, "", h)
+		} else {
+			err = s.hashCache.Store(key, path, h, "")
+		}
+		if err != nil {
+			logrus.Debugf("Could not cache hash of %s: %v", path, err)
+		}
+	}
+	return h, nil
+}
+
+// cacheMoved tells the hash cache that a file was renamed.
+func (s *MediaScanner) cacheMoved(oldPath, newPath string) {
+	if s.hashCache == nil {
+		return
+	}
+	if err := s.hashCache.Moved(oldPath, newPath); err != nil {
+		logrus.Debugf("Could not update hash cache for %s: %v", newPath, err)
+	}
+}
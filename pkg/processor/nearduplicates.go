@@ -0,0 +1,165 @@
+package processor
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/media"
+)
+
+// phashIndex is a BK-tree of the perceptual hashes of primary images. Since
+// the Hamming distance is a metric, a lookup only descends into the children
+// whose edge distance is within maxDist of the distance to the query, instead
+// of comparing against every image in the library.
+type phashIndex struct {
+	root *phashNode
+}
+
+type phashNode struct {
+	hash     uint64
+	id       int64
+	removed  bool // replaced by a higher-resolution copy; kept to route lookups
+	children map[int]*phashNode
+}
+
+func newPHashIndex() *phashIndex {
+	return &phashIndex{}
+}
+
+func (ix *phashIndex) add(hash uint64, id int64) {
+	node := &phashNode{hash: hash, id: id}
+	if ix.root == nil {
+		ix.root = node
+		return
+	}
+	cur := ix.root
+	for {
+		d := media.HammingDistance(cur.hash, hash)
+		next, ok := cur.children[d]
+		if !ok {
+			if cur.children == nil {
+				cur.children = make(map[int]*phashNode)
+			}
+			cur.children[d] = node
+			return
+		}
+		cur = next
+	}
+}
+
+// nearest returns the ID of the closest image within maxDist of hash and
+// its distance, or 0 if there is none.
+func (ix *phashIndex) nearest(hash uint64, maxDist int) (int64, int) {
+	if ix.root == nil {
+		return 0, 0
+	}
+	bestID, bestDist := int64(0), maxDist+1
+	stack := []*phashNode{ix.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := media.HammingDistance(node.hash, hash)
+		if !node.removed && d < bestDist {
+			bestID, bestDist = node.id, d
+		}
+		for edge, child := range node.children {
+			if edge >= d-maxDist && edge <= d+maxDist {
+				stack = append(stack, child)
+			}
+		}
+	}
+	if bestID == 0 {
+		return 0, 0
+	}
+	return bestID, bestDist
+}
+
+// remove drops the image id from the results of later lookups.
+func (ix *phashIndex) remove(hash uint64, id int64) {
+	cur := ix.root
+	for cur != nil {
+		if cur.id == id {
+			cur.removed = true
+			return
+		}
+		cur = cur.children[media.HammingDistance(cur.hash, hash)]
+	}
+}
+
+// loadPHashIndex indexes the primary images of earlier runs, so that new
+// copies of them are found too.
+func (s *MediaScanner) loadPHashIndex() {
+	s.phashes = newPHashIndex()
+	primaries, err := s.journal.GetPerceptualPrimaries()
+	if err != nil {
+		logrus.Errorf("Failed to load perceptual hashes: %v", err)
+		return
+	}
+	for _, rec := range primaries {
+		if h, err := media.ParsePerceptualHash(rec.PerceptualHash); err == nil {
+			s.phashes.add(h, rec.ID)
+		}
+	}
+	logrus.Debugf("Loaded %d perceptual hashes", len(primaries))
+}
+
+// findNearDuplicate checks the image id against the primary images seen so
+// far. It returns the primary that file is a near-duplicate of, or nil if
+// file is a new primary itself. A file with a higher resolution than its
+// match takes over as primary, and the former primary with its own
+// near-duplicates is set aside for review instead.
+func (s *MediaScanner) findNearDuplicate(file *media.MediaFile, id int64) *db.FileRecord {
+	hash, err := media.ParsePerceptualHash(file.PerceptualHash)
+	if err != nil {
+		return nil
+	}
+
+	primaryID, dist := s.phashes.nearest(hash, s.nearDistance)
+	if primaryID == 0 {
+		s.phashes.add(hash, id)
+		return nil
+	}
+	primary, err := s.journal.GetFile(primaryID)
+	if err != nil || primary == nil {
+		if err != nil {
+			logrus.Errorf("GetFile error: %v", err)
+		}
+		return nil
+	}
+
+	if !outranks(file, primary) || s.isPaired(primary) {
+		s.journal.SetNearDuplicateOf(id, primary.ID)
+		logrus.Debugf("Near-duplicate (distance %d): %s of %s", dist, file.SourcePath, primary.SourcePath)
+		return primary
+	}
+
+	primaryHash, _ := media.ParsePerceptualHash(primary.PerceptualHash)
+	s.phashes.remove(primaryHash, primary.ID)
+	s.phashes.add(hash, id)
+	s.journal.ReassignNearDuplicates(primary.ID, id)
+	s.journal.SetNearDuplicateOf(primary.ID, id)
+	primary.NearDuplicateOf = id
+	logrus.Debugf("Near-duplicate (distance %d): %s of %s", dist, primary.SourcePath, file.SourcePath)
+
+	s.refile(primary, s.recordDestPath(primary, primary.SequenceNum), primary.SequenceNum, "near-duplicate")
+	return nil
+}
+
+// outranks reports whether file is a better primary copy than rec: a higher
+// resolution, or the same resolution at a larger file size.
+func outranks(file *media.MediaFile, rec *db.FileRecord) bool {
+	if file.LargerDimension != rec.LargerDimension {
+		return file.LargerDimension > rec.LargerDimension
+	}
+	return file.FileSize > rec.FileSize
+}
+
+// isPaired reports whether rec belongs to a RAW+JPEG or Live Photo pair.
+// Pairs stay together, so a paired primary is never set aside.
+func (s *MediaScanner) isPaired(rec *db.FileRecord) bool {
+	if rec.PairID != 0 {
+		return true
+	}
+	members, err := s.journal.GetPairMembers(rec.ID)
+	return err == nil && len(members) > 0
+}
@@ -0,0 +1,97 @@
+package processor
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mediaorganizer/pkg/db"
+)
+
+// photoBytes returns a w x h JPEG with a gradient and a bright disc placed
+// by seed, at the given quality.
+func photoBytes(t *testing.T, w, h int, seed float64, quality int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			v := 80 + 100*fx*fy + 40*math.Sin(6*fx+seed)
+			if dx, dy := fx-0.3-seed/10, fy-0.4; dx*dx+dy*dy < 0.04 {
+				v = 240
+			}
+			c := uint8(math.Max(0, math.Min(255, v)))
+			img.Set(x, y, color.RGBA{c, c / 2, 255 - c, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// nearDuplicate reports whether path lies in the near-duplicates folder.
+func (e *testEnv) nearDuplicate(path string) bool {
+	return strings.Contains(filepath.ToSlash(path), "/"+e.cfg.NearDuplicatesDir+"/")
+}
+
+func TestNearDuplicatesSetAside(t *testing.T) {
+	e := newTestEnv(t)
+	e.cfg.NearDuplicates = true
+	e.cfg.NearDuplicateDist = 6
+	e.write("a/photo.jpg", photoBytes(t, 480, 320, 0, 90), baseTime)
+	e.write("b/resaved.jpg", photoBytes(t, 480, 320, 0, 40), baseTime.Add(time.Minute))
+	e.write("c/other.jpg", photoBytes(t, 480, 320, 3, 90), baseTime.Add(2*time.Minute))
+
+	result, j := e.scan()
+	if result.ErrorCount != 0 || result.NearDuplicateCount != 1 {
+		t.Fatalf("%d errors, %d near-duplicates", result.ErrorCount, result.NearDuplicateCount)
+	}
+	recs := e.records(j)
+	primary, resaved, other := recs["a/photo.jpg"], recs["b/resaved.jpg"], recs["c/other.jpg"]
+	if resaved.NearDuplicateOf != primary.ID || !e.nearDuplicate(resaved.DestPath) {
+		t.Errorf("re-saved copy near-duplicate of %d at %s", resaved.NearDuplicateOf, e.rel(resaved.DestPath))
+	}
+	for _, rec := range []*db.FileRecord{primary, other} {
+		if rec.NearDuplicateOf != 0 || e.nearDuplicate(rec.DestPath) {
+			t.Errorf("%s set aside at %s", e.rel(rec.SourcePath), e.rel(rec.DestPath))
+		}
+	}
+	for _, rec := range []*db.FileRecord{primary, resaved, other} {
+		if rec.Status != db.StatusCompleted || !exists(rec.DestPath) {
+			t.Errorf("%s %s, not at %s", e.rel(rec.SourcePath), rec.Status, e.rel(rec.DestPath))
+		}
+	}
+
+	// A higher-resolution copy in a later run becomes the primary; the former
+	// primary joins the copies set aside for review
+	e.write("d/large.jpg", photoBytes(t, 1200, 800, 0, 90), baseTime.Add(3*time.Minute))
+	result, j = e.scan()
+	if result.ErrorCount != 0 || result.NearDuplicateCount != 2 {
+		t.Fatalf("%d errors, %d near-duplicates", result.ErrorCount, result.NearDuplicateCount)
+	}
+	recs = e.records(j)
+	large := recs["d/large.jpg"]
+	if large.NearDuplicateOf != 0 || e.nearDuplicate(large.DestPath) || !exists(large.DestPath) {
+		t.Errorf("better copy set aside at %s", e.rel(large.DestPath))
+	}
+	for _, name := range []string{"a/photo.jpg", "b/resaved.jpg"} {
+		rec := recs[name]
+		if rec.NearDuplicateOf != large.ID || !e.nearDuplicate(rec.DestPath) || !exists(rec.DestPath) {
+			t.Errorf("%s near-duplicate of %d at %s", name, rec.NearDuplicateOf, e.rel(rec.DestPath))
+		}
+	}
+	if exists(primary.DestPath) {
+		t.Errorf("former primary left at %s", e.rel(primary.DestPath))
+	}
+	if rec := recs["c/other.jpg"]; rec.NearDuplicateOf != 0 || rec.DestPath != other.DestPath {
+		t.Errorf("other photo moved to %s", e.rel(rec.DestPath))
+	}
+}
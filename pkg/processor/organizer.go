@@ -0,0 +1,438 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+
+	"mediaorganizer/pkg/config"
+	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/filter"
+	"mediaorganizer/pkg/media"
+)
+
+// organizerLookahead bounds how many files the organizer takes in while an
+// earlier one waits for its hashes.
+const organizerLookahead = 256
+
+// queuedFile is a file the organizer has taken in but not organized yet.
+type queuedFile struct {
+	file     *media.MediaFile
+	tsKey    string
+	partial  bool      // its partial hash is needed, another file having its size
+	compared bool      // its partial hash was looked up among the journaled files
+	full     bool      // its full hash is needed, its partial hash matching another file's
+	waitFor  []hashKey // hashes that must be in before it is organized
+}
+
+// organizer is the state of the organizer goroutine between files. Hashing
+// runs on a pool of its own, so the organizer keeps taking in files while a
+// large one is hashed. It still does all journal writes, and a file only
+// overtakes an earlier one still waiting for hashes when their sizes,
+// sequence keys, pairs and near-duplicate candidates can't meet, so the
+// outcome is the same as in walk order.
+type organizer struct {
+	s        *MediaScanner
+	moveCh   chan<- moveJob
+	pool     *hashPool
+	queue    []*queuedFile
+	sizes    map[int64]int          // queued files per size
+	hashes   map[hashKey]hashResult // collected hashes of queued files
+	backfill map[hashKey][]int64    // journaled records waiting for a hash of their file
+}
+
+// organize organizes the files of orderedCh, in walk order except where a
+// file can safely overtake one waiting for its hashes.
+func (s *MediaScanner) organize(orderedCh <-chan metadataResult, moveCh chan<- moveJob) {
+	o := s.newOrganizer(moveCh)
+	defer o.pool.close()
+	o.run(orderedCh)
+}
+
+// newOrganizer returns an organizer queuing moves on moveCh, with its hash
+// workers started.
+func (s *MediaScanner) newOrganizer(moveCh chan<- moveJob) *organizer {
+	return &organizer{
+		s:        s,
+		moveCh:   moveCh,
+		pool:     newHashPool(s.hashJobs, s.hashFile),
+		sizes:    make(map[int64]int),
+		hashes:   make(map[hashKey]hashResult),
+		backfill: make(map[hashKey][]int64),
+	}
+}
+
+// run organizes the files of orderedCh until it is closed and every file
+// taken in is organized.
+func (o *organizer) run(orderedCh <-chan metadataResult) {
+	in := orderedCh
+	for {
+		o.organizeReady()
+		if in == nil && len(o.queue) == 0 {
+			return
+		}
+		intake := in
+		if len(o.queue) >= organizerLookahead {
+			intake = nil
+		}
+		jobs, next := o.pool.next()
+		select {
+		case mr, ok := <-intake:
+			if !ok {
+				in = nil
+				continue
+			}
+			o.take(mr)
+		case jobs <- next:
+			o.pool.handedOut()
+		case r := <-o.pool.results:
+			o.collect(r)
+		}
+	}
+}
+
+// take queues a file and submits the partial hashes it needs.
+func (o *organizer) take(mr metadataResult) {
+	s := o.s
+	if mr.Err != nil {
+		s.result.ErrorCount++
+		s.countSkip(SkipUnreadable, mr.Err.Error())
+		s.release(mr.Path)
+		return
+	}
+
+	file := mr.File
+	atomic.AddInt32(&s.processed, 1)
+	s.result.ProcessedFiles++
+
+	// The creation date is only known now, so the date range filters last
+	if s.outsideDateRange(file) {
+		s.countSkip(filter.ReasonDateRange, file.SourcePath)
+		s.release(file.SourcePath)
+		return
+	}
+
+	// Build sequence key (timestamp-based, or the rendered path for templates and music)
+	q := &queuedFile{file: file, tsKey: s.sequenceKey(file)}
+
+	// --- Lazy hashing ---
+	// A file gets a partial hash once another file of its size is journaled
+	// or queued; only files whose partial hashes match are hashed in full
+	sizeCount, err := s.journal.CountByFileSize(file.FileSize)
+	if err != nil {
+		logrus.Errorf("CountByFileSize error: %v", err)
+	}
+	if sizeCount+o.sizes[file.FileSize] > 0 {
+		q.partial = true
+		o.waitForHash(q, hashKey{file.SourcePath, true}, "")
+
+		// Backfill files with same size (includes pre-indexed destination files)
+		missing, err := s.journal.GetWithoutPartialHashByFileSize(file.FileSize)
+		if err != nil {
+			logrus.Errorf("GetWithoutPartialHashByFileSize error: %v", err)
+		}
+		for _, rec := range missing {
+			o.backfillHash(q, rec, true)
+		}
+
+		// Queued files of the same size were the first of it when taken in
+		for _, other := range o.queue {
+			if other.file.FileSize == file.FileSize && !other.partial {
+				other.partial = true
+				o.waitForHash(other, hashKey{other.file.SourcePath, true}, "")
+			}
+		}
+	}
+
+	o.sizes[file.FileSize]++
+	o.queue = append(o.queue, q)
+}
+
+// compareFull has q wait for the full hashes of itself and of the journaled
+// files with the same partial hash, if there are any. It returns whether q
+// has to wait.
+func (o *organizer) compareFull(q *queuedFile) bool {
+	q.compared = true
+	partial := o.hashes[hashKey{q.file.SourcePath, true}]
+	if partial.Err != nil || partial.Hash == "" {
+		return false
+	}
+	matches, err := o.s.journal.GetByPartialHash(partial.Hash)
+	if err != nil {
+		logrus.Errorf("GetByPartialHash error: %v", err)
+	}
+	if len(matches) == 0 {
+		return false
+	}
+
+	q.full = true
+	o.waitForHash(q, hashKey{q.file.SourcePath, false}, "")
+	for _, rec := range matches {
+		if rec.Hash == "" {
+			o.backfillHash(q, rec, false)
+		}
+	}
+	return true
+}
+
+// backfillHash has q wait for a hash of the journaled file rec, to be
+// journaled once it is in.
+func (o *organizer) backfillHash(q *queuedFile, rec *db.FileRecord, partial bool) {
+	// The movers may be moving the file meanwhile; it is hashed at its
+	// destination once it is complete there. For dest_index rows,
+	// source_path IS the dest path.
+	if _, statErr := os.Stat(rec.SourcePath); os.IsNotExist(statErr) && rec.DestPath == "" {
+		logrus.Debugf("Skipping backfill hash for missing source: %s", rec.SourcePath)
+		return
+	}
+	key := hashKey{rec.SourcePath, partial}
+	if !slices.Contains(o.backfill[key], rec.ID) {
+		o.backfill[key] = append(o.backfill[key], rec.ID)
+	}
+	o.waitForHash(q, key, rec.DestPath)
+}
+
+// waitForHash has q wait for a hash of the file at key.Path, or at fallback
+// once moved there, submitting it if needed.
+func (o *organizer) waitForHash(q *queuedFile, key hashKey, fallback string) {
+	q.waitFor = append(q.waitFor, key)
+	o.pool.submit(key, fallback)
+}
+
+// collect journals a hash of backfilled records, or keeps it for the queued
+// file it belongs to.
+func (o *organizer) collect(r hashResult) {
+	o.pool.collected(r.hashKey)
+	if ids, ok := o.backfill[r.hashKey]; ok {
+		delete(o.backfill, r.hashKey)
+		if r.Err != nil {
+			logrus.Warnf("Could not backfill hash for %s: %v", r.Path, r.Err)
+		} else {
+			for _, id := range ids {
+				if r.Partial {
+					o.s.journal.UpdatePartialHash(id, r.Hash)
+				} else {
+					o.s.journal.UpdateHash(id, r.Hash)
+				}
+			}
+		}
+	}
+	for _, q := range o.queue {
+		if q.file.SourcePath == r.Path && (r.Partial && q.partial || !r.Partial && q.full) {
+			o.hashes[r.hashKey] = r
+			break
+		}
+	}
+}
+
+// organizeReady organizes the queued files whose hashes are in and that no
+// earlier queued file has to go before.
+func (o *organizer) organizeReady() {
+	for i := 0; i < len(o.queue); {
+		q := o.queue[i]
+		if !o.ready(q) || slices.ContainsFunc(o.queue[:i], func(earlier *queuedFile) bool { return interacts(earlier, q) }) {
+			i++
+			continue
+		}
+		// All files it can be a duplicate of are journaled by now
+		if q.partial && !q.compared && o.compareFull(q) {
+			i++
+			continue
+		}
+		o.queue = slices.Delete(o.queue, i, i+1)
+		if o.sizes[q.file.FileSize]--; o.sizes[q.file.FileSize] == 0 {
+			delete(o.sizes, q.file.FileSize)
+		}
+
+		var partial, full *hashResult
+		if q.partial {
+			partial = o.takeHash(hashKey{q.file.SourcePath, true})
+		}
+		if q.full {
+			full = o.takeHash(hashKey{q.file.SourcePath, false})
+		}
+		o.s.organizeFile(q.file, q.tsKey, partial, full, o.moveCh)
+	}
+}
+
+// takeHash removes a collected hash of a queued file and returns it.
+func (o *organizer) takeHash(key hashKey) *hashResult {
+	r := o.hashes[key]
+	delete(o.hashes, key)
+	return &r
+}
+
+// ready reports whether all hashes q waits for are in.
+func (o *organizer) ready(q *queuedFile) bool {
+	for _, path := range q.waitFor {
+		if o.pool.pending[path] {
+			return false
+		}
+	}
+	return true
+}
+
+// interacts reports whether the outcome of organizing a or b can depend on
+// which goes first: as possible duplicates, by sharing a sequence key, as a
+// RAW+JPEG or Live Photo pair, or as near-duplicates.
+func interacts(a, b *queuedFile) bool {
+	fa, fb := a.file, b.file
+	switch {
+	case fa.FileSize == fb.FileSize, a.tsKey == b.tsKey:
+		return true
+	case fa.ContentIdentifier != "" && fa.ContentIdentifier == fb.ContentIdentifier:
+		return true
+	case fa.PerceptualHash != "" && fb.PerceptualHash != "":
+		return true
+	}
+	return filepath.Dir(fa.SourcePath) == filepath.Dir(fb.SourcePath) && baseName(fa.SourcePath) == baseName(fb.SourcePath)
+}
+
+// baseName returns the name of the file at path up to its first dot, which
+// namesakes share.
+func baseName(path string) string {
+	name, _, _ := strings.Cut(filepath.Base(path), ".")
+	return name
+}
+
+// organizeFile journals a file, detects whether it is a duplicate,
+// near-duplicate or pair member, numbers it and queues its move. partial and
+// full are its hashes, or nil if they weren't needed.
+func (s *MediaScanner) organizeFile(file *media.MediaFile, tsKey string, partial, full *hashResult, moveCh chan<- moveJob) {
+	rec := newFileRecord(file, tsKey)
+	for _, h := range []*hashResult{partial, full} {
+		switch {
+		case h == nil:
+		case h.Err != nil:
+			logrus.Warnf("Could not hash %s: %v", file.SourcePath, h.Err)
+		case h.Partial:
+			rec.PartialHash = h.Hash
+		default:
+			rec.Hash = h.Hash
+		}
+	}
+	fileHash := rec.Hash
+
+	// Insert into journal
+	id, err := s.journal.InsertFile(rec)
+	if err != nil {
+		if err == db.ErrAlreadyExists {
+			logrus.Debugf("Skipping already-journaled file: %s", file.SourcePath)
+		} else {
+			logrus.Errorf("Failed to insert journal record for %s: %v", file.SourcePath, err)
+			s.result.ErrorCount++
+		}
+		s.release(file.SourcePath)
+		return
+	}
+
+	if len(file.Sidecars) > 0 {
+		if err := s.journal.InsertSidecars(id, file.Sidecars); err != nil {
+			logrus.Errorf("Failed to record sidecars of %s: %v", file.SourcePath, err)
+		}
+	}
+
+	// --- Global dedup ---
+	isDuplicate := false
+	var duplicateOf int64
+	var duplicateAction config.DuplicatePolicy
+	if fileHash != "" {
+		matches, err := s.journal.GetByHash(fileHash)
+		if err != nil {
+			logrus.Errorf("GetByHash error: %v", err)
+		}
+		// If there's another record with the same hash (not this one), it's a duplicate
+		if duplicateOf = originalOf(matches, id); duplicateOf != 0 {
+			isDuplicate = true
+			duplicateAction = s.duplicatePolicy
+			logrus.Debugf("Duplicate detected (hash %s): %s", fileHash[:12], file.SourcePath)
+			s.journal.SetDuplicate(id, duplicateOf, string(duplicateAction))
+		}
+	}
+
+	// Duplicates left in the source or deleted get no destination
+	if isDuplicate && !placesDuplicate(duplicateAction) {
+		moveCh <- moveJob{
+			RecordID:        id,
+			File:            file,
+			IsDuplicate:     true,
+			DuplicateOf:     duplicateOf,
+			DuplicateAction: duplicateAction,
+		}
+		return
+	}
+
+	// --- Near-duplicates ---
+	// Resized or re-saved copies of an image go to the review folder
+	var nearDuplicateOf *db.FileRecord
+	if s.nearDuplicates && !isDuplicate && file.PerceptualHash != "" {
+		nearDuplicateOf = s.findNearDuplicate(file, id)
+	}
+
+	// --- Pairing ---
+	// A file that doesn't lead its pair takes the leader's name and sequence
+	var pairLeader *db.FileRecord
+	if s.pairFiles && !isDuplicate && nearDuplicateOf == nil {
+		pairLeader = s.findPairLeader(file, id)
+	}
+	if pairLeader != nil && !media.PairLeads(file, recordToMediaFile(pairLeader)) {
+		destPath := s.pairedDestPath(pairLeader.DestPath, file)
+		s.journal.SetPairLeader(id, pairLeader.ID)
+		s.journal.UpdateDestPath(id, destPath, pairLeader.SequenceNum, false)
+		logrus.Debugf("Paired %s with %s", file.SourcePath, pairLeader.SourcePath)
+
+		moveCh <- moveJob{
+			RecordID: id,
+			File:     file,
+			DestPath: destPath,
+		}
+		return
+	}
+
+	// --- Sequence numbering ---
+	tsCount, err := s.journal.CountByTimestampKey(tsKey)
+	if err != nil {
+		logrus.Errorf("CountByTimestampKey error: %v", err)
+	}
+	seqNum := 0
+	if tsCount > 1 {
+		seqNum = tsCount
+
+		// When we transition from 1→2 files sharing a timestamp,
+		// retroactively assign _001 to the first file so the naming
+		// is consistent (_001, _002, _003, ...) instead of (none, _002, _003).
+		if tsCount == 2 {
+			s.retroFixFirstSequence(tsKey, moveCh)
+		}
+	}
+
+	// --- Compute destination path ---
+	var destPath string
+	if nearDuplicateOf != nil {
+		destPath = s.computeNearDuplicatePath(file, seqNum)
+	} else {
+		destPath = s.computeDestPath(file, isDuplicate, seqNum)
+	}
+
+	// Update journal
+	s.journal.UpdateDestPath(id, destPath, seqNum, isDuplicate)
+
+	// This file outranks the leader of the pair it joins, e.g. a RAW
+	// file found after its JPEG: it names the pair from now on
+	if pairLeader != nil {
+		s.takeOverPair(id, destPath, seqNum, pairLeader)
+	}
+
+	moveCh <- moveJob{
+		RecordID:        id,
+		File:            file,
+		DestPath:        destPath,
+		IsDuplicate:     isDuplicate,
+		DuplicateOf:     duplicateOf,
+		DuplicateAction: duplicateAction,
+	}
+}
@@ -0,0 +1,226 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mediaorganizer/pkg/config"
+	"mediaorganizer/pkg/media"
+)
+
+// writeOrderingTree fills the source with files whose outcome depends on the
+// order they are organized in, behind two large files that have to be hashed
+// in full, which later files may overtake.
+func writeOrderingTree(e *testEnv) {
+	// Same size and ends, different middles
+	const bigSize = 8 << 20
+	big := jpegBytes(bigSize, 1)
+	e.write("0big/a.jpg", big, baseTime)
+	big[bigSize/2] = 2
+	e.write("0big/b.jpg", big, baseTime.Add(time.Minute))
+
+	// Identical content
+	for i, dir := range []string{"a", "b", "c"} {
+		e.write(dir+"/dup.jpg", jpegBytes(5000, 7), baseTime.Add(time.Duration(2+i)*time.Minute))
+	}
+	// Same size, different content
+	e.write("d/size1.jpg", jpegBytes(6000, 8), baseTime.Add(10*time.Minute))
+	e.write("d/size2.jpg", jpegBytes(6000, 9), baseTime.Add(11*time.Minute))
+	// Same timestamp
+	for i, name := range []string{"t1", "t2", "t3"} {
+		e.write("e/"+name+".jpg", jpegBytes(7000+i, 10), baseTime.Add(20*time.Minute))
+	}
+	// RAW+JPEG namesakes, the JPEG found first
+	e.write("f/IMG_0001.jpg", jpegBytes(8000, 11), baseTime.Add(30*time.Minute))
+	raw := bytes.Repeat([]byte{12}, 9000)
+	copy(raw, "II*\x00")
+	e.write("f/IMG_0001.nef", raw, baseTime.Add(30*time.Minute))
+	// Independent files that may go first
+	for i := 0; i < 40; i++ {
+		e.write(fmt.Sprintf("g/%02d.jpg", i), jpegBytes(10000+i, 13), baseTime.Add(time.Duration(40+i)*time.Minute))
+	}
+}
+
+func TestOrganizerOutcomeFollowsWalkOrder(t *testing.T) {
+	var first map[string]string
+	for run := 0; run < 3; run++ {
+		e := newTestEnv(t)
+		writeOrderingTree(e)
+		result, j := e.scan()
+		if result.ErrorCount != 0 {
+			t.Fatalf("run %d: %d errors", run, result.ErrorCount)
+		}
+		recs := e.records(j)
+		if len(recs) != 52 {
+			t.Fatalf("run %d: %d records, want 52", run, len(recs))
+		}
+
+		original := recs["a/dup.jpg"]
+		if original.IsDuplicate {
+			t.Errorf("first of identical files marked duplicate")
+		}
+		for _, name := range []string{"b/dup.jpg", "c/dup.jpg"} {
+			if rec := recs[name]; !rec.IsDuplicate || rec.DuplicateOf != original.ID {
+				t.Errorf("%s: duplicate %v of %d, want duplicate of %d", name, rec.IsDuplicate, rec.DuplicateOf, original.ID)
+			}
+		}
+		for _, pair := range [][2]string{{"0big/a.jpg", "0big/b.jpg"}, {"d/size1.jpg", "d/size2.jpg"}} {
+			a, b := recs[pair[0]], recs[pair[1]]
+			if a.IsDuplicate || b.IsDuplicate {
+				t.Errorf("%s and %s are not duplicates", pair[0], pair[1])
+			}
+			if a.PartialHash == "" || b.PartialHash == "" {
+				t.Errorf("%s and %s share a size but have no partial hashes", pair[0], pair[1])
+			}
+		}
+		if a, b := recs["0big/a.jpg"], recs["0big/b.jpg"]; a.Hash == "" || b.Hash == "" || a.Hash == b.Hash {
+			t.Errorf("files with the same partial hash: full hashes %q and %q", a.Hash, b.Hash)
+		}
+		for i, name := range []string{"e/t1.jpg", "e/t2.jpg", "e/t3.jpg"} {
+			if rec := recs[name]; rec.SequenceNum != i+1 {
+				t.Errorf("%s: sequence %d, want %d", name, rec.SequenceNum, i+1)
+			}
+		}
+		jpg, raw := recs["f/IMG_0001.jpg"], recs["f/IMG_0001.nef"]
+		if jpg.PairID != raw.ID || stem(jpg.DestPath) != stem(raw.DestPath) {
+			t.Errorf("JPEG %s not paired with RAW %s", jpg.DestPath, raw.DestPath)
+		}
+
+		outcome := make(map[string]string, len(recs))
+		for name, rec := range recs {
+			if rec.Status != "completed" || !exists(rec.DestPath) {
+				t.Errorf("%s: status %s, destination %s", name, rec.Status, rec.DestPath)
+			}
+			dupOf := ""
+			for other, r := range recs {
+				if r.ID == rec.DuplicateOf {
+					dupOf = other
+				}
+			}
+			outcome[name] = fmt.Sprintf("dest=%s seq=%d duplicate_of=%s", e.rel(rec.DestPath), rec.SequenceNum, dupOf)
+		}
+		if first == nil {
+			first = outcome
+			continue
+		}
+		for name, want := range first {
+			if got := outcome[name]; got != want {
+				t.Errorf("run %d: %s: %s, first run %s", run, name, got, want)
+			}
+		}
+	}
+}
+
+func TestOrganizerDrainsHashPool(t *testing.T) {
+	e := newTestEnv(t)
+	writeOrderingTree(e)
+	j := e.openJournal()
+	s := NewMediaScanner(e.cfg, j, nil, false)
+
+	var files []*media.MediaFile
+	filepath.WalkDir(e.cfg.SourceDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && isSourceMediaFile(path) {
+			mf, err := s.extractor.Extract(path)
+			if err != nil {
+				t.Fatalf("Extract %s: %v", path, err)
+			}
+			files = append(files, mf)
+		}
+		return nil
+	})
+
+	orderedCh := make(chan metadataResult)
+	moveCh := make(chan moveJob, 100)
+	movers := s.startMovers(moveCh)
+	o := s.newOrganizer(moveCh)
+	done := make(chan struct{})
+	go func() {
+		o.run(orderedCh)
+		close(done)
+	}()
+	for i, mf := range files {
+		orderedCh <- metadataResult{Index: i, File: mf}
+	}
+	close(orderedCh)
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("organizer did not finish")
+	}
+	close(moveCh)
+	movers.Wait()
+	o.pool.close()
+
+	if len(o.pool.pending) != 0 || len(o.pool.backlog) != 0 {
+		t.Errorf("hashes left: %d pending, %d not handed out", len(o.pool.pending), len(o.pool.backlog))
+	}
+	if len(o.queue) != 0 || len(o.hashes) != 0 || len(o.backfill) != 0 || len(o.sizes) != 0 {
+		t.Errorf("organizer state left: %d queued, %d hashes, %d backfills, %d sizes", len(o.queue), len(o.hashes), len(o.backfill), len(o.sizes))
+	}
+	if n, _ := j.TotalCount(); n != len(files) {
+		t.Errorf("%d records, want %d", n, len(files))
+	}
+}
+
+func TestTemplateSequencesFollowRenderedPaths(t *testing.T) {
+	e := newTestEnv(t)
+	tpl, err := media.ParseTemplate("{yyyymmdd}/photo.{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.cfg.OrganizationScheme = config.SchemeTemplate
+	e.cfg.PathTemplate = tpl.String()
+	e.cfg.Template = tpl
+
+	// Files of two days, the first two of the first day hashed in full;
+	// only files of the same day render to the same path
+	const bigSize = 4 << 20
+	big := jpegBytes(bigSize, 1)
+	e.write("0/a.jpg", big, baseTime)
+	big[bigSize/2] = 2
+	e.write("0/b.jpg", big, baseTime.Add(time.Minute))
+	var order []string
+	for i := 0; i < 20; i++ {
+		day := baseTime.Add(time.Duration(i%2) * 24 * time.Hour)
+		name := fmt.Sprintf("1/%02d.jpg", i)
+		e.write(name, jpegBytes(1000+i, 3), day.Add(time.Duration(i)*time.Second))
+		order = append(order, name)
+	}
+
+	result, j := e.scan()
+	if result.ErrorCount != 0 {
+		t.Fatalf("%d errors", result.ErrorCount)
+	}
+	recs := e.records(j)
+
+	seqs := map[string]int{}
+	dests := map[string]string{}
+	for _, name := range append([]string{"0/a.jpg", "0/b.jpg"}, order...) {
+		rec := recs[name]
+		day := filepath.Base(filepath.Dir(rec.DestPath))
+		seqs[day]++
+		if rec.SequenceNum != seqs[day] {
+			t.Errorf("%s: sequence %d, want %d on %s", name, rec.SequenceNum, seqs[day], day)
+		}
+		if other, ok := dests[rec.DestPath]; ok {
+			t.Errorf("%s and %s both went to %s", other, name, rec.DestPath)
+		}
+		dests[rec.DestPath] = name
+		if rec.Status != "completed" || !exists(rec.DestPath) {
+			t.Errorf("%s: status %s, destination %s", name, rec.Status, rec.DestPath)
+		}
+	}
+	if seqs["20230501"] != 12 || seqs["20230502"] != 10 {
+		t.Errorf("files per day = %v", seqs)
+	}
+}
+
+// stem returns the name of the file at path without its extension.
+func stem(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+
+	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/media"
+)
+
+// findPairLeader returns the leader of the pair file forms with an already
+// journaled file, from this or an earlier run: a namesake in the same
+// source directory or a file with the same Apple ContentIdentifier. Returns
+// nil if file is not part of a pair yet.
+func (s *MediaScanner) findPairLeader(file *media.MediaFile, id int64) *db.FileRecord {
+	candidates, err := s.journal.GetNamesakes(file.SourcePath)
+	if err != nil {
+		logrus.Errorf("GetNamesakes error: %v", err)
+	}
+	if file.ContentIdentifier != "" {
+		recs, err := s.journal.GetByContentID(file.ContentIdentifier)
+		if err != nil {
+			logrus.Errorf("GetByContentID error: %v", err)
+		}
+		candidates = append(candidates, recs...)
+	}
+
+	for _, rec := range candidates {
+		if rec.ID == id || rec.IsDuplicate || rec.NearDuplicateOf != 0 || rec.DestPath == "" {
+			continue
+		}
+		if !media.IsPair(file, recordToMediaFile(rec)) {
+			continue
+		}
+		if rec.PairID == 0 {
+			return rec
+		}
+		leader, err := s.journal.GetFile(rec.PairID)
+		if err != nil {
+			logrus.Errorf("GetFile error: %v", err)
+			continue
+		}
+		if leader != nil {
+			return leader
+		}
+	}
+	return nil
+}
+
+// pairedDestPath returns the destination of a pair member: the base name of
+// the leader's destination with the member's own extension, in the member's
+// own folder or, with pairSameFolder, in the leader's.
+func (s *MediaScanner) pairedDestPath(leaderDest string, file *media.MediaFile) string {
+	if leaderDest == "" {
+		return ""
+	}
+	dir := filepath.Dir(leaderDest)
+	if !s.pairSameFolder {
+		ownDest := s.computeDestPath(file, false, 0)
+		if ownDest == "" {
+			return ""
+		}
+		dir = filepath.Dir(ownDest)
+	}
+	return filepath.Join(dir, media.PairedFilename(leaderDest, file))
+}
+
+// takeOverPair makes the record id, filed at destPath, the leader of the
+// pair formerly led by oldLeader. The old leader and its members are
+// renamed to match.
+func (s *MediaScanner) takeOverPair(id int64, destPath string, seqNum int, oldLeader *db.FileRecord) {
+	members, err := s.journal.GetPairMembers(oldLeader.ID)
+	if err != nil {
+		logrus.Errorf("GetPairMembers error: %v", err)
+		return
+	}
+	for _, rec := range append([]*db.FileRecord{oldLeader}, members...) {
+		s.journal.SetPairLeader(rec.ID, id)
+		s.refile(rec, s.pairedDestPath(destPath, recordToMediaFile(rec)), seqNum, "pair")
+	}
+}
+
+// refilePairMembers moves the members of the pair led by leaderID after the
+// leader's destination changed to leaderDest.
+func (s *MediaScanner) refilePairMembers(leaderID int64, leaderDest string, seqNum int) {
+	members, err := s.journal.GetPairMembers(leaderID)
+	if err != nil {
+		logrus.Errorf("GetPairMembers error: %v", err)
+		return
+	}
+	for _, rec := range members {
+		s.refile(rec, s.pairedDestPath(leaderDest, recordToMediaFile(rec)), seqNum, "pair")
+	}
+}
@@ -0,0 +1,103 @@
+package processor
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// rawBytes returns size bytes of a TIFF-based RAW file without metadata.
+func rawBytes(size int, fill byte) []byte {
+	b := bytes.Repeat([]byte{fill}, size)
+	copy(b, "II*\x00\x08\x00\x00\x00")
+	return b
+}
+
+// movBytes returns size bytes of a QuickTime file without metadata.
+func movBytes(size int, fill byte) []byte {
+	b := bytes.Repeat([]byte{fill}, size)
+	copy(b, "\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00qt  ")
+	return b
+}
+
+// sameName reports whether the files at a and b share a base name.
+func sameName(a, b string) bool {
+	return filepath.Base(stemPath(a, "")) == filepath.Base(stemPath(b, ""))
+}
+
+func TestPairsShareLeaderName(t *testing.T) {
+	e := newTestEnv(t)
+	// The JPEG is walked before its RAW file, which takes the pair over
+	e.write("a/IMG_1.jpg", jpegBytes(5000, 1), baseTime)
+	e.write("a/IMG_1.nef", rawBytes(9000, 1), baseTime)
+	// A Live Photo, its video started a moment before the photo
+	later := baseTime.Add(time.Hour)
+	e.write("c/IMG_9.jpg", jpegBytes(6000, 3), later)
+	e.write("c/IMG_9.mov", movBytes(7000, 3), later.Add(-time.Second))
+
+	result, j := e.scan()
+	if result.ErrorCount != 0 {
+		t.Fatalf("%d errors", result.ErrorCount)
+	}
+	recs := e.records(j)
+	pairs := []struct{ leader, member string }{
+		{"a/IMG_1.nef", "a/IMG_1.jpg"},
+		{"c/IMG_9.jpg", "c/IMG_9.mov"},
+	}
+	for _, p := range pairs {
+		leader, member := recs[p.leader], recs[p.member]
+		if leader.PairID != 0 || member.PairID != leader.ID {
+			t.Errorf("%s paired with %d, %s with %d", p.leader, leader.PairID, p.member, member.PairID)
+		}
+		if !sameName(member.DestPath, leader.DestPath) || member.SequenceNum != leader.SequenceNum {
+			t.Errorf("%s at %s, %s at %s", p.member, e.rel(member.DestPath), p.leader, e.rel(leader.DestPath))
+		}
+		for _, rec := range []string{p.leader, p.member} {
+			if !exists(recs[rec].DestPath) || exists(e.src(rec)) {
+				t.Errorf("%s not moved to %s", rec, e.rel(recs[rec].DestPath))
+			}
+		}
+	}
+	if recs["a/IMG_1.nef"].MediaType != recs["a/IMG_1.jpg"].MediaType || filepath.Ext(recs["a/IMG_1.jpg"].DestPath) != ".jpg" {
+		t.Errorf("JPEG filed at %s", recs["a/IMG_1.jpg"].DestPath)
+	}
+}
+
+func TestPairFollowsLeaderSequence(t *testing.T) {
+	e := newTestEnv(t)
+	e.write("a/IMG_1.jpg", jpegBytes(5000, 1), baseTime)
+	e.write("a/IMG_1.nef", rawBytes(9000, 1), baseTime)
+	_, j := e.scan()
+	first := e.records(j)
+	if seq := first["a/IMG_1.nef"].SequenceNum; seq != 0 {
+		t.Fatalf("lone shot numbered %d", seq)
+	}
+
+	// Another shot with the same name in the same second numbers the pair
+	// retroactively
+	e.write("b/IMG_1.nef", rawBytes(9000, 2), baseTime)
+	result, j := e.scan()
+	if result.ErrorCount != 0 {
+		t.Fatalf("%d errors", result.ErrorCount)
+	}
+	recs := e.records(j)
+	leader, member, other := recs["a/IMG_1.nef"], recs["a/IMG_1.jpg"], recs["b/IMG_1.nef"]
+	if leader.SequenceNum == 0 || other.SequenceNum == 0 || leader.SequenceNum == other.SequenceNum {
+		t.Fatalf("shots numbered %d and %d", leader.SequenceNum, other.SequenceNum)
+	}
+	if leader.DestPath == first["a/IMG_1.nef"].DestPath {
+		t.Fatalf("leader not renamed from %s", e.rel(leader.DestPath))
+	}
+	if !sameName(member.DestPath, leader.DestPath) || member.SequenceNum != leader.SequenceNum {
+		t.Errorf("member at %s, leader at %s", e.rel(member.DestPath), e.rel(leader.DestPath))
+	}
+	for _, rec := range []struct{ old, new string }{
+		{first["a/IMG_1.nef"].DestPath, leader.DestPath},
+		{first["a/IMG_1.jpg"].DestPath, member.DestPath},
+	} {
+		if exists(rec.old) || !exists(rec.new) {
+			t.Errorf("not renamed from %s to %s", e.rel(rec.old), e.rel(rec.new))
+		}
+	}
+}
@@ -0,0 +1,503 @@
+package processor
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"mediaorganizer/pkg/config"
+	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/media"
+)
+
+// PlanEntry is one row of a plan: a source file, where it goes, and what it
+// looked like when the plan was made. Size, ModTime and Hash let apply refuse
+// files that changed in between.
+type PlanEntry struct {
+	Source          string   `json:"source"`
+	Destination     string   `json:"destination"`
+	Size            int64    `json:"size"`
+	ModTime         string   `json:"mtime"`
+	Hash            string   `json:"hash"`
+	Type            string   `json:"type"`
+	Created         string   `json:"created"`
+	DateSource      string   `json:"date_source"`
+	Sequence        int      `json:"sequence"`
+	Duplicate       bool     `json:"duplicate"`
+	DuplicateOf     string   `json:"duplicate_of,omitempty"`     // path of the original
+	DuplicateAction string   `json:"duplicate_action,omitempty"` // duplicate policy
+	NearDuplicateOf string   `json:"near_duplicate_of,omitempty"`
+	Sidecars        []string `json:"sidecars,omitempty"`
+}
+
+// planColumns are the CSV columns of a plan, in the order of PlanEntry.
+var planColumns = []string{
+	"source", "destination", "size", "mtime", "hash", "type", "created", "date_source",
+	"sequence", "duplicate", "duplicate_of", "duplicate_action", "near_duplicate_of", "sidecars",
+}
+
+// sidecarSeparator joins the sidecars of an entry in a CSV plan.
+const sidecarSeparator = "|"
+
+// formatModTime formats a modification time for a plan, in UTC with full
+// precision so that any change is noticed.
+func formatModTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// BuildPlan turns the files of a dry run into plan entries, ordered by source
+// path. Files are hashed as needed, so every entry can be checked on apply.
+func (s *MediaScanner) BuildPlan() ([]PlanEntry, error) {
+	recs, err := s.journal.GetRunFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[int64]string)
+	pathOf := func(id int64) string {
+		if id == 0 {
+			return ""
+		}
+		if path, ok := paths[id]; ok {
+			return path
+		}
+		var path string
+		if rec, err := s.journal.GetFile(id); err == nil && rec != nil {
+			path = rec.SourcePath
+		}
+		paths[id] = path
+		return path
+	}
+
+	var entries []PlanEntry
+	for _, rec := range recs {
+		if rec.Status != db.StatusDryRun && rec.Status != db.StatusSkipped {
+			logrus.Warnf("Not planned (%s): %s %s", rec.Status, rec.SourcePath, rec.ErrorMessage)
+			continue
+		}
+		info, err := os.Stat(rec.SourcePath)
+		if err != nil {
+			logrus.Warnf("Not planned: %v", err)
+			continue
+		}
+		hash := rec.Hash
+		if hash == "" {
+			if hash, err = s.hashFile(rec.SourcePath, false); err != nil {
+				logrus.Warnf("Not planned, could not hash %s: %v", rec.SourcePath, err)
+				continue
+			}
+		}
+
+		entry := PlanEntry{
+			Source:          rec.SourcePath,
+			Destination:     rec.DestPath,
+			Size:            info.Size(),
+			ModTime:         formatModTime(info.ModTime()),
+			Hash:            hash,
+			Type:            rec.MediaType,
+			Created:         rec.CreationTime,
+			DateSource:      rec.DateSource,
+			Sequence:        rec.SequenceNum,
+			Duplicate:       rec.IsDuplicate,
+			DuplicateOf:     pathOf(rec.DuplicateOf),
+			NearDuplicateOf: pathOf(rec.NearDuplicateOf),
+		}
+		if rec.IsDuplicate {
+			entry.DuplicateAction = rec.DuplicateAction
+		}
+		sidecars, err := s.journal.GetSidecars(rec.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, sc := range sidecars {
+			entry.Sidecars = append(entry.Sidecars, sc.SourcePath)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// WritePlan writes a plan to path: CSV if it ends in .csv, JSON Lines otherwise.
+func WritePlan(path string, entries []PlanEntry) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if isCSVPlan(path) {
+		err = writePlanCSV(w, entries)
+	} else {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for i := range entries {
+			if err = enc.Encode(&entries[i]); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func writePlanCSV(w io.Writer, entries []PlanEntry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(planColumns); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := cw.Write([]string{
+			e.Source, e.Destination, strconv.FormatInt(e.Size, 10), e.ModTime, e.Hash,
+			e.Type, e.Created, e.DateSource, strconv.Itoa(e.Sequence), strconv.FormatBool(e.Duplicate),
+			e.DuplicateOf, e.DuplicateAction, e.NearDuplicateOf, strings.Join(e.Sidecars, sidecarSeparator),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadPlan reads a plan written by WritePlan, possibly edited since.
+func ReadPlan(path string) ([]PlanEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if isCSVPlan(path) {
+		return readPlanCSV(f)
+	}
+
+	var entries []PlanEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var e PlanEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+func readPlanCSV(r io.Reader) ([]PlanEntry, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	col := make(map[string]int)
+	for i, name := range header {
+		col[name] = i
+	}
+	for _, name := range []string{"source", "destination", "size", "mtime", "hash"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	var entries []PlanEntry
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := col[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+		e := PlanEntry{
+			Source:          field("source"),
+			Destination:     field("destination"),
+			ModTime:         field("mtime"),
+			Hash:            field("hash"),
+			Type:            field("type"),
+			Created:         field("created"),
+			DateSource:      field("date_source"),
+			DuplicateOf:     field("duplicate_of"),
+			DuplicateAction: field("duplicate_action"),
+			NearDuplicateOf: field("near_duplicate_of"),
+		}
+		if e.Size, err = strconv.ParseInt(field("size"), 10, 64); err != nil {
+			return nil, fmt.Errorf("line %d: invalid size: %w", line, err)
+		}
+		if v := field("sequence"); v != "" {
+			if e.Sequence, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid sequence: %w", line, err)
+			}
+		}
+		if v := field("duplicate"); v != "" {
+			if e.Duplicate, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid duplicate flag: %w", line, err)
+			}
+		}
+		if v := field("sidecars"); v != "" {
+			e.Sidecars = strings.Split(v, sidecarSeparator)
+		}
+		entries = append(entries, e)
+	}
+}
+
+func isCSVPlan(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".csv")
+}
+
+// Apply executes a plan with the mover: every file whose source is unchanged
+// since planning goes to the planned destination. Originals are placed before
+// duplicates, which may be linked to or compared with them.
+func (s *MediaScanner) Apply(entries []PlanEntry) *ScanResult {
+	// Originals of duplicates may be files organized before the plan
+	s.preIndexDestinations()
+
+	// Every row is checked before the first file moves: rows the movers
+	// would race for are refused, not left to whichever mover comes first
+	refused := s.checkDestinations(entries)
+
+	var originals, duplicates []moveJob
+	var duplicateEntries []*PlanEntry
+	for i := range entries {
+		e := &entries[i]
+		err := refused[i]
+		var job *moveJob
+		if err == nil {
+			job, err = s.applyEntry(e)
+		}
+		if err != nil {
+			logrus.Errorf("Refusing %s: %v", e.Source, err)
+			s.result.RefusedFiles++
+			continue
+		}
+		if job == nil {
+			continue
+		}
+		if e.Duplicate || e.NearDuplicateOf != "" {
+			duplicates = append(duplicates, *job)
+			duplicateEntries = append(duplicateEntries, e)
+		} else {
+			originals = append(originals, *job)
+		}
+	}
+
+	// Every planned file is journaled now, so the originals can be found
+	for i, e := range duplicateEntries {
+		job := &duplicates[i]
+		if e.NearDuplicateOf != "" {
+			if rec, err := s.journal.GetBySourcePath(e.NearDuplicateOf); err == nil && rec != nil {
+				s.journal.SetNearDuplicateOf(job.RecordID, rec.ID)
+			}
+		}
+		if e.Duplicate {
+			if rec, err := s.journal.GetBySourcePath(e.DuplicateOf); err == nil && rec != nil {
+				job.DuplicateOf = rec.ID
+			}
+			s.journal.SetDuplicate(job.RecordID, job.DuplicateOf, string(job.DuplicateAction))
+		}
+	}
+
+	for _, jobs := range [][]moveJob{originals, duplicates} {
+		moveCh := make(chan moveJob)
+		moverWg := s.startMovers(moveCh)
+		for _, job := range jobs {
+			moveCh <- job
+		}
+		close(moveCh)
+		moverWg.Wait()
+	}
+
+	refusedFiles := s.result.RefusedFiles
+	result := s.finish()
+	result.RefusedFiles = refusedFiles
+	return result
+}
+
+// checkDestinations returns why entries must be refused for their
+// destination, by index: it is shared with another entry, outside the
+// destination directories, or taken on disk. A destination the journal
+// already has this source at, organized or interrupted, is left to
+// applyEntry.
+func (s *MediaScanner) checkDestinations(entries []PlanEntry) map[int]error {
+	refused := make(map[int]error)
+	byDest := make(map[string][]int)
+	for i, e := range entries {
+		if e.Destination != "" {
+			dest := filepath.Clean(e.Destination)
+			byDest[dest] = append(byDest[dest], i)
+		}
+	}
+	for dest, rows := range byDest {
+		if len(rows) > 1 {
+			for _, i := range rows {
+				refused[i] = fmt.Errorf("destination %s is planned for %d files", dest, len(rows))
+			}
+			continue
+		}
+		i := rows[0]
+		if !s.inDestination(dest) {
+			refused[i] = fmt.Errorf("destination %s is outside the destination directories", dest)
+			continue
+		}
+		if _, err := os.Lstat(dest); err != nil {
+			continue
+		}
+		rec, err := s.journal.GetBySourcePath(entries[i].Source)
+		if err == nil && rec != nil && filepath.Clean(rec.DestPath) == dest &&
+			(rec.Status == db.StatusCompleted || rec.Status == db.StatusInProgress) {
+			continue
+		}
+		refused[i] = fmt.Errorf("destination %s already exists", dest)
+	}
+	return refused
+}
+
+// inDestination reports whether path is inside a directory files are
+// organized into.
+func (s *MediaScanner) inDestination(path string) bool {
+	dirs := []string{s.destination}
+	for _, d := range s.destinationDirs {
+		dirs = append(dirs, d)
+	}
+	for _, d := range s.extensionDirs {
+		dirs = append(dirs, d)
+	}
+	// Relative ones are inside the others
+	for _, d := range []string{s.duplicatesDir, s.nearDuplicatesDir, s.quarantineDir} {
+		if filepath.IsAbs(d) {
+			dirs = append(dirs, d)
+		}
+	}
+	for _, d := range dirs {
+		if d == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(filepath.Clean(d), path); err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEntry checks a plan entry against its source and journals it with the
+// planned destination. It returns nil without error for files the journal
+// already has as organized.
+func (s *MediaScanner) applyEntry(e *PlanEntry) (*moveJob, error) {
+	info, err := os.Stat(e.Source)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != e.Size {
+		return nil, fmt.Errorf("size changed since planning (%d bytes, planned %d)", info.Size(), e.Size)
+	}
+	if formatModTime(info.ModTime()) != e.ModTime {
+		return nil, fmt.Errorf("modified since planning (%s, planned %s)", formatModTime(info.ModTime()), e.ModTime)
+	}
+	// Always read the content: this catches changes that kept the mtime,
+	// which the hash cache would not
+	hash, err := media.ComputeFileHash(e.Source)
+	if err != nil {
+		return nil, err
+	}
+	if hash != e.Hash {
+		return nil, fmt.Errorf("content changed since planning (hash %s, planned %s)", hash, e.Hash)
+	}
+
+	var action config.DuplicatePolicy
+	if e.Duplicate {
+		action = config.DuplicatePolicy(e.DuplicateAction)
+		if action == "" {
+			action = config.DuplicateMove
+		}
+		if !config.IsValidDuplicatePolicy(string(action)) {
+			return nil, fmt.Errorf("invalid duplicate action %q", e.DuplicateAction)
+		}
+		if action == config.DuplicateDelete && s.keepsSource() {
+			return nil, fmt.Errorf("duplicate action delete cannot be combined with --copy or --link")
+		}
+	}
+	if e.Destination == "" && placesDuplicate(action) {
+		return nil, fmt.Errorf("no destination")
+	}
+
+	file, err := s.extractor.Extract(e.Source)
+	if err != nil {
+		logrus.Warnf("Could not read metadata of %s, journaling it without: %v", e.Source, err)
+		file = &media.MediaFile{
+			SourcePath:   e.Source,
+			Type:         media.MediaType(e.Type),
+			FileSize:     e.Size,
+			OriginalName: filepath.Base(e.Source),
+		}
+	}
+	file.Sidecars = e.Sidecars
+	if s.fixExtensions {
+		file.Extension = file.ContentFormat
+	}
+
+	rec := newFileRecord(file, s.sequenceKey(file))
+	rec.Hash = hash
+	rec.DestPath = e.Destination
+	rec.SequenceNum = e.Sequence
+	rec.IsDuplicate = e.Duplicate
+	rec.DuplicateAction = string(action)
+
+	job := &moveJob{
+		File:            file,
+		DestPath:        e.Destination,
+		IsDuplicate:     e.Duplicate,
+		DuplicateAction: action,
+	}
+	job.RecordID, err = s.journal.InsertFile(rec)
+	if err == db.ErrAlreadyExists {
+		// Journaled by an earlier run: take it over unless it was organized
+		existing, gerr := s.journal.GetBySourcePath(e.Source)
+		if gerr != nil || existing == nil {
+			return nil, fmt.Errorf("journal lookup: %v", gerr)
+		}
+		if existing.Status == db.StatusCompleted {
+			logrus.Infof("Already organized: %s -> \n%s", e.Source, existing.DestPath)
+			return nil, nil
+		}
+		if existing.Status == db.StatusInProgress && existing.DestPath != "" {
+			removeTemps(existing.DestPath)
+			job.Interrupted = existing.DestPath == e.Destination
+		}
+		job.RecordID = existing.ID
+		s.journal.UpdateHash(existing.ID, hash)
+		s.journal.UpdateDestPath(existing.ID, e.Destination, e.Sequence, e.Duplicate)
+		s.journal.SetDuplicateAction(existing.ID, string(action))
+		s.journal.UpdateStatus(existing.ID, db.StatusPending, "")
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(e.Sidecars) > 0 {
+		if err := s.journal.InsertSidecars(job.RecordID, e.Sidecars); err != nil {
+			logrus.Errorf("Failed to journal sidecars of %s: %v", e.Source, err)
+		}
+	}
+	return job, nil
+}
@@ -0,0 +1,198 @@
+package processor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/media"
+)
+
+// planned returns the plan entry of the file at path, as planned now, going to
+// dest.
+func planned(t *testing.T, path, dest string) PlanEntry {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := media.ComputeFileHash(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return PlanEntry{
+		Source:      path,
+		Destination: dest,
+		Size:        info.Size(),
+		ModTime:     formatModTime(info.ModTime()),
+		Hash:        hash,
+		Type:        string(media.TypeImage),
+	}
+}
+
+// apply applies entries with a scanner of its own and returns the result and
+// the records by source path relative to the source directory.
+func (e *testEnv) apply(entries []PlanEntry) (*ScanResult, map[string]*db.FileRecord) {
+	e.t.Helper()
+	j := e.openJournal()
+	result := NewMediaScanner(e.cfg, j, nil, false).Apply(entries)
+	return result, e.records(j)
+}
+
+func TestApplyRefusesChangedSources(t *testing.T) {
+	e := newTestEnv(t)
+	dest := func(name string) string { return filepath.Join(e.cfg.DestDirs["image"], name) }
+	var entries []PlanEntry
+	for _, name := range []string{"same.jpg", "size.jpg", "mtime.jpg", "content.jpg"} {
+		entries = append(entries, planned(t, e.write(name, jpegBytes(5000, 1), baseTime), dest(name)))
+	}
+
+	// Changed since planning: grown, touched, and rewritten in place with the
+	// old size and mtime
+	e.write("size.jpg", jpegBytes(5001, 1), baseTime)
+	e.write("mtime.jpg", jpegBytes(5000, 1), baseTime.Add(time.Second))
+	e.write("content.jpg", jpegBytes(5000, 2), baseTime)
+
+	result, recs := e.apply(entries)
+	if result.RefusedFiles != 3 || result.OrganizedFiles != 1 || result.ErrorCount != 0 {
+		t.Fatalf("refused %d, organized %d, %d errors", result.RefusedFiles, result.OrganizedFiles, result.ErrorCount)
+	}
+	if rec := recs["same.jpg"]; rec == nil || rec.Status != db.StatusCompleted || !exists(dest("same.jpg")) {
+		t.Errorf("unchanged file not organized: %+v", rec)
+	}
+	for _, name := range []string{"size.jpg", "mtime.jpg", "content.jpg"} {
+		if rec := recs[name]; rec != nil {
+			t.Errorf("%s journaled %s", name, rec.Status)
+		}
+		if !exists(e.src(name)) || exists(dest(name)) {
+			t.Errorf("changed %s moved", name)
+		}
+	}
+}
+
+func TestApplyRefusesSharedAndTakenDestinations(t *testing.T) {
+	e := newTestEnv(t)
+	dest := func(name string) string { return filepath.Join(e.cfg.DestDirs["image"], name) }
+	entry := func(name string, fill byte, dest string) PlanEntry {
+		return planned(t, e.write(name, jpegBytes(5000, fill), baseTime), dest)
+	}
+	taken := jpegBytes(100, 9)
+	writeFile(t, dest("taken.jpg"), taken, baseTime)
+	entries := []PlanEntry{
+		entry("a.jpg", 1, dest("shared.jpg")),
+		entry("b.jpg", 2, dest("sub/../shared.jpg")),
+		entry("c.jpg", 3, dest("taken.jpg")),
+		entry("d.jpg", 4, filepath.Join(e.root, "elsewhere", "d.jpg")),
+		entry("e.jpg", 5, dest("e.jpg")),
+	}
+
+	result, recs := e.apply(entries)
+	if result.RefusedFiles != 4 || result.OrganizedFiles != 1 || result.ErrorCount != 0 {
+		t.Fatalf("refused %d, organized %d, %d errors", result.RefusedFiles, result.OrganizedFiles, result.ErrorCount)
+	}
+	if rec := recs["e.jpg"]; rec == nil || rec.Status != db.StatusCompleted {
+		t.Errorf("e.jpg not organized: %+v", rec)
+	}
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg"} {
+		if !exists(e.src(name)) {
+			t.Errorf("refused %s moved", name)
+		}
+	}
+	if exists(dest("shared.jpg")) || exists(filepath.Join(e.root, "elsewhere")) {
+		t.Errorf("refused file placed")
+	}
+	if got, _ := os.ReadFile(dest("taken.jpg")); !bytes.Equal(got, taken) {
+		t.Errorf("existing destination overwritten")
+	}
+}
+
+func TestPlanRoundTrip(t *testing.T) {
+	for _, ext := range []string{".csv", ".jsonl"} {
+		t.Run(ext, func(t *testing.T) {
+			e := newTestEnv(t)
+			contents := map[string][]byte{
+				"a/IMG_1.jpg": jpegBytes(5000, 1),
+				"a/IMG_2.jpg": jpegBytes(6000, 2),
+				"b/IMG_1.jpg": jpegBytes(5000, 1), // a duplicate
+				"b/IMG_3.jpg": jpegBytes(7000, 3),
+			}
+			for rel, content := range contents {
+				e.write(rel, content, baseTime)
+			}
+			e.write("a/IMG_1.xmp", []byte("<x:xmpmeta/>"), baseTime)
+
+			// Planned with a journal of its own, as the plan command does
+			e.cfg.DryRun = true
+			j, err := db.InitJournal(filepath.Join(e.root, "plan.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer j.Close()
+			if _, err := j.StartRun("test", "plan", "{}"); err != nil {
+				t.Fatal(err)
+			}
+			s := NewMediaScanner(e.cfg, j, nil, false)
+			s.Scan()
+			entries, err := s.BuildPlan()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(contents) {
+				t.Fatalf("%d entries, want %d", len(entries), len(contents))
+			}
+
+			path := filepath.Join(e.root, "plan"+ext)
+			if err := WritePlan(path, entries); err != nil {
+				t.Fatal(err)
+			}
+			read, err := ReadPlan(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(read, entries) {
+				t.Fatalf("read back\n%+v\nwritten\n%+v", read, entries)
+			}
+
+			e.cfg.DryRun = false
+			result, recs := e.apply(read)
+			if result.RefusedFiles != 0 || result.ErrorCount != 0 {
+				t.Fatalf("refused %d, %d errors", result.RefusedFiles, result.ErrorCount)
+			}
+			for _, entry := range read {
+				rel, _ := filepath.Rel(e.cfg.SourceDir, entry.Source)
+				rel = filepath.ToSlash(rel)
+				rec := recs[rel]
+				if rec == nil || rec.Status != db.StatusCompleted || rec.DestPath != entry.Destination {
+					t.Errorf("%s: %+v, planned to %s", rel, rec, entry.Destination)
+					continue
+				}
+				if got, err := os.ReadFile(entry.Destination); err != nil || !bytes.Equal(got, contents[rel]) {
+					t.Errorf("%s not at %s: %v", rel, entry.Destination, err)
+				}
+				if exists(entry.Source) {
+					t.Errorf("%s not moved", rel)
+				}
+				if rel == "a/IMG_1.jpg" {
+					if len(entry.Sidecars) != 1 {
+						t.Errorf("sidecars %v", entry.Sidecars)
+					}
+					xmp := strings.TrimSuffix(entry.Destination, ".jpg") + ".xmp"
+					if !exists(xmp) {
+						t.Errorf("sidecar not at %s", xmp)
+					}
+				}
+				if want := rel == "b/IMG_1.jpg"; rec.IsDuplicate != want || entry.Duplicate != want {
+					t.Errorf("%s duplicate %v, planned %v", rel, rec.IsDuplicate, entry.Duplicate)
+				}
+			}
+			if n := len(dirFiles(t, e.cfg.SourceDir)); n != 0 {
+				t.Errorf("%d files left in the source", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package processor
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflinkFile creates destPath as a copy-on-write clone of srcPath
+// (clonefile). It fails on file systems other than APFS.
+func reflinkFile(srcPath, destPath string) error {
+	if err := unix.Clonefile(srcPath, destPath, unix.CLONE_NOFOLLOW); err != nil {
+		return &os.LinkError{Op: "reflink", Old: srcPath, New: destPath, Err: err}
+	}
+	return nil
+}
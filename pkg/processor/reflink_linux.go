@@ -0,0 +1,28 @@
+package processor
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflinkFile creates destPath as a copy-on-write clone of srcPath (FICLONE).
+// It fails on file systems without reflinks, like ext4, and across devices.
+func reflinkFile(srcPath, destPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dest, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlFileClone(int(dest.Fd()), int(src.Fd())); err != nil {
+		dest.Close()
+		os.Remove(destPath)
+		return &os.LinkError{Op: "reflink", Old: srcPath, New: destPath, Err: err}
+	}
+	return dest.Close()
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package processor
+
+import (
+	"errors"
+	"os"
+)
+
+// reflinkFile is not supported on this platform.
+func reflinkFile(srcPath, destPath string) error {
+	return &os.LinkError{Op: "reflink", Old: srcPath, New: destPath, Err: errors.New("not supported on this platform")}
+}
@@ -0,0 +1,22 @@
+package processor
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// renameNoReplace renames oldPath to newPath, failing with an error that
+// matches fs.ErrExist if newPath exists (renameatx_np with RENAME_EXCL).
+// File systems that don't support the flag get linkRename instead.
+func renameNoReplace(oldPath, newPath string) error {
+	err := unix.RenameatxNp(unix.AT_FDCWD, oldPath, unix.AT_FDCWD, newPath, unix.RENAME_EXCL)
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOTSUP) {
+		return linkRename(oldPath, newPath)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package processor
+
+// renameNoReplace renames oldPath to newPath, failing with an error that
+// matches fs.ErrExist if newPath exists.
+func renameNoReplace(oldPath, newPath string) error {
+	return linkRename(oldPath, newPath)
+}
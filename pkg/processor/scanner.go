@@ -1,6 +1,7 @@
 package processor
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,70 +14,146 @@ import (
 
 	"github.com/sirupsen/logrus"
 
+	"mediaorganizer/pkg/config"
 	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/filter"
 	"mediaorganizer/pkg/media"
 )
 
+// SkipUnreadable is the reason counted in ScanResult.SkippedByReason for
+// files whose metadata could not be read.
+const SkipUnreadable = "unreadable"
+
 type ScanResult struct {
-	TotalFiles     int
-	ProcessedFiles int
-	SkippedFiles   int
-	OrganizedFiles int
-	ErrorCount     int
-	DuplicateCount int
-	StartTime      time.Time
-	EndTime        time.Time
+	TotalFiles         int
+	ProcessedFiles     int
+	SkippedFiles       int
+	OrganizedFiles     int
+	ErrorCount         int
+	DuplicateCount     int
+	DuplicateActions   map[string]int // Handled duplicates per duplicate policy
+	NearDuplicateCount int
+	QuarantinedCount   int            // Copies that failed verification
+	RefusedFiles       int            // Plan entries whose source changed since planning
+	SidecarFiles       int            // Sidecars organized alongside their media files
+	SkippedByReason    map[string]int // Skipped files per filter.Reason* or SkipUnreadable
+	StartTime          time.Time
+	EndTime            time.Time
 }
 
 type MediaScanner struct {
-	sourceDir        string
-	destination      string // Unified destination for date_first scheme
-	destinationDirs  map[string]string
-	extensionDirs    map[string]string
-	scheme           string
-	spaceReplacement string
-	noOriginalName   bool
-	duplicatesDir    string
-	dryRun           bool
-	copyFiles        bool
-	deleteEmptyDirs  bool
-	concurrency      int
-	journal          *db.Journal
-	resumeMode       bool
-	result           ScanResult
-	totalFiles       int32 // Atomic counter for discovered files
-	processed        int32 // Atomic counter for metadata-extracted files
-	organized        int32 // Atomic counter for moved/copied files
+	sourceDir         string
+	destination       string // Unified destination for date_first and template schemes
+	destinationDirs   map[string]string
+	extensionDirs     map[string]string
+	scheme            string
+	pathTemplate      *media.PathTemplate // Parsed template for the template scheme
+	audioScheme       string              // "music" to organize tagged audio by artist/album
+	extractor         *media.Extractor
+	pairFiles         bool // give RAW+JPEG and Live Photo pairs one base name
+	pairSameFolder    bool // put pair members in the folder of the pair leader
+	spaceReplacement  string
+	noOriginalName    bool
+	fixExtensions     bool // organize mislabeled files under the extension of their content
+	duplicatesDir     string
+	duplicatePolicy   config.DuplicatePolicy
+	nearDuplicates    bool        // detect near-duplicate images by perceptual hash
+	nearDistance      int         // maximum Hamming distance between near-duplicates
+	nearDuplicatesDir string      // review folder for near-duplicates
+	phashes           *phashIndex // perceptual hashes of primary images, organizer only
+	dryRun            bool
+	copyFiles         bool
+	linkMode          string // hard, reflink or symlink to link instead of moving
+	verify            bool
+	quarantineDir     string
+	deleteEmptyDirs   bool
+	concurrency       int
+	hashJobs          int           // files hashed at once, independent of concurrency
+	hashCache         *db.HashCache // hashes of unchanged files from earlier runs; nil to always hash
+	watchSettle       time.Duration // how long a new file must stay unchanged in watch mode
+	watcher           *watcher      // nil unless watching
+	filterRules       filter.Rules
+	sourceFilter      *filter.Matcher // include/exclude, size, depth and .mediaignore rules for the source
+	dateFrom, dateTo  string          // creation date range, YYYY-MM-DD; empty for open-ended
+	skipMu            sync.Mutex
+	recordLocks       sync.Map       // *sync.Mutex per record ID, see lockRecord
+	skipped           map[string]int // files skipped per reason, walker and organizer
+	journal           *db.Journal
+	resumeMode        bool
+	result            ScanResult
+	totalFiles        int32 // Atomic counter for discovered files
+	processed         int32 // Atomic counter for metadata-extracted files
+	organized         int32 // Atomic counter for moved/copied files
+}
+
+// walkEntry is a media file found by the walker, with its sidecar files.
+type walkEntry struct {
+	Index    int // position in walk order
+	Path     string
+	Sidecars []string
 }
 
 type metadataResult struct {
-	File *media.MediaFile
-	Err  error
+	Index int
+	Path  string
+	File  *media.MediaFile
+	Err   error
 }
 
 type moveJob struct {
-	RecordID    int64
-	File        *media.MediaFile
-	DestPath    string
-	IsDuplicate bool
+	RecordID        int64
+	File            *media.MediaFile
+	DestPath        string
+	IsDuplicate     bool
+	DuplicateOf     int64                  // record ID of the original of a duplicate
+	DuplicateAction config.DuplicatePolicy // policy for a duplicate; empty means move
+	Interrupted     bool                   // a previous run was killed while transferring it
 }
 
-func NewMediaScanner(sourceDir string, destination string, destDirs map[string]string, extensionDirs map[string]string, scheme string, spaceReplacement string, noOriginalName bool, duplicatesDir string, dryRun bool, copyFiles bool, concurrency int, deleteEmptyDirs bool, journal *db.Journal, resumeMode bool) *MediaScanner {
+func NewMediaScanner(cfg *config.Config, journal *db.Journal, hashCache *db.HashCache, resumeMode bool) *MediaScanner {
+	rules := filter.Rules{
+		Include:  cfg.Include,
+		Exclude:  cfg.Exclude,
+		MinSize:  cfg.MinSizeBytes,
+		MaxSize:  cfg.MaxSizeBytes,
+		MaxDepth: cfg.MaxDepth,
+	}
 	return &MediaScanner{
-		sourceDir:        sourceDir,
-		destination:      destination,
-		destinationDirs:  destDirs,
-		extensionDirs:    extensionDirs,
-		scheme:           scheme,
-		spaceReplacement: spaceReplacement,
-		noOriginalName:   noOriginalName,
-		duplicatesDir:    duplicatesDir,
-		dryRun:           dryRun,
-		copyFiles:        copyFiles,
-		deleteEmptyDirs:  deleteEmptyDirs,
-		concurrency:      concurrency,
-		journal:          journal,
-		resumeMode:       resumeMode,
+		sourceDir:         cfg.SourceDir,
+		destination:       cfg.Destination,
+		destinationDirs:   cfg.DestDirs,
+		extensionDirs:     cfg.ExtensionDirs,
+		scheme:            string(cfg.OrganizationScheme),
+		pathTemplate:      cfg.Template,
+		audioScheme:       cfg.AudioScheme,
+		extractor:         media.NewExtractor(cfg.DateSources),
+		pairFiles:         !cfg.NoPairing,
+		pairSameFolder:    cfg.PairSameFolder,
+		spaceReplacement:  cfg.SpaceReplacement,
+		noOriginalName:    cfg.NoOriginalName,
+		fixExtensions:     cfg.FixExtensions,
+		duplicatesDir:     cfg.DuplicatesDir,
+		duplicatePolicy:   cfg.DuplicatePolicy,
+		nearDuplicates:    cfg.NearDuplicates,
+		nearDistance:      cfg.NearDuplicateDist,
+		nearDuplicatesDir: cfg.NearDuplicatesDir,
+		dryRun:            cfg.DryRun,
+		copyFiles:         cfg.CopyFiles,
+		linkMode:          cfg.Link,
+		verify:            cfg.Verify,
+		quarantineDir:     cfg.QuarantineDir,
+		deleteEmptyDirs:   cfg.DeleteEmptyDirs,
+		concurrency:       cfg.ConcurrentJobs,
+		hashJobs:          cfg.HashJobs,
+		hashCache:         hashCache,
+		watchSettle:       cfg.WatchSettle,
+		filterRules:       rules,
+		sourceFilter:      filter.New(cfg.SourceDir, rules),
+		dateFrom:          cfg.DateFrom,
+		dateTo:            cfg.DateTo,
+		skipped:           make(map[string]int),
+		journal:           journal,
+		resumeMode:        resumeMode,
 		result: ScanResult{
 			StartTime: time.Now(),
 		},
@@ -84,6 +161,12 @@ func NewMediaScanner(sourceDir string, destination string, destDirs map[string]s
 }
 
 func (s *MediaScanner) Scan() *ScanResult {
+	return s.run(nil)
+}
+
+// run organizes the files of the source directory. With a watcher, it then
+// keeps organizing new files until the watcher stops.
+func (s *MediaScanner) run(w *watcher) *ScanResult {
 	logrus.Debugf("Scanner.Scan() started")
 
 	logrus.Debugf("Source directory: %s", s.sourceDir)
@@ -97,6 +180,10 @@ func (s *MediaScanner) Scan() *ScanResult {
 	// Pre-index destination directories for cross-scan duplicate detection
 	s.preIndexDestinations()
 
+	if s.nearDuplicates {
+		s.loadPHashIndex()
+	}
+
 	// Resume support: load completed paths and pending records
 	var completedPaths map[string]bool
 	var pendingRecords []*db.FileRecord
@@ -126,7 +213,7 @@ func (s *MediaScanner) Scan() *ScanResult {
 	}
 
 	// Pipeline channels
-	pathsCh := make(chan string, 100)
+	pathsCh := make(chan walkEntry, 100)
 	metaCh := make(chan metadataResult, 100)
 	moveCh := make(chan moveJob, 100)
 
@@ -135,12 +222,27 @@ func (s *MediaScanner) Scan() *ScanResult {
 	// and to avoid following symlinks (which can cause infinite loops).
 	go func() {
 		defer close(pathsCh)
+		index := 0
+		emit := func(path string, sidecars []string) {
+			atomic.AddInt32(&s.totalFiles, 1)
+			pathsCh <- walkEntry{Index: index, Path: path, Sidecars: sidecars}
+			index++
+		}
+
+		sidecars := &sidecarIndex{}
 		filepath.WalkDir(s.sourceDir, func(path string, d os.DirEntry, err error) error {
 			if err != nil {
 				logrus.Errorf("Error accessing path %s: %v", path, err)
 				return nil
 			}
 			if d.IsDir() {
+				if reason := s.sourceFilter.SkipDir(path); reason != "" {
+					logrus.Debugf("Skipping folder %s (%s)", path, reason)
+					return filepath.SkipDir
+				}
+				if w != nil {
+					w.addDir(path)
+				}
 				return nil
 			}
 
@@ -149,20 +251,11 @@ func (s *MediaScanner) Scan() *ScanResult {
 				return nil
 			}
 
-			// Skip macOS hidden files
-			fileName := filepath.Base(path)
-			if strings.HasPrefix(fileName, "._") {
+			if !isSourceMediaFile(path) {
 				return nil
 			}
-
-			// Skip the journal database file
-			if strings.HasSuffix(path, ".mediaorganizer.db") ||
-				strings.HasSuffix(path, ".mediaorganizer.db-wal") ||
-				strings.HasSuffix(path, ".mediaorganizer.db-shm") {
-				return nil
-			}
-
-			if media.DetermineMediaType(path) == media.TypeUnknown {
+			if reason := s.filterFile(path, d); reason != "" {
+				s.countSkip(reason, path)
 				return nil
 			}
 
@@ -172,10 +265,18 @@ func (s *MediaScanner) Scan() *ScanResult {
 				return nil
 			}
 
-			atomic.AddInt32(&s.totalFiles, 1)
-			pathsCh <- path
+			// A file still being written is left to the watcher until it settles
+			if w != nil && w.deferUnsettled(path, d) {
+				return nil
+			}
+
+			emit(path, sidecars.lookup(path))
 			return nil
 		})
+
+		if w != nil {
+			w.watch(emit)
+		}
 	}()
 
 	// --- Stage 2: Metadata worker goroutines ---
@@ -184,14 +285,25 @@ func (s *MediaScanner) Scan() *ScanResult {
 		metaWg.Add(1)
 		go func() {
 			defer metaWg.Done()
-			for filePath := range pathsCh {
-				mf, err := media.ExtractFileMetadata(filePath)
+			for entry := range pathsCh {
+				mf, err := s.extractor.Extract(entry.Path)
 				if err != nil {
-					logrus.Errorf("Error extracting metadata for %s: %v", filePath, err)
-					metaCh <- metadataResult{Err: fmt.Errorf("%s: %w", filePath, err)}
+					logrus.Errorf("Error extracting metadata for %s: %v", entry.Path, err)
+					metaCh <- metadataResult{Index: entry.Index, Path: entry.Path, Err: fmt.Errorf("%s: %w", entry.Path, err)}
 					continue
 				}
-				metaCh <- metadataResult{File: mf}
+				mf.Sidecars = entry.Sidecars
+				if s.fixExtensions {
+					mf.Extension = mf.ContentFormat
+				}
+				if s.nearDuplicates && mf.Type == media.TypeImage {
+					if h, err := media.ComputePerceptualHash(entry.Path); err != nil {
+						logrus.Debugf("No perceptual hash for %s: %v", entry.Path, err)
+					} else {
+						mf.PerceptualHash = h
+					}
+				}
+				metaCh <- metadataResult{Index: entry.Index, Path: entry.Path, File: mf}
 			}
 		}()
 	}
@@ -202,6 +314,27 @@ func (s *MediaScanner) Scan() *ScanResult {
 		close(metaCh)
 	}()
 
+	// Restore walk order, so that sequence numbers, duplicate originals and
+	// pair leaders don't depend on which worker finished first
+	orderedCh := make(chan metadataResult, 100)
+	go func() {
+		defer close(orderedCh)
+		waiting := make(map[int]metadataResult)
+		next := 0
+		for mr := range metaCh {
+			waiting[mr.Index] = mr
+			for {
+				r, ok := waiting[next]
+				if !ok {
+					break
+				}
+				delete(waiting, next)
+				orderedCh <- r
+				next++
+			}
+		}
+	}()
+
 	// --- Stage 3: Organizer goroutine (single, serializes all DB writes) ---
 	var organizerWg sync.WaitGroup
 	organizerWg.Add(1)
@@ -211,6 +344,14 @@ func (s *MediaScanner) Scan() *ScanResult {
 
 		// First: re-queue pending records from resume
 		for _, rec := range pendingRecords {
+			interrupted := rec.Status == db.StatusInProgress
+			if interrupted && rec.DestPath != "" {
+				// Copies are written under a temporary name; drop a partial one
+				if n := removeTemps(rec.DestPath); n > 0 {
+					logrus.Infof("Resume: removed %d partial copies of %s", n, rec.DestPath)
+				}
+			}
+
 			// Verify source file still exists before re-queuing
 			if _, err := os.Stat(rec.SourcePath); os.IsNotExist(err) {
 				// Source is gone — check if dest already has the file (previous move succeeded but status wasn't updated)
@@ -229,142 +370,32 @@ func (s *MediaScanner) Scan() *ScanResult {
 
 			mf := recordToMediaFile(rec)
 			moveCh <- moveJob{
-				RecordID:    rec.ID,
-				File:        mf,
-				DestPath:    rec.DestPath,
-				IsDuplicate: rec.IsDuplicate,
+				RecordID:        rec.ID,
+				File:            mf,
+				DestPath:        rec.DestPath,
+				IsDuplicate:     rec.IsDuplicate,
+				DuplicateOf:     rec.DuplicateOf,
+				DuplicateAction: config.DuplicatePolicy(rec.DuplicateAction),
+				Interrupted:     interrupted,
 			}
 		}
 
-		// Then: process new metadata results
-		for mr := range metaCh {
-			if mr.Err != nil {
-				s.result.ErrorCount++
-				s.result.SkippedFiles++
-				continue
-			}
-
-			file := mr.File
-			atomic.AddInt32(&s.processed, 1)
-			s.result.ProcessedFiles++
-
-			// Build timestamp key
-			tsKey := file.CreationTime.Format("20060102-150405") + "_" + string(file.Type) + "_" + filepath.Ext(file.SourcePath)
-
-			// Insert into journal
-			rec := &db.FileRecord{
-				SourcePath:      file.SourcePath,
-				FileSize:        file.FileSize,
-				MediaType:       string(file.Type),
-				Extension:       file.GetExtension(),
-				CreationTime:    file.CreationTime.Format("2006-01-02 15:04:05"),
-				LargerDimension: file.LargerDimension,
-				OriginalName:    file.OriginalName,
-				TimestampKey:    tsKey,
-				Status:          db.StatusPending,
-			}
-
-			id, err := s.journal.InsertFile(rec)
-			if err != nil {
-				if err == db.ErrAlreadyExists {
-					logrus.Debugf("Skipping already-journaled file: %s", file.SourcePath)
-					continue
-				}
-				logrus.Errorf("Failed to insert journal record for %s: %v", file.SourcePath, err)
-				s.result.ErrorCount++
-				continue
-			}
-
-			// --- Lazy hashing ---
-			sizeCount, err := s.journal.CountByFileSize(file.FileSize)
-			if err != nil {
-				logrus.Errorf("CountByFileSize error: %v", err)
-			}
-
-			var fileHash string
-			if sizeCount >= 2 {
-				// Hash the new file
-				h, err := media.ComputeFileHash(file.SourcePath)
-				if err != nil {
-					logrus.Warnf("Could not hash %s: %v", file.SourcePath, err)
-				} else {
-					fileHash = h
-					s.journal.UpdateHash(id, fileHash)
-				}
-
-				// Backfill unhashed files with same size (includes pre-indexed destination files)
-				unhashed, err := s.journal.GetUnhashedByFileSize(file.FileSize)
-				if err != nil {
-					logrus.Errorf("GetUnhashedByFileSize error: %v", err)
-				}
-				for _, ur := range unhashed {
-					// Only hash from source paths to avoid racing with mover goroutines.
-					// For dest_index rows, source_path IS the dest path (safe, already written).
-					hashPath := ur.SourcePath
-					if _, statErr := os.Stat(hashPath); os.IsNotExist(statErr) {
-						// Source gone — skip to avoid reading a partially-written dest file mid-move.
-						logrus.Debugf("Skipping backfill hash for missing source: %s", ur.SourcePath)
-						continue
-					}
-					bh, err := media.ComputeFileHash(hashPath)
-					if err != nil {
-						logrus.Warnf("Could not backfill hash for %s: %v", ur.SourcePath, err)
-						continue
-					}
-					s.journal.UpdateHash(ur.ID, bh)
-				}
-			}
-
-			// --- Global dedup ---
-			isDuplicate := false
-			if fileHash != "" {
-				matches, err := s.journal.GetByHash(fileHash)
-				if err != nil {
-					logrus.Errorf("GetByHash error: %v", err)
-				}
-				// If there's another record with the same hash (not this one), it's a duplicate
-				for _, m := range matches {
-					if m.ID != id {
-						isDuplicate = true
-						logrus.Debugf("Duplicate detected (hash %s): %s", fileHash[:12], file.SourcePath)
-						break
-					}
-				}
-			}
-
-			// --- Sequence numbering ---
-			tsCount, err := s.journal.CountByTimestampKey(tsKey)
-			if err != nil {
-				logrus.Errorf("CountByTimestampKey error: %v", err)
-			}
-			seqNum := 0
-			if tsCount > 1 {
-				seqNum = tsCount
-
-				// When we transition from 1→2 files sharing a timestamp,
-				// retroactively assign _001 to the first file so the naming
-				// is consistent (_001, _002, _003, ...) instead of (none, _002, _003).
-				if tsCount == 2 {
-					s.retroFixFirstSequence(tsKey, moveCh)
-				}
-			}
+		// Then: organize new files, hashing them on the hash workers meanwhile
+		s.organize(orderedCh, moveCh)
+	}()
 
-			// --- Compute destination path ---
-			destPath := s.computeDestPath(file, isDuplicate, seqNum)
+	// --- Stage 4: Mover worker goroutines ---
+	moverWg := s.startMovers(moveCh)
 
-			// Update journal
-			s.journal.UpdateDestPath(id, destPath, seqNum, isDuplicate)
+	// Wait for all stages to complete
+	moverWg.Wait()
 
-			moveCh <- moveJob{
-				RecordID:    id,
-				File:        file,
-				DestPath:    destPath,
-				IsDuplicate: isDuplicate,
-			}
-		}
-	}()
+	return s.finish()
+}
 
-	// --- Stage 4: Mover worker goroutines ---
+// startMovers starts the mover workers, which execute the jobs until the
+// channel is closed.
+func (s *MediaScanner) startMovers(moveCh <-chan moveJob) *sync.WaitGroup {
 	var moverWg sync.WaitGroup
 	for i := 0; i < s.concurrency; i++ {
 		moverWg.Add(1)
@@ -372,15 +403,21 @@ func (s *MediaScanner) Scan() *ScanResult {
 			defer moverWg.Done()
 			for job := range moveCh {
 				s.executeMoveJob(job)
+				s.release(job.File.SourcePath)
 			}
 		}()
 	}
+	return &moverWg
+}
 
-	// Wait for all stages to complete
-	moverWg.Wait()
+// finish wraps up a run once all files are moved: leftover sidecars, empty
+// source directories and the result.
+func (s *MediaScanner) finish() *ScanResult {
+	// Sidecars left behind by an interrupted run whose media file was organized
+	s.moveLeftoverSidecars()
 
 	// Delete empty directories if enabled
-	if s.deleteEmptyDirs && !s.dryRun && !s.copyFiles {
+	if s.deleteEmptyDirs && !s.dryRun && !s.keepsSource() {
 		logrus.Infof("Cleaning up empty directories in source...")
 		s.cleanupEmptyDirectories()
 	}
@@ -393,20 +430,146 @@ func (s *MediaScanner) Scan() *ScanResult {
 	// Populate result from journal stats
 	s.populateResultFromJournal()
 
+	s.skipMu.Lock()
+	s.result.SkippedByReason = s.skipped
+	for _, n := range s.skipped {
+		s.result.SkippedFiles += n
+	}
+	s.skipMu.Unlock()
+
 	s.result.EndTime = time.Now()
 	logrus.Debugf("Scan complete, processed %d files", s.result.ProcessedFiles)
 	return &s.result
 }
 
+// isSourceMediaFile reports whether the file at path is a media file to
+// organize, rather than a journal, partial copy or macOS resource fork file.
+// Files with a missing or unknown extension are recognized by their content.
+func isSourceMediaFile(path string) bool {
+	// Skip macOS hidden files
+	if strings.HasPrefix(filepath.Base(path), "._") {
+		return false
+	}
+
+	// Skip the journal database file
+	if strings.HasSuffix(path, ".mediaorganizer.db") ||
+		strings.HasSuffix(path, ".mediaorganizer.db-wal") ||
+		strings.HasSuffix(path, ".mediaorganizer.db-shm") {
+		return false
+	}
+
+	// Skip copies still being written, which would sniff as media
+	if strings.HasSuffix(path, tempSuffix) {
+		return false
+	}
+
+	return media.DetectMediaType(path) != media.TypeUnknown
+}
+
+// filterFile returns why the filters skip the file at path, or "" if they
+// don't. The file is only stat'ed if a size limit is set.
+func (s *MediaScanner) filterFile(path string, d os.DirEntry) string {
+	var size int64
+	if s.sourceFilter.NeedsSize() {
+		info, err := d.Info()
+		if err != nil {
+			return ""
+		}
+		size = info.Size()
+	}
+	return s.sourceFilter.SkipFile(path, size)
+}
+
+// outsideDateRange reports whether file was created before --date-from or
+// after --date-to.
+func (s *MediaScanner) outsideDateRange(file *media.MediaFile) bool {
+	day := file.CreationTime.Format("2006-01-02")
+	return (s.dateFrom != "" && day < s.dateFrom) || (s.dateTo != "" && day > s.dateTo)
+}
+
+// countSkip counts a file left alone for reason.
+func (s *MediaScanner) countSkip(reason, path string) {
+	logrus.Debugf("Skipping %s (%s)", path, reason)
+	s.skipMu.Lock()
+	defer s.skipMu.Unlock()
+	s.skipped[reason]++
+}
+
+// sequenceKey returns the key that groups files competing for the same
+// destination name. Files sharing a key get sequence suffixes (_001, _002, ...).
+func (s *MediaScanner) sequenceKey(file *media.MediaFile) string {
+	if s.usesMusicLayout(file) {
+		return "music:" + file.GetMusicPath(0)
+	}
+	if s.scheme == string(config.SchemeTemplate) && s.pathTemplate != nil {
+		// Any two files rendering to the same path collide, whatever their timestamps
+		relPath, err := s.pathTemplate.Render(file, 0, s.spaceReplacement, s.noOriginalName)
+		if err != nil {
+			// It fails to get a destination; don't group it with others that did too
+			return "template-error:" + file.SourcePath
+		}
+		return "template:" + relPath
+	}
+	return file.CreationTime.Format("20060102-150405") + "_" + string(file.Type) + "_" + extensionOf(file)
+}
+
+// extensionOf returns the extension file is organized under, with the dot: the
+// corrected one, or the source's in its original case.
+func extensionOf(file *media.MediaFile) string {
+	if file.Extension != "" {
+		return "." + file.Extension
+	}
+	return filepath.Ext(file.SourcePath)
+}
+
+// usesMusicLayout reports whether file goes to the artist/album layout.
+func (s *MediaScanner) usesMusicLayout(file *media.MediaFile) bool {
+	return s.audioScheme == config.AudioSchemeMusic && file.HasMusicTags()
+}
+
+// usesUnifiedDestination reports whether all media types go to s.destination.
+func (s *MediaScanner) usesUnifiedDestination() bool {
+	if s.destination == "" {
+		return false
+	}
+	return s.scheme == string(config.SchemeDateFirst) || s.scheme == string(config.SchemeTemplate)
+}
+
 func (s *MediaScanner) computeDestPath(file *media.MediaFile, isDuplicate bool, seqNum int) string {
-	ext := filepath.Ext(file.SourcePath)
+	if isDuplicate {
+		return s.computeSetAsideDestPath(file, s.duplicatesDir, seqNum)
+	}
+	return s.computeSetAsideDestPath(file, "", seqNum)
+}
+
+// computeNearDuplicatePath returns the destination of a near-duplicate image
+// in the review folder.
+func (s *MediaScanner) computeNearDuplicatePath(file *media.MediaFile, seqNum int) string {
+	return s.computeSetAsideDestPath(file, s.nearDuplicatesDir, seqNum)
+}
+
+// recordDestPath recomputes the destination of a journaled file with a new
+// sequence number, keeping it in the folder it was set aside to.
+func (s *MediaScanner) recordDestPath(rec *db.FileRecord, seqNum int) string {
+	if rec.NearDuplicateOf != 0 {
+		return s.computeNearDuplicatePath(recordToMediaFile(rec), seqNum)
+	}
+	return s.computeDestPath(recordToMediaFile(rec), rec.IsDuplicate, seqNum)
+}
+
+// computeSetAsideDestPath computes the destination of a file. Files set aside
+// for review (duplicates, near-duplicates) go below setAsideDir, a directory
+// name relative to the destination or an absolute path; regular files pass "".
+func (s *MediaScanner) computeSetAsideDestPath(file *media.MediaFile, setAsideDir string, seqNum int) string {
+	isDuplicate := setAsideDir != ""
+	ext := extensionOf(file)
 	if len(ext) > 0 {
 		ext = ext[1:] // Remove leading dot
 	}
 
 	// Get base destination directory
 	var baseDestDir string
-	if s.scheme == "date_first" && s.destination != "" {
+	if s.usesUnifiedDestination() {
 		baseDestDir = s.destination
 	} else {
 		baseDestDir = s.destinationDirs[string(file.Type)]
@@ -422,19 +585,72 @@ func (s *MediaScanner) computeDestPath(file *media.MediaFile, isDuplicate bool,
 		extensionDir = s.extensionDirs[ext]
 	}
 
-	fileDir := file.GetDestinationPath(baseDestDir, extensionDir, isDuplicate, s.scheme, s.duplicatesDir)
+	if s.usesMusicLayout(file) {
+		return joinRelativeDestPath(baseDestDir, extensionDir, file.GetMusicPath(seqNum), setAsideDir)
+	}
+
+	if s.scheme == string(config.SchemeTemplate) && s.pathTemplate != nil {
+		return s.computeTemplateDestPath(file, baseDestDir, extensionDir, setAsideDir, seqNum)
+	}
+
+	fileDir := file.GetDestinationPath(baseDestDir, extensionDir, isDuplicate, s.scheme, setAsideDir)
 	fileName := file.GetNewFilename(s.scheme, s.spaceReplacement, s.noOriginalName)
 
 	// Add sequence suffix (_001, _002, ...) for files sharing a timestamp
 	if seqNum >= 1 {
-		fileExt := filepath.Ext(fileName)
-		baseName := fileName[:len(fileName)-len(fileExt)]
-		fileName = baseName + "_" + formatSequence(seqNum) + fileExt
+		fileName = addSequenceSuffix(fileName, seqNum)
 	}
 
 	return filepath.Join(fileDir, fileName)
 }
 
+// computeTemplateDestPath renders the path template below the base (or
+// extension-specific) directory, or returns "" if it can't be rendered.
+func (s *MediaScanner) computeTemplateDestPath(file *media.MediaFile, baseDestDir, extensionDir, setAsideDir string, seqNum int) string {
+	relPath, err := s.pathTemplate.Render(file, seqNum, s.spaceReplacement, s.noOriginalName)
+	if err != nil {
+		logrus.Errorf("No destination: %v", err)
+		return ""
+	}
+	if seqNum >= 1 && !s.pathTemplate.HasSequence() {
+		relPath = addSequenceSuffix(relPath, seqNum)
+	}
+	return joinRelativeDestPath(baseDestDir, extensionDir, relPath, setAsideDir)
+}
+
+// joinRelativeDestPath places relPath below the base (or extension-specific)
+// directory. Files set aside get the same relative path below setAsideDir.
+func joinRelativeDestPath(baseDestDir, extensionDir, relPath, setAsideDir string) string {
+	if extensionDir != "" {
+		baseDestDir = extensionDir
+	}
+
+	if filepath.IsAbs(setAsideDir) {
+		return filepath.Join(setAsideDir, relPath)
+	}
+	return filepath.Join(baseDestDir, setAsideDir, relPath)
+}
+
+// isQuarantineDir reports whether dir holds copies that failed verification:
+// the absolute quarantine directory, or the relative one under any base.
+func (s *MediaScanner) isQuarantineDir(dir string) bool {
+	quarantineDir := filepath.Clean(s.quarantineDir)
+	if s.quarantineDir == "" {
+		return false
+	}
+	if filepath.IsAbs(quarantineDir) {
+		return dir == quarantineDir
+	}
+	return strings.HasSuffix(dir, string(os.PathSeparator)+quarantineDir)
+}
+
+// addSequenceSuffix inserts _NNN before the extension of fileName.
+func addSequenceSuffix(fileName string, seqNum int) string {
+	fileExt := filepath.Ext(fileName)
+	baseName := fileName[:len(fileName)-len(fileExt)]
+	return baseName + "_" + formatSequence(seqNum) + fileExt
+}
+
 // retroFixFirstSequence updates the first file with the given timestamp key
 // from seqNum=0 (no suffix) to seqNum=1 (_001). If the file was already moved,
 // it renames the file on disk to match.
@@ -449,45 +665,101 @@ func (s *MediaScanner) retroFixFirstSequence(tsKey string, moveCh chan<- moveJob
 	}
 
 	// Recompute dest path with seqNum=1
-	mf := recordToMediaFile(first)
-	newDestPath := s.computeDestPath(mf, first.IsDuplicate, 1)
+	newDestPath := s.recordDestPath(first, 1)
 
-	// Update journal
-	s.journal.UpdateDestPath(first.ID, newDestPath, 1, first.IsDuplicate)
+	s.refile(first, newDestPath, 1, "sequence")
+}
 
-	oldDestPath := first.DestPath
+// refile changes the destination of a record to newDestPath. A file that was
+// already moved is renamed on disk together with its sidecars; a pending one
+// is picked up by the mover, which re-reads dest_path from the journal before
+// executing. Members of a pair led by rec follow it.
+func (s *MediaScanner) refile(rec *db.FileRecord, newDestPath string, seqNum int, reason string) {
+	if newDestPath == "" {
+		logrus.Errorf("No destination to re-file %s for %s", rec.SourcePath, reason)
+		return
+	}
+	if s.renameRecord(rec, newDestPath, seqNum, reason) && s.pairFiles {
+		s.refilePairMembers(rec.ID, newDestPath, seqNum)
+	}
+}
 
-	switch first.Status {
-	case db.StatusCompleted:
-		// File was already moved — rename on disk
-		if oldDestPath != "" && oldDestPath != newDestPath {
-			if err := os.MkdirAll(filepath.Dir(newDestPath), 0755); err != nil {
-				logrus.Errorf("retroFixFirstSequence: mkdir error: %v", err)
-				return
-			}
-			if err := os.Rename(oldDestPath, newDestPath); err != nil {
-				logrus.Errorf("retroFixFirstSequence: rename error: %v", err)
-			} else {
-				logrus.Infof("Renamed for sequence: %s -> %s", oldDestPath, newDestPath)
-			}
+// renameRecord does the work of refile for rec alone. It reports whether rec
+// was re-filed.
+func (s *MediaScanner) renameRecord(rec *db.FileRecord, newDestPath string, seqNum int, reason string) bool {
+	// A mover may be transferring the file; wait for it, and go by the status
+	// it left rather than the one rec was read with
+	defer s.lockRecord(rec.ID)()
+	if current, err := s.journal.GetFile(rec.ID); err != nil || current == nil {
+		logrus.Errorf("Failed to re-read %s for %s: %v", rec.SourcePath, reason, err)
+		return false
+	} else {
+		rec = current
+	}
+	s.journal.UpdateDestPath(rec.ID, newDestPath, seqNum, rec.IsDuplicate)
+
+	oldDestPath := rec.DestPath
+	if rec.Status == db.StatusCompleted && oldDestPath != "" && oldDestPath != newDestPath {
+		if !s.syncJournal() {
+			return false
+		}
+		if err := os.MkdirAll(filepath.Dir(newDestPath), 0755); err != nil {
+			logrus.Errorf("Failed to create directory for %s: %v", newDestPath, err)
+			return false
 		}
-	case db.StatusPending:
-		// File hasn't been moved yet — the mover re-reads dest_path from the
-		// journal before executing, so it will pick up this update.
+		if err := renameNoReplace(oldDestPath, newDestPath); err != nil {
+			logrus.Errorf("Failed to rename %s for %s: %v", oldDestPath, reason, err)
+			return false
+		}
+		logrus.Infof("Renamed for %s: %s -> %s", reason, oldDestPath, newDestPath)
+		s.cacheMoved(oldDestPath, newDestPath)
+		s.renameSidecars(rec, newDestPath)
+	}
+	return true
+}
+
+// lockRecord keeps the mover and refile from acting on the file of a record
+// at the same time, so that a file is never renamed while it is transferred.
+// It returns the function that unlocks it.
+func (s *MediaScanner) lockRecord(id int64) func() {
+	mu, _ := s.recordLocks.LoadOrStore(id, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// syncJournal commits the journal's batched writes before files are acted
+// on, so that after a crash resume finds what was about to happen to them. It
+// reports whether the files may be acted on.
+func (s *MediaScanner) syncJournal() bool {
+	if err := s.journal.Sync(); err != nil {
+		logrus.Errorf("Failed to commit journal, leaving files alone: %v", err)
+		return false
 	}
+	return true
 }
 
 func (s *MediaScanner) executeMoveJob(job moveJob) {
+	defer s.lockRecord(job.RecordID)()
+
 	// Re-read dest path from journal to pick up any retroactive sequence fixes
 	if latestDest, err := s.journal.GetDestPath(job.RecordID); err == nil && latestDest != "" {
 		job.DestPath = latestDest
 	}
+	// Duplicates that stay in the source or are deleted have no destination
+	if job.DestPath == "" && (!job.IsDuplicate || placesDuplicate(job.DuplicateAction)) {
+		// Never fall back to the working or destination directory
+		s.journal.UpdateStatus(job.RecordID, db.StatusFailed, "no destination path")
+		return
+	}
 
-	operation := "move"
-	if s.copyFiles {
-		operation = "copy"
+	if job.IsDuplicate && job.DuplicateAction != "" && job.DuplicateAction != config.DuplicateMove {
+		if s.executeDuplicateJob(job) {
+			return
+		}
 	}
 
+	operation := s.transferMethod()
+
 	if s.dryRun {
 		dupLabel := ""
 		if job.IsDuplicate {
@@ -496,6 +768,7 @@ func (s *MediaScanner) executeMoveJob(job moveJob) {
 		logrus.Infof("[DRY RUN] Would %s%s: %s -> \n%s", operation, dupLabel, job.File.SourcePath, job.DestPath)
 		s.journal.UpdateStatus(job.RecordID, db.StatusDryRun, "")
 		atomic.AddInt32(&s.organized, 1)
+		s.moveSidecars(job)
 		return
 	}
 
@@ -508,33 +781,62 @@ func (s *MediaScanner) executeMoveJob(job moveJob) {
 	}
 
 	// Check for destination collision before writing
-	if _, statErr := os.Stat(job.DestPath); statErr == nil {
+	if _, statErr := os.Lstat(job.DestPath); statErr == nil {
+		// An interrupted run that kept its sources may have placed it already
+		if s.keepsSource() && alreadyTransferred(job.File.SourcePath, job.DestPath) {
+			logrus.Infof("Already in destination: %s", job.DestPath)
+			s.journal.UpdateStatus(job.RecordID, db.StatusCompleted, "")
+			atomic.AddInt32(&s.organized, 1)
+			s.moveSidecars(job)
+			return
+		}
+		// Copies are renamed into place only when complete, so an interrupted
+		// move across devices may only have missed removing its source
+		if job.Interrupted && !s.keepsSource() {
+			if same, err := identicalFiles(job.File.SourcePath, job.DestPath); err == nil && same {
+				if err := os.Remove(job.File.SourcePath); err != nil {
+					logrus.Errorf("Failed to remove source of interrupted move %s: %v", job.File.SourcePath, err)
+					s.journal.UpdateStatus(job.RecordID, db.StatusFailed, err.Error())
+					return
+				}
+				logrus.Infof("Resume: finished interrupted move: %s -> \n%s", job.File.SourcePath, job.DestPath)
+				s.journal.UpdateStatus(job.RecordID, db.StatusCompleted, "")
+				atomic.AddInt32(&s.organized, 1)
+				s.moveSidecars(job)
+				return
+			}
+		}
 		logrus.Errorf("Destination already exists, refusing to overwrite: %s", job.DestPath)
 		s.journal.UpdateStatus(job.RecordID, db.StatusFailed, "destination file already exists")
 		return
 	}
 
-	var err error
-	if s.copyFiles {
-		err = copyFileImpl(job.File.SourcePath, job.DestPath)
-		if err == nil {
-			logrus.Infof("Copied: %s -> \n%s", job.File.SourcePath, job.DestPath)
-		}
-	} else {
-		err = moveFileImpl(job.File.SourcePath, job.DestPath)
-		if err == nil {
-			logrus.Infof("Moved: %s -> \n%s", job.File.SourcePath, job.DestPath)
-		}
+	// A run killed from here on leaves the record in progress, so that resume
+	// knows the destination may be incomplete
+	s.journal.UpdateStatus(job.RecordID, db.StatusInProgress, "")
+	if !s.syncJournal() {
+		return
+	}
+	transfer, err := s.transferFile(job.File.SourcePath, job.DestPath)
+	var mismatch *ChecksumMismatchError
+	if errors.As(err, &mismatch) {
+		s.quarantine(job, mismatch)
+		return
 	}
-
 	if err != nil {
 		logrus.Errorf("Failed to %s file %s to %s: %v", operation, job.File.SourcePath, job.DestPath, err)
 		s.journal.UpdateStatus(job.RecordID, db.StatusFailed, err.Error())
 		return
 	}
+	logrus.Infof("%s: %s -> \n%s", transferVerbs[transfer], job.File.SourcePath, job.DestPath)
+	if transfer == transferMove {
+		s.cacheMoved(job.File.SourcePath, job.DestPath)
+	}
 
+	s.journal.UpdateTransfer(job.RecordID, transfer)
 	s.journal.UpdateStatus(job.RecordID, db.StatusCompleted, "")
 	atomic.AddInt32(&s.organized, 1)
+	s.moveSidecars(job)
 }
 
 func (s *MediaScanner) populateResultFromJournal() {
@@ -546,6 +848,14 @@ func (s *MediaScanner) populateResultFromJournal() {
 
 	s.result.OrganizedFiles = stats[db.StatusCompleted] + stats[db.StatusDryRun]
 	s.result.ErrorCount = stats[db.StatusFailed]
+	s.result.QuarantinedCount = stats[db.StatusQuarantined]
+
+	sidecarStats, err := s.journal.SidecarStats()
+	if err != nil {
+		logrus.Errorf("Failed to read sidecar stats: %v", err)
+	} else {
+		s.result.SidecarFiles = sidecarStats[db.StatusCompleted] + sidecarStats[db.StatusDryRun]
+	}
 
 	dupCount, err := s.journal.DuplicateCount()
 	if err != nil {
@@ -554,6 +864,22 @@ func (s *MediaScanner) populateResultFromJournal() {
 		s.result.DuplicateCount = dupCount
 	}
 
+	actions, err := s.journal.DuplicateActionCounts()
+	if err != nil {
+		logrus.Errorf("Failed to read duplicate actions: %v", err)
+	} else {
+		s.result.DuplicateActions = actions
+	}
+
+	if s.nearDuplicates {
+		nearCount, err := s.journal.NearDuplicateCount()
+		if err != nil {
+			logrus.Errorf("Failed to read near-duplicate count: %v", err)
+		} else {
+			s.result.NearDuplicateCount = nearCount
+		}
+	}
+
 	total, err := s.journal.TotalCount()
 	if err != nil {
 		logrus.Errorf("Failed to read total count: %v", err)
@@ -563,20 +889,77 @@ func (s *MediaScanner) populateResultFromJournal() {
 	}
 }
 
+// newFileRecord returns a pending journal record for file.
+func newFileRecord(file *media.MediaFile, tsKey string) *db.FileRecord {
+	return &db.FileRecord{
+		SourcePath:      file.SourcePath,
+		FileSize:        file.FileSize,
+		MediaType:       string(file.Type),
+		Extension:       file.GetExtension(),
+		CreationTime:    file.CreationTime.Format("2006-01-02 15:04:05"),
+		LargerDimension: file.LargerDimension,
+		OriginalName:    file.OriginalName,
+		TimestampKey:    tsKey,
+		Status:          db.StatusPending,
+		CameraMake:      file.CameraMake,
+		CameraModel:     file.CameraModel,
+		Artist:          file.Artist,
+		AlbumArtist:     file.AlbumArtist,
+		Album:           file.Album,
+		Title:           file.Title,
+		TrackNumber:     file.TrackNumber,
+		Year:            file.Year,
+		DateSource:      string(file.DateSource),
+		HasGPS:          file.HasGPS,
+		Latitude:        file.Latitude,
+		Longitude:       file.Longitude,
+		Description:     file.Description,
+		ContentID:       file.ContentIdentifier,
+		PerceptualHash:  file.PerceptualHash,
+		ContentFormat:   file.ContentFormat,
+	}
+}
+
 // recordToMediaFile converts a journal FileRecord back to a MediaFile for re-queuing.
 func recordToMediaFile(rec *db.FileRecord) *media.MediaFile {
 	t, _ := time.Parse("2006-01-02 15:04:05", rec.CreationTime)
 	return &media.MediaFile{
-		SourcePath:      rec.SourcePath,
-		Type:            media.MediaType(rec.MediaType),
-		CreationTime:    t,
-		LargerDimension: rec.LargerDimension,
-		FileSize:        rec.FileSize,
-		Hash:            rec.Hash,
-		OriginalName:    rec.OriginalName,
+		SourcePath:        rec.SourcePath,
+		Type:              media.MediaType(rec.MediaType),
+		CreationTime:      t,
+		LargerDimension:   rec.LargerDimension,
+		FileSize:          rec.FileSize,
+		Hash:              rec.Hash,
+		OriginalName:      rec.OriginalName,
+		CameraMake:        rec.CameraMake,
+		CameraModel:       rec.CameraModel,
+		Artist:            rec.Artist,
+		AlbumArtist:       rec.AlbumArtist,
+		Album:             rec.Album,
+		Title:             rec.Title,
+		TrackNumber:       rec.TrackNumber,
+		Year:              rec.Year,
+		DateSource:        media.DateSource(rec.DateSource),
+		HasGPS:            rec.HasGPS,
+		Latitude:          rec.Latitude,
+		Longitude:         rec.Longitude,
+		Description:       rec.Description,
+		ContentIdentifier: rec.ContentID,
+		PerceptualHash:    rec.PerceptualHash,
+		ContentFormat:     rec.ContentFormat,
+		Extension:         correctedExtension(rec),
 	}
 }
 
+// correctedExtension returns the extension a record was organized under when
+// it was corrected to that of its content, or "".
+func correctedExtension(rec *db.FileRecord) string {
+	if rec.ContentFormat != "" && rec.Extension == rec.ContentFormat {
+		return rec.Extension
+	}
+	return ""
+}
+
 func formatSequence(num int) string {
 	return fmt.Sprintf("%03d", num)
 }
@@ -584,59 +967,184 @@ func formatSequence(num int) string {
 // moveFileImpl moves a file, falling back to copy+delete for cross-device moves.
 // Preserves modification time when falling back to copy.
 func moveFileImpl(srcPath, destPath string) error {
-	err := os.Rename(srcPath, destPath)
+	return moveFileVerified(srcPath, destPath, false)
+}
+
+// moveFileVerified is moveFileImpl that, with verify, deletes the source of
+// a cross-device move only once the copy is verified. A rename within a
+// device doesn't rewrite the data, so there is nothing to verify. An
+// existing destPath is never replaced.
+func moveFileVerified(srcPath, destPath string, verify bool) error {
+	err := renameNoReplace(srcPath, destPath)
 	if err != nil {
 		if strings.Contains(err.Error(), "cross-device link") {
-			if err := copyFileImpl(srcPath, destPath); err != nil {
+			if err := copyFileVerified(srcPath, destPath, verify); err != nil {
 				return err
 			}
 			return os.Remove(srcPath)
 		}
 		return err
 	}
+	syncDir(filepath.Dir(destPath))
 	return nil
 }
 
 func copyFileImpl(srcPath, destPath string) error {
-	srcInfo, err := os.Stat(srcPath)
+	return copyFileVerified(srcPath, destPath, false)
+}
+
+// ChecksumMismatchError is returned by a verified copy whose destination
+// doesn't read back with the hash of its source.
+type ChecksumMismatchError struct {
+	Path       string // the rejected copy, still at its temporary name
+	SourceHash string
+	DestHash   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch: source %s, destination %s", e.SourceHash, e.DestHash)
+}
+
+// copyFileVerified copies srcPath to a temporary file next to destPath and
+// renames it into place once it is complete and synced, so destPath never
+// holds a partial copy. With verify, the copy is read back first and its hash
+// compared with the one of the source, computed during the copy. Returns a
+// *ChecksumMismatchError if they differ; the rejected copy is left at its
+// temporary name for inspection.
+func copyFileVerified(srcPath, destPath string, verify bool) error {
+	tmp, err := createTemp(destPath)
 	if err != nil {
 		return err
 	}
+	tmpPath := tmp.Name()
+	srcHash, err := copyFileHashed(srcPath, tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && verify {
+		var destHash string
+		if destHash, err = hashCopy(tmpPath); err != nil {
+			err = fmt.Errorf("verify %s: %w", destPath, err)
+		} else if destHash != srcHash {
+			return &ChecksumMismatchError{Path: tmpPath, SourceHash: srcHash, DestHash: destHash}
+		}
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return commitTemp(tmpPath, destPath)
+}
+
+// hashCopy reads back a copy to verify it. Tests replace it to damage the
+// copy first.
+var hashCopy = media.ComputeFileHash
+
+// copyFileHashed copies a file into the new, empty file dst and returns the
+// hash of the data read from the source, as computed by
+// media.ComputeFileHash.
+func copyFileHashed(srcPath string, dst *os.File) (string, error) {
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return "", err
+	}
 
 	src, err := os.Open(srcPath)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer src.Close()
 
-	dst, err := os.OpenFile(destPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, srcInfo.Mode())
-	if err != nil {
-		return err
+	if err := dst.Chmod(srcInfo.Mode().Perm()); err != nil {
+		logrus.Warnf("Could not preserve permissions for %s: %v", dst.Name(), err)
 	}
-	defer dst.Close()
 
-	written, err := io.Copy(dst, src)
+	h := media.NewFileHash()
+	written, err := io.Copy(io.MultiWriter(dst, h), src)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if err = dst.Sync(); err != nil {
-		return err
+		return "", err
 	}
 
 	// Verify written size matches source
 	if written != srcInfo.Size() {
-		return fmt.Errorf("size mismatch after copy: wrote %d bytes, expected %d", written, srcInfo.Size())
+		return "", fmt.Errorf("size mismatch after copy: wrote %d bytes, expected %d", written, srcInfo.Size())
 	}
 
 	// Preserve modification time
-	if err := os.Chtimes(destPath, srcInfo.ModTime(), srcInfo.ModTime()); err != nil {
-		logrus.Warnf("Could not preserve timestamps for %s: %v", destPath, err)
+	if err := os.Chtimes(dst.Name(), srcInfo.ModTime(), srcInfo.ModTime()); err != nil {
+		logrus.Warnf("Could not preserve timestamps for %s: %v", dst.Name(), err)
 	}
 
+	return media.FormatFileHash(h), nil
+}
+
+// tempSuffix marks the temporary files that copies are written to. They are
+// hidden and have no media extension, so no scan ever picks them up.
+const tempSuffix = ".mediaorganizer-tmp"
+
+// createTemp creates a temporary file to write destPath under, named
+// ".<name>.<random>.mediaorganizer-tmp" next to it. Each writer gets a file
+// of its own, even when two are after the same destination.
+func createTemp(destPath string) (*os.File, error) {
+	return os.CreateTemp(filepath.Dir(destPath), "."+filepath.Base(destPath)+".*"+tempSuffix)
+}
+
+// removeTemps removes the temporary files left for destPath by writers that
+// were killed, and returns how many there were.
+func removeTemps(destPath string) int {
+	entries, err := os.ReadDir(filepath.Dir(destPath))
+	if err != nil {
+		return 0
+	}
+	prefix := "." + filepath.Base(destPath) + "."
+	removed := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, tempSuffix) {
+			continue
+		}
+		// The random part is digits, so the temporary files of another
+		// destination named like this one plus a suffix don't match
+		random := strings.TrimSuffix(strings.TrimPrefix(name, prefix), tempSuffix)
+		if random == "" || strings.Trim(random, "0123456789") != "" {
+			continue
+		}
+		if os.Remove(filepath.Join(filepath.Dir(destPath), name)) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// commitTemp renames a complete temporary file to destPath and syncs the
+// directory, so the new name survives a crash. It fails rather than replace
+// a file at destPath, e.g. one another writer committed meanwhile.
+func commitTemp(tmpPath, destPath string) error {
+	if err := renameNoReplace(tmpPath, destPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	syncDir(filepath.Dir(destPath))
 	return nil
 }
 
+// syncDir flushes a directory's entries to disk. Not every platform can sync
+// a directory (Windows can't), so failures are only logged.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err == nil {
+		err = d.Sync()
+		d.Close()
+	}
+	if err != nil {
+		logrus.Debugf("Could not sync directory %s: %v", dir, err)
+	}
+}
+
 // GetProcessedCount returns the current count of metadata-extracted files.
 func (s *MediaScanner) GetProcessedCount() int {
 	return int(atomic.LoadInt32(&s.processed))
@@ -659,7 +1167,7 @@ func (s *MediaScanner) preIndexDestinations() {
 	// Collect unique destination directories to scan
 	destDirs := make(map[string]bool)
 
-	if s.scheme == "date_first" && s.destination != "" {
+	if s.usesUnifiedDestination() {
 		destDirs[s.destination] = true
 	}
 	for _, dir := range s.destinationDirs {
@@ -694,6 +1202,10 @@ func (s *MediaScanner) preIndexDestinations() {
 			logrus.Debugf("Destination directory does not exist yet, skipping pre-index: %s", dir)
 			continue
 		}
+		// Files the filters skip in the source are no originals in the
+		// destinations either. Patterns and depth are relative to each
+		// destination, as they are to the source.
+		matcher := filter.New(dir, s.filterRules)
 
 		filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
 			if err != nil {
@@ -704,6 +1216,13 @@ func (s *MediaScanner) preIndexDestinations() {
 				if path == s.sourceDir || strings.HasPrefix(path, s.sourceDir+string(os.PathSeparator)) {
 					return filepath.SkipDir
 				}
+				// Quarantined copies are corrupt, never originals of anything
+				if s.isQuarantineDir(path) {
+					return filepath.SkipDir
+				}
+				if matcher.SkipDir(path) != "" {
+					return filepath.SkipDir
+				}
 				return nil
 			}
 
@@ -717,19 +1236,7 @@ func (s *MediaScanner) preIndexDestinations() {
 				return nil
 			}
 
-			// Skip hidden files and journal DB files
-			fileName := filepath.Base(path)
-			if strings.HasPrefix(fileName, "._") {
-				return nil
-			}
-			if strings.HasSuffix(path, ".mediaorganizer.db") ||
-				strings.HasSuffix(path, ".mediaorganizer.db-wal") ||
-				strings.HasSuffix(path, ".mediaorganizer.db-shm") {
-				return nil
-			}
-
-			mediaType := media.DetermineMediaType(path)
-			if mediaType == media.TypeUnknown {
+			if !isSourceMediaFile(path) {
 				return nil
 			}
 
@@ -738,13 +1245,16 @@ func (s *MediaScanner) preIndexDestinations() {
 				logrus.Warnf("Could not stat %s: %v", path, err)
 				return nil
 			}
+			if matcher.SkipFile(path, info.Size()) != "" {
+				return nil
+			}
 
 			ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
 			visited[path] = true
 			files = append(files, db.DestFile{
 				Path:      path,
 				Size:      info.Size(),
-				MediaType: string(mediaType),
+				MediaType: string(media.DetectMediaType(path)),
 				Extension: ext,
 			})
 			return nil
@@ -0,0 +1,160 @@
+package processor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mediaorganizer/pkg/config"
+	"mediaorganizer/pkg/db"
+)
+
+// baseTime is the modification time test files get, give or take.
+var baseTime = time.Date(2023, 5, 1, 12, 0, 0, 0, time.Local)
+
+// testEnv is a source tree and its destinations, with the journal outside
+// both.
+type testEnv struct {
+	t    *testing.T
+	root string
+	cfg  *config.Config
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	root := t.TempDir()
+	cfg := &config.Config{
+		SourceDir: filepath.Join(root, "src"),
+		DestDirs: map[string]string{
+			"image": filepath.Join(root, "out", "images"),
+			"video": filepath.Join(root, "out", "videos"),
+			"audio": filepath.Join(root, "out", "audio"),
+		},
+		ExtensionDirs:      make(map[string]string),
+		OrganizationScheme: config.SchemeExtensionFirst,
+		DuplicatesDir:      "duplicates",
+		DuplicatePolicy:    config.DuplicateMove,
+		NearDuplicatesDir:  "near_duplicates",
+		QuarantineDir:      "quarantine",
+		ConcurrentJobs:     4,
+		HashJobs:           4,
+		DBPath:             filepath.Join(root, "journal.db"),
+	}
+	if err := os.MkdirAll(cfg.SourceDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &testEnv{t: t, root: root, cfg: cfg}
+}
+
+// src returns the path of a file in the source tree.
+func (e *testEnv) src(rel string) string {
+	return filepath.Join(e.cfg.SourceDir, filepath.FromSlash(rel))
+}
+
+// write creates a file in the source tree with the given modification time.
+func (e *testEnv) write(rel string, content []byte, mtime time.Time) string {
+	e.t.Helper()
+	path := e.src(rel)
+	writeFile(e.t, path, content, mtime)
+	return path
+}
+
+func writeFile(t *testing.T, path string, content []byte, mtime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openJournal opens the journal, closed at the end of the test.
+func (e *testEnv) openJournal() *db.Journal {
+	e.t.Helper()
+	j, err := db.InitJournal(e.cfg.DBPath)
+	if err != nil {
+		e.t.Fatalf("InitJournal: %v", err)
+	}
+	e.t.Cleanup(func() { j.Close() })
+	return j
+}
+
+// scan organizes the source tree, resuming if the journal exists, and fails
+// the test if the run doesn't end.
+func (e *testEnv) scan() (*ScanResult, *db.Journal) {
+	e.t.Helper()
+	_, err := os.Stat(e.cfg.DBPath)
+	resume := err == nil
+	j := e.openJournal()
+	s := NewMediaScanner(e.cfg, j, nil, resume)
+
+	done := make(chan *ScanResult, 1)
+	go func() { done <- s.Scan() }()
+	select {
+	case result := <-done:
+		return result, j
+	case <-time.After(time.Minute):
+		e.t.Fatal("scan did not finish")
+		return nil, nil
+	}
+}
+
+// records returns the source records of the journal by source path relative
+// to the source directory.
+func (e *testEnv) records(j *db.Journal) map[string]*db.FileRecord {
+	e.t.Helper()
+	recs, err := j.QueryFiles(db.FileFilter{})
+	if err != nil {
+		e.t.Fatalf("QueryFiles: %v", err)
+	}
+	byPath := make(map[string]*db.FileRecord, len(recs))
+	for _, rec := range recs {
+		rel, err := filepath.Rel(e.cfg.SourceDir, rec.SourcePath)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		byPath[filepath.ToSlash(rel)] = rec
+	}
+	return byPath
+}
+
+// rel returns path relative to the test root, "" for "".
+func (e *testEnv) rel(path string) string {
+	if path == "" {
+		return ""
+	}
+	return filepath.ToSlash(strings.TrimPrefix(path, e.root+string(os.PathSeparator)))
+}
+
+// jpegBytes returns size bytes that start like a JPEG, filled with fill.
+func jpegBytes(size int, fill byte) []byte {
+	b := bytes.Repeat([]byte{fill}, size)
+	copy(b, "\xff\xd8\xff\xe0")
+	return b
+}
+
+// dirFiles returns the files under dir, relative to it.
+func dirFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return files
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
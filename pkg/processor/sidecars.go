@@ -0,0 +1,156 @@
+package processor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+
+	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/media"
+)
+
+// sidecarIndex caches the sidecar assignment of the directory the walker is
+// in. WalkDir visits a directory's files in order, interleaved with its
+// subdirectories, so the listing is only re-read after returning from one.
+type sidecarIndex struct {
+	dir     string
+	matches map[string][]string
+}
+
+// lookup returns the source paths of the sidecars of the media file at path.
+func (idx *sidecarIndex) lookup(path string) []string {
+	dir, name := filepath.Split(path)
+	if idx.matches == nil || dir != idx.dir {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			logrus.Warnf("Could not list %s for sidecar detection: %v", dir, err)
+			return nil
+		}
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				names = append(names, e.Name())
+			}
+		}
+		idx.dir = dir
+		idx.matches = media.MatchSidecars(names)
+	}
+
+	var sidecars []string
+	for _, sidecar := range idx.matches[name] {
+		sidecars = append(sidecars, filepath.Join(dir, sidecar))
+	}
+	return sidecars
+}
+
+// moveSidecars moves (or copies) the pending sidecars of a just-organized
+// media file next to it, renamed to match.
+func (s *MediaScanner) moveSidecars(job moveJob) {
+	sidecars, err := s.journal.GetSidecars(job.RecordID)
+	if err != nil {
+		logrus.Errorf("Failed to load sidecars of %s: %v", job.File.SourcePath, err)
+		return
+	}
+	for _, sc := range sidecars {
+		if sc.Status != db.StatusPending {
+			continue
+		}
+		s.moveSidecar(sc, media.SidecarDestPath(job.File.SourcePath, job.DestPath, sc.SourcePath))
+	}
+}
+
+// moveLeftoverSidecars organizes pending sidecars whose media file was
+// completed by an earlier, interrupted run.
+func (s *MediaScanner) moveLeftoverSidecars() {
+	sidecars, err := s.journal.GetPendingSidecarsOfCompleted()
+	if err != nil {
+		logrus.Errorf("Failed to load leftover sidecars: %v", err)
+		return
+	}
+	if len(sidecars) > 0 {
+		logrus.Infof("Resuming: %d sidecar files to move next to their media files", len(sidecars))
+	}
+	for _, sc := range sidecars {
+		s.moveSidecar(sc, media.SidecarDestPath(sc.ParentSourcePath, sc.ParentDestPath, sc.SourcePath))
+	}
+}
+
+func (s *MediaScanner) moveSidecar(sc *db.SidecarRecord, destPath string) {
+	operation := s.transferMethod()
+
+	if s.dryRun {
+		logrus.Infof("[DRY RUN] Would %s sidecar: %s -> \n%s", operation, sc.SourcePath, destPath)
+		s.journal.UpdateSidecar(sc.ID, destPath, db.StatusDryRun, "")
+		return
+	}
+
+	if _, err := os.Lstat(sc.SourcePath); os.IsNotExist(err) {
+		// A previous run may have moved it without recording the result
+		if sc.DestPath != "" {
+			if _, err := os.Stat(sc.DestPath); err == nil {
+				s.journal.UpdateSidecar(sc.ID, sc.DestPath, db.StatusCompleted, "")
+				return
+			}
+		}
+		logrus.Warnf("Sidecar no longer exists: %s", sc.SourcePath)
+		s.journal.UpdateSidecar(sc.ID, sc.DestPath, db.StatusFailed, "sidecar file missing")
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		logrus.Errorf("Failed to create directory for sidecar %s: %v", destPath, err)
+		s.journal.UpdateSidecar(sc.ID, destPath, db.StatusFailed, err.Error())
+		return
+	}
+	if _, err := os.Stat(destPath); err == nil {
+		logrus.Errorf("Sidecar destination already exists, refusing to overwrite: %s", destPath)
+		s.journal.UpdateSidecar(sc.ID, destPath, db.StatusFailed, "destination file already exists")
+		return
+	}
+
+	// Record the destination first so a crash mid-move can be recovered
+	s.journal.UpdateSidecar(sc.ID, destPath, db.StatusPending, "")
+	if !s.syncJournal() {
+		return
+	}
+
+	transfer, err := s.transferFile(sc.SourcePath, destPath)
+	if err == nil {
+		logrus.Infof("%s sidecar: %s -> \n%s", transferVerbs[transfer], sc.SourcePath, destPath)
+	}
+	if err != nil {
+		logrus.Errorf("Failed to %s sidecar %s to %s: %v", operation, sc.SourcePath, destPath, err)
+		// Drop a corrupt copy so the sidecar can be retried on the next run
+		var mismatch *ChecksumMismatchError
+		if errors.As(err, &mismatch) {
+			os.Remove(mismatch.Path)
+		}
+		s.journal.UpdateSidecar(sc.ID, destPath, db.StatusFailed, err.Error())
+		return
+	}
+
+	s.journal.UpdateSidecar(sc.ID, destPath, db.StatusCompleted, "")
+}
+
+// renameSidecars follows a completed media file that was renamed to
+// newDestPath, e.g. by a retroactive sequence fix.
+func (s *MediaScanner) renameSidecars(rec *db.FileRecord, newDestPath string) {
+	sidecars, err := s.journal.GetCompletedSidecars(rec.ID)
+	if err != nil {
+		logrus.Errorf("Failed to load sidecars of %s: %v", rec.SourcePath, err)
+		return
+	}
+	for _, sc := range sidecars {
+		newPath := media.SidecarDestPath(rec.SourcePath, newDestPath, sc.SourcePath)
+		if newPath == sc.DestPath {
+			continue
+		}
+		if err := renameNoReplace(sc.DestPath, newPath); err != nil {
+			logrus.Errorf("Failed to rename sidecar %s: %v", sc.DestPath, err)
+			continue
+		}
+		s.journal.UpdateSidecar(sc.ID, newPath, db.StatusCompleted, "")
+	}
+}
@@ -0,0 +1,102 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mediaorganizer/pkg/db"
+)
+
+// stemPath returns path with its extension replaced by ext.
+func stemPath(path, ext string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ext
+}
+
+func TestSidecarsFollowMediaFile(t *testing.T) {
+	e := newTestEnv(t)
+	// Taken in the same second, so the second takes a sequence number
+	for _, dir := range []string{"a", "b"} {
+		e.write(dir+"/IMG_1.jpg", jpegBytes(5000+len(dir), dir[0]), baseTime)
+		e.write(dir+"/IMG_1.xmp", []byte("<x:xmpmeta>"+dir+"</x:xmpmeta>"), baseTime)
+	}
+	e.write("a/IMG_1.jpg.json", []byte("{}"), baseTime)
+
+	result, j := e.scan()
+	if result.ErrorCount != 0 || result.SidecarFiles != 3 {
+		t.Fatalf("%d errors, %d sidecars", result.ErrorCount, result.SidecarFiles)
+	}
+	recs := e.records(j)
+	if recs["a/IMG_1.jpg"].DestPath == recs["b/IMG_1.jpg"].DestPath {
+		t.Fatalf("both files at %s", recs["a/IMG_1.jpg"].DestPath)
+	}
+	for _, dir := range []string{"a", "b"} {
+		dest := recs[dir+"/IMG_1.jpg"].DestPath
+		xmp := stemPath(dest, ".xmp")
+		if got, err := os.ReadFile(xmp); err != nil || string(got) != "<x:xmpmeta>"+dir+"</x:xmpmeta>" {
+			t.Errorf("sidecar of %s not at %s: %q, %v", dir, xmp, got, err)
+		}
+		if exists(e.src(dir + "/IMG_1.xmp")) {
+			t.Errorf("sidecar of %s left in the source", dir)
+		}
+	}
+	if json := recs["a/IMG_1.jpg"].DestPath + ".json"; !exists(json) {
+		t.Errorf("Takeout sidecar not at %s", json)
+	}
+
+	sidecars, err := j.GetSidecars(recs["a/IMG_1.jpg"].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sc := range sidecars {
+		if sc.Status != db.StatusCompleted || !exists(sc.DestPath) {
+			t.Errorf("sidecar %s %s at %s", e.rel(sc.SourcePath), sc.Status, e.rel(sc.DestPath))
+		}
+	}
+}
+
+func TestResumeMovesLeftoverSidecars(t *testing.T) {
+	e := newTestEnv(t)
+	e.write("IMG_1.jpg", jpegBytes(5000, 1), baseTime)
+	xmpSource := e.write("IMG_1.xmp", []byte("<x:xmpmeta/>"), baseTime)
+	e.write("IMG_1.jpg.json", []byte("{}"), baseTime)
+	_, j := e.scan()
+
+	// Killed after the media file was organized: one sidecar was still in
+	// the source, the other moved without its result recorded
+	rec := e.records(j)["IMG_1.jpg"]
+	sidecars, err := j.GetSidecars(rec.ID)
+	if err != nil || len(sidecars) != 2 {
+		t.Fatalf("sidecars %v, %v", sidecars, err)
+	}
+	for _, sc := range sidecars {
+		dest := sc.DestPath
+		if sc.SourcePath == xmpSource {
+			if err := os.Rename(sc.DestPath, sc.SourcePath); err != nil {
+				t.Fatal(err)
+			}
+			dest = ""
+		}
+		if err := j.UpdateSidecar(sc.ID, dest, db.StatusPending, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, j := e.scan()
+	if result.ErrorCount != 0 {
+		t.Errorf("%d errors", result.ErrorCount)
+	}
+	sidecars, err = j.GetSidecars(rec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sc := range sidecars {
+		if sc.Status != db.StatusCompleted || !exists(sc.DestPath) || exists(sc.SourcePath) {
+			t.Errorf("sidecar %s %s at %s", e.rel(sc.SourcePath), sc.Status, e.rel(sc.DestPath))
+		}
+	}
+	if xmp := stemPath(rec.DestPath, ".xmp"); !exists(xmp) {
+		t.Errorf("sidecar not moved to %s", xmp)
+	}
+}
@@ -0,0 +1,187 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+
+	"mediaorganizer/pkg/config"
+	"mediaorganizer/pkg/db"
+)
+
+// Transfer methods, recorded in the journal's transfer column. A link mode
+// falls back to transferCopy where the link can't be made.
+const (
+	transferMove     = "move"
+	transferCopy     = "copy"
+	transferHardlink = "hardlink"
+	transferReflink  = "reflink"
+	transferSymlink  = "symlink"
+)
+
+// transferVerbs are the past tense of the transfer methods, for the log.
+var transferVerbs = map[string]string{
+	transferMove:     "Moved",
+	transferCopy:     "Copied",
+	transferHardlink: "Hard-linked",
+	transferReflink:  "Reflinked",
+	transferSymlink:  "Symlinked",
+}
+
+// Links are made through these, so that tests can fail them as a file system
+// that can't make them would.
+var (
+	hardLink = os.Link
+	reflink  = reflinkFile
+)
+
+// transferMethod returns the method used to place files in the destination.
+func (s *MediaScanner) transferMethod() string {
+	switch s.linkMode {
+	case config.LinkHard:
+		return transferHardlink
+	case config.LinkReflink:
+		return transferReflink
+	case config.LinkSymlink:
+		return transferSymlink
+	}
+	if s.copyFiles {
+		return transferCopy
+	}
+	return transferMove
+}
+
+// keepsSource reports whether the source files stay in place: when copying
+// or linking rather than moving.
+func (s *MediaScanner) keepsSource() bool {
+	return s.copyFiles || s.linkMode != ""
+}
+
+// transferFile places srcPath at destPath with the configured method and
+// returns the method actually used. Links that the file system can't make,
+// e.g. across devices, fall back to a copy. With verify, copies are read
+// back and checked against the hash of their source.
+func (s *MediaScanner) transferFile(srcPath, destPath string) (string, error) {
+	method := s.transferMethod()
+	var err error
+	switch method {
+	case transferMove:
+		return method, moveFileVerified(srcPath, destPath, s.verify)
+	case transferCopy:
+		return method, copyFileVerified(srcPath, destPath, s.verify)
+	case transferHardlink:
+		err = hardLink(srcPath, destPath)
+	case transferReflink:
+		err = cloneFile(srcPath, destPath)
+	case transferSymlink:
+		err = os.Symlink(srcPath, destPath)
+	}
+	if err == nil || errors.Is(err, fs.ErrExist) {
+		return method, err
+	}
+
+	logrus.Debugf("Could not %s %s, copying instead: %v", method, srcPath, err)
+	return transferCopy, copyFileVerified(srcPath, destPath, s.verify)
+}
+
+// cloneFile reflinks srcPath to a temporary file and renames it into place,
+// so an interrupted clone never leaves an empty file at destPath.
+func cloneFile(srcPath, destPath string) error {
+	// The clone must create its file; take a unique name and free it
+	tmp, err := createTemp(destPath)
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	os.Remove(tmpPath)
+	if err := reflink(srcPath, tmpPath); err != nil {
+		return err
+	}
+	return commitTemp(tmpPath, destPath)
+}
+
+// linkRename renames oldPath to newPath by linking it there, which fails if
+// newPath exists, and removing oldPath. Where the file system has no hard
+// links either, it checks for newPath and renames, which another writer can
+// still race.
+func linkRename(oldPath, newPath string) error {
+	err := os.Link(oldPath, newPath)
+	if err == nil {
+		return os.Remove(oldPath)
+	}
+	if errors.Is(err, fs.ErrExist) || errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if _, serr := os.Lstat(newPath); serr == nil {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: fs.ErrExist}
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// alreadyTransferred reports whether destPath already holds srcPath, as left
+// by an interrupted run that kept its sources: a symlink to it, a hard link,
+// or a clone or copy with identical content.
+func alreadyTransferred(srcPath, destPath string) bool {
+	destInfo, err := os.Lstat(destPath)
+	if err != nil {
+		return false
+	}
+	if destInfo.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(destPath)
+		return err == nil && target == srcPath
+	}
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return false
+	}
+	if os.SameFile(srcInfo, destInfo) {
+		return true
+	}
+	same, err := identicalFiles(srcPath, destPath)
+	return err == nil && same
+}
+
+// quarantine sets aside the copy of job that failed verification, so a
+// corrupt file never sits in the organized tree. Its source is left in place
+// and the record is marked quarantined, to be retried on the next run.
+func (s *MediaScanner) quarantine(job moveJob, mismatch *ChecksumMismatchError) {
+	msg := mismatch.Error()
+	logrus.Errorf("Verification failed for %s: %s", job.DestPath, msg)
+
+	seqNum := 0
+	if rec, err := s.journal.GetFile(job.RecordID); err == nil && rec != nil {
+		seqNum = rec.SequenceNum
+	}
+	// An earlier failed attempt may already be in quarantine
+	quarantinePath := s.computeSetAsideDestPath(job.File, s.quarantineDir, seqNum)
+	for n := seqNum + 1; quarantinePath != ""; n++ {
+		if _, err := os.Lstat(quarantinePath); os.IsNotExist(err) {
+			break
+		}
+		quarantinePath = addSequenceSuffix(s.computeSetAsideDestPath(job.File, s.quarantineDir, 0), n)
+	}
+
+	err := errors.New("no quarantine path")
+	if quarantinePath != "" {
+		if err = os.MkdirAll(filepath.Dir(quarantinePath), 0755); err == nil {
+			err = renameNoReplace(mismatch.Path, quarantinePath)
+		}
+	}
+	if err != nil {
+		// Don't leave the corrupt copy where it would pass for organized
+		logrus.Errorf("Failed to quarantine %s, removing it: %v", mismatch.Path, err)
+		os.Remove(mismatch.Path)
+		s.journal.UpdateDestPath(job.RecordID, "", seqNum, job.IsDuplicate)
+		s.journal.UpdateStatus(job.RecordID, db.StatusQuarantined, fmt.Sprintf("%s (copy removed: %v)", msg, err))
+		return
+	}
+
+	logrus.Warnf("Quarantined: %s -> \n%s", job.File.SourcePath, quarantinePath)
+	s.journal.UpdateDestPath(job.RecordID, quarantinePath, seqNum, job.IsDuplicate)
+	s.journal.UpdateStatus(job.RecordID, db.StatusQuarantined, msg)
+}
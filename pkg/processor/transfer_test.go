@@ -0,0 +1,290 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"mediaorganizer/pkg/config"
+	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/media"
+)
+
+func TestLinkFallsBackToCopy(t *testing.T) {
+	crossDevice := func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	noReflinks := func(oldname, newname string) error {
+		return &os.LinkError{Op: "reflink", Old: oldname, New: newname, Err: syscall.EOPNOTSUPP}
+	}
+	tests := []struct {
+		link     string
+		fail     func()
+		transfer string
+	}{
+		{config.LinkHard, nil, transferHardlink},
+		{config.LinkHard, func() { hardLink = crossDevice }, transferCopy},
+		{config.LinkReflink, func() { reflink = noReflinks }, transferCopy},
+		{config.LinkSymlink, nil, transferSymlink},
+	}
+	for _, tt := range tests {
+		t.Run(tt.link+"->"+tt.transfer, func(t *testing.T) {
+			if tt.fail != nil {
+				tt.fail()
+				t.Cleanup(func() { hardLink, reflink = os.Link, reflinkFile })
+			}
+			e := newTestEnv(t)
+			e.cfg.Link = tt.link
+			content := jpegBytes(5000, 1)
+			src := e.write("a.jpg", content, baseTime)
+
+			result, j := e.scan()
+			if result.ErrorCount != 0 {
+				t.Fatalf("%d errors", result.ErrorCount)
+			}
+			rec := e.records(j)["a.jpg"]
+			if rec.Status != db.StatusCompleted || rec.Transfer != tt.transfer {
+				t.Errorf("record %s by %q, want completed by %q", rec.Status, rec.Transfer, tt.transfer)
+			}
+			if got, err := os.ReadFile(rec.DestPath); err != nil || !bytes.Equal(got, content) {
+				t.Errorf("destination content: %v", err)
+			}
+			srcInfo, err := os.Stat(src)
+			if err != nil {
+				t.Fatalf("source not kept: %v", err)
+			}
+			destInfo, err := os.Lstat(rec.DestPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Copies are files of their own
+			if linked := os.SameFile(srcInfo, destInfo); linked != (tt.transfer == transferHardlink) {
+				t.Errorf("destination hard-linked: %v", linked)
+			}
+			if symlink := destInfo.Mode()&os.ModeSymlink != 0; symlink != (tt.transfer == transferSymlink) {
+				t.Errorf("destination symlinked: %v", symlink)
+			}
+		})
+	}
+}
+
+// damageCopies makes verified copies read back with a byte flipped until the
+// end of the test.
+func damageCopies(t *testing.T) {
+	hashCopy = func(path string) (string, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		b[len(b)-1] ^= 0xff
+		if err := os.WriteFile(path, b, 0644); err != nil {
+			return "", err
+		}
+		return media.ComputeFileHash(path)
+	}
+	t.Cleanup(func() { hashCopy = media.ComputeFileHash })
+}
+
+func TestVerifyQuarantinesDamagedCopy(t *testing.T) {
+	e := newTestEnv(t)
+	e.cfg.CopyFiles = true
+	e.cfg.Verify = true
+	content := jpegBytes(5000, 1)
+	src := e.write("a.jpg", content, baseTime)
+	damageCopies(t)
+
+	result, j := e.scan()
+	if result.QuarantinedCount != 1 {
+		t.Fatalf("%d quarantined, want 1", result.QuarantinedCount)
+	}
+	rec := e.records(j)["a.jpg"]
+	if rec.Status != db.StatusQuarantined || !strings.Contains(rec.ErrorMessage, "checksum mismatch") {
+		t.Errorf("record %s: %s", rec.Status, rec.ErrorMessage)
+	}
+	quarantined, err := os.ReadFile(rec.DestPath)
+	if err != nil || bytes.Equal(quarantined, content) {
+		t.Errorf("quarantined copy %s: %v", rec.DestPath, err)
+	}
+	if !strings.Contains(filepath.ToSlash(rec.DestPath), "/quarantine/") {
+		t.Errorf("copy set aside at %s, outside the quarantine", rec.DestPath)
+	}
+	if got, err := os.ReadFile(src); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("source not kept: %v", err)
+	}
+	// Nothing is left in the organized tree, not even the temporary copy
+	for _, f := range dirFiles(t, e.cfg.DestDirs["image"]) {
+		if !strings.Contains(f, "quarantine/") || strings.HasSuffix(f, tempSuffix) {
+			t.Errorf("left in the destination: %s", f)
+		}
+	}
+}
+
+// interrupt marks the record of the file at rel in progress, as a run killed
+// during its transfer leaves it.
+func (e *testEnv) interrupt(j *db.Journal, rel string) *db.FileRecord {
+	e.t.Helper()
+	rec := e.records(j)[rel]
+	if err := j.UpdateStatus(rec.ID, db.StatusInProgress, ""); err != nil {
+		e.t.Fatal(err)
+	}
+	return rec
+}
+
+func TestResumeRemovesPartialCopy(t *testing.T) {
+	e := newTestEnv(t)
+	e.cfg.CopyFiles = true
+	content := jpegBytes(5000, 1)
+	e.write("a.jpg", content, baseTime)
+	gone := e.write("b.jpg", jpegBytes(6000, 2), baseTime)
+	_, j := e.scan()
+
+	// Killed halfway through writing the copies; the source of one has gone
+	// since, so it is not copied again
+	var tmps []string
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		rec := e.interrupt(j, name)
+		if err := os.Remove(rec.DestPath); err != nil {
+			t.Fatal(err)
+		}
+		// Two killed writers of the same copy, each with a file of its own
+		for i := 0; i < 2; i++ {
+			tmp, err := createTemp(rec.DestPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tmp.Write(content[:2000])
+			tmp.Close()
+			if name := filepath.Base(tmp.Name()); !strings.HasPrefix(name, "."+filepath.Base(rec.DestPath)+".") || !strings.HasSuffix(name, ".mediaorganizer-tmp") {
+				t.Fatalf("temporary copy at %s", tmp.Name())
+			}
+			tmps = append(tmps, tmp.Name())
+		}
+	}
+	// Nor is the temporary file of a destination whose name extends this one
+	other := e.records(j)["a.jpg"].DestPath + ".jpg"
+	otherTmp, err := createTemp(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherTmp.Close()
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+
+	_, j = e.scan()
+	recs := e.records(j)
+	if rec := recs["a.jpg"]; rec.Status != db.StatusCompleted {
+		t.Errorf("record %s: %s", rec.Status, rec.ErrorMessage)
+	} else if got, err := os.ReadFile(rec.DestPath); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("copy not redone: %d bytes, %v", len(got), err)
+	}
+	if rec := recs["b.jpg"]; rec.Status != db.StatusFailed {
+		t.Errorf("record of missing source %s", rec.Status)
+	}
+	for _, tmp := range tmps {
+		if exists(tmp) {
+			t.Errorf("partial copy %s left", tmp)
+		}
+	}
+	if !exists(otherTmp.Name()) {
+		t.Errorf("temporary file of %s removed", other)
+	}
+	if files := dirFiles(t, e.cfg.DestDirs["image"]); len(files) != 2 {
+		t.Errorf("destination holds %v", files)
+	}
+}
+
+func TestMoversNeverShareDestination(t *testing.T) {
+	for _, copyFiles := range []bool{true, false} {
+		e := newTestEnv(t)
+		e.cfg.CopyFiles = copyFiles
+		s := NewMediaScanner(e.cfg, e.openJournal(), nil, false)
+
+		// Both planned to one destination, as an edited plan could
+		dest := filepath.Join(e.cfg.DestDirs["image"], "same.jpg")
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			t.Fatal(err)
+		}
+		contents := make(map[string][]byte)
+		var jobs []moveJob
+		for i := 0; i < 4; i++ {
+			content := jpegBytes(8<<20, byte(i))
+			path := e.write(fmt.Sprintf("%d.jpg", i), content, baseTime)
+			contents[path] = content
+			jobs = append(jobs, journalFile(t, s, path, dest))
+		}
+		var wg sync.WaitGroup
+		for _, job := range jobs {
+			wg.Add(1)
+			go func(job moveJob) {
+				defer wg.Done()
+				s.executeMoveJob(job)
+			}(job)
+		}
+		wg.Wait()
+
+		got, err := os.ReadFile(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		completed := 0
+		for _, job := range jobs {
+			rec, _ := s.journal.GetFile(job.RecordID)
+			src, content := job.File.SourcePath, contents[job.File.SourcePath]
+			switch rec.Status {
+			case db.StatusCompleted:
+				completed++
+				if !bytes.Equal(got, content) {
+					t.Errorf("copy=%v: %s completed, but the destination holds other data", copyFiles, src)
+				}
+			case db.StatusFailed:
+				// Its source is untouched
+				if kept, err := os.ReadFile(src); err != nil || !bytes.Equal(kept, content) {
+					t.Errorf("copy=%v: source of failed %s lost: %v", copyFiles, src, err)
+				}
+			default:
+				t.Errorf("copy=%v: %s %s", copyFiles, src, rec.Status)
+			}
+		}
+		if completed != 1 {
+			t.Errorf("copy=%v: %d completed, want 1", copyFiles, completed)
+		}
+		if files := dirFiles(t, e.cfg.DestDirs["image"]); len(files) != 1 {
+			t.Errorf("copy=%v: destination holds %v", copyFiles, files)
+		}
+	}
+}
+
+func TestResumeKeepsFinishedCopy(t *testing.T) {
+	e := newTestEnv(t)
+	e.cfg.CopyFiles = true
+	e.write("a.jpg", jpegBytes(5000, 1), baseTime)
+	_, j := e.scan()
+
+	// Killed after the copy was renamed into place, before it was recorded
+	rec := e.interrupt(j, "a.jpg")
+	before, err := os.Stat(rec.DestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, j := e.scan()
+	if result.ErrorCount != 0 {
+		t.Errorf("%d errors", result.ErrorCount)
+	}
+	if got := e.records(j)["a.jpg"]; got.Status != db.StatusCompleted || got.DestPath != rec.DestPath {
+		t.Errorf("record %s at %s: %s", got.Status, got.DestPath, got.ErrorMessage)
+	}
+	after, err := os.Stat(rec.DestPath)
+	if err != nil || !os.SameFile(before, after) {
+		t.Errorf("destination copied again: %v", err)
+	}
+	if files := dirFiles(t, e.cfg.DestDirs["image"]); len(files) != 1 {
+		t.Errorf("destination holds %v", files)
+	}
+}
@@ -0,0 +1,358 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"mediaorganizer/pkg/config"
+	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/media"
+)
+
+// UndoConflict describes a completed record that could not be reversed.
+type UndoConflict struct {
+	RecordID   int64
+	SourcePath string
+	DestPath   string
+	Reason     string
+}
+
+type UndoResult struct {
+	TotalRecords int
+	Restored     int
+	Conflicts    []UndoConflict
+	ErrorCount   int
+	StartTime    time.Time
+	EndTime      time.Time
+}
+
+// Undoer reverses a previous run by moving every completed file in the journal
+// from its dest_path back to its source_path.
+type Undoer struct {
+	journal *db.Journal
+	dryRun  bool
+	result  UndoResult
+}
+
+func NewUndoer(journal *db.Journal, dryRun bool) *Undoer {
+	return &Undoer{
+		journal: journal,
+		dryRun:  dryRun,
+		result: UndoResult{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+func (u *Undoer) Undo() *UndoResult {
+	records, err := u.journal.GetCompletedFiles()
+	if err != nil {
+		logrus.Errorf("Failed to load completed records: %v", err)
+		u.result.ErrorCount++
+		u.result.EndTime = time.Now()
+		return &u.result
+	}
+	u.result.TotalRecords = len(records)
+	logrus.Infof("Undo: %d completed files recorded in journal", len(records))
+
+	for _, rec := range records {
+		u.undoRecord(rec)
+	}
+
+	u.result.EndTime = time.Now()
+	return &u.result
+}
+
+func (u *Undoer) undoRecord(rec *db.FileRecord) {
+	if rec.DuplicateAction == string(config.DuplicateDelete) {
+		u.restoreDeleted(rec)
+		return
+	}
+	if rec.DestPath == "" {
+		u.conflict(rec, "no destination recorded")
+		return
+	}
+
+	// The destination must still be the file we put there
+	destInfo, err := os.Lstat(rec.DestPath)
+	if os.IsNotExist(err) {
+		u.conflict(rec, "destination file missing")
+		return
+	}
+	if err != nil {
+		u.fail(rec, err)
+		return
+	}
+	if destInfo.Mode()&os.ModeSymlink != 0 {
+		u.removeSymlink(rec)
+		return
+	}
+	if !destInfo.Mode().IsRegular() {
+		u.conflict(rec, "destination is not a regular file")
+		return
+	}
+	if destInfo.Size() != rec.FileSize {
+		u.conflict(rec, fmt.Sprintf("destination size changed: %d bytes, journal has %d", destInfo.Size(), rec.FileSize))
+		return
+	}
+	destHash := ""
+	if rec.Hash != "" {
+		destHash, err = media.ComputeFileHash(rec.DestPath)
+		if err != nil {
+			u.fail(rec, err)
+			return
+		}
+		if destHash != rec.Hash {
+			u.conflict(rec, "destination content changed since it was organized")
+			return
+		}
+	}
+
+	// If something already sits at the source path, the run was a copy (or the
+	// user put a new file there). Only an identical copy may be discarded.
+	if _, err := os.Lstat(rec.SourcePath); err == nil {
+		same, err := sameContent(rec.SourcePath, rec.DestPath, destHash)
+		if err != nil {
+			u.fail(rec, err)
+			return
+		}
+		if !same {
+			u.conflict(rec, "source path is occupied by a different file")
+			return
+		}
+
+		if u.dryRun {
+			logrus.Infof("[DRY RUN] Would remove copy: %s (original still at %s)", rec.DestPath, rec.SourcePath)
+			u.result.Restored++
+			u.undoSidecars(rec)
+			return
+		}
+		if err := os.Remove(rec.DestPath); err != nil {
+			u.fail(rec, err)
+			return
+		}
+		logrus.Infof("Removed copy: %s (original still at %s)", rec.DestPath, rec.SourcePath)
+		u.restored(rec)
+		return
+	}
+
+	if u.dryRun {
+		logrus.Infof("[DRY RUN] Would restore: %s -> \n%s", rec.DestPath, rec.SourcePath)
+		u.result.Restored++
+		u.undoSidecars(rec)
+		return
+	}
+
+	// Recreate source directories removed by cleanupEmptyDirectories
+	if err := os.MkdirAll(filepath.Dir(rec.SourcePath), 0755); err != nil {
+		u.fail(rec, err)
+		return
+	}
+	if err := moveFileImpl(rec.DestPath, rec.SourcePath); err != nil {
+		u.fail(rec, err)
+		return
+	}
+	logrus.Infof("Restored: %s -> \n%s", rec.DestPath, rec.SourcePath)
+	u.restored(rec)
+}
+
+// removeSymlink undoes a file organized with --link=symlink: the source never
+// moved, so only the link to it is removed.
+func (u *Undoer) removeSymlink(rec *db.FileRecord) {
+	target, err := os.Readlink(rec.DestPath)
+	if err != nil {
+		u.fail(rec, err)
+		return
+	}
+	if target != rec.SourcePath {
+		u.conflict(rec, fmt.Sprintf("destination is a symlink to %s", target))
+		return
+	}
+	if _, err := os.Lstat(rec.SourcePath); err != nil {
+		u.conflict(rec, "symlinked source file missing")
+		return
+	}
+
+	if u.dryRun {
+		logrus.Infof("[DRY RUN] Would remove link: %s (original still at %s)", rec.DestPath, rec.SourcePath)
+		u.result.Restored++
+		u.undoSidecars(rec)
+		return
+	}
+	if err := os.Remove(rec.DestPath); err != nil {
+		u.fail(rec, err)
+		return
+	}
+	logrus.Infof("Removed link: %s (original still at %s)", rec.DestPath, rec.SourcePath)
+	u.restored(rec)
+}
+
+// restoreDeleted brings back a duplicate deleted by the delete duplicate
+// policy, as a copy of its original.
+func (u *Undoer) restoreDeleted(rec *db.FileRecord) {
+	original, err := locateOriginal(u.journal, rec.DuplicateOf)
+	if err != nil {
+		u.conflict(rec, fmt.Sprintf("cannot restore deleted duplicate: %v", err))
+		return
+	}
+	origHash, err := media.ComputeFileHash(original)
+	if err != nil {
+		u.fail(rec, err)
+		return
+	}
+	if origHash != rec.Hash {
+		u.conflict(rec, "original content changed since the duplicate was deleted")
+		return
+	}
+
+	if _, err := os.Lstat(rec.SourcePath); err == nil {
+		same, err := sameContent(rec.SourcePath, original, origHash)
+		if err != nil {
+			u.fail(rec, err)
+			return
+		}
+		if !same {
+			u.conflict(rec, "source path is occupied by a different file")
+			return
+		}
+		logrus.Infof("Deleted duplicate is already back: %s", rec.SourcePath)
+		if !u.dryRun {
+			u.restored(rec)
+		} else {
+			u.result.Restored++
+		}
+		return
+	}
+
+	if u.dryRun {
+		logrus.Infof("[DRY RUN] Would restore deleted duplicate: copy of %s -> \n%s", original, rec.SourcePath)
+		u.result.Restored++
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(rec.SourcePath), 0755); err != nil {
+		u.fail(rec, err)
+		return
+	}
+	if err := copyFileImpl(original, rec.SourcePath); err != nil {
+		u.fail(rec, err)
+		return
+	}
+	logrus.Infof("Restored deleted duplicate: copy of %s -> \n%s", original, rec.SourcePath)
+	u.restored(rec)
+}
+
+func (u *Undoer) restored(rec *db.FileRecord) {
+	if err := u.journal.UpdateStatus(rec.ID, db.StatusUndone, ""); err != nil {
+		logrus.Errorf("Failed to mark %s as undone: %v", rec.SourcePath, err)
+	}
+	u.result.Restored++
+	u.undoSidecars(rec)
+}
+
+// undoSidecars returns the completed sidecars of a restored file to their
+// source paths. Sidecar conflicts are reported but don't affect the file.
+func (u *Undoer) undoSidecars(rec *db.FileRecord) {
+	sidecars, err := u.journal.GetCompletedSidecars(rec.ID)
+	if err != nil {
+		logrus.Errorf("Failed to load sidecars of %s: %v", rec.SourcePath, err)
+		u.result.ErrorCount++
+		return
+	}
+
+	for _, sc := range sidecars {
+		conflict := func(reason string) {
+			logrus.Warnf("Undo conflict for sidecar %s: %s", sc.SourcePath, reason)
+			u.result.Conflicts = append(u.result.Conflicts, UndoConflict{
+				RecordID:   rec.ID,
+				SourcePath: sc.SourcePath,
+				DestPath:   sc.DestPath,
+				Reason:     reason,
+			})
+		}
+
+		if _, err := os.Lstat(sc.DestPath); err != nil {
+			conflict("sidecar destination missing")
+			continue
+		}
+
+		removeCopy := false
+		if _, err := os.Lstat(sc.SourcePath); err == nil {
+			same, err := sameContent(sc.SourcePath, sc.DestPath, "")
+			if err != nil {
+				logrus.Errorf("Failed to undo sidecar %s: %v", sc.SourcePath, err)
+				u.result.ErrorCount++
+				continue
+			}
+			if !same {
+				conflict("source path is occupied by a different file")
+				continue
+			}
+			removeCopy = true
+		}
+
+		if u.dryRun {
+			logrus.Infof("[DRY RUN] Would restore sidecar: %s -> \n%s", sc.DestPath, sc.SourcePath)
+			continue
+		}
+
+		if removeCopy {
+			err = os.Remove(sc.DestPath)
+		} else if err = os.MkdirAll(filepath.Dir(sc.SourcePath), 0755); err == nil {
+			err = moveFileImpl(sc.DestPath, sc.SourcePath)
+		}
+		if err != nil {
+			logrus.Errorf("Failed to undo sidecar %s: %v", sc.SourcePath, err)
+			u.result.ErrorCount++
+			continue
+		}
+		logrus.Infof("Restored sidecar: %s -> \n%s", sc.DestPath, sc.SourcePath)
+		if err := u.journal.UpdateSidecar(sc.ID, sc.DestPath, db.StatusUndone, ""); err != nil {
+			logrus.Errorf("Failed to mark sidecar %s as undone: %v", sc.SourcePath, err)
+		}
+	}
+}
+
+func (u *Undoer) conflict(rec *db.FileRecord, reason string) {
+	logrus.Warnf("Undo conflict for %s: %s", rec.SourcePath, reason)
+	u.result.Conflicts = append(u.result.Conflicts, UndoConflict{
+		RecordID:   rec.ID,
+		SourcePath: rec.SourcePath,
+		DestPath:   rec.DestPath,
+		Reason:     reason,
+	})
+}
+
+func (u *Undoer) fail(rec *db.FileRecord, err error) {
+	logrus.Errorf("Failed to undo %s: %v", rec.SourcePath, err)
+	u.result.ErrorCount++
+}
+
+// sameContent reports whether the file at path has the same content as the file
+// at other. knownHash, if non-empty, is the already-computed hash of other.
+func sameContent(path, other, knownHash string) (bool, error) {
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	otherInfo, err := os.Stat(other)
+	if err != nil {
+		return false, err
+	}
+	if pathInfo.Size() != otherInfo.Size() {
+		return false, nil
+	}
+	if knownHash == "" {
+		if knownHash, err = media.ComputeFileHash(other); err != nil {
+			return false, err
+		}
+	}
+	h, err := media.ComputeFileHash(path)
+	if err != nil {
+		return false, err
+	}
+	return h == knownHash, nil
+}
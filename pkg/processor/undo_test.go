@@ -0,0 +1,148 @@
+package processor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mediaorganizer/pkg/db"
+)
+
+// snapshot returns the content of every file in the source and destinations
+// by path.
+func (e *testEnv) snapshot() map[string]string {
+	e.t.Helper()
+	files := make(map[string]string)
+	filepath.WalkDir(e.root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path == e.cfg.DBPath || strings.HasPrefix(path, e.cfg.DBPath+"-") {
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		files[path] = string(b)
+		return nil
+	})
+	return files
+}
+
+// organized writes two files into the source and organizes them.
+func organized(e *testEnv) (*db.Journal, map[string][]byte) {
+	e.t.Helper()
+	contents := map[string][]byte{
+		"a/one.jpg": jpegBytes(5000, 1),
+		"b/two.jpg": jpegBytes(6000, 2),
+	}
+	for rel, content := range contents {
+		e.write(rel, content, baseTime)
+	}
+	result, j := e.scan()
+	if result.ErrorCount != 0 || result.OrganizedFiles != len(contents) {
+		e.t.Fatalf("organized %d files, %d errors", result.OrganizedFiles, result.ErrorCount)
+	}
+	return j, contents
+}
+
+func TestUndoRestoresMovedFiles(t *testing.T) {
+	e := newTestEnv(t)
+	j, contents := organized(e)
+	recs := e.records(j)
+
+	result := NewUndoer(j, false).Undo()
+	if result.Restored != len(contents) || len(result.Conflicts) != 0 || result.ErrorCount != 0 {
+		t.Fatalf("undo restored %d, conflicts %v, %d errors", result.Restored, result.Conflicts, result.ErrorCount)
+	}
+	for rel, content := range contents {
+		if got, err := os.ReadFile(e.src(rel)); err != nil || !bytes.Equal(got, content) {
+			t.Errorf("%s not restored: %v", rel, err)
+		}
+		if exists(recs[rel].DestPath) {
+			t.Errorf("%s left at %s", rel, recs[rel].DestPath)
+		}
+	}
+	for rel, rec := range e.records(j) {
+		if rec.Status != db.StatusUndone {
+			t.Errorf("%s: status %s", rel, rec.Status)
+		}
+	}
+}
+
+func TestUndoKeepsFileAtReoccupiedSource(t *testing.T) {
+	e := newTestEnv(t)
+	j, contents := organized(e)
+	recs := e.records(j)
+
+	// A new file of the same size arrived under the old name
+	newer := jpegBytes(len(contents["a/one.jpg"]), 9)
+	e.write("a/one.jpg", newer, baseTime)
+
+	result := NewUndoer(j, false).Undo()
+	if result.Restored != 1 || len(result.Conflicts) != 1 {
+		t.Fatalf("undo restored %d, conflicts %v", result.Restored, result.Conflicts)
+	}
+	if c := result.Conflicts[0]; c.SourcePath != e.src("a/one.jpg") || !strings.Contains(c.Reason, "occupied") {
+		t.Errorf("conflict %+v", c)
+	}
+	if got, _ := os.ReadFile(e.src("a/one.jpg")); !bytes.Equal(got, newer) {
+		t.Errorf("file at the source overwritten")
+	}
+	if got, _ := os.ReadFile(recs["a/one.jpg"].DestPath); !bytes.Equal(got, contents["a/one.jpg"]) {
+		t.Errorf("organized file not left in the destination")
+	}
+	if rec := e.records(j)["a/one.jpg"]; rec.Status != db.StatusCompleted {
+		t.Errorf("conflicting record %s", rec.Status)
+	}
+}
+
+func TestUndoRemovesOnlyIdenticalCopies(t *testing.T) {
+	e := newTestEnv(t)
+	e.cfg.CopyFiles = true
+	j, contents := organized(e)
+	recs := e.records(j)
+
+	// The source of one copy changed since
+	changed := jpegBytes(len(contents["b/two.jpg"]), 9)
+	e.write("b/two.jpg", changed, baseTime)
+
+	result := NewUndoer(j, false).Undo()
+	if result.Restored != 1 || len(result.Conflicts) != 1 || result.ErrorCount != 0 {
+		t.Fatalf("undo restored %d, conflicts %v, %d errors", result.Restored, result.Conflicts, result.ErrorCount)
+	}
+	if exists(recs["a/one.jpg"].DestPath) {
+		t.Errorf("copy %s not removed", recs["a/one.jpg"].DestPath)
+	}
+	if got, _ := os.ReadFile(e.src("a/one.jpg")); !bytes.Equal(got, contents["a/one.jpg"]) {
+		t.Errorf("source of removed copy changed")
+	}
+	if got, _ := os.ReadFile(recs["b/two.jpg"].DestPath); !bytes.Equal(got, contents["b/two.jpg"]) {
+		t.Errorf("copy of a changed source removed")
+	}
+	if got, _ := os.ReadFile(e.src("b/two.jpg")); !bytes.Equal(got, changed) {
+		t.Errorf("changed source overwritten")
+	}
+}
+
+func TestUndoDryRunChangesNothing(t *testing.T) {
+	e := newTestEnv(t)
+	j, contents := organized(e)
+	// One restore and one conflict
+	e.write("a/one.jpg", jpegBytes(100, 9), baseTime)
+	before := e.snapshot()
+
+	result := NewUndoer(j, true).Undo()
+	if result.Restored != len(contents)-1 || len(result.Conflicts) != 1 {
+		t.Errorf("dry run restored %d, conflicts %v", result.Restored, result.Conflicts)
+	}
+	if after := e.snapshot(); !reflect.DeepEqual(before, after) {
+		t.Errorf("dry run changed the tree")
+	}
+	for rel, rec := range e.records(j) {
+		if rec.Status != db.StatusCompleted {
+			t.Errorf("dry run marked %s %s", rel, rec.Status)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"mediaorganizer/pkg/config"
+	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/media"
+)
+
+// VerifyProblem describes an organized file that is no longer as the journal
+// recorded it.
+type VerifyProblem struct {
+	RecordID int64
+	Path     string
+	Reason   string
+}
+
+type VerifyResult struct {
+	TotalRecords int
+	Intact       int
+	Problems     []VerifyProblem
+	ErrorCount   int
+	StartTime    time.Time
+	EndTime      time.Time
+}
+
+// Verifier checks that every completed file in the journal is still at its
+// destination with the size and hash recorded when it was organized. It never
+// changes a file or the journal.
+type Verifier struct {
+	journal *db.Journal
+	result  VerifyResult
+}
+
+func NewVerifier(journal *db.Journal) *Verifier {
+	return &Verifier{
+		journal: journal,
+		result: VerifyResult{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+func (v *Verifier) Verify() *VerifyResult {
+	records, err := v.journal.GetCompletedFiles()
+	if err != nil {
+		logrus.Errorf("Failed to load completed records: %v", err)
+		v.result.ErrorCount++
+		v.result.EndTime = time.Now()
+		return &v.result
+	}
+	logrus.Infof("Verify: %d completed files recorded in journal", len(records))
+
+	for _, rec := range records {
+		// Deleted duplicates have nothing left to check
+		if rec.DuplicateAction == string(config.DuplicateDelete) {
+			continue
+		}
+		v.result.TotalRecords++
+		if reason := v.checkRecord(rec); reason != "" {
+			v.problem(rec.ID, rec.DestPath, reason)
+			continue
+		}
+		if v.checkSidecars(rec) {
+			v.result.Intact++
+		}
+	}
+
+	v.result.EndTime = time.Now()
+	return &v.result
+}
+
+// checkRecord returns why the destination of rec doesn't match the journal,
+// or "" if it does.
+func (v *Verifier) checkRecord(rec *db.FileRecord) string {
+	if rec.DestPath == "" {
+		return "no destination recorded"
+	}
+	info, err := os.Lstat(rec.DestPath)
+	if os.IsNotExist(err) {
+		return "destination file missing"
+	}
+	if err != nil {
+		return err.Error()
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(rec.DestPath)
+		if err != nil {
+			return err.Error()
+		}
+		if target != rec.SourcePath {
+			return fmt.Sprintf("destination is a symlink to %s", target)
+		}
+		// The link is as made; its target is what must be intact
+		if info, err = os.Stat(rec.DestPath); err != nil {
+			return "symlinked source file missing"
+		}
+	}
+	if !info.Mode().IsRegular() {
+		return "destination is not a regular file"
+	}
+	if info.Size() != rec.FileSize {
+		return fmt.Sprintf("size is %d bytes, journal has %d", info.Size(), rec.FileSize)
+	}
+	if rec.Hash != "" {
+		hash, err := media.ComputeFileHash(rec.DestPath)
+		if err != nil {
+			return err.Error()
+		}
+		if hash != rec.Hash {
+			return fmt.Sprintf("content changed (hash %s, journal has %s)", hash, rec.Hash)
+		}
+	}
+	return ""
+}
+
+// checkSidecars reports whether the sidecars organized with rec are all still
+// at their destinations.
+func (v *Verifier) checkSidecars(rec *db.FileRecord) bool {
+	sidecars, err := v.journal.GetCompletedSidecars(rec.ID)
+	if err != nil {
+		logrus.Errorf("Failed to load sidecars of %s: %v", rec.SourcePath, err)
+		v.result.ErrorCount++
+		return false
+	}
+	intact := true
+	for _, sc := range sidecars {
+		if _, err := os.Lstat(sc.DestPath); err != nil {
+			v.problem(rec.ID, sc.DestPath, "sidecar file missing")
+			intact = false
+		}
+	}
+	return intact
+}
+
+func (v *Verifier) problem(id int64, path, reason string) {
+	logrus.Warnf("Verify: %s: %s", path, reason)
+	v.result.Problems = append(v.result.Problems, VerifyProblem{RecordID: id, Path: path, Reason: reason})
+}
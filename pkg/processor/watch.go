@@ -0,0 +1,306 @@
+package processor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+
+	"mediaorganizer/pkg/db"
+	"mediaorganizer/pkg/filter"
+)
+
+// watcher feeds files that appear in the source directory into a running
+// pipeline once they have stopped changing, so that a file still being
+// copied in is never organized half-written.
+type watcher struct {
+	s       *MediaScanner
+	ctx     context.Context
+	fsw     *fsnotify.Watcher
+	settle  time.Duration
+	mu      sync.Mutex
+	pending map[string]*pendingFile // waiting for their size and mtime to settle
+	queued  map[string]bool         // handed to the pipeline, until it is done with them
+}
+
+// pendingFile is the last observed state of a file waiting to settle.
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	changed time.Time // when the file was last seen changing
+}
+
+// Watch organizes the source directory like Scan, then keeps organizing files
+// as they arrive until ctx is canceled. Files handed to the pipeline by then
+// are finished before it returns; files still changing are left for the next
+// run.
+func (s *MediaScanner) Watch(ctx context.Context) (*ScanResult, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	defer fsw.Close()
+
+	w := &watcher{
+		s:       s,
+		ctx:     ctx,
+		fsw:     fsw,
+		settle:  s.watchSettle,
+		pending: make(map[string]*pendingFile),
+		queued:  make(map[string]bool),
+	}
+	s.watcher = w
+	go w.handleEvents()
+	return s.run(w), nil
+}
+
+// addDir starts watching dir, unless it is one of the destinations, as files
+// organized into a destination inside the source are not new arrivals, or the
+// filters skip it.
+func (w *watcher) addDir(dir string) bool {
+	if w.s.isDestinationDir(dir) || w.s.sourceFilter.SkipDir(dir) != "" {
+		return false
+	}
+	if err := w.fsw.Add(dir); err != nil {
+		logrus.Warnf("Watch: cannot watch %s: %v", dir, err)
+		return false
+	}
+	return true
+}
+
+// addTree watches a directory created in the source and everything in it.
+// Files may have been moved in with it, before it was watched.
+func (w *watcher) addTree(dir string) {
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if !w.addDir(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			w.track(path, info)
+		}
+		return nil
+	})
+}
+
+// deferUnsettled leaves a file found by the initial walk to the watcher if it
+// was modified within the settle time, and reports whether it did.
+func (w *watcher) deferUnsettled(path string, d os.DirEntry) bool {
+	info, err := d.Info()
+	if err != nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if time.Since(info.ModTime()) < w.settle {
+		w.pending[path] = &pendingFile{size: info.Size(), modTime: info.ModTime(), changed: time.Now()}
+		return true
+	}
+	w.queued[path] = true
+	return false
+}
+
+// track notes that the file at path appeared or changed.
+func (w *watcher) track(path string, info os.FileInfo) {
+	if !info.Mode().IsRegular() || !isSourceMediaFile(path) {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.queued[path] {
+		return
+	}
+	w.pending[path] = &pendingFile{size: info.Size(), modTime: info.ModTime(), changed: time.Now()}
+}
+
+// forget drops a path that was removed or renamed, so that a new file
+// arriving under the same name is organized too.
+func (w *watcher) forget(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.pending, path)
+	delete(w.queued, path)
+}
+
+// release tells the watcher that the pipeline is done with the file at path,
+// organized or not, so that a change to it from now on is tracked again: a
+// source kept by copy mode may be rewritten in place. Outside watch mode it
+// does nothing.
+func (s *MediaScanner) release(path string) {
+	if s.watcher == nil {
+		return
+	}
+	s.watcher.mu.Lock()
+	defer s.watcher.mu.Unlock()
+	delete(s.watcher.queued, path)
+}
+
+// handleEvents tracks the file system events until the watcher is closed.
+func (w *watcher) handleEvents() {
+	for {
+		select {
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			// Read .mediaignore files again, for the files still to settle
+			if filepath.Base(ev.Name) == filter.IgnoreFileName {
+				w.s.sourceFilter.Reset()
+			}
+			switch {
+			case ev.Has(fsnotify.Create) || ev.Has(fsnotify.Write):
+				info, err := os.Lstat(ev.Name)
+				if err != nil {
+					continue
+				}
+				if info.IsDir() {
+					w.addTree(ev.Name)
+					continue
+				}
+				w.track(ev.Name, info)
+			case ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename):
+				w.forget(ev.Name)
+			}
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			logrus.Warnf("Watch: %v", err)
+		}
+	}
+}
+
+// watch hands settled files to emit until the context is canceled.
+func (w *watcher) watch(emit func(path string, sidecars []string)) {
+	logrus.Infof("Watching %s for new files...", w.s.sourceDir)
+	interval := w.settle / 2
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			w.mu.Lock()
+			unsettled := len(w.pending)
+			w.mu.Unlock()
+			if unsettled > 0 {
+				logrus.Warnf("Watch: %d files still changing are left for the next run", unsettled)
+			}
+			logrus.Infof("Watch stopped, finishing files in progress...")
+			return
+		case <-ticker.C:
+			settled := w.settled()
+			if len(settled) == 0 {
+				continue
+			}
+			settled = w.s.dropJournaled(w.s.dropFiltered(settled))
+			if len(settled) == 0 {
+				continue
+			}
+			logrus.Infof("Watch: %d new files", len(settled))
+			// Sidecars may have arrived since the directory was last listed
+			sidecars := &sidecarIndex{}
+			for _, path := range settled {
+				emit(path, sidecars.lookup(path))
+			}
+		}
+	}
+}
+
+// settled returns the pending files whose size and mtime haven't changed for
+// the settle time, in path order, and marks them queued.
+func (w *watcher) settled() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	var paths []string
+	for path, p := range w.pending {
+		info, err := os.Lstat(path)
+		if err != nil {
+			delete(w.pending, path)
+			continue
+		}
+		if info.Size() != p.size || !info.ModTime().Equal(p.modTime) {
+			p.size, p.modTime, p.changed = info.Size(), info.ModTime(), now
+			continue
+		}
+		if now.Sub(p.changed) >= w.settle {
+			paths = append(paths, path)
+			delete(w.pending, path)
+			w.queued[path] = true
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// dropJournaled removes the paths that the journal already has a completed
+// record for. A new file arriving under the name of one organized before
+// would be skipped as already journaled; say so rather than ignore it.
+func (s *MediaScanner) dropJournaled(paths []string) []string {
+	kept := paths[:0]
+	for _, path := range paths {
+		if rec, err := s.journal.GetBySourcePath(path); err == nil && rec != nil && rec.Status == db.StatusCompleted {
+			logrus.Warnf("Watch: a file from %s was organized before; rename this one to organize it too", path)
+			s.release(path)
+			continue
+		}
+		kept = append(kept, path)
+	}
+	return kept
+}
+
+// dropFiltered removes the paths that the filters skip. Their size is only
+// final now that they have settled.
+func (s *MediaScanner) dropFiltered(paths []string) []string {
+	kept := paths[:0]
+	for _, path := range paths {
+		var size int64
+		if info, err := os.Lstat(path); err == nil {
+			size = info.Size()
+		}
+		if reason := s.sourceFilter.SkipFile(path, size); reason != "" {
+			s.countSkip(reason, path)
+			s.release(path)
+			continue
+		}
+		kept = append(kept, path)
+	}
+	return kept
+}
+
+// isDestinationDir reports whether dir is, or is inside, a directory that
+// files are organized into within the source directory.
+func (s *MediaScanner) isDestinationDir(dir string) bool {
+	dests := []string{s.destination, s.duplicatesDir, s.nearDuplicatesDir, s.quarantineDir}
+	for _, d := range s.destinationDirs {
+		dests = append(dests, d)
+	}
+	for _, d := range s.extensionDirs {
+		dests = append(dests, d)
+	}
+	for _, d := range dests {
+		if d == "" || !filepath.IsAbs(d) || !strings.HasPrefix(d, s.sourceDir+string(os.PathSeparator)) {
+			continue
+		}
+		if dir == d || strings.HasPrefix(dir, d+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"mediaorganizer/pkg/db"
+)
+
+// startWatch runs Watch with a short settle time until the returned function
+// is called, which waits for it to finish.
+func (e *testEnv) startWatch(settle time.Duration) (*db.Journal, func() *ScanResult) {
+	e.t.Helper()
+	e.cfg.Watch = true
+	e.cfg.WatchSettle = settle
+	j := e.openJournal()
+	s := NewMediaScanner(e.cfg, j, nil, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan *ScanResult, 1)
+	go func() {
+		result, err := s.Watch(ctx)
+		if err != nil {
+			e.t.Errorf("Watch: %v", err)
+		}
+		done <- result
+	}()
+	return j, func() *ScanResult {
+		cancel()
+		select {
+		case result := <-done:
+			return result
+		case <-time.After(time.Minute):
+			e.t.Fatal("watch did not stop")
+			return nil
+		}
+	}
+}
+
+// waitFor polls until cond holds, failing the test after a while.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// completed reports whether the journal has organized the file at path.
+func completed(j *db.Journal, path string) bool {
+	rec, err := j.GetBySourcePath(path)
+	return err == nil && rec != nil && rec.Status == db.StatusCompleted
+}
+
+// warnings collects the warnings logged, see captureWarnings.
+type warnings struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (w *warnings) Levels() []logrus.Level { return []logrus.Level{logrus.WarnLevel} }
+
+func (w *warnings) Fire(entry *logrus.Entry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.msgs = append(w.msgs, entry.Message)
+	return nil
+}
+
+// count returns how many warnings mention s.
+func (w *warnings) count(s string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := 0
+	for _, msg := range w.msgs {
+		if strings.Contains(msg, s) {
+			n++
+		}
+	}
+	return n
+}
+
+// captureWarnings collects the warnings logged until the end of the test.
+func captureWarnings(t *testing.T) *warnings {
+	w := &warnings{}
+	hooks := logrus.StandardLogger().ReplaceHooks(logrus.LevelHooks{})
+	logrus.AddHook(w)
+	t.Cleanup(func() { logrus.StandardLogger().ReplaceHooks(hooks) })
+	return w
+}
+
+func TestWatchWaitsForFilesToSettle(t *testing.T) {
+	e := newTestEnv(t)
+	const settle = 500 * time.Millisecond
+	content := jpegBytes(20000, 5)
+	path := e.write("new.jpg", content[:10000], time.Now())
+	j, stop := e.startWatch(settle)
+
+	// The second half arrives before the first has settled
+	time.Sleep(settle / 2)
+	if rec, _ := j.GetBySourcePath(path); rec != nil {
+		t.Fatalf("file organized after %s, before it settled", settle/2)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(content[10000:]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	written := time.Now()
+
+	waitFor(t, "the file to be organized", func() bool { return completed(j, path) })
+	if waited := time.Since(written); waited < settle {
+		t.Errorf("file organized %s after its last change, settle time %s", waited, settle)
+	}
+	stop()
+
+	rec, _ := j.GetBySourcePath(path)
+	got, err := os.ReadFile(rec.DestPath)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("organized %d of %d bytes: %v", len(got), len(content), err)
+	}
+	if exists(path) {
+		t.Errorf("source %s not moved", path)
+	}
+}
+
+func TestWatchIgnoresDestinationInsideSource(t *testing.T) {
+	e := newTestEnv(t)
+	for mediaType := range e.cfg.DestDirs {
+		e.cfg.DestDirs[mediaType] = e.src("out/" + mediaType)
+	}
+	first := e.write("a.jpg", jpegBytes(3000, 1), baseTime)
+	j, stop := e.startWatch(200 * time.Millisecond)
+	waitFor(t, "the first file to be organized", func() bool { return completed(j, first) })
+
+	// A file dropped into the destination settles no later than one dropped
+	// into the source after it
+	manual := filepath.Join(e.cfg.DestDirs["image"], "manual.jpg")
+	writeFile(t, manual, jpegBytes(3001, 2), time.Now())
+	second := e.write("b.jpg", jpegBytes(3002, 3), time.Now())
+	waitFor(t, "the second file to be organized", func() bool { return completed(j, second) })
+	stop()
+
+	recs, err := j.QueryFiles(db.FileFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		if strings.HasPrefix(rec.SourcePath, e.src("out")+string(os.PathSeparator)) {
+			t.Errorf("file in the destination organized: %s -> %s", rec.SourcePath, rec.DestPath)
+		}
+	}
+	if len(recs) != 2 {
+		t.Errorf("%d records, want 2", len(recs))
+	}
+	if !exists(manual) {
+		t.Errorf("%s moved", manual)
+	}
+}
+
+func TestWatchFinishesQueuedFilesWhenCanceled(t *testing.T) {
+	e := newTestEnv(t)
+	e.cfg.CopyFiles = true
+	e.cfg.Verify = true
+	const n = 20
+	var paths []string
+	for i := 0; i < n; i++ {
+		paths = append(paths, e.write(fmt.Sprintf("%02d.jpg", i), jpegBytes(1<<20+i, byte(i)), time.Now()))
+	}
+	j, stop := e.startWatch(200 * time.Millisecond)
+
+	// Stop once all are handed to the pipeline, with copies still running
+	waitFor(t, "the files to be queued", func() bool {
+		count, _ := j.TotalCount()
+		return count == n
+	})
+	late := e.write("late.jpg", jpegBytes(1000, 99), time.Now())
+	result := stop()
+
+	if result.ErrorCount != 0 {
+		t.Errorf("%d errors", result.ErrorCount)
+	}
+	for _, path := range paths {
+		rec, err := j.GetBySourcePath(path)
+		if err != nil || rec == nil || rec.Status != db.StatusCompleted || !exists(rec.DestPath) {
+			t.Errorf("%s not finished: %+v, %v", path, rec, err)
+		}
+	}
+	// A file still changing is left for the next run
+	if rec, _ := j.GetBySourcePath(late); rec != nil {
+		t.Errorf("unsettled file organized: %+v", rec)
+	}
+	if !exists(late) {
+		t.Errorf("unsettled file %s removed", late)
+	}
+}
+
+func TestWatchTracksRewrittenCopySource(t *testing.T) {
+	e := newTestEnv(t)
+	e.cfg.CopyFiles = true
+	warned := captureWarnings(t)
+	content := jpegBytes(3000, 1)
+	path := e.write("a.jpg", content, baseTime)
+	j, stop := e.startWatch(200 * time.Millisecond)
+	waitFor(t, "the file to be organized", func() bool { return completed(j, path) })
+	rec, _ := j.GetBySourcePath(path)
+
+	// The kept source is rewritten in place, twice; each time it is noticed
+	// as a new file under the name of one organized before
+	for i := 1; i <= 2; i++ {
+		if err := os.WriteFile(path, jpegBytes(3000, byte(1+i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, fmt.Sprintf("rewrite %d to be noticed", i), func() bool { return warned.count(path) == i })
+	}
+	stop()
+
+	if got, err := os.ReadFile(rec.DestPath); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("organized copy changed: %v", err)
+	}
+	if count, _ := j.TotalCount(); count != 1 {
+		t.Errorf("%d records, want 1", count)
+	}
+}